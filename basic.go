@@ -1,24 +1,34 @@
 // basic.go implements the interface billy.Basic
 
-package main
+package s3fs
 
 import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/go-git/go-billy/v5"
 )
 
 const (
 	O_RDONLY      int = os.O_RDONLY // open the file read-only.
 	O_WRONLY      int = os.O_WRONLY // open the file write-only.
+	O_RDWR        int = os.O_RDWR   // open the file read-write, holding its contents in memory until it's closed.
+	O_APPEND      int = os.O_APPEND // append data to the file when writing (implies O_RDWR).
+	O_CREATE      int = os.O_CREATE // create a new file if none exists.
+	O_EXCL        int = os.O_EXCL   // used with O_CREATE, file must not exist.
+	O_TRUNC       int = os.O_TRUNC  // truncate the file when opened.
 	O_WRMULTIPART int = 0x4         // open the file for write-only using multipart upload.
 
-	SupportedOFlags = O_RDONLY | O_WRONLY | O_WRMULTIPART // supported open flags for s3fs
+	SupportedOFlags = O_RDONLY | O_WRONLY | O_RDWR | O_APPEND | O_CREATE | O_EXCL | O_TRUNC | O_WRMULTIPART // supported open flags for s3fs
 )
 
 var (
@@ -44,56 +54,181 @@ func (fs3 *S3FS) Open(filename string) (billy.File, error) {
 // instead. It opens the named file with specified flag (O_RDONLY etc.) and
 // perm, (0666 etc.) if applicable. If successful, methods on the returned
 // File can be used for I/O.
+//
+// S3 objects can't be partially overwritten, so files opened write-only
+// (O_WRONLY or O_WRMULTIPART) are always truncated, and are uploaded when
+// they're closed. Files opened with O_RDWR or O_APPEND are held in memory
+// and shared with other files open on the same name, so that they see each
+// other's changes before they're uploaded.
 func (fs3 *S3FS) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
 	// Is the supplied flag supported?
 	if flag&SupportedOFlags != flag {
 		return nil, errors.New("unsupported open flag")
 	}
+	rw := flag&(O_RDWR|O_APPEND) != 0
+	if rw && flag&O_WRMULTIPART != 0 {
+		return nil, errors.New("unsupported open flag")
+	}
 
 	// Get the file path
-	p := path.Join(fs3.root, filename)
+	p, err := fs3.resolve(filename)
+	if err != nil {
+		return nil, err
+	}
 
-	switch flag & SupportedOFlags {
-	case O_RDONLY:
-		return newS3ReadFile(fs3.client, fs3.bucket, p)
+	// Keep the permissions, to store with the file
+	if fs3.posix {
+		nfs := *fs3
+		nfs.file.perm = perm
+		fs3 = &nfs
+	}
+
+	// Check that the file doesn't exist, if required
+	// TODO: This isn't atomic
+	if flag&(O_CREATE|O_EXCL) == O_CREATE|O_EXCL {
+		_, open := fs3.openFiles.get(p)
+		if _, err := fs3.Stat(filename); open || err == nil {
+			return nil, &os.PathError{Op: "open", Path: filename, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
 
-	case O_WRONLY:
-		return newS3WriteFile(fs3.client, fs3.bucket, p)
+	switch {
+	case rw:
+		return newS3ReadWriteFile(fs3, filename, p, flag)
 
-	case O_WRMULTIPART:
-		return newS3MultipartUploadFile(fs3.client, fs3.bucket, p)
+	case flag&O_WRMULTIPART != 0:
+		f, err := newS3MultipartUploadFile(fs3, fs3.bucket, p)
+		if err != nil {
+			return nil, err
+		}
+		f.name = filename
+		return f, nil
+
+	case flag&O_WRONLY != 0:
+		f, err := newS3WriteFile(fs3, fs3.bucket, p)
+		if err != nil {
+			return nil, err
+		}
+		f.name = filename
+		return f, nil
 
 	default:
-		return nil, errors.New("unsupported open flag")
+		f, err := newS3ReadFile(fs3, fs3.bucket, p)
+		if os.IsNotExist(err) && flag&O_CREATE != 0 {
+			// Create an empty file, as os.OpenFile would
+			if err := fs3.putObject(context.TODO(), p, nil, nil); err != nil {
+				return nil, err
+			}
+			f, err = newS3ReadFile(fs3, fs3.bucket, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f.name = filename
+		return f, nil
 	}
 }
 
 // Stat returns a FileInfo describing the named file.
+//
+// Objects are described using a HeadObject request. If no object exists
+// with the given name but objects exist beneath it, it is described as a
+// directory.
 func (fs3 *S3FS) Stat(filename string) (os.FileInfo, error) {
-	return nil, errors.New("not implemented")
+	// Create a context
+	ctx := context.TODO() // TODO: Get user-supplied context?
+
+	// Format the path
+	rel, err := fs3.clean(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := fs3.rootKey(rel)
+	name := rel[strings.LastIndex(rel, fs3.separator)+1:]
+	if name == "" {
+		name = "."
+	}
+
+	// Check the cache
+	if fi, ok := fs3.statCache.get(key); ok {
+		return fi, nil
+	}
+
+	// Is it a file?
+	if rel != "" {
+		in := &s3.HeadObjectInput{
+			Bucket:       &fs3.bucket,
+			Key:          &key,
+			ChecksumMode: types.ChecksumModeEnabled,
+		}
+		fs3.enc.applyHead(in)
+		res, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+		if err == nil {
+			fi := headFileInfo(name, key, res)
+			fs3.statCache.put(key, fi)
+			return fi, nil
+		}
+		if !isNotFound(err) {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+	}
+
+	// Is it a directory?
+	ok, err := fs3.isDir(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: filename, Err: os.ErrNotExist}
+	}
+	fi := newDirInfo(name)
+	fs3.statCache.put(key, fi)
+	return fi, nil
+}
+
+// isNotFound reports whether err is an S3 "not found" error.
+func isNotFound(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "NotFound", "NoSuchKey":
+			return true
+		}
+	}
+	return false
 }
 
 // Rename renames (moves) oldpath to newpath. If newpath already exists and
 // is not a directory, Rename replaces it. OS-specific restrictions may
 // apply when oldpath and newpath are in different directories.
+//
+// Objects larger than the copy threshold (see WithCopyThreshold) are
+// copied with a multipart upload. The source is read with the
+// filesystem's encryption settings, so renaming a file that was written
+// with a different SSE-C key (see WithFileEncryption) fails.
 func (fs3 *S3FS) Rename(oldpath, newpath string) error {
-	// TODO: Validate the paths?
-
 	// Create a context
 	ctx := context.TODO() // TODO: Get user-supplied context?
 
-	// Format the paths
-	src := path.Join(fs3.root, oldpath)
-	dst := path.Join(fs3.root, newpath)
+	// Describe the source and format the destination path
+	src, head, err := fs3.headObject(ctx, "rename", oldpath)
+	if err != nil {
+		return err
+	}
+	dst, err := fs3.resolve(newpath)
+	if err != nil {
+		return err
+	}
 
-	// Send the copy request
-	_, err := fs3.client.CopyObject(ctx, &s3.CopyObjectInput{
-		Bucket:     &fs3.bucket,
-		CopySource: &src,
-		Key:        &dst,
-	})
+	// Copy the object
+	err = fs3.copyKey(ctx, src, dst, head)
+	if isNotFound(err) {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to rename file: %s", err)
+		return fmt.Errorf("failed to rename file: %w", err)
 	}
 
 	// Delete the old file
@@ -101,40 +236,81 @@ func (fs3 *S3FS) Rename(oldpath, newpath string) error {
 	_, err = fs3.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: &fs3.bucket,
 		Key:    &src,
-	})
+	}, fs3.optFns...)
 	if err != nil {
-		return fmt.Errorf("failed to remove file: %s", err)
+		return fmt.Errorf("failed to remove file: %w", err)
 	}
 
+	// The cached Stat results are now out of date
+	fs3.statCache.remove(src)
+	fs3.statCache.remove(dst)
+
 	return nil
 }
 
+// copyKey copies the object described by head from src to dst, in parts
+// if it's larger than the copy threshold.
+func (fs3 *S3FS) copyKey(ctx context.Context, src, dst string, head *s3.HeadObjectOutput) error {
+	if head.ContentLength > fs3.copyThreshold {
+		return fs3.copyInParts(ctx, src, dst, head, head.Metadata)
+	}
+	in := &s3.CopyObjectInput{
+		Bucket:            &fs3.bucket,
+		CopySource:        aws.String(copySource(fs3.bucket, src)),
+		CopySourceIfMatch: head.ETag,
+		Key:               &dst,
+	}
+	fs3.enc.applyCopy(in, fs3.enc)
+	_, err := fs3.client.CopyObject(ctx, in, fs3.optFns...)
+	return err
+}
+
 // Remove removes the named file or directory.
 func (fs3 *S3FS) Remove(filename string) error {
-	// TODO: Validate the path?
-	// ...
-
 	// Create a context
 	ctx := context.TODO() // TODO: Get user-supplied context?
 
 	// Format the path
-	p := path.Join(fs3.root, filename)
+	p, err := fs3.resolve(filename)
+	if err != nil {
+		return err
+	}
 
 	// Send the request
 	// TODO: Parse the response?
-	_, err := fs3.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+	_, err = fs3.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: &fs3.bucket,
 		Key:    &p,
-	})
+	}, fs3.optFns...)
 	if err != nil {
 		return fmt.Errorf("failed to remove file: %s", err)
 	}
+
+	// The cached Stat result is now out of date
+	fs3.statCache.remove(p)
 	return nil
 }
 
-// Join joins any number of path elements into a single path
+// Join joins any number of path elements into a single path, using the
+// filesystem's separator.
 func (fs3 *S3FS) Join(elem ...string) string {
-	j := path.Join(elem...)
-	c := path.Clean(j)
-	return c
+	if fs3.separator == DefaultSeparator {
+		return path.Join(elem...)
+	}
+
+	var parts []string
+	for _, e := range elem {
+		e = strings.Trim(e, fs3.separator)
+		if e != "" {
+			parts = append(parts, e)
+		}
+	}
+	return strings.Join(parts, fs3.separator)
+}
+
+// copySource formats the CopySource parameter for a CopyObject request,
+// URL-encoding the key.
+func copySource(bucket, key string) string {
+	u := url.URL{Path: bucket + "/" + key}
+	return u.EscapedPath()
 }
@@ -0,0 +1,323 @@
+package s3fs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+)
+
+func TestCapabilities(t *testing.T) {
+	fs3 := newTestFS(t)
+	c := fs3.Capabilities()
+	if c&billy.ReadCapability == 0 || c&billy.WriteCapability == 0 {
+		t.Errorf("expected read and write capabilities, got %v", c)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello")
+
+	if got := readFile(t, fs3, "foo"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
+
+func TestCreateOverwrite(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello world")
+	writeFile(t, fs3, "foo", "bye")
+
+	if got := readFile(t, fs3, "foo"); got != "bye" {
+		t.Errorf("expected %q, got %q", "bye", got)
+	}
+}
+
+func TestCreateInSubdirectory(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/bar/baz", "hello")
+
+	if got := readFile(t, fs3, "foo/bar/baz"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
+
+func TestOpenNotExists(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	_, err := fs3.Open("not-found")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestOpenFileUnsupportedFlag(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	for _, flag := range []int{os.O_SYNC, os.O_RDWR | s3fs.O_WRMULTIPART} {
+		if _, err := fs3.OpenFile("foo", flag, 0666); err == nil {
+			t.Errorf("expected an error for unsupported flag %#x", flag)
+		}
+	}
+}
+
+func TestOpenFileReadWrite(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello world")
+
+	f, err := fs3.OpenFile("foo", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+
+	// Overwrite part of the file and read back the rest
+	if _, err := f.Seek(6, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %s", err)
+	}
+	if _, err := f.Write([]byte("there")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	buf := make([]byte, 11)
+	if n, err := f.ReadAt(buf, 0); err != nil || string(buf[:n]) != "hello there" {
+		t.Errorf("ReadAt: got %q, %v", buf[:n], err)
+	}
+
+	// Changes aren't uploaded until the file is closed
+	if err := f.Truncate(5); err != nil {
+		t.Fatalf("Truncate: %s", err)
+	}
+	if fi, err := fs3.Stat("foo"); err != nil || fi.Size() != 11 {
+		t.Errorf("Stat before Close: expected the original size, got %v, %v", fi, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "foo"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+
+	// O_APPEND writes to the end of the file
+	f, err = fs3.OpenFile("foo", os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	f.Write([]byte("!"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "foo"); got != "hello!" {
+		t.Errorf("expected %q, got %q", "hello!", got)
+	}
+}
+
+func TestOpenFileCreate(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	if _, err := fs3.OpenFile("foo", os.O_RDWR, 0666); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error without O_CREATE, got %v", err)
+	}
+
+	// O_CREATE creates the file straight away
+	f, err := fs3.OpenFile("foo", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	defer f.Close()
+	if fi, err := fs3.Stat("foo"); err != nil || fi.Size() != 0 {
+		t.Errorf("expected an empty file, got %v, %v", fi, err)
+	}
+	if _, err := fs3.OpenFile("foo", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666); !os.IsExist(err) {
+		t.Errorf("expected an exists error with O_EXCL, got %v", err)
+	}
+}
+
+func TestOpenFileShared(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	w, err := fs3.OpenFile("foo", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	r, err := fs3.Open("foo")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+
+	// Readers see writes as they're made, before the file is uploaded
+	buf := make([]byte, 5)
+	for _, s := range []string{"hello", "world"} {
+		w.Write([]byte(s))
+		if n, err := r.Read(buf); err != nil || string(buf[:n]) != s {
+			t.Errorf("Read: expected %q, got %q, %v", s, buf[:n], err)
+		}
+	}
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("Read at the end: expected io.EOF, got %v", err)
+	}
+	r.Close()
+	w.Close()
+}
+
+func TestOpenFileMultipart(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	// Write enough data for three parts
+	data := make([]byte, 2*s3fs.MinPartSize+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	f, err := fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("multipart upload contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+}
+
+func TestStat(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/bar", "hello")
+
+	fi, err := fs3.Stat("foo/bar")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Name() != "bar" || fi.Size() != 5 || fi.IsDir() {
+		t.Errorf("unexpected file info: name=%q size=%d dir=%t", fi.Name(), fi.Size(), fi.IsDir())
+	}
+
+	fi, err = fs3.Stat("foo")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Name() != "foo" || !fi.IsDir() {
+		t.Errorf("unexpected dir info: name=%q dir=%t", fi.Name(), fi.IsDir())
+	}
+}
+
+func TestStatNotExists(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	_, err := fs3.Stat("not-found")
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello")
+
+	if err := fs3.Rename("foo", "bar/baz qux"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if _, err := fs3.Stat("foo"); !os.IsNotExist(err) {
+		t.Errorf("expected old path not to exist, got %v", err)
+	}
+	if got := readFile(t, fs3, "bar/baz qux"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
+
+func TestRenameNotExists(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	if err := fs3.Rename("not-found", "bar"); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error renaming a missing file, got %v", err)
+	}
+}
+
+func TestRenameMultipartCopy(t *testing.T) {
+	client, backend := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCopyThreshold(s3fs.MinPartSize))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	data := testData(int(2*s3fs.MinPartSize) + 10)
+	writeWithOptions(t, fs3, "big", s3fs.O_WRMULTIPART, data,
+		s3fs.WithFileTags(map[string]string{"a": "1"}),
+		s3fs.WithFileMetadata(map[string]string{"owner": "me"}),
+	)
+
+	if err := fs3.Rename("big", "moved"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if _, err := fs3.Stat("big"); !os.IsNotExist(err) {
+		t.Errorf("expected old path not to exist, got %v", err)
+	}
+
+	// The object was copied in parts, keeping its metadata and tags
+	res := headObject(t, client, "moved")
+	if res.PartsCount != 3 {
+		t.Errorf("expected a copy of 3 parts, got %d", res.PartsCount)
+	}
+	if res.Metadata["owner"] != "me" {
+		t.Errorf("expected the metadata to be kept, got %v", res.Metadata)
+	}
+	tags, err := client.GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("moved"),
+	})
+	if err != nil {
+		t.Fatalf("GetObjectTagging: %s", err)
+	}
+	if len(tags.TagSet) != 1 {
+		t.Errorf("expected the tags to be kept, got %v", tags.TagSet)
+	}
+	if got := readFile(t, fs3, "moved"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	if n, err := backend.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello")
+
+	if err := fs3.Remove("foo"); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if _, err := fs3.Stat("foo"); !os.IsNotExist(err) {
+		t.Errorf("expected removed file not to exist, got %v", err)
+	}
+}
+
+func TestRemoveNotExists(t *testing.T) {
+	deviation(t, "S3 deletes are idempotent, so removing a missing file succeeds")
+}
+
+func TestJoin(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	if got := fs3.Join("foo", "bar"); got != "foo/bar" {
+		t.Errorf("expected %q, got %q", "foo/bar", got)
+	}
+}
+
+func TestPathEscape(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	_, err := fs3.Open("../foo")
+	var ee *s3fs.EscapeError
+	if !errors.As(err, &ee) || !errors.Is(err, s3fs.ErrPathEscapesRoot) {
+		t.Errorf("expected an escape error, got %v", err)
+	}
+}
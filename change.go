@@ -0,0 +1,221 @@
+// change.go implements the interface billy.Change
+
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-git/go-billy/v5"
+)
+
+// Ensure S3FS implements billy.Change
+var _ billy.Change = (*S3FS)(nil)
+
+// Chmod changes the mode of the named file to mode, stored in its metadata
+// as s3fs-fuse stores it (see WithPOSIXMetadata).
+//
+// Like SetXattr, Chmod replaces the object's metadata by copying it onto
+// itself. Its tags, headers and encryption are kept, but its ACL is reset.
+func (fs3 *S3FS) Chmod(name string, mode os.FileMode) error {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	return fs3.updateMetadata(ctx, "chmod", name, func(meta map[string]string) error {
+		meta[metaMode] = strconv.FormatUint(posixMode(mode), 10)
+		return nil
+	})
+}
+
+// Lchown changes the numeric uid and gid of the named file. S3 has no
+// symbolic links, so it's the same as Chown.
+func (fs3 *S3FS) Lchown(name string, uid, gid int) error {
+	return fs3.Chown(name, uid, gid)
+}
+
+// Chown changes the numeric uid and gid of the named file, stored in its
+// metadata the same way as Chmod stores its mode. A uid or gid of -1 means
+// to not change that value.
+func (fs3 *S3FS) Chown(name string, uid, gid int) error {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	return fs3.updateMetadata(ctx, "chown", name, func(meta map[string]string) error {
+		if uid != -1 {
+			meta[metaUID] = strconv.Itoa(uid)
+		}
+		if gid != -1 {
+			meta[metaGID] = strconv.Itoa(gid)
+		}
+		return nil
+	})
+}
+
+// Chtimes changes the access and modification times of the named file,
+// stored in its metadata the same way as Chmod stores its mode.
+func (fs3 *S3FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	return fs3.updateMetadata(ctx, "chtimes", name, func(meta map[string]string) error {
+		meta[metaAtime] = formatMtime(atime)
+		meta[metaMtime] = formatMtime(mtime)
+		return nil
+	})
+}
+
+// copyInParts copies an object too large for CopyObject from src to dst
+// with a multipart upload, giving it the metadata meta. Copying an object
+// onto itself replaces its metadata. Multipart uploads don't keep the
+// object's tags, so they're read and set again.
+func (fs3 *S3FS) copyInParts(ctx context.Context, src, dst string, head *s3.HeadObjectOutput, meta map[string]string) error {
+	tags, err := fs3.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket:    &fs3.bucket,
+		Key:       &src,
+		VersionId: head.VersionId,
+	}, fs3.optFns...)
+	if err != nil {
+		return fmt.Errorf("unable to perform GetObjectTagging operation: %w", err)
+	}
+	q := make(url.Values, len(tags.TagSet))
+	for _, t := range tags.TagSet {
+		q.Set(aws.ToString(t.Key), aws.ToString(t.Value))
+	}
+
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:             &fs3.bucket,
+		Key:                &dst,
+		Metadata:           meta,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		Expires:            head.Expires,
+		StorageClass:       head.StorageClass,
+		Tagging:            optString(q.Encode()),
+	}
+	fs3.enc.applyCreateMultipart(in)
+	fs3.file.applyCopyParts(in)
+
+	// Keep the object's own SSE-S3 or SSE-KMS settings
+	if in.ServerSideEncryption == "" && in.SSECustomerKey == nil {
+		in.ServerSideEncryption = head.ServerSideEncryption
+		in.SSEKMSKeyId = head.SSEKMSKeyId
+	}
+	res, err := fs3.client.CreateMultipartUpload(ctx, in, fs3.optFns...)
+	if err != nil {
+		return fmt.Errorf("unable to create multipart upload: %w", err)
+	}
+
+	parts, err := fs3.copyParts(ctx, src, dst, res.UploadId, head)
+	if err == nil {
+		_, err = fs3.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   &fs3.bucket,
+			Key:      &dst,
+			UploadId: res.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: parts,
+			},
+		}, fs3.optFns...)
+		if err != nil {
+			err = fmt.Errorf("unable to complete multipart upload: %w", err)
+		}
+	}
+	if err != nil {
+		_, aerr := fs3.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   &fs3.bucket,
+			Key:      &dst,
+			UploadId: res.UploadId,
+		}, fs3.optFns...)
+		if aerr != nil {
+			return fmt.Errorf("%s (unable to abort multipart upload: %w)", err, aerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// copyParts copies the object at src into the parts of a multipart upload
+// to dst, concurrently, and returns the completed parts in order. The parts are
+// the filesystem's part size, or larger if the object wouldn't fit into
+// MaxParts parts. The copy only succeeds if the object hasn't changed
+// since it was described.
+func (fs3 *S3FS) copyParts(ctx context.Context, src, dst string, uploadID *string, head *s3.HeadObjectOutput) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	size := head.ContentLength
+	partSize := fs3.partSize
+	if n := (size + MaxParts - 1) / MaxParts; n > partSize {
+		partSize = n
+	}
+
+	sem := make(chan struct{}, fs3.concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var once sync.Once
+	var firstErr error
+	var parts []types.CompletedPart
+	for pn, off := int32(1), int64(0); off < size; pn, off = pn+1, off+partSize {
+		if ctx.Err() != nil {
+			break // A part failed to copy
+		}
+		end := off + partSize
+		if end > size {
+			end = size
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(pn int32, off, end int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			in := &s3.UploadPartCopyInput{
+				Bucket:            &fs3.bucket,
+				Key:               &dst,
+				UploadId:          uploadID,
+				PartNumber:        pn,
+				CopySource:        aws.String(copySource(fs3.bucket, src)),
+				CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+				CopySourceIfMatch: head.ETag,
+			}
+			fs3.enc.applyUploadPartCopy(in, fs3.enc)
+			res, err := fs3.client.UploadPartCopy(ctx, in, fs3.optFns...)
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("unable to copy part %d: %w", pn, err)
+					cancel()
+				})
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			parts = append(parts, types.CompletedPart{
+				ETag:           res.CopyPartResult.ETag,
+				PartNumber:     pn,
+				ChecksumCRC32:  res.CopyPartResult.ChecksumCRC32,
+				ChecksumCRC32C: res.CopyPartResult.ChecksumCRC32C,
+				ChecksumSHA1:   res.CopyPartResult.ChecksumSHA1,
+				ChecksumSHA256: res.CopyPartResult.ChecksumSHA256,
+			})
+		}(pn, off, end)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	// Parts must be listed in order
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
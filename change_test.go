@@ -0,0 +1,161 @@
+package s3fs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+func TestChange(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeWithOptions(t, fs3, "page.html", s3fs.O_WRONLY, []byte("<p>hi</p>"),
+		s3fs.WithFileTags(map[string]string{"team": "web"}),
+	)
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	if err := fs3.Chmod("page.html", 0640); err != nil {
+		t.Fatalf("Chmod: %s", err)
+	}
+	if err := fs3.Chown("page.html", 1000, 100); err != nil {
+		t.Fatalf("Chown: %s", err)
+	}
+	if err := fs3.Lchown("page.html", 1001, -1); err != nil {
+		t.Fatalf("Lchown: %s", err)
+	}
+	if err := fs3.Chtimes("page.html", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+
+	fi, err := fs3.Stat("page.html")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode() != 0640 {
+		t.Errorf("expected mode %v, got %v", os.FileMode(0640), fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("expected modification time %v, got %v", mtime, fi.ModTime())
+	}
+	if obj := fi.Sys().(*s3fs.ObjectInfo); obj.UID != 1001 || obj.GID != 100 {
+		t.Errorf("expected owner 1001:100, got %d:%d", obj.UID, obj.GID)
+	}
+
+	// The object's contents, Content-Type and tags are kept
+	res := headObject(t, client, "page.html")
+	if got := aws.ToString(res.ContentType); got != "text/html; charset=utf-8" {
+		t.Errorf("expected the Content-Type to be kept, got %q", got)
+	}
+	tags, err := client.GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("page.html"),
+	})
+	if err != nil {
+		t.Fatalf("GetObjectTagging: %s", err)
+	}
+	if len(tags.TagSet) != 1 || aws.ToString(tags.TagSet[0].Value) != "web" {
+		t.Errorf("expected the tags to be kept, got %v", tags.TagSet)
+	}
+	if got := readFile(t, fs3, "page.html"); got != "<p>hi</p>" {
+		t.Errorf("expected the contents to be kept, got %q", got)
+	}
+
+	// Missing files
+	if err := fs3.Chmod("missing", 0600); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestChangeMultipartCopy(t *testing.T) {
+	client, backend := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCopyThreshold(s3fs.MinPartSize))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	data := testData(int(2*s3fs.MinPartSize) + 10)
+	writeWithOptions(t, fs3, "big", s3fs.O_WRMULTIPART, data,
+		s3fs.WithFileTags(map[string]string{"a": "1", "b": "2"}),
+		s3fs.WithFileHeaders(s3fs.Headers{ContentType: "application/x-custom"}),
+	)
+
+	if err := fs3.Chmod("big", 0600); err != nil {
+		t.Fatalf("Chmod: %s", err)
+	}
+
+	fi, err := fs3.Stat("big")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("expected mode %v, got %v", os.FileMode(0600), fi.Mode())
+	}
+
+	// The object was copied in parts, keeping its Content-Type and tags
+	res := headObject(t, client, "big")
+	if res.PartsCount != 3 {
+		t.Errorf("expected a copy of 3 parts, got %d", res.PartsCount)
+	}
+	if got := aws.ToString(res.ContentType); got != "application/x-custom" {
+		t.Errorf("expected the Content-Type to be kept, got %q", got)
+	}
+	tags, err := client.GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("big"),
+	})
+	if err != nil {
+		t.Fatalf("GetObjectTagging: %s", err)
+	}
+	if len(tags.TagSet) != 2 {
+		t.Errorf("expected the tags to be kept, got %v", tags.TagSet)
+	}
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	if n, err := backend.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+}
+
+func TestCopyThresholdOption(t *testing.T) {
+	client, _ := newTestClient(t)
+	for _, size := range []int64{s3fs.MinPartSize - 1, s3fs.DefaultCopyThreshold + 1} {
+		if _, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCopyThreshold(size)); !errors.Is(err, s3fs.ErrInvalidOption) {
+			t.Errorf("WithCopyThreshold(%d): expected ErrInvalidOption, got %v", size, err)
+		}
+	}
+}
+
+func TestSyncKeepsModTime(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	mtime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	fs3 := newTestFS(t)
+
+	if _, err := s3fs.Sync(osfs.New(dir), fs3, s3fs.SyncOptions{}); err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+	fi, err := fs3.Stat("a.txt")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("expected modification time %v, got %v", mtime, fi.ModTime())
+	}
+}
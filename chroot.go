@@ -1,26 +1,46 @@
 // chroot.go implements the interface billy.Chroot
 
-package main
+package s3fs
 
-import "github.com/go-git/go-billy/v5"
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+)
 
 // Chroot returns a new filesystem from the same type where the new root is
 // the given path. Files outside of the designated directory tree cannot be
 // accessed.
+//
+// The path is resolved relative to the current root and may not escape it.
+// If the filesystem was configured to verify chroots, the new root must be
+// an existing directory. All other settings are carried over to the new
+// filesystem.
 func (fs3 *S3FS) Chroot(path string) (billy.Filesystem, error) {
-	// TODO: Check that path is a valid subdirectory of the current root
-	// ...
-
 	// Calculate the new root
-	p := fs3.Join(fs3.root, path)
+	p, err := fs3.resolve(path)
+	if err != nil {
+		return nil, err
+	}
 
-	// Create the new S3FS with the new root directory
-	nfs := &S3FS{
-		client: fs3.client,
-		bucket: fs3.bucket,
-		root:   p,
+	// Check that the new root is a directory
+	if fs3.verifyChroot {
+		ctx := context.TODO() // TODO: Get user-supplied context?
+		ok, err := fs3.isDir(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to check chroot directory: %w", err)
+		}
+		if !ok {
+			return nil, &os.PathError{Op: "chroot", Path: path, Err: ErrNotDirectory}
+		}
 	}
-	return nfs, nil
+
+	// Create the new S3FS with the new root directory
+	nfs := *fs3
+	nfs.root = p
+	return &nfs, nil
 }
 
 // Root returns the root path of the filesystem.
@@ -0,0 +1,114 @@
+package s3fs_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+)
+
+func TestChroot(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/bar/baz", "hello")
+
+	c, err := fs3.Chroot("foo")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	if c.Root() != "foo" {
+		t.Errorf("expected root %q, got %q", "foo", c.Root())
+	}
+	if got := readFile(t, c, "bar/baz"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+
+	// Files written in the chroot are visible from the parent
+	writeFile(t, c, "qux", "bye")
+	if got := readFile(t, fs3, "foo/qux"); got != "bye" {
+		t.Errorf("expected %q, got %q", "bye", got)
+	}
+}
+
+func TestChrootNested(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "a/b/c", "hello")
+
+	c, err := fs3.Chroot("a")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	cc, err := c.Chroot("b")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	if cc.Root() != "a/b" {
+		t.Errorf("expected root %q, got %q", "a/b", cc.Root())
+	}
+	if got := readFile(t, cc, "c"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
+
+func TestChrootEscape(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "secret", "hello")
+
+	c, err := fs3.Chroot("foo")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+
+	if _, err := c.Open("../secret"); !errors.Is(err, s3fs.ErrPathEscapesRoot) {
+		t.Errorf("Open: expected an escape error, got %v", err)
+	}
+	if err := c.Rename("../secret", "x"); !errors.Is(err, s3fs.ErrPathEscapesRoot) {
+		t.Errorf("Rename: expected an escape error, got %v", err)
+	}
+	if err := c.Remove("bar/../../secret"); !errors.Is(err, s3fs.ErrPathEscapesRoot) {
+		t.Errorf("Remove: expected an escape error, got %v", err)
+	}
+	if _, err := c.Chroot(".."); !errors.Is(err, s3fs.ErrPathEscapesRoot) {
+		t.Errorf("Chroot: expected an escape error, got %v", err)
+	}
+
+	// Paths that stay inside the root are fine
+	writeFile(t, c, "bar/../baz", "bye")
+	if got := readFile(t, fs3, "foo/baz"); got != "bye" {
+		t.Errorf("expected %q, got %q", "bye", got)
+	}
+}
+
+func TestChrootVerification(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithChrootVerification(true))
+	writeFile(t, fs3, "foo/bar", "hello")
+
+	if _, err := fs3.Chroot("foo"); err != nil {
+		t.Errorf("expected chroot to existing directory to succeed, got %v", err)
+	}
+	if _, err := fs3.Chroot("missing"); !errors.Is(err, s3fs.ErrNotDirectory) {
+		t.Errorf("expected a not-a-directory error, got %v", err)
+	}
+}
+
+func TestChrootPreservesSettings(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithSeparator(":"))
+	writeFile(t, fs3, "a:b:c", "hello")
+
+	c, err := fs3.Chroot("a")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	fis, err := c.ReadDir("")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "b" || !fis[0].IsDir() {
+		t.Errorf("unexpected entries: %v", fis)
+	}
+	if got := readFile(t, c, "b:c"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+	if _, err := c.Stat("b:c"); err != nil {
+		t.Errorf("Stat: %s", err)
+	}
+}
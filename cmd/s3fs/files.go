@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/a-poor/s3fs"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// runCat prints files.
+func runCat(e *env, args []string) error {
+	fset := e.flags("cat")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		f, err := l.fs.Open(l.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, unwrapPathError(err))
+		}
+		_, err = io.Copy(e.stdout, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst. Large files are uploaded to S3
+// using multipart uploads.
+func copyFile(src, dst *location, size int64) error {
+	in, err := src.fs.Open(src.path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", src.raw, unwrapPathError(err))
+	}
+	defer in.Close()
+
+	var out billy.File
+	if dst.s3 != nil && size >= s3fs.DefaultPartSize {
+		out, err = dst.fs.OpenFile(dst.path, s3fs.O_WRMULTIPART, 0666)
+	} else {
+		out, err = dst.fs.Create(dst.path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst.raw, unwrapPathError(err))
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("copying %s to %s: %w", src.raw, dst.raw, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("%s: %w", dst.raw, err)
+	}
+	return nil
+}
+
+// copyTree copies src, which has already been described by info, to dst,
+// recursing into directories.
+func copyTree(src, dst *location, info os.FileInfo) error {
+	return walkInfo(src, "", info, func(l *location, rel string, info os.FileInfo) error {
+		target := dst
+		if rel != "" {
+			target = dst.join(rel)
+		}
+		if info.IsDir() {
+			return target.fs.MkdirAll(target.path, 0777)
+		}
+		return copyFile(l, target, info.Size())
+	})
+}
+
+// transfer is a source and destination for cp and mv.
+type transfer struct {
+	src, dst *location
+	info     os.FileInfo // Describes src
+}
+
+// transfers resolves the sources and destination of a cp or mv command.
+// If there are several sources, or the destination is a directory, each
+// source is copied into the destination directory.
+func (e *env) transfers(args []string) ([]transfer, error) {
+	if len(args) < 2 {
+		return nil, usagef("expected at least one source and a destination")
+	}
+	srcs, dstArg := args[:len(args)-1], args[len(args)-1]
+
+	dst, err := e.locate(dstArg)
+	if err != nil {
+		return nil, err
+	}
+	dstIsDir := strings.HasSuffix(dstArg, "/")
+	if info, err := dst.stat(); err == nil {
+		dstIsDir = info.IsDir()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(srcs) > 1 && !dstIsDir {
+		return nil, fmt.Errorf("%s: not a directory", dstArg)
+	}
+
+	var ts []transfer
+	for _, arg := range srcs {
+		src, err := e.locate(arg)
+		if err != nil {
+			return nil, err
+		}
+		info, err := src.stat()
+		if err != nil {
+			return nil, err
+		}
+		t := transfer{src: src, dst: dst, info: info}
+		if dstIsDir {
+			t.dst = dst.join(src.base())
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// runCp copies files.
+func runCp(e *env, args []string) error {
+	fset := e.flags("cp")
+	recursive := fset.Bool("r", false, "copy directories recursively")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+
+	ts, err := e.transfers(fset.Args())
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		if t.info.IsDir() && !*recursive {
+			return fmt.Errorf("%s: is a directory (use -r to copy it)", t.src.raw)
+		}
+		if err := copyTree(t.src, t.dst, t.info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMv moves files. Files are renamed within a bucket (or the local
+// filesystem); anything else is copied and then removed.
+func runMv(e *env, args []string) error {
+	fset := e.flags("mv")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+
+	ts, err := e.transfers(fset.Args())
+	if err != nil {
+		return err
+	}
+	for _, t := range ts {
+		local := t.src.s3 == nil && t.dst.s3 == nil
+		if local || (t.src.sameFS(t.dst) && !t.info.IsDir()) {
+			if err := t.src.fs.Rename(t.src.path, t.dst.path); err != nil {
+				return fmt.Errorf("moving %s to %s: %w", t.src.raw, t.dst.raw, err)
+			}
+			continue
+		}
+		if err := copyTree(t.src, t.dst, t.info); err != nil {
+			return err
+		}
+		if err := util.RemoveAll(t.src.fs, t.src.path); err != nil {
+			return fmt.Errorf("%s: %w", t.src.raw, err)
+		}
+	}
+	return nil
+}
+
+// runRm removes files.
+func runRm(e *env, args []string) error {
+	fset := e.flags("rm")
+	recursive := fset.Bool("r", false, "remove directories and their contents recursively")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		if l.s3 != nil && l.path == "" {
+			return fmt.Errorf("%s: refusing to remove the root of a bucket", arg)
+		}
+		info, err := l.stat()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir() && !*recursive:
+			return fmt.Errorf("%s: is a directory (use -r to remove it)", arg)
+		case info.IsDir():
+			err = util.RemoveAll(l.fs, l.path)
+		default:
+			err = l.fs.Remove(l.path)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, unwrapPathError(err))
+		}
+	}
+	return nil
+}
+
+// runMkdir creates directories.
+func runMkdir(e *env, args []string) error {
+	fset := e.flags("mkdir")
+	parents := fset.Bool("p", false, "create parent directories as needed, and don't fail if the directory exists")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		if !*parents {
+			if err := checkMkdir(l); err != nil {
+				return err
+			}
+		}
+		if err := l.fs.MkdirAll(l.path, 0777); err != nil {
+			return fmt.Errorf("%s: %w", arg, unwrapPathError(err))
+		}
+	}
+	return nil
+}
+
+// checkMkdir checks that l doesn't exist but its parent directory does.
+func checkMkdir(l *location) error {
+	if _, err := l.stat(); err == nil {
+		return fmt.Errorf("%s: %w", l.raw, fs.ErrExist)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	parent := filepath.Dir(l.path)
+	if l.s3 != nil {
+		parent = strings.TrimPrefix(path.Dir(l.path), ".")
+	}
+	info, err := l.fs.Stat(parent)
+	if err != nil {
+		return fmt.Errorf("%s: parent directory: %w", l.raw, unwrapPathError(err))
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: parent is not a directory", l.raw)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// entry describes a file or directory in command output.
+type entry struct {
+	Path    string    `json:"path"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Dir     bool      `json:"dir"`
+	ModTime time.Time `json:"modTime,omitempty"`
+}
+
+// newEntry creates an entry from a FileInfo.
+func newEntry(p string, info os.FileInfo) entry {
+	e := entry{
+		Path: p,
+		Name: info.Name(),
+		Size: info.Size(),
+		Dir:  info.IsDir(),
+	}
+	if !info.ModTime().IsZero() {
+		e.ModTime = info.ModTime().UTC()
+	}
+	return e
+}
+
+// readDir lists a directory, sorted by name.
+func readDir(l *location) ([]os.FileInfo, error) {
+	fis, err := l.fs.ReadDir(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", l.raw, unwrapPathError(err))
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+// walk calls fn for l and, if it's a directory, everything beneath it in
+// depth-first order. rel is the path relative to l ("" for l itself).
+func walk(l *location, fn func(l *location, rel string, info os.FileInfo) error) error {
+	info, err := l.stat()
+	if err != nil {
+		return err
+	}
+	return walkInfo(l, "", info, fn)
+}
+
+// walkInfo implements walk for a location that has already been described.
+func walkInfo(l *location, rel string, info os.FileInfo, fn func(l *location, rel string, info os.FileInfo) error) error {
+	if err := fn(l, rel, info); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	fis, err := readDir(l)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		crel := fi.Name()
+		if rel != "" {
+			crel = rel + "/" + fi.Name()
+		}
+		if err := walkInfo(l.join(fi.Name()), crel, fi, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLs lists directory contents.
+func runLs(e *env, args []string) error {
+	fset := e.flags("ls")
+	long := fset.Bool("l", false, "use a long listing format")
+	recursive := fset.Bool("R", false, "list subdirectories recursively")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	entries := []entry{} // Empty directories are listed as [] in JSON
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		info, err := l.stat()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			entries = append(entries, newEntry(arg, info))
+			continue
+		}
+
+		if *recursive {
+			err = walkInfo(l, "", info, func(_ *location, rel string, info os.FileInfo) error {
+				if rel != "" {
+					entries = append(entries, newEntry(rel, info))
+				}
+				return nil
+			})
+		} else {
+			var fis []os.FileInfo
+			fis, err = readDir(l)
+			for _, fi := range fis {
+				entries = append(entries, newEntry(fi.Name(), fi))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.output(entries, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+		for _, ent := range entries {
+			name := ent.Path
+			if ent.Dir {
+				name += "/"
+			}
+			if !*long {
+				fmt.Fprintln(w, name)
+				continue
+			}
+			mod := ""
+			if !ent.ModTime.IsZero() {
+				mod = ent.ModTime.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%d\t %s\t %s\n", typeChar(ent.Dir), ent.Size, mod, name)
+		}
+		tw.Flush()
+	})
+}
+
+// typeChar returns the ls -l type character for an entry.
+func typeChar(dir bool) string {
+	if dir {
+		return "d"
+	}
+	return "-"
+}
+
+// runStat describes files.
+func runStat(e *env, args []string) error {
+	fset := e.flags("stat")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	var entries []entry
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		info, err := l.stat()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, newEntry(arg, info))
+	}
+
+	return e.output(entries, func(w io.Writer) {
+		for _, ent := range entries {
+			typ := "file"
+			if ent.Dir {
+				typ = "directory"
+			}
+			fmt.Fprintf(w, "  Path: %s\n", ent.Path)
+			fmt.Fprintf(w, "  Type: %s\n", typ)
+			fmt.Fprintf(w, "  Size: %d\n", ent.Size)
+			if !ent.ModTime.IsZero() {
+				fmt.Fprintf(w, "Modify: %s\n", ent.ModTime.Format(time.RFC3339))
+			}
+		}
+	})
+}
+
+// usage is the disk usage of a path in du output.
+type usage struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
+}
+
+// runDu totals the size of files.
+func runDu(e *env, args []string) error {
+	fset := e.flags("du")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	var usages []usage
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+		u := usage{Path: arg}
+		err = walk(l, func(_ *location, _ string, info os.FileInfo) error {
+			if !info.IsDir() {
+				u.Size += info.Size()
+				u.Files++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		usages = append(usages, u)
+	}
+
+	return e.output(usages, func(w io.Writer) {
+		for _, u := range usages {
+			fmt.Fprintf(w, "%d\t%s\n", u.Size, u.Path)
+		}
+	})
+}
+
+// node is a file or directory in tree output.
+type node struct {
+	Name     string  `json:"name"`
+	Dir      bool    `json:"dir"`
+	Size     int64   `json:"size"`
+	Children []*node `json:"children,omitempty"`
+}
+
+// runTree prints directory trees.
+func runTree(e *env, args []string) error {
+	fset := e.flags("tree")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return usagef("no paths given")
+	}
+
+	var roots []*node
+	for _, arg := range fset.Args() {
+		l, err := e.locate(arg)
+		if err != nil {
+			return err
+		}
+
+		// Build the tree, tracking the current path of directories
+		var root *node
+		var stack []*node
+		err = walk(l, func(_ *location, rel string, info os.FileInfo) error {
+			n := &node{Name: info.Name(), Dir: info.IsDir(), Size: info.Size()}
+			if rel == "" {
+				n.Name = l.raw
+				root = n
+				stack = []*node{n}
+				return nil
+			}
+			depth := strings.Count(rel, "/") + 1
+			stack = stack[:depth]
+			parent := stack[depth-1]
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+
+	return e.output(roots, func(w io.Writer) {
+		for _, root := range roots {
+			fmt.Fprintln(w, root.Name)
+			printTree(w, root.Children, "")
+		}
+	})
+}
+
+// printTree prints nodes as a tree, with each line starting with prefix.
+func printTree(w io.Writer, nodes []*node, prefix string) {
+	for i, n := range nodes {
+		branch, indent := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, indent = "└── ", "    "
+		}
+		name := n.Name
+		if n.Dir {
+			name += "/"
+		}
+		fmt.Fprintln(w, prefix+branch+name)
+		printTree(w, n.Children, prefix+indent)
+	}
+}
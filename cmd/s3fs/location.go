@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/a-poor/s3fs"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// location is a file or directory named on the command line, either in S3
+// (s3://bucket/path) or on the local filesystem.
+type location struct {
+	raw  string           // As given on the command line, without any query string
+	fs   billy.Filesystem // Filesystem containing the file
+	path string           // Path within fs
+	s3   *s3fs.S3FS       // S3 filesystem, or nil for local files
+}
+
+// isS3URL reports whether raw names an S3 location.
+func isS3URL(raw string) bool {
+	return strings.HasPrefix(raw, "s3://")
+}
+
+// locate resolves a command-line argument to a location. S3 filesystems
+// are opened once per bucket (and query string) and reused.
+func (e *env) locate(raw string) (*location, error) {
+	if !isS3URL(raw) {
+		abs, err := filepath.Abs(raw)
+		if err != nil {
+			return nil, err
+		}
+		return &location{
+			raw:  raw,
+			fs:   osfs.New(string(filepath.Separator)),
+			path: abs,
+		}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, usagef("invalid S3 URL %q: %s", raw, err)
+	}
+	bucketURL := (&url.URL{Scheme: u.Scheme, Host: u.Host, RawQuery: u.RawQuery}).String()
+	fs3, ok := e.fss[bucketURL]
+	if !ok {
+		fs3, err = s3fs.NewS3FSFromURL(
+			e.ctx,
+			bucketURL,
+			s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s: %w", bucketURL, err)
+		}
+		e.fss[bucketURL] = fs3
+	}
+	return &location{
+		raw:  strings.TrimSuffix(raw, "?"+u.RawQuery),
+		fs:   fs3,
+		path: strings.Trim(u.Path, "/"),
+		s3:   fs3,
+	}, nil
+}
+
+// join returns the location of name within the directory l.
+func (l *location) join(name string) *location {
+	raw := strings.TrimSuffix(l.raw, "/") + "/" + name
+	if l.s3 == nil {
+		raw = filepath.Join(l.raw, name)
+	}
+	return &location{
+		raw:  raw,
+		fs:   l.fs,
+		path: l.fs.Join(l.path, name),
+		s3:   l.s3,
+	}
+}
+
+// base returns the last element of the location's path.
+func (l *location) base() string {
+	if l.s3 != nil {
+		return l.path[strings.LastIndex(l.path, "/")+1:]
+	}
+	return filepath.Base(l.path)
+}
+
+// stat describes the location.
+func (l *location) stat() (os.FileInfo, error) {
+	info, err := l.fs.Stat(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", l.raw, unwrapPathError(err))
+	}
+	return info, nil
+}
+
+// chroot returns a filesystem rooted at the location.
+func (l *location) chroot() (billy.Filesystem, error) {
+	if l.s3 == nil {
+		return osfs.New(l.path), nil
+	}
+	if l.path == "" {
+		return l.s3, nil
+	}
+	return l.s3.Chroot(l.path)
+}
+
+// sameFS reports whether l and o are in the same S3 bucket.
+func (l *location) sameFS(o *location) bool {
+	return l.s3 != nil && l.s3 == o.s3
+}
+
+// unwrapPathError strips an *os.PathError, whose path may be an internal
+// key or absolute path, so errors can be reported with the path the user
+// gave.
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
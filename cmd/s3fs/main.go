@@ -0,0 +1,239 @@
+// Command s3fs works with files in S3 buckets using the s3fs library.
+//
+//	s3fs [-json] <command> [flags] [args]
+//
+// S3 locations are given as URLs of the form s3://bucket/path, with the
+// same query parameters as s3fs.NewS3FSFromURL (e.g. ?region=us-west-2).
+// Anything else is a path on the local filesystem. Environment variables
+// (e.g. AWS credentials) may be set in a .env file in the working
+// directory.
+//
+// The commands are:
+//
+//	ls [-l] [-R] path...              list directory contents
+//	cat path...                       print files
+//	cp [-r] src... dst                copy files (local and S3, in any direction)
+//	mv src... dst                     move files
+//	rm [-r] path...                   remove files
+//	stat path...                      describe files
+//	mkdir [-p] path...                create directories
+//	du path...                        total the size of files
+//	tree path...                      print a directory tree
+//	sync [flags] src dst              make dst a copy of src
+//	webdav [-addr host:port] s3://... serve a bucket over WebDAV
+//	sftp [flags] s3://...             serve a bucket over SFTP
+//
+// With -json, results and errors are written as JSON for scripting. The
+// exit code is 0 on success, 1 on error, 2 for invalid usage and 3 if a
+// path doesn't exist.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/a-poor/s3fs"
+	"github.com/joho/godotenv"
+)
+
+// Exit codes
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	usage string
+	run   func(e *env, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":     {"ls [-l] [-R] path...", runLs},
+	"cat":    {"cat path...", runCat},
+	"cp":     {"cp [-r] src... dst", runCp},
+	"mv":     {"mv src... dst", runMv},
+	"rm":     {"rm [-r] path...", runRm},
+	"stat":   {"stat path...", runStat},
+	"mkdir":  {"mkdir [-p] path...", runMkdir},
+	"du":     {"du path...", runDu},
+	"tree":   {"tree path...", runTree},
+	"sync":   {"sync [-checksum] [-delete] [-dry-run] [-j n] [-include glob]... [-exclude glob]... src dst", runSync},
+	"webdav": {"webdav [-addr host:port] s3://bucket/prefix", runWebDAV},
+	"sftp":   {"sftp [-addr host:port] -host-key file -user name:authorized_keys[:root] ... s3://bucket/prefix", runSFTP},
+}
+
+// env is the environment commands run in.
+type env struct {
+	ctx    context.Context
+	stdout io.Writer
+	stderr io.Writer
+	json   bool                  // Write output as JSON?
+	usage  string                // Usage of the command being run
+	fss    map[string]*s3fs.S3FS // Filesystems opened so far, by bucket URL
+}
+
+// usageError is returned for invalid command-line usage.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// usagef returns a usageError with a formatted message.
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func main() {
+	// Load the .env file, if there is one
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "unable to load .env file: %s\n", err)
+		os.Exit(exitError)
+	}
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command line args and returns the exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	e := &env{
+		ctx:    ctx,
+		stdout: stdout,
+		stderr: stderr,
+		fss:    make(map[string]*s3fs.S3FS),
+	}
+
+	fset := flag.NewFlagSet("s3fs", flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	fset.BoolVar(&e.json, "json", false, "write output as JSON")
+	if err := fset.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(stdout)
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "s3fs: %s\n", err)
+		printUsage(stderr)
+		return exitUsage
+	}
+	if fset.NArg() == 0 {
+		printUsage(stderr)
+		return exitUsage
+	}
+
+	name := fset.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return e.fail(name, usagef("unknown command %q", name))
+	}
+	e.usage = cmd.usage
+	err := cmd.run(e, fset.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	var ue *usageError
+	if errors.As(err, &ue) {
+		ue.msg += "\nusage: s3fs " + cmd.usage
+	}
+	return e.fail(name, err)
+}
+
+// fail reports err (if any) and returns the matching exit code.
+func (e *env) fail(name string, err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	code := exitError
+	var ue *usageError
+	switch {
+	case errors.As(err, &ue):
+		code = exitUsage
+	case errors.Is(err, fs.ErrNotExist):
+		code = exitNotFound
+	}
+
+	if e.json {
+		json.NewEncoder(e.stderr).Encode(struct {
+			Error string `json:"error"`
+			Code  int    `json:"code"`
+		}{err.Error(), code})
+	} else {
+		fmt.Fprintf(e.stderr, "s3fs %s: %s\n", name, err)
+	}
+	return code
+}
+
+// stringList collects the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// flags creates a FlagSet for the named command. Errors are reported by
+// parse rather than by the FlagSet itself.
+func (e *env) flags(name string) *flag.FlagSet {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	return fset
+}
+
+// parse parses args with fset, printing help if requested and turning
+// flag errors into usage errors.
+func (e *env) parse(fset *flag.FlagSet, args []string) error {
+	err := fset.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(e.stdout, "usage: s3fs %s\n", e.usage)
+		fset.SetOutput(e.stdout)
+		fset.PrintDefaults()
+		return err
+	}
+	if err != nil {
+		return &usageError{msg: err.Error()}
+	}
+	return nil
+}
+
+// output writes v as JSON if JSON output is enabled, otherwise calling
+// text to write it as text.
+func (e *env) output(v interface{}, text func(w io.Writer)) error {
+	if e.json {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	text(e.stdout)
+	return nil
+}
+
+// printUsage prints the list of commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: s3fs [-json] <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/a-poor/s3fs/s3mem"
+)
+
+// newTestBucket starts an in-process S3 server and returns a function
+// that builds s3:// URLs for paths in its bucket.
+func newTestBucket(t *testing.T) func(p string) string {
+	t.Helper()
+
+	backend := s3mem.New()
+	backend.CreateBucket("test-bucket")
+	srv := httptest.NewServer(backend.Handler())
+	t.Cleanup(srv.Close)
+
+	// Don't pick up the user's AWS configuration
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	q := url.Values{
+		"endpoint":   {srv.URL},
+		"path_style": {"true"},
+		"region":     {"us-east-1"},
+	}
+	return func(p string) string {
+		return "s3://test-bucket/" + p + "?" + q.Encode()
+	}
+}
+
+// s3fsCmd runs the CLI and returns its exit code and output.
+func s3fsCmd(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// mustRun runs the CLI, failing the test if it doesn't succeed.
+func mustRun(t *testing.T, args ...string) string {
+	t.Helper()
+
+	code, stdout, stderr := s3fsCmd(t, args...)
+	if code != exitOK {
+		t.Fatalf("s3fs %s: exit code %d: %s", strings.Join(args, " "), code, stderr)
+	}
+	return stdout
+}
+
+func TestCopyAndCat(t *testing.T) {
+	s3url := newTestBucket(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "hello.txt")
+	if err := ioutil.WriteFile(local, []byte("Hello, World!"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// local -> S3 -> S3 -> local
+	mustRun(t, "cp", local, s3url("a/hello.txt"))
+	mustRun(t, "cp", s3url("a/hello.txt"), s3url("b/hello.txt"))
+	mustRun(t, "cp", s3url("b/hello.txt"), filepath.Join(dir, "back.txt"))
+
+	if got := mustRun(t, "cat", s3url("b/hello.txt")); got != "Hello, World!" {
+		t.Errorf("cat: expected %q, got %q", "Hello, World!", got)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "back.txt"))
+	if err != nil || string(b) != "Hello, World!" {
+		t.Errorf("expected the round-tripped file to contain %q, got %q (%v)", "Hello, World!", b, err)
+	}
+
+	// Recursive copies need -r
+	if code, _, _ := s3fsCmd(t, "cp", s3url("a"), filepath.Join(dir, "a")); code != exitError {
+		t.Errorf("cp of a directory without -r: expected exit code %d, got %d", exitError, code)
+	}
+	mustRun(t, "cp", "-r", s3url("a"), dir+"/")
+	if _, err := os.Stat(filepath.Join(dir, "a", "hello.txt")); err != nil {
+		t.Errorf("expected cp -r to copy the directory: %s", err)
+	}
+}
+
+func TestListing(t *testing.T) {
+	s3url := newTestBucket(t)
+	dir := t.TempDir()
+	for _, name := range []string{"one.txt", "sub/two.txt", "sub/deep/three.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0777)
+		if err := ioutil.WriteFile(p, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, "cp", "-r", dir, s3url("data"))
+
+	if got, want := mustRun(t, "ls", s3url("data")), "one.txt\nsub/\n"; got != want {
+		t.Errorf("ls: expected %q, got %q", want, got)
+	}
+
+	var entries []entry
+	if err := json.Unmarshal([]byte(mustRun(t, "-json", "ls", "-R", s3url("data"))), &entries); err != nil {
+		t.Fatalf("ls -R: invalid JSON: %s", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	if got, want := strings.Join(paths, ","), "one.txt,sub,sub/deep,sub/deep/three.txt,sub/two.txt"; got != want {
+		t.Errorf("ls -R: expected paths %s, got %s", want, got)
+	}
+
+	var usages []usage
+	if err := json.Unmarshal([]byte(mustRun(t, "-json", "du", s3url("data"))), &usages); err != nil {
+		t.Fatalf("du: invalid JSON: %s", err)
+	}
+	if len(usages) != 1 || usages[0].Files != 3 || usages[0].Size != int64(len("one.txtsub/two.txtsub/deep/three.txt")) {
+		t.Errorf("du: unexpected result %+v", usages)
+	}
+
+	want := "s3://test-bucket/data\n├── one.txt\n└── sub/\n    ├── deep/\n    │   └── three.txt\n    └── two.txt\n"
+	if got := mustRun(t, "tree", s3url("data")); got != want {
+		t.Errorf("tree: expected\n%s\ngot\n%s", want, got)
+	}
+
+	var stats []entry
+	if err := json.Unmarshal([]byte(mustRun(t, "-json", "stat", s3url("data/one.txt"))), &stats); err != nil {
+		t.Fatalf("stat: invalid JSON: %s", err)
+	}
+	if len(stats) != 1 || stats[0].Dir || stats[0].Size != int64(len("one.txt")) {
+		t.Errorf("stat: unexpected result %+v", stats)
+	}
+}
+
+func TestMoveRemoveMkdir(t *testing.T) {
+	s3url := newTestBucket(t)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "f.txt")
+	if err := ioutil.WriteFile(local, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, "cp", local, s3url("x/f.txt"))
+
+	mustRun(t, "mv", s3url("x/f.txt"), s3url("y/g.txt"))
+	if code, _, _ := s3fsCmd(t, "stat", s3url("x/f.txt")); code != exitNotFound {
+		t.Errorf("stat after mv: expected exit code %d, got %d", exitNotFound, code)
+	}
+	mustRun(t, "mv", s3url("y"), s3url("z"))
+	if got := mustRun(t, "cat", s3url("z/g.txt")); got != "data" {
+		t.Errorf("cat after mv: expected %q, got %q", "data", got)
+	}
+
+	if code, _, _ := s3fsCmd(t, "rm", s3url("z")); code != exitError {
+		t.Errorf("rm of a directory without -r: expected exit code %d, got %d", exitError, code)
+	}
+	mustRun(t, "rm", "-r", s3url("z"))
+	if code, _, _ := s3fsCmd(t, "ls", s3url("z")); code != exitNotFound {
+		t.Errorf("ls after rm -r: expected exit code %d, got %d", exitNotFound, code)
+	}
+
+	if code, _, _ := s3fsCmd(t, "mkdir", s3url("p/q")); code != exitNotFound {
+		t.Errorf("mkdir without a parent: expected exit code %d, got %d", exitNotFound, code)
+	}
+	mustRun(t, "mkdir", "-p", s3url("p/q"))
+	mustRun(t, "mkdir", s3url("p/r"))
+	if got := mustRun(t, "ls", s3url("p")); got != "q/\nr/\n" {
+		t.Errorf("ls after mkdir: expected %q, got %q", "q/\nr/\n", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	s3url := newTestBucket(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.log", "sub/c.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0777)
+		if err := ioutil.WriteFile(p, []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustRun(t, "cp", dir+"/a.txt", s3url("mirror/extra.txt"))
+
+	// Directories are created first, then files are copied in order
+	got := mustRun(t, "sync", "-exclude", "*.log", "-j", "1", dir, s3url("mirror"))
+	want := "mkdir sub\ncopy a.txt\ncopy sub/c.txt\ncopied 2 files (14 bytes), created 1 directories, deleted 0, 0 up to date\n"
+	if got != want {
+		t.Errorf("sync: expected %q, got %q", want, got)
+	}
+	if got := mustRun(t, "cat", s3url("mirror/sub/c.txt")); got != "sub/c.txt" {
+		t.Errorf("cat after sync: expected %q, got %q", "sub/c.txt", got)
+	}
+
+	// A dry run with -delete only reports the extraneous file
+	var res struct {
+		Actions []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		} `json:"actions"`
+		DryRun bool `json:"dryRun"`
+	}
+	out := mustRun(t, "-json", "sync", "-exclude", "*.log", "-delete", "-dry-run", dir, s3url("mirror"))
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatalf("sync: invalid JSON: %s", err)
+	}
+	if !res.DryRun || len(res.Actions) != 1 || res.Actions[0].Op != "delete" || res.Actions[0].Path != "extra.txt" {
+		t.Errorf("sync -dry-run: unexpected result %s", out)
+	}
+	if got := mustRun(t, "cat", s3url("mirror/extra.txt")); got != "a.txt" {
+		t.Errorf("expected the dry run to keep extra.txt, got %q", got)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	s3url := newTestBucket(t)
+
+	if code, _, _ := s3fsCmd(t); code != exitUsage {
+		t.Errorf("no command: expected exit code %d, got %d", exitUsage, code)
+	}
+	if code, _, _ := s3fsCmd(t, "frobnicate"); code != exitUsage {
+		t.Errorf("unknown command: expected exit code %d, got %d", exitUsage, code)
+	}
+	if code, _, _ := s3fsCmd(t, "ls", "-x", s3url("")); code != exitUsage {
+		t.Errorf("unknown flag: expected exit code %d, got %d", exitUsage, code)
+	}
+
+	code, _, stderr := s3fsCmd(t, "-json", "cat", s3url("missing.txt"))
+	if code != exitNotFound {
+		t.Errorf("missing file: expected exit code %d, got %d", exitNotFound, code)
+	}
+	var res struct {
+		Error string `json:"error"`
+		Code  int    `json:"code"`
+	}
+	if err := json.Unmarshal([]byte(stderr), &res); err != nil || res.Code != exitNotFound || res.Error == "" {
+		t.Errorf("expected a JSON error on stderr, got %q (%v)", stderr, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/a-poor/s3fs"
+	"golang.org/x/crypto/ssh"
+)
+
+// runSFTP serves a bucket (or a prefix within it) over SFTP:
+//
+//	s3fs sftp [-addr localhost:2022] -host-key ssh_host_ed25519_key \
+//		-user name:authorized_keys[:root] ... s3://bucket/prefix
+//
+// Each -user flag adds a user who can log in with the keys in the given
+// authorized_keys file, chrooted to root (if set).
+func runSFTP(e *env, args []string) error {
+	var users stringList
+	fset := e.flags("sftp")
+	addr := fset.String("addr", "localhost:2022", "address to listen on")
+	hostKeyPath := fset.String("host-key", "", "path to the server's private host key")
+	fset.Var(&users, "user", "user to allow, as name:authorized_keys[:root] (repeatable)")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 || *hostKeyPath == "" || len(users) == 0 {
+		return usagef("expected a host key, at least one user and a single S3 URL")
+	}
+	rawURL := fset.Arg(0)
+
+	// Load the host key
+	pem, err := ioutil.ReadFile(*hostKeyPath)
+	if err != nil {
+		return fmt.Errorf("unable to read host key: %w", err)
+	}
+	hostKey, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return fmt.Errorf("unable to parse host key: %w", err)
+	}
+
+	// Load the users
+	var sftpUsers []s3fs.SFTPUser
+	for _, u := range users {
+		parts := strings.SplitN(u, ":", 3)
+		if len(parts) < 2 {
+			return fmt.Errorf("invalid user %q: expected name:authorized_keys[:root]", u)
+		}
+		data, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return fmt.Errorf("unable to read authorized keys for %q: %w", parts[0], err)
+		}
+		keys, err := s3fs.ParseAuthorizedKeys(data)
+		if err != nil {
+			return fmt.Errorf("unable to parse authorized keys for %q: %w", parts[0], err)
+		}
+		su := s3fs.SFTPUser{Name: parts[0], AuthorizedKeys: keys}
+		if len(parts) == 3 {
+			su.Root = parts[2]
+		}
+		sftpUsers = append(sftpUsers, su)
+	}
+
+	fs3, err := s3fs.NewS3FSFromURL(
+		e.ctx,
+		rawURL,
+		s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", rawURL, err)
+	}
+
+	srv, err := s3fs.NewSFTPServer(fs3, hostKey, sftpUsers)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving %s over SFTP at %s", rawURL, *addr)
+	return srv.ListenAndServe(*addr)
+}
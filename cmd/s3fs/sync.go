@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/a-poor/s3fs"
+)
+
+// runSync makes one directory a copy of another, copying only the files
+// that have changed:
+//
+//	s3fs sync [-checksum] [-delete] [-dry-run] [-j n] \
+//		[-include glob]... [-exclude glob]... src dst
+//
+// Either side may be local or in S3. Changes are printed as they're made,
+// or with -json, listed along with totals once the sync is done.
+func runSync(e *env, args []string) error {
+	var include, exclude stringList
+	fset := e.flags("sync")
+	checksum := fset.Bool("checksum", false, "compare files by checksum rather than size and modification time")
+	del := fset.Bool("delete", false, "delete files from dst that aren't in src")
+	dryRun := fset.Bool("dry-run", false, "show what would be changed without changing anything")
+	concurrency := fset.Int("j", s3fs.DefaultSyncConcurrency, "number of files to copy at once")
+	noServerCopy := fset.Bool("no-server-copy", false, "stream S3 to S3 copies through this machine rather than copying server-side")
+	fset.Var(&include, "include", "only sync files matching the glob (repeatable)")
+	fset.Var(&exclude, "exclude", "skip files and directories matching the glob (repeatable)")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() != 2 {
+		return usagef("expected a source and a destination")
+	}
+
+	// Both sides are directories
+	src, err := e.locate(fset.Arg(0))
+	if err != nil {
+		return err
+	}
+	info, err := src.stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", src.raw)
+	}
+	dst, err := e.locate(fset.Arg(1))
+	if err != nil {
+		return err
+	}
+	if info, err := dst.stat(); err == nil && !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", dst.raw)
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	srcFS, err := src.chroot()
+	if err != nil {
+		return fmt.Errorf("%s: %w", src.raw, err)
+	}
+	dstFS, err := dst.chroot()
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst.raw, err)
+	}
+
+	actions := []s3fs.SyncAction{}
+	stats, err := s3fs.Sync(srcFS, dstFS, s3fs.SyncOptions{
+		Checksum:              *checksum,
+		Delete:                *del,
+		DryRun:                *dryRun,
+		Include:               include,
+		Exclude:               exclude,
+		Concurrency:           *concurrency,
+		DisableServerSideCopy: *noServerCopy,
+		Report: func(a s3fs.SyncAction) {
+			if e.json {
+				actions = append(actions, a)
+			} else {
+				fmt.Fprintf(e.stdout, "%s %s\n", a.Op, a.Path)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.output(struct {
+		Actions []s3fs.SyncAction `json:"actions"`
+		Stats   s3fs.SyncStats    `json:"stats"`
+		DryRun  bool              `json:"dryRun"`
+	}{actions, stats, *dryRun}, func(w io.Writer) {
+		prefix := ""
+		if *dryRun {
+			prefix = "(dry run) "
+		}
+		fmt.Fprintf(w, "%scopied %d files (%d bytes), created %d directories, deleted %d, %d up to date\n",
+			prefix, stats.Copied, stats.Bytes, stats.Created, stats.Deleted, stats.Skipped)
+	})
+}
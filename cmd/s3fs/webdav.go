@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/a-poor/s3fs"
+	"golang.org/x/net/webdav"
+)
+
+// runWebDAV serves a bucket (or a prefix within it) over WebDAV:
+//
+//	s3fs webdav [-addr localhost:8080] s3://bucket/prefix
+//
+// Directories are created with trailing-slash marker objects, so empty
+// folders made by WebDAV clients are visible to other S3 tools. Locks are
+// held in memory, so they only coordinate clients of this server.
+func runWebDAV(e *env, args []string) error {
+	fset := e.flags("webdav")
+	addr := fset.String("addr", "localhost:8080", "address to listen on")
+	if err := e.parse(fset, args); err != nil {
+		return err
+	}
+	if fset.NArg() != 1 {
+		return usagef("expected a single S3 URL")
+	}
+	rawURL := fset.Arg(0)
+
+	fs3, err := s3fs.NewS3FSFromURL(
+		e.ctx,
+		rawURL,
+		s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", rawURL, err)
+	}
+
+	h := &webdav.Handler{
+		FileSystem: fs3.WebDAV(),
+		LockSystem: fs3.WebDAVLockSystem(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Printf("%s %s: %s", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	log.Printf("serving %s over WebDAV at http://%s/", rawURL, *addr)
+	return http.ListenAndServe(*addr, h)
+}
@@ -0,0 +1,429 @@
+// compress.go implements transparent compression
+
+package s3fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the compression applied to files when they're written.
+type Compression int
+
+const (
+	CompressionNone Compression = iota // Files are stored as they are
+	CompressionGzip                    // Files are compressed with gzip
+	CompressionZstd                    // Files are compressed with zstd, using the seekable format
+)
+
+const (
+	ZstdFrameSize = 1024 * 1024 // Uncompressed size of each frame of a seekable zstd file
+)
+
+// Object metadata written by compression
+const (
+	metaUncompressedSize = "s3fs-uncompressed-size" // Size of the uncompressed contents
+
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// Seekable zstd format, from https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	zstdSkippableMagic = 0x184D2A5E // Magic number of the skippable frame holding the seek table
+	zstdSeekableMagic  = 0x8F92EAB1 // Magic number at the end of the seek table
+	zstdSeekFooterSize = 9          // Size of the seek table footer
+	zstdChecksumFlag   = 1 << 7     // Seek table descriptor flag for entries with checksums
+
+	maxZstdFrameSize = 64 * 1024 * 1024 // Largest uncompressed frame that's decompressed in one go
+)
+
+var (
+	ErrDecompress = errors.New("unable to decompress object")
+)
+
+// contentEncoding returns the Content-Encoding of compressed files.
+func (c Compression) contentEncoding() string {
+	switch c {
+	case CompressionGzip:
+		return encodingGzip
+	case CompressionZstd:
+		return encodingZstd
+	}
+	return ""
+}
+
+// zstd encoders and decoders are expensive to create, but safe for
+// concurrent use with EncodeAll and DecodeAll, so they're shared.
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+
+	zstdFrameOnce    sync.Once
+	zstdFrameDecoder *zstd.Decoder
+)
+
+// zstdCodecs returns the shared zstd encoder and decoder.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// zstdDecodeFrame decompresses a frame, or a series of frames, of no more
+// than maxZstdFrameSize bytes uncompressed. Sizes claimed by the frames
+// aren't trusted beyond that, so corrupt data can't make it allocate
+// without bound.
+func zstdDecodeFrame(b []byte) ([]byte, error) {
+	zstdFrameOnce.Do(func() {
+		zstdFrameDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxZstdFrameSize))
+	})
+	return zstdFrameDecoder.DecodeAll(b, nil)
+}
+
+// newWriter returns a writer that compresses to w. It must be
+// closed to finish the compressed stream.
+func (c Compression) newWriter(w io.Writer) io.WriteCloser {
+	switch c {
+	case CompressionGzip:
+		return gzip.NewWriter(w)
+	case CompressionZstd:
+		return &seekableWriter{w: w}
+	}
+	return nopWriteCloser{w}
+}
+
+// nopWriteCloser adds a no-op Close method to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close implements io.Closer.
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// objectWriter compresses and/or encrypts the data written to it.
+type objectWriter struct {
+	w       io.Writer   // First writer in the chain
+	closers []io.Closer // Closed in order, to flush each layer
+}
+
+// newObjectWriter returns a writer that compresses data with the
+// filesystem's compression and then encrypts it with c (if not nil),
+// writing the result to w.
+func (fs3 *S3FS) newObjectWriter(w io.Writer, c *chunkCipher) *objectWriter {
+	ow := &objectWriter{w: w}
+	if c != nil {
+		ew := c.newWriter(ow.w)
+		ow.w = ew
+		ow.closers = append(ow.closers, ew)
+	}
+	if fs3.compression != CompressionNone {
+		cw := fs3.compression.newWriter(ow.w)
+		ow.w = cw
+		ow.closers = append([]io.Closer{cw}, ow.closers...)
+	}
+	return ow
+}
+
+// Write implements io.Writer.
+func (ow *objectWriter) Write(p []byte) (int, error) {
+	return ow.w.Write(p)
+}
+
+// Close flushes each layer of the chain.
+func (ow *objectWriter) Close() error {
+	for _, c := range ow.closers {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressObject compresses data, returning the compressed data and the
+// metadata to store with it.
+func (c Compression) compressObject(data []byte) ([]byte, map[string]string) {
+	var buf bytes.Buffer
+	w := c.newWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes(), map[string]string{
+		metaUncompressedSize: strconv.Itoa(len(data)),
+	}
+}
+
+// seekableWriter writes the zstd seekable format: the data is compressed
+// in independent frames of ZstdFrameSize, followed by a seek table
+// recording the size of each frame, so that any range can be decompressed
+// without the rest of the file. Other zstd decoders skip the seek table.
+type seekableWriter struct {
+	w      io.Writer
+	buf    []byte      // Data not yet compressed
+	frames [][2]uint32 // Compressed and uncompressed size of each frame
+	out    []byte      // Reused buffer for the compressed frame
+}
+
+// Write implements io.Writer.
+func (s *seekableWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for len(s.buf) >= ZstdFrameSize {
+		if err := s.flush(s.buf[:ZstdFrameSize]); err != nil {
+			return 0, err
+		}
+		s.buf = append(s.buf[:0], s.buf[ZstdFrameSize:]...)
+	}
+	return len(p), nil
+}
+
+// flush compresses and writes a frame.
+func (s *seekableWriter) flush(p []byte) error {
+	enc, _ := zstdCodecs()
+	s.out = enc.EncodeAll(p, s.out[:0])
+	s.frames = append(s.frames, [2]uint32{uint32(len(s.out)), uint32(len(p))})
+	_, err := s.w.Write(s.out)
+	return err
+}
+
+// Close writes the last frame and the seek table.
+func (s *seekableWriter) Close() error {
+	if len(s.buf) > 0 {
+		if err := s.flush(s.buf); err != nil {
+			return err
+		}
+		s.buf = nil
+	}
+
+	table := make([]byte, 8+8*len(s.frames)+zstdSeekFooterSize)
+	binary.LittleEndian.PutUint32(table[0:], zstdSkippableMagic)
+	binary.LittleEndian.PutUint32(table[4:], uint32(len(table)-8))
+	for i, f := range s.frames {
+		binary.LittleEndian.PutUint32(table[8+8*i:], f[0])
+		binary.LittleEndian.PutUint32(table[12+8*i:], f[1])
+	}
+	footer := table[len(table)-zstdSeekFooterSize:]
+	binary.LittleEndian.PutUint32(footer[0:], uint32(len(s.frames)))
+	footer[4] = 0 // No checksums
+	binary.LittleEndian.PutUint32(footer[5:], zstdSeekableMagic)
+	_, err := s.w.Write(table)
+	return err
+}
+
+// zstdFrame is an entry in a seek table.
+type zstdFrame struct {
+	off  int64 // Offset of the compressed frame
+	size int64 // Size of the compressed frame
+	doff int64 // Offset of the frame's uncompressed data
+	dlen int64 // Size of the frame's uncompressed data
+}
+
+// seekableReader decompresses a seekable zstd file. It implements
+// io.ReaderAt, decompressing only the frames needed for each read.
+type seekableReader struct {
+	r      io.ReaderAt
+	frames []zstdFrame
+	size   int64 // Uncompressed size
+
+	mu    sync.Mutex // Guards the last decompressed frame
+	last  int        // Index of the last decompressed frame
+	ldata []byte     // Its uncompressed data
+}
+
+// newSeekableReader reads the seek table at the end of r, returning nil if
+// there isn't one.
+func newSeekableReader(r io.ReaderAt, size int64) (*seekableReader, error) {
+	footer := make([]byte, zstdSeekFooterSize)
+	if size < 8+zstdSeekFooterSize {
+		return nil, nil
+	}
+	if _, err := readFullAt(r, footer, size-zstdSeekFooterSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekableMagic {
+		return nil, nil
+	}
+	n := int64(binary.LittleEndian.Uint32(footer))
+	entry := int64(8)
+	if footer[4]&zstdChecksumFlag != 0 {
+		entry = 12
+	}
+
+	tableSize := 8 + n*entry + zstdSeekFooterSize
+	if tableSize > size {
+		return nil, fmt.Errorf("%w: invalid seek table", ErrDecompress)
+	}
+	table := make([]byte, tableSize)
+	if _, err := readFullAt(r, table, size-tableSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(table) != zstdSkippableMagic {
+		return nil, fmt.Errorf("%w: invalid seek table", ErrDecompress)
+	}
+
+	s := &seekableReader{r: r, frames: make([]zstdFrame, n), last: -1}
+	var off int64
+	for i := range s.frames {
+		e := table[8+int64(i)*entry:]
+		f := zstdFrame{
+			off:  off,
+			size: int64(binary.LittleEndian.Uint32(e)),
+			doff: s.size,
+			dlen: int64(binary.LittleEndian.Uint32(e[4:])),
+		}
+		if f.dlen > maxZstdFrameSize {
+			return nil, fmt.Errorf("%w: frame too large", ErrDecompress)
+		}
+		s.frames[i] = f
+		off += f.size
+		s.size += f.dlen
+	}
+	if off != size-tableSize {
+		return nil, fmt.Errorf("%w: seek table doesn't match the file", ErrDecompress)
+	}
+	return s, nil
+}
+
+// frame returns the uncompressed data of the i'th frame.
+func (s *seekableReader) frame(i int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i == s.last {
+		return s.ldata, nil
+	}
+	f := s.frames[i]
+	buf := make([]byte, f.size)
+	if _, err := readFullAt(s.r, buf, f.off); err != nil {
+		return nil, err
+	}
+	data, err := zstdDecodeFrame(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecompress, err)
+	}
+	if int64(len(data)) != f.dlen {
+		return nil, fmt.Errorf("%w: frame doesn't match the seek table", ErrDecompress)
+	}
+	s.last, s.ldata = i, data
+	return data, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *seekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+
+	// Find the first frame
+	i := sort.Search(len(s.frames), func(i int) bool {
+		return s.frames[i].doff+s.frames[i].dlen > off
+	})
+
+	n := 0
+	for ; n < len(p) && i < len(s.frames); i++ {
+		data, err := s.frame(i)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[off+int64(n)-s.frames[i].doff:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readFullAt reads exactly len(p) bytes from r at off.
+func readFullAt(r io.ReaderAt, p []byte, off int64) (int, error) {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return n, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// decompressBody returns a reader for the uncompressed contents of an
+// object with the given Content-Encoding. Objects with other encodings
+// are read as they are.
+func decompressBody(encoding string, r readSeekerAt, size int64) (readSeekerAt, int64, error) {
+	switch encoding {
+	case encodingGzip:
+		zr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDecompress, err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDecompress, err)
+		}
+		return bytes.NewReader(data), int64(len(data)), nil
+
+	case encodingZstd:
+		s, err := newSeekableReader(r, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if s != nil {
+			return io.NewSectionReader(s, 0, s.size), s.size, nil
+		}
+
+		// Not seekable, so decompress the whole file
+		buf, err := ioutil.ReadAll(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, 0, err
+		}
+		_, dec := zstdCodecs()
+		data, err := dec.DecodeAll(buf, nil)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s", ErrDecompress, err)
+		}
+		return bytes.NewReader(data), int64(len(data)), nil
+	}
+	return r, size, nil
+}
+
+// logicalSize returns the size of an object's contents, given its size in
+// S3 and its metadata, accounting for compression and encryption.
+func logicalSize(meta map[string]string, size int64) int64 {
+	if n, err := strconv.ParseInt(meta[metaUncompressedSize], 10, 64); err == nil {
+		return n
+	}
+	return plaintextSize(meta, size)
+}
+
+// mergeMetadata returns the union of two metadata maps.
+func mergeMetadata(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
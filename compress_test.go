@@ -0,0 +1,263 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// compressibleData returns n bytes of test data that compresses well.
+func compressibleData(n int) []byte {
+	return bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), n/44+1)[:n]
+}
+
+func TestCompression(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		compression s3fs.Compression
+		encoding    string
+	}{
+		{"gzip", s3fs.CompressionGzip, "gzip"},
+		{"zstd", s3fs.CompressionZstd, "zstd"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, _ := newTestClient(t)
+			fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCompression(tc.compression))
+			if err != nil {
+				t.Fatalf("NewS3FS: %s", err)
+			}
+
+			data := compressibleData(100000)
+			writeFile(t, fs3, "data.txt", string(data))
+			writeFile(t, fs3, "empty", "")
+
+			// The object is stored compressed, with its encoding
+			head := headObject(t, client, "data.txt")
+			if got := aws.ToString(head.ContentEncoding); got != tc.encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tc.encoding, got)
+			}
+			if head.ContentLength >= int64(len(data)) {
+				t.Errorf("expected the object to be compressed, got %d bytes", head.ContentLength)
+			}
+
+			// Stat reports the uncompressed size
+			fi, err := fs3.Stat("data.txt")
+			if err != nil {
+				t.Fatalf("Stat: %s", err)
+			}
+			if fi.Size() != int64(len(data)) {
+				t.Errorf("expected size %d, got %d", len(data), fi.Size())
+			}
+
+			// Reads are decompressed
+			if got := readFile(t, fs3, "data.txt"); got != string(data) {
+				t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+			}
+			if got := readFile(t, fs3, "empty"); got != "" {
+				t.Errorf("expected an empty file, got %q", got)
+			}
+
+			// Appending keeps the file compressed
+			rw, err := fs3.OpenFile("data.txt", s3fs.O_APPEND, 0666)
+			if err != nil {
+				t.Fatalf("OpenFile(O_APPEND): %s", err)
+			}
+			if _, err := rw.Write([]byte("more")); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+			if err := rw.Close(); err != nil {
+				t.Fatalf("Close: %s", err)
+			}
+			if got := readFile(t, fs3, "data.txt"); got != string(data)+"more" {
+				t.Errorf("unexpected contents after append (got %d bytes)", len(got))
+			}
+
+			// Uncompressed objects can still be read
+			plain, err := s3fs.NewS3FS(client, testBucket)
+			if err != nil {
+				t.Fatalf("NewS3FS: %s", err)
+			}
+			writeFile(t, plain, "plain.txt", "plain")
+			if got := readFile(t, fs3, "plain.txt"); got != "plain" {
+				t.Errorf("expected %q, got %q", "plain", got)
+			}
+		})
+	}
+}
+
+func TestCompressionSeekable(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCompression(s3fs.CompressionZstd))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	data := testData(3*s3fs.ZstdFrameSize + 100)
+	writeFile(t, fs3, "data.bin", string(data))
+
+	f, err := fs3.Open("data.bin")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	// Reads within and across frames
+	for _, off := range []int64{0, 10, s3fs.ZstdFrameSize - 10, 2*s3fs.ZstdFrameSize + 5, int64(len(data)) - 50} {
+		p := make([]byte, 50)
+		if _, err := f.ReadAt(p, off); err != nil {
+			t.Fatalf("ReadAt(%d): %s", off, err)
+		}
+		if !bytes.Equal(p, data[off:off+50]) {
+			t.Errorf("ReadAt(%d) returned the wrong data", off)
+		}
+	}
+}
+
+func TestCompressionSeekableCorrupt(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCompression(s3fs.CompressionZstd))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeFile(t, fs3, "data.bin", string(compressibleData(1000)))
+	res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("data.bin"),
+	})
+	if err != nil {
+		t.Fatalf("GetObject: %s", err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+
+	// Seek tables that claim frames are shorter, longer or far larger
+	// than they are
+	for _, dlen := range []uint32{900, 1100, 1 << 31} {
+		corrupt := append([]byte(nil), body...)
+		binary.LittleEndian.PutUint32(corrupt[len(corrupt)-13:], dlen)
+		_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket:          aws.String(testBucket),
+			Key:             aws.String("data.bin"),
+			Body:            bytes.NewReader(corrupt),
+			ContentEncoding: aws.String("zstd"),
+		})
+		if err != nil {
+			t.Fatalf("PutObject: %s", err)
+		}
+
+		f, err := fs3.Open("data.bin")
+		if err == nil {
+			_, err = ioutil.ReadAll(f)
+			f.Close()
+		}
+		if !errors.Is(err, s3fs.ErrDecompress) {
+			t.Errorf("%d: expected ErrDecompress, got %v", dlen, err)
+		}
+	}
+}
+
+func TestCompressionMultipart(t *testing.T) {
+	client, _ := newTestClient(t)
+	keys, err := s3fs.NewAESKeyProvider(bytes.Repeat([]byte{1}, s3fs.DataKeySize))
+	if err != nil {
+		t.Fatalf("NewAESKeyProvider: %s", err)
+	}
+
+	for name, opts := range map[string][]s3fs.Option{
+		"compressed": {s3fs.WithCompression(s3fs.CompressionZstd)},
+		"encrypted":  {s3fs.WithCompression(s3fs.CompressionGzip), s3fs.WithClientSideEncryption(keys)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs3, err := s3fs.NewS3FS(client, testBucket, opts...)
+			if err != nil {
+				t.Fatalf("NewS3FS: %s", err)
+			}
+
+			// Random-looking data, so that it spans more than one part
+			// after compression
+			data := testData(int(s3fs.MinPartSize) + 1024)
+			for i := range data {
+				data[i] ^= byte(i * 7919 >> 8)
+			}
+			f, err := fs3.OpenFile(name, s3fs.O_WRMULTIPART, 0666)
+			if err != nil {
+				t.Fatalf("OpenFile: %s", err)
+			}
+			if _, err := f.Write(data); err != nil {
+				t.Fatalf("Write: %s", err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatalf("Close: %s", err)
+			}
+
+			if got := readFile(t, fs3, name); got != string(data) {
+				t.Errorf("multipart upload contents don't match (got %d bytes, expected %d)", len(got), len(data))
+			}
+			fi, err := fs3.Stat(name)
+			if err != nil {
+				t.Fatalf("Stat: %s", err)
+			}
+			if fi.Size() != int64(len(data)) {
+				t.Errorf("expected size %d, got %d", len(data), fi.Size())
+			}
+		})
+	}
+}
+
+func TestCompressionCopyInParts(t *testing.T) {
+	client, backend := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket,
+		s3fs.WithCompression(s3fs.CompressionZstd),
+		s3fs.WithCopyThreshold(s3fs.MinPartSize),
+	)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Objects above the copy threshold have their uncompressed size
+	// recorded without CopyObject
+	backend.SetFaults(s3mem.Faults{ErrorEvery: 1, Operations: []string{"CopyObject"}})
+	data := make([]byte, s3fs.MinPartSize+1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	writeWithOptions(t, fs3, "big", s3fs.O_WRMULTIPART, data,
+		s3fs.WithFileMetadata(map[string]string{"owner": "me"}),
+	)
+
+	fi, err := fs3.Stat("big")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), fi.Size())
+	}
+	if res := headObject(t, client, "big"); res.Metadata["owner"] != "me" {
+		t.Errorf("expected the metadata to be kept, got %v", res.Metadata)
+	}
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	if n, err := backend.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	_, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCompression(s3fs.Compression(42)))
+	if !errors.Is(err, s3fs.ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption, got %v", err)
+	}
+}
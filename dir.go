@@ -1,26 +1,58 @@
 // dir.go implements the interface billy.Dir
 
-package main
+package s3fs
 
 import (
+	"bytes"
 	"context"
-	"errors"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // ReadDir reads the directory named by dirname and returns a list of
 // directory entries sorted by filename.
 func (fs3 *S3FS) ReadDir(path string) ([]os.FileInfo, error) {
-	// p := fs3.cleanPath(fs3.root, path)
-	// if p != "" {
-	// 	p += "/"
-	// }
-	// fmt.Println("ReadDir:", p)
-	p := path
+	// Get the directory's prefix
+	key, err := fs3.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	p := fs3.dirPrefix(key)
+
+	// Answer from the inventory report, if one has been loaded
+	var fis []os.FileInfo
+	switch {
+	case fs3.inventory != nil && !fs3.inventory.overlay:
+		fis = fs3.inventory.readDir(p, fs3.separator)
+	case fs3.inventory != nil:
+		stale := fs3.inventory.readDir(p, fs3.separator)
+		live, err := fs3.listDir(p)
+		if err != nil {
+			return nil, err
+		}
+		fis = overlayDir(stale, live)
+	default:
+		if fis, err = fs3.listDir(p); err != nil {
+			return nil, err
+		}
+	}
+
+	// Decrypt the names, if they're encrypted
+	if fs3.names != nil {
+		fis = fs3.decryptEntries(fis)
+	}
+	return fis, nil
+}
 
+// listDir lists the objects and common prefixes directly under the prefix
+// p using ListObjectsV2. Entries are named relative to the prefix.
+func (fs3 *S3FS) listDir(p string) ([]os.FileInfo, error) {
 	// Create a context with a timeout
 	ctx := context.TODO() // TODO: Get user context?
 
@@ -33,22 +65,47 @@ func (fs3 *S3FS) ReadDir(path string) ([]os.FileInfo, error) {
 			Prefix:            &p,
 			ContinuationToken: ct,
 			Delimiter:         &fs3.separator,
-		})
+			FetchOwner:        true,
+		}, fs3.optFns...)
 		if err != nil {
 			return nil, err
 		}
 
 		// Add the directories to the list
 		for _, d := range res.CommonPrefixes {
-			dirs = append(dirs, newDirInfo(*d.Prefix))
+			name := strings.TrimSuffix(aws.ToString(d.Prefix)[len(p):], fs3.separator)
+			dirs = append(dirs, newDirInfo(name))
 		}
 
 		// Add the files to the list
 		for _, f := range res.Contents {
+			// Skip the directory's own marker object
+			name := aws.ToString(f.Key)[len(p):]
+			if name == "" {
+				continue
+			}
+
+			// "_$folder$" markers are listed as directories
+			if fs3.dirMarker == DirMarkerFolderSuffix && strings.HasSuffix(name, FolderSuffix) {
+				dirs = append(dirs, newDirInfo(strings.TrimSuffix(name, FolderSuffix)))
+				continue
+			}
+
+			// Listings don't include metadata, so unless the files are
+			// described below, assume that they're encrypted if the
+			// filesystem encrypts them
+			size := f.Size
+			if fs3.cse != nil && !fs3.readDirStat {
+				if n, ok := plainSize(size, EncryptionChunkSize); ok {
+					size = n
+				}
+			}
+
 			files = append(files, newFileInfo(
-				aws.ToString(f.Key),
-				f.Size,
+				name,
+				size,
 				aws.ToTime(f.LastModified),
+				listedObject(f),
 			))
 		}
 
@@ -61,15 +118,221 @@ func (fs3 *S3FS) ReadDir(path string) ([]os.FileInfo, error) {
 		}
 	}
 
+	// Describe the files like Stat, if required
+	if fs3.readDirStat {
+		if err := fs3.describeFiles(ctx, p, files); err != nil {
+			return nil, err
+		}
+	}
+
+	// A directory may have both a marker and contents
+	if fs3.dirMarker == DirMarkerFolderSuffix {
+		dirs = uniqueDirs(dirs)
+	}
+
 	// Join the directories and files & return
 	res := append(dirs, files...)
 	return res, nil
 }
 
+// uniqueDirs sorts dirs by name and removes duplicates.
+func uniqueDirs(dirs []os.FileInfo) []os.FileInfo {
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].Name() < dirs[j].Name()
+	})
+	var res []os.FileInfo
+	for i, d := range dirs {
+		if i == 0 || d.Name() != dirs[i-1].Name() {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
 // MkdirAll creates a directory named path, along with any necessary
 // parents, and returns nil, or else returns an error. The permission bits
 // perm are used for all directories that MkdirAll creates. If path is/
 // already a directory, MkdirAll does nothing and returns nil.
+//
+// With the DirMarkerNone style, directories only exist implicitly, so
+// MkdirAll does nothing. Otherwise an empty marker object is written for
+// each directory in the path.
 func (fs3 *S3FS) MkdirAll(filename string, perm os.FileMode) error {
-	return errors.New("not implemented")
+	// Format the path
+	rel, err := fs3.clean(filename)
+	if err != nil {
+		return err
+	}
+	if fs3.dirMarker == DirMarkerNone || rel == "" {
+		return nil
+	}
+
+	// Create a context
+	ctx := context.TODO() // TODO: Get user-supplied context?
+
+	// Write a marker for each directory in the path
+	segs := strings.Split(rel, fs3.separator)
+	for i := range segs {
+		key := fs3.rootKey(strings.Join(segs[:i+1], fs3.separator))
+		switch fs3.dirMarker {
+		case DirMarkerTrailingSeparator:
+			key += fs3.separator
+		case DirMarkerFolderSuffix:
+			key += FolderSuffix
+		}
+
+		in := &s3.PutObjectInput{
+			Bucket:       &fs3.bucket,
+			Key:          &key,
+			Body:         bytes.NewReader(nil),
+			StorageClass: fs3.storageClass,
+		}
+		fs3.enc.applyPut(in)
+		_, err := fs3.client.PutObject(ctx, in, fs3.optFns...)
+		if err != nil {
+			return fmt.Errorf("failed to create directory marker %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// deleteBatchSize is the maximum number of keys in a DeleteObjects request.
+const deleteBatchSize = 1000
+
+// listKeys returns the keys of all objects under the prefix p, at any
+// depth.
+func (fs3 *S3FS) listKeys(ctx context.Context, p string) ([]string, error) {
+	var ct *string
+	var keys []string
+	for {
+		res, err := fs3.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &fs3.bucket,
+			Prefix:            &p,
+			ContinuationToken: ct,
+		}, fs3.optFns...)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+		ct = res.NextContinuationToken
+		if !res.IsTruncated {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// deleteKeys deletes the objects with the given keys, using batched
+// DeleteObjects requests.
+func (fs3 *S3FS) deleteKeys(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > deleteBatchSize {
+			n = deleteBatchSize
+		}
+		ids := make([]types.ObjectIdentifier, n)
+		for i, k := range keys[:n] {
+			ids[i] = types.ObjectIdentifier{Key: aws.String(k)}
+		}
+		keys = keys[n:]
+
+		res, err := fs3.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &fs3.bucket,
+			Delete: &types.Delete{Objects: ids, Quiet: true},
+		}, fs3.optFns...)
+		if err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		if len(res.Errors) > 0 {
+			e := res.Errors[0]
+			return fmt.Errorf("failed to delete %q: %s", aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes path and any children it contains, using batched
+// DeleteObjects requests rather than removing each file in turn. If the
+// path does not exist, RemoveAll returns nil. It is used by billy's
+// util.RemoveAll.
+func (fs3 *S3FS) RemoveAll(path string) error {
+	// Create a context
+	ctx := context.TODO() // TODO: Get user-supplied context?
+
+	key, err := fs3.resolve(path)
+	if err != nil {
+		return err
+	}
+	return fs3.removeAll(ctx, key)
+}
+
+// removeAll removes the file or directory with the given key, along with
+// everything beneath it (including directory markers). It does nothing if
+// the key doesn't exist.
+func (fs3 *S3FS) removeAll(ctx context.Context, key string) error {
+	keys, err := fs3.listKeys(ctx, fs3.dirPrefix(key))
+	if err != nil {
+		return fmt.Errorf("failed to list directory: %w", err)
+	}
+	if key != "" {
+		keys = append(keys, key)
+		if fs3.dirMarker == DirMarkerFolderSuffix {
+			keys = append(keys, key+FolderSuffix)
+		}
+	}
+	if err := fs3.deleteKeys(ctx, keys); err != nil {
+		return err
+	}
+
+	// The cached Stat results are now out of date
+	fs3.statCache.removePrefix(key)
+	return nil
+}
+
+// renameDir moves every object beneath the directory src (including
+// directory markers) beneath dst, by copying and then deleting them. S3
+// has no atomic rename, so a failure part way through can leave objects
+// in both places. As with Rename, objects larger than the copy threshold
+// are copied with a multipart upload, and objects with their own SSE-C
+// key can't be moved.
+func (fs3 *S3FS) renameDir(ctx context.Context, src, dst string) error {
+	keys, err := fs3.listKeys(ctx, fs3.dirPrefix(src))
+	if err != nil {
+		return fmt.Errorf("failed to list directory: %w", err)
+	}
+	if fs3.dirMarker == DirMarkerFolderSuffix {
+		keys = append(keys, src+FolderSuffix)
+	}
+
+	var moved []string
+	for _, k := range keys {
+		// Describe each object first, as Rename does, so that large
+		// objects are copied in parts
+		in := &s3.HeadObjectInput{
+			Bucket: &fs3.bucket,
+			Key:    aws.String(k),
+		}
+		fs3.enc.applyHead(in)
+		head, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+		if err == nil {
+			err = fs3.copyKey(ctx, k, dst+k[len(src):], head)
+		}
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy %q: %w", k, err)
+		}
+		moved = append(moved, k)
+	}
+	if err := fs3.deleteKeys(ctx, moved); err != nil {
+		return err
+	}
+
+	// The cached Stat results are now out of date
+	fs3.statCache.removePrefix(src)
+	fs3.statCache.removePrefix(dst)
+	return nil
 }
@@ -0,0 +1,129 @@
+package s3fs_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+)
+
+func TestReadDir(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/a", "1")
+	writeFile(t, fs3, "foo/b", "22")
+	writeFile(t, fs3, "foo/sub/c", "333")
+	writeFile(t, fs3, "bar", "4444")
+
+	fis, err := fs3.ReadDir("foo")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+
+	expected := []struct {
+		name string
+		dir  bool
+		size int64
+	}{
+		{"sub", true, 0},
+		{"a", false, 1},
+		{"b", false, 2},
+	}
+	if len(fis) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(fis))
+	}
+	for i, e := range expected {
+		fi := fis[i]
+		if fi.Name() != e.name || fi.IsDir() != e.dir || fi.Size() != e.size {
+			t.Errorf("entry %d: expected %+v, got name=%q dir=%t size=%d", i, e, fi.Name(), fi.IsDir(), fi.Size())
+		}
+	}
+}
+
+func TestReadDirRoot(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/a", "1")
+	writeFile(t, fs3, "bar", "22")
+
+	fis, err := fs3.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(fis) != 2 || fis[0].Name() != "foo" || fis[1].Name() != "bar" {
+		t.Errorf("unexpected entries: %v", fis)
+	}
+}
+
+func TestReadDirNotExists(t *testing.T) {
+	deviation(t, "directories are implicit in S3, so listing a missing directory returns no entries")
+}
+
+func TestMkdirAll(t *testing.T) {
+	for _, style := range []s3fs.DirMarkerStyle{
+		s3fs.DirMarkerTrailingSeparator,
+		s3fs.DirMarkerFolderSuffix,
+	} {
+		fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(style))
+
+		if err := fs3.MkdirAll("foo/bar", 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		fi, err := fs3.Stat("foo/bar")
+		if err != nil {
+			t.Fatalf("style %d: Stat: %s", style, err)
+		}
+		if !fi.IsDir() {
+			t.Errorf("style %d: expected a directory", style)
+		}
+
+		fis, err := fs3.ReadDir("foo")
+		if err != nil {
+			t.Fatalf("style %d: ReadDir: %s", style, err)
+		}
+		if len(fis) != 1 || fis[0].Name() != "bar" || !fis[0].IsDir() {
+			t.Errorf("style %d: unexpected entries: %v", style, fis)
+		}
+	}
+}
+
+func TestMkdirAllNoMarker(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	if err := fs3.MkdirAll("foo/bar", 0755); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+
+	// Without markers, empty directories don't exist
+	if _, err := fs3.Stat("foo/bar"); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo/a", "1")
+	writeFile(t, fs3, "foo/sub/b", "2")
+	writeFile(t, fs3, "c", "3")
+
+	var paths []string
+	err := fs3.Walk("foo", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+
+	expected := []string{"foo", "foo/sub", "foo/sub/b", "foo/a"}
+	if len(paths) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, paths)
+			break
+		}
+	}
+}
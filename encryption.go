@@ -0,0 +1,168 @@
+// encryption.go implements server-side encryption settings
+
+package s3fs
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	CustomerKeySize = 32 // Size of an SSE-C customer-provided key (AES-256)
+)
+
+// Encryption describes how S3 encrypts objects at rest. The zero value
+// uses the bucket's default encryption.
+type Encryption struct {
+	// Type is the server-side encryption for new objects, either
+	// types.ServerSideEncryptionAes256 (SSE-S3) or
+	// types.ServerSideEncryptionAwsKms (SSE-KMS). It must be empty when
+	// a CustomerKey is given.
+	Type types.ServerSideEncryption
+
+	// KMSKeyID is the KMS key used for SSE-KMS. If it's empty, the
+	// bucket's default KMS key is used.
+	KMSKeyID string
+
+	// KMSContext is the encryption context for SSE-KMS. The same context
+	// is needed to decrypt the objects.
+	KMSContext map[string]string
+
+	// CustomerKey is a 256-bit key for SSE-C. S3 doesn't store the key,
+	// so the same key must be given to read (or Stat) the objects.
+	CustomerKey []byte
+}
+
+// encryption holds Encryption settings in the form sent to S3.
+type encryption struct {
+	sse               types.ServerSideEncryption
+	kmsKeyID          *string
+	kmsContext        *string // Base64-encoded JSON
+	customerAlgorithm *string
+	customerKey       *string // Base64-encoded
+	customerKeyMD5    *string // Base64-encoded
+}
+
+// newEncryption validates the Encryption settings and encodes them.
+func newEncryption(e Encryption) (encryption, error) {
+	kms := e.KMSKeyID != "" || len(e.KMSContext) > 0
+	switch e.Type {
+	case "":
+		if kms {
+			return encryption{}, fmt.Errorf("KMS settings can only be used with %q encryption", types.ServerSideEncryptionAwsKms)
+		}
+	case types.ServerSideEncryptionAes256:
+		if kms {
+			return encryption{}, fmt.Errorf("KMS settings can't be used with %q encryption", e.Type)
+		}
+	case types.ServerSideEncryptionAwsKms:
+	default:
+		return encryption{}, fmt.Errorf("unknown server-side encryption %q", e.Type)
+	}
+
+	enc := encryption{
+		sse:      e.Type,
+		kmsKeyID: optString(e.KMSKeyID),
+	}
+	if len(e.KMSContext) > 0 {
+		b, err := json.Marshal(e.KMSContext)
+		if err != nil {
+			return encryption{}, fmt.Errorf("invalid KMS encryption context: %w", err)
+		}
+		enc.kmsContext = aws.String(base64.StdEncoding.EncodeToString(b))
+	}
+
+	if e.CustomerKey != nil {
+		if e.Type != "" {
+			return encryption{}, fmt.Errorf("a customer key can't be used with %q encryption", e.Type)
+		}
+		if len(e.CustomerKey) != CustomerKeySize {
+			return encryption{}, fmt.Errorf("customer keys must be %d bytes, got %d", CustomerKeySize, len(e.CustomerKey))
+		}
+		sum := md5.Sum(e.CustomerKey)
+		enc.customerAlgorithm = aws.String("AES256")
+		enc.customerKey = aws.String(base64.StdEncoding.EncodeToString(e.CustomerKey))
+		enc.customerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return enc, nil
+}
+
+// etagIsMD5 reports whether the ETags of single-part uploads are the MD5
+// of the object's contents, which isn't the case with SSE-KMS or SSE-C.
+func (e encryption) etagIsMD5() bool {
+	return e.sse != types.ServerSideEncryptionAwsKms && e.customerKey == nil
+}
+
+// applyPut sets the encryption parameters of a PutObject request.
+func (e encryption) applyPut(in *s3.PutObjectInput) {
+	in.ServerSideEncryption = e.sse
+	in.SSEKMSKeyId = e.kmsKeyID
+	in.SSEKMSEncryptionContext = e.kmsContext
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+}
+
+// applyCreateMultipart sets the encryption parameters of a
+// CreateMultipartUpload request.
+func (e encryption) applyCreateMultipart(in *s3.CreateMultipartUploadInput) {
+	in.ServerSideEncryption = e.sse
+	in.SSEKMSKeyId = e.kmsKeyID
+	in.SSEKMSEncryptionContext = e.kmsContext
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+}
+
+// applyUploadPart sets the encryption parameters of an UploadPart request.
+// Only SSE-C keys are sent with each part.
+func (e encryption) applyUploadPart(in *s3.UploadPartInput) {
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+}
+
+// applyUploadPartCopy sets the encryption parameters of an UploadPartCopy
+// request, copying from an object encrypted with src.
+func (e encryption) applyUploadPartCopy(in *s3.UploadPartCopyInput, src encryption) {
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+	in.CopySourceSSECustomerAlgorithm = src.customerAlgorithm
+	in.CopySourceSSECustomerKey = src.customerKey
+	in.CopySourceSSECustomerKeyMD5 = src.customerKeyMD5
+}
+
+// applyGet sets the encryption parameters of a GetObject request.
+func (e encryption) applyGet(in *s3.GetObjectInput) {
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+}
+
+// applyHead sets the encryption parameters of a HeadObject request.
+func (e encryption) applyHead(in *s3.HeadObjectInput) {
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+}
+
+// applyCopy sets the encryption parameters of a CopyObject request, where
+// the source object was encrypted with src.
+func (e encryption) applyCopy(in *s3.CopyObjectInput, src encryption) {
+	in.ServerSideEncryption = e.sse
+	in.SSEKMSKeyId = e.kmsKeyID
+	in.SSEKMSEncryptionContext = e.kmsContext
+	in.SSECustomerAlgorithm = e.customerAlgorithm
+	in.SSECustomerKey = e.customerKey
+	in.SSECustomerKeyMD5 = e.customerKeyMD5
+	in.CopySourceSSECustomerAlgorithm = src.customerAlgorithm
+	in.CopySourceSSECustomerKey = src.customerKey
+	in.CopySourceSSECustomerKeyMD5 = src.customerKeyMD5
+}
@@ -0,0 +1,224 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// testCustomerKey returns a 256-bit SSE-C key filled with b.
+func testCustomerKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, s3fs.CustomerKeySize)
+}
+
+func TestEncryptionOptions(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	cases := []struct {
+		name string
+		enc  s3fs.Encryption
+		ok   bool
+	}{
+		{"none", s3fs.Encryption{}, true},
+		{"aes256", s3fs.Encryption{Type: types.ServerSideEncryptionAes256}, true},
+		{"kms", s3fs.Encryption{Type: types.ServerSideEncryptionAwsKms, KMSKeyID: "key", KMSContext: map[string]string{"a": "b"}}, true},
+		{"customer key", s3fs.Encryption{CustomerKey: testCustomerKey(1)}, true},
+		{"unknown type", s3fs.Encryption{Type: "rot13"}, false},
+		{"kms key with aes256", s3fs.Encryption{Type: types.ServerSideEncryptionAes256, KMSKeyID: "key"}, false},
+		{"kms context without kms", s3fs.Encryption{KMSContext: map[string]string{"a": "b"}}, false},
+		{"short customer key", s3fs.Encryption{CustomerKey: []byte("short")}, false},
+		{"customer key with kms", s3fs.Encryption{Type: types.ServerSideEncryptionAwsKms, CustomerKey: testCustomerKey(1)}, false},
+	}
+	for _, c := range cases {
+		_, err := s3fs.NewS3FS(client, testBucket, s3fs.WithEncryption(c.enc))
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, s3fs.ErrInvalidOption) {
+			t.Errorf("%s: expected ErrInvalidOption, got %v", c.name, err)
+		}
+	}
+}
+
+func TestEncryptionKMS(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithEncryption(s3fs.Encryption{
+		Type:       types.ServerSideEncryptionAwsKms,
+		KMSKeyID:   "alias/test",
+		KMSContext: map[string]string{"project": "s3fs"},
+	}))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	writeFile(t, fs3, "secret.txt", "hello")
+	if err := fs3.Rename("secret.txt", "moved.txt"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+
+	// The copy keeps the KMS settings
+	res, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("moved.txt"),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject: %s", err)
+	}
+	if res.ServerSideEncryption != types.ServerSideEncryptionAwsKms || aws.ToString(res.SSEKMSKeyId) != "alias/test" {
+		t.Errorf("expected aws:kms encryption with alias/test, got %q with %q", res.ServerSideEncryption, aws.ToString(res.SSEKMSKeyId))
+	}
+	if got := readFile(t, fs3, "moved.txt"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+}
+
+func TestEncryptionCustomerKey(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithEncryption(s3fs.Encryption{
+		CustomerKey: testCustomerKey(1),
+	}))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	plain, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Simple uploads, reads and Stat
+	writeFile(t, fs3, "dir/a.txt", "Hello, World!")
+	if got := readFile(t, fs3, "dir/a.txt"); got != "Hello, World!" {
+		t.Errorf("expected %q, got %q", "Hello, World!", got)
+	}
+	fi, err := fs3.Stat("dir/a.txt")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Size() != 13 {
+		t.Errorf("expected size 13, got %d", fi.Size())
+	}
+
+	// The object can't be read without the key
+	if _, err := plain.Open("dir/a.txt"); err == nil || os.IsNotExist(err) {
+		t.Errorf("expected an error reading without the key, got %v", err)
+	}
+	if _, err := plain.Stat("dir/a.txt"); err == nil {
+		t.Error("expected an error from Stat without the key")
+	}
+
+	// Renames copy the object with the same key
+	if err := fs3.Rename("dir/a.txt", "dir/b.txt"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if got := readFile(t, fs3, "dir/b.txt"); got != "Hello, World!" {
+		t.Errorf("expected %q after rename, got %q", "Hello, World!", got)
+	}
+
+	// Files opened for reading and writing are loaded and uploaded with
+	// the key
+	f, err := fs3.OpenFile("dir/b.txt", s3fs.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile(O_APPEND): %s", err)
+	}
+	if _, err := f.Write([]byte(" Bye!")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "dir/b.txt"); got != "Hello, World! Bye!" {
+		t.Errorf("expected %q after append, got %q", "Hello, World! Bye!", got)
+	}
+
+	// Multipart uploads send the key with every part
+	data := make([]byte, s3fs.MinPartSize+1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	f, err = fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile(O_WRMULTIPART): %s", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("multipart upload contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+
+	// Ranged reads through the handler
+	h, err := s3fs.NewHandler(fs3)
+	if err != nil {
+		t.Fatalf("NewHandler: %s", err)
+	}
+	res := serve(t, h, http.MethodGet, "/dir/b.txt", map[string]string{"Range": "bytes=0-4"})
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", res.StatusCode)
+	}
+	if got := body(t, res); got != "Hello" {
+		t.Errorf("expected body %q, got %q", "Hello", got)
+	}
+}
+
+func TestFileEncryption(t *testing.T) {
+	fs3 := newTestFS(t)
+	enc := s3fs.Encryption{CustomerKey: testCustomerKey(2)}
+
+	f, err := fs3.OpenFileWithOptions("secret.txt", s3fs.O_WRONLY, s3fs.WithFileEncryption(enc))
+	if err != nil {
+		t.Fatalf("OpenFileWithOptions: %s", err)
+	}
+	if _, err := io.WriteString(f, "hello"); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	// The filesystem's default settings can't read it
+	if _, err := fs3.Open("secret.txt"); err == nil {
+		t.Error("expected an error reading without the key")
+	}
+
+	// Nor can a different key
+	other := s3fs.Encryption{CustomerKey: testCustomerKey(3)}
+	if _, err := fs3.OpenFileWithOptions("secret.txt", s3fs.O_RDONLY, s3fs.WithFileEncryption(other)); err == nil {
+		t.Error("expected an error reading with the wrong key")
+	}
+
+	// Rename only knows the filesystem's settings, so it refuses the
+	// file and leaves it in place
+	if err := fs3.Rename("secret.txt", "moved.txt"); err == nil {
+		t.Error("expected an error renaming without the key")
+	}
+
+	f, err = fs3.OpenFileWithOptions("secret.txt", s3fs.O_RDONLY, s3fs.WithFileEncryption(enc))
+	if err != nil {
+		t.Fatalf("OpenFileWithOptions: %s", err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(b) != "hello" {
+		t.Errorf("expected %q, got %q", "hello", b)
+	}
+
+	if _, err := fs3.OpenFileWithOptions("x", s3fs.O_RDONLY, s3fs.WithFileEncryption(s3fs.Encryption{CustomerKey: []byte("short")})); err == nil {
+		t.Error("expected an error for an invalid key")
+	}
+}
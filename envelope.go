@@ -0,0 +1,420 @@
+// envelope.go implements client-side envelope encryption
+
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	EncryptionChunkSize = 64 * 1024 // Size of the plaintext chunks encrypted by client-side encryption
+	DataKeySize         = 32        // Size of the per-object AES-256 data keys
+
+	aesGCMOverhead = 16 // Size of the AES-GCM tag added to each chunk
+)
+
+// Object metadata written by client-side encryption
+const (
+	metaCipher    = "s3fs-cipher"     // Name of the cipher
+	metaDataKey   = "s3fs-data-key"   // Base64-encoded wrapped data key
+	metaChunkSize = "s3fs-chunk-size" // Size of the plaintext chunks
+
+	cipherChunkedAESGCM = "AES-256-GCM-CHUNKED"
+)
+
+var (
+	ErrNoKeyProvider = errors.New("object is encrypted, but no key provider is configured")
+	ErrDecrypt       = errors.New("unable to decrypt object")
+)
+
+// KeyProvider wraps and unwraps the data keys used for client-side
+// encryption. Each object is encrypted with its own random data key, which
+// is stored with the object after being wrapped (encrypted) by the
+// provider, e.g. with a KMS key or a locally held master key.
+type KeyProvider interface {
+	// WrapKey encrypts a data key.
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey decrypts a data key returned by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// aesKeyProvider is a KeyProvider that wraps data keys with a master key
+// using AES-GCM.
+type aesKeyProvider struct {
+	aead cipher.AEAD
+}
+
+// NewAESKeyProvider creates a KeyProvider that wraps data keys with a
+// 256-bit master key using AES-GCM.
+func NewAESKeyProvider(masterKey []byte) (KeyProvider, error) {
+	if len(masterKey) != DataKeySize {
+		return nil, fmt.Errorf("master keys must be %d bytes, got %d", DataKeySize, len(masterKey))
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &aesKeyProvider{aead: aead}, nil
+}
+
+// WrapKey implements KeyProvider.
+func (p *aesKeyProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// UnwrapKey implements KeyProvider.
+func (p *aesKeyProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	n := p.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return p.aead.Open(nil, wrapped[:n], wrapped[n:], nil)
+}
+
+// clientEncryption holds the client-side encryption settings. It's shared
+// with chroots, so filesystems can tell if they use the same settings.
+type clientEncryption struct {
+	keys KeyProvider
+}
+
+// newObjectCipher creates a cipher with a new data key, along with the
+// object metadata needed to decrypt it.
+func (ce *clientEncryption) newObjectCipher(ctx context.Context) (*chunkCipher, map[string]string, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := ce.keys.WrapKey(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to wrap data key: %w", err)
+	}
+	c, err := newChunkCipher(key, EncryptionChunkSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta := map[string]string{
+		metaCipher:    cipherChunkedAESGCM,
+		metaDataKey:   base64.StdEncoding.EncodeToString(wrapped),
+		metaChunkSize: strconv.Itoa(EncryptionChunkSize),
+	}
+	return c, meta, nil
+}
+
+// objectCipher returns the cipher needed to decrypt an object with the
+// given metadata, or nil if the object isn't encrypted.
+func (fs3 *S3FS) objectCipher(ctx context.Context, meta map[string]string) (*chunkCipher, error) {
+	if meta[metaCipher] == "" {
+		return nil, nil
+	}
+	if meta[metaCipher] != cipherChunkedAESGCM {
+		return nil, fmt.Errorf("%w: unknown cipher %q", ErrDecrypt, meta[metaCipher])
+	}
+	if fs3.cse == nil {
+		return nil, ErrNoKeyProvider
+	}
+	chunkSize, err := metaChunkSizeOf(meta)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(meta[metaDataKey])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid data key: %s", ErrDecrypt, err)
+	}
+	key, err := fs3.cse.keys.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to unwrap data key: %s", ErrDecrypt, err)
+	}
+	return newChunkCipher(key, chunkSize)
+}
+
+// metaChunkSizeOf returns the chunk size recorded in an object's metadata.
+// Objects are only ever written with EncryptionChunkSize, and the chunk
+// size decides how much is buffered to decrypt them, so any other size is
+// rejected.
+func metaChunkSizeOf(meta map[string]string) (int64, error) {
+	n, err := strconv.ParseInt(meta[metaChunkSize], 10, 64)
+	if err != nil || n != EncryptionChunkSize {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrDecrypt, meta[metaChunkSize])
+	}
+	return n, nil
+}
+
+// plaintextSize returns the size of an object's contents, which differs
+// from its size in S3 if it's encrypted.
+func plaintextSize(meta map[string]string, size int64) int64 {
+	if meta[metaCipher] == "" {
+		return size
+	}
+	chunkSize, err := metaChunkSizeOf(meta)
+	if err != nil {
+		return size
+	}
+	if n, ok := plainSize(size, chunkSize); ok {
+		return n
+	}
+	return size
+}
+
+// decryptBody returns a reader for the contents of an object that has been
+// read into memory, decrypting it if it's encrypted.
+func (fs3 *S3FS) decryptBody(ctx context.Context, meta map[string]string, body []byte) (readSeekerAt, int64, error) {
+	c, err := fs3.objectCipher(ctx, meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c == nil {
+		return bytes.NewReader(body), int64(len(body)), nil
+	}
+	d, err := c.newDecryptReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NewSectionReader(d, 0, d.plain), d.plain, nil
+}
+
+// encryptObject encrypts data with a new data key, returning the
+// ciphertext and the metadata to store with it.
+func (ce *clientEncryption) encryptObject(ctx context.Context, data []byte) ([]byte, map[string]string, error) {
+	c, meta, err := ce.newObjectCipher(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	w := c.newWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes(), meta, nil
+}
+
+// chunkCipher encrypts objects as a sequence of fixed-size chunks, each
+// sealed with AES-GCM, so that any range can be decrypted without reading
+// the rest of the object. Every data key is only used for one object, so
+// each chunk's nonce is its index. The last chunk is marked as such in
+// its additional data, so truncated objects are detected.
+type chunkCipher struct {
+	aead      cipher.AEAD
+	chunkSize int64 // Size of each plaintext chunk (except the last)
+}
+
+// newChunkCipher creates a chunkCipher for a data key.
+func newChunkCipher(key []byte, chunkSize int64) (*chunkCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecrypt, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkCipher{aead: aead, chunkSize: chunkSize}, nil
+}
+
+// nonce returns the nonce for the chunk with the given index.
+func (c *chunkCipher) nonce(index int64) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(index))
+	return nonce
+}
+
+// additionalData returns the authenticated data for a chunk.
+func additionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// seal encrypts a chunk, appending it to dst.
+func (c *chunkCipher) seal(dst, p []byte, index int64, final bool) []byte {
+	return c.aead.Seal(dst, c.nonce(index), p, additionalData(final))
+}
+
+// open decrypts a chunk, appending it to dst.
+func (c *chunkCipher) open(dst, ct []byte, index int64, final bool) ([]byte, error) {
+	p, err := c.aead.Open(dst, c.nonce(index), ct, additionalData(final))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d: %s", ErrDecrypt, index, err)
+	}
+	return p, nil
+}
+
+// plainSize returns the size of the plaintext of an object of the given
+// (encrypted) size, and whether the size is valid.
+func plainSize(size, chunkSize int64) (int64, bool) {
+	overhead := int64(aesGCMOverhead)
+	n := (size + chunkSize + overhead - 1) / (chunkSize + overhead) // Number of chunks
+	if n == 0 || size-(n-1)*(chunkSize+overhead) < overhead {
+		return 0, false
+	}
+	return size - n*overhead, true
+}
+
+// newWriter returns a writer that encrypts to w. It must be closed to
+// write the final chunk.
+func (c *chunkCipher) newWriter(w io.Writer) *encryptWriter {
+	return &encryptWriter{c: c, w: w}
+}
+
+// encryptWriter encrypts the data written to it, chunk by chunk.
+type encryptWriter struct {
+	c     *chunkCipher
+	w     io.Writer
+	buf   []byte // Plaintext not yet encrypted
+	index int64  // Index of the next chunk
+	out   []byte // Reused buffer for the encrypted chunk
+}
+
+// Write implements io.Writer. A full chunk is held back until more data
+// is written, as the last chunk is encrypted differently.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	cs := int(e.c.chunkSize)
+	for len(e.buf) > cs {
+		if err := e.flush(e.buf[:cs], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[cs:]...)
+	}
+	return len(p), nil
+}
+
+// Close encrypts and writes the final chunk.
+func (e *encryptWriter) Close() error {
+	err := e.flush(e.buf, true)
+	e.buf = nil
+	return err
+}
+
+// flush encrypts and writes a chunk.
+func (e *encryptWriter) flush(p []byte, final bool) error {
+	e.out = e.c.seal(e.out[:0], p, e.index, final)
+	e.index++
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// decryptReader decrypts an encrypted object, read from r. It implements
+// io.ReaderAt, decrypting only the chunks needed for each read.
+type decryptReader struct {
+	c     *chunkCipher
+	r     io.ReaderAt
+	size  int64 // Size of the encrypted object
+	plain int64 // Size of the plaintext
+}
+
+// newDecryptReader creates a decryptReader for an object of the given
+// (encrypted) size.
+func (c *chunkCipher) newDecryptReader(r io.ReaderAt, size int64) (*decryptReader, error) {
+	plain, ok := plainSize(size, c.chunkSize)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid size %d", ErrDecrypt, size)
+	}
+	return &decryptReader{c: c, r: r, size: size, plain: plain}, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (d *decryptReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= d.plain {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	end := off + int64(len(p))
+	if end > d.plain {
+		end = d.plain
+	}
+
+	// Read the chunks covering the range
+	cs := d.c.chunkSize
+	stride := cs + aesGCMOverhead
+	first, last := off/cs, (end-1)/cs
+	start, stop := first*stride, (last+1)*stride
+	if stop > d.size {
+		stop = d.size
+	}
+	ct := make([]byte, stop-start)
+	if n, err := d.r.ReadAt(ct, start); n < len(ct) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	// Decrypt them
+	final := (d.size - 1) / stride
+	plain := make([]byte, 0, (last-first+1)*cs)
+	for i := first; i <= last; i++ {
+		chunk := ct[(i-first)*stride:]
+		if int64(len(chunk)) > stride {
+			chunk = chunk[:stride]
+		}
+		var err error
+		if plain, err = d.c.open(plain, chunk, i, i == final); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, plain[off-first*cs:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// objectReaderAt reads ranges of an object with ranged GetObject requests.
+type objectReaderAt struct {
+	ctx  context.Context
+	fs3  *S3FS
+	key  string
+	etag *string // Fails the reads if the object changes
+}
+
+// ReadAt implements io.ReaderAt.
+func (o *objectReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	in := &s3.GetObjectInput{
+		Bucket:  &o.fs3.bucket,
+		Key:     &o.key,
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1)),
+		IfMatch: o.etag,
+	}
+	o.fs3.enc.applyGet(in)
+	res, err := o.fs3.client.GetObject(o.ctx, in, o.fs3.optFns...)
+	if err != nil {
+		return 0, fmt.Errorf("unable to perform GetObject operation: %w", err)
+	}
+	defer res.Body.Close()
+	n, err := io.ReadFull(res.Body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
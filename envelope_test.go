@@ -0,0 +1,287 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newEncryptedFS creates an S3FS using client-side encryption with a
+// master key filled with b.
+func newEncryptedFS(t *testing.T, client *s3.Client, b byte) *s3fs.S3FS {
+	t.Helper()
+
+	keys, err := s3fs.NewAESKeyProvider(bytes.Repeat([]byte{b}, s3fs.DataKeySize))
+	if err != nil {
+		t.Fatalf("NewAESKeyProvider: %s", err)
+	}
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithClientSideEncryption(keys))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	return fs3
+}
+
+// testData returns n bytes of non-repeating test data.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestClientSideEncryption(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3 := newEncryptedFS(t, client, 1)
+
+	data := testData(3*s3fs.EncryptionChunkSize + 100)
+	writeFile(t, fs3, "data.bin", string(data))
+	writeFile(t, fs3, "empty", "")
+
+	// The object in S3 is encrypted
+	res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("data.bin"),
+	})
+	if err != nil {
+		t.Fatalf("GetObject: %s", err)
+	}
+	raw, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if bytes.Contains(raw, data[:1024]) {
+		t.Error("expected the object to be encrypted")
+	}
+	if len(res.Metadata) == 0 {
+		t.Error("expected the data key to be stored in the object's metadata")
+	}
+
+	// Sizes are reported without the encryption overhead
+	for name, size := range map[string]int64{"data.bin": int64(len(data)), "empty": 0} {
+		fi, err := fs3.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%q): %s", name, err)
+		}
+		if fi.Size() != size {
+			t.Errorf("Stat(%q): expected size %d, got %d", name, size, fi.Size())
+		}
+	}
+	fis, err := fs3.ReadDir("")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	for _, fi := range fis {
+		if fi.Name() == "data.bin" && fi.Size() != int64(len(data)) {
+			t.Errorf("ReadDir: expected size %d, got %d", len(data), fi.Size())
+		}
+	}
+
+	// Whole and ranged reads
+	if got := readFile(t, fs3, "data.bin"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	if got := readFile(t, fs3, "empty"); got != "" {
+		t.Errorf("expected an empty file, got %q", got)
+	}
+	f, err := fs3.Open("data.bin")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+	off := int64(s3fs.EncryptionChunkSize - 10)
+	p := make([]byte, s3fs.EncryptionChunkSize+20)
+	if _, err := f.ReadAt(p, off); err != nil {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if !bytes.Equal(p, data[off:off+int64(len(p))]) {
+		t.Error("ReadAt across chunks returned the wrong data")
+	}
+	if _, err := f.Seek(-50, io.SeekEnd); err != nil {
+		t.Fatalf("Seek: %s", err)
+	}
+	tail, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(tail, data[len(data)-50:]) {
+		t.Error("reading after Seek returned the wrong data")
+	}
+
+	// Files opened for reading and writing are decrypted and re-encrypted
+	rw, err := fs3.OpenFile("data.bin", s3fs.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile(O_APPEND): %s", err)
+	}
+	if _, err := rw.Write([]byte("more")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "data.bin"); got != string(data)+"more" {
+		t.Errorf("unexpected contents after append (got %d bytes)", len(got))
+	}
+
+	// Ranged reads through the handler
+	h, err := s3fs.NewHandler(fs3)
+	if err != nil {
+		t.Fatalf("NewHandler: %s", err)
+	}
+	hres := serve(t, h, http.MethodGet, "/data.bin", map[string]string{"Range": "bytes=65530-65545"})
+	if hres.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected status 206, got %d", hres.StatusCode)
+	}
+	if got := body(t, hres); got != string(data[65530:65546]) {
+		t.Errorf("unexpected range body %q", got)
+	}
+	hres = serve(t, h, http.MethodGet, "/data.bin", nil)
+	if got := body(t, hres); got != string(data)+"more" {
+		t.Errorf("unexpected body from handler (got %d bytes)", len(got))
+	}
+}
+
+func TestClientSideEncryptionMultipart(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3 := newEncryptedFS(t, client, 1)
+
+	data := testData(int(s3fs.MinPartSize) + 1024)
+	f, err := fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("multipart upload contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	fi, err := fs3.Stat("big")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Size() != int64(len(data)) {
+		t.Errorf("expected size %d, got %d", len(data), fi.Size())
+	}
+}
+
+func TestClientSideEncryptionReadDirStat(t *testing.T) {
+	client, _ := newTestClient(t)
+	keys, err := s3fs.NewAESKeyProvider(bytes.Repeat([]byte{1}, s3fs.DataKeySize))
+	if err != nil {
+		t.Fatalf("NewAESKeyProvider: %s", err)
+	}
+	fs3, err := s3fs.NewS3FS(client, testBucket,
+		s3fs.WithClientSideEncryption(keys),
+		s3fs.WithReadDirStat(true),
+	)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Encrypted and plain files in the same directory have the sizes Stat
+	// reports
+	writeFile(t, fs3, "dir/encrypted", string(testData(1000)))
+	putObject(t, client, testBucket, "dir/plain", testData(1000))
+	fis, err := fs3.ReadDir("dir")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(fis) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(fis))
+	}
+	for _, fi := range fis {
+		if fi.Size() != 1000 {
+			t.Errorf("ReadDir: expected %q to have size 1000, got %d", fi.Name(), fi.Size())
+		}
+	}
+}
+
+func TestClientSideEncryptionKeys(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3 := newEncryptedFS(t, client, 1)
+	writeFile(t, fs3, "secret.txt", "hello")
+
+	// Without a key provider
+	plain, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	if _, err := plain.Open("secret.txt"); !errors.Is(err, s3fs.ErrNoKeyProvider) {
+		t.Errorf("expected ErrNoKeyProvider, got %v", err)
+	}
+
+	// With the wrong master key
+	other := newEncryptedFS(t, client, 2)
+	if _, err := other.Open("secret.txt"); !errors.Is(err, s3fs.ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt with the wrong key, got %v", err)
+	}
+
+	// Unencrypted objects can still be read
+	writeFile(t, plain, "public.txt", "hi")
+	if got := readFile(t, fs3, "public.txt"); got != "hi" {
+		t.Errorf("expected %q, got %q", "hi", got)
+	}
+
+	// Tampering is detected
+	res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("secret.txt"),
+	})
+	if err != nil {
+		t.Fatalf("GetObject: %s", err)
+	}
+	raw, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	raw[0] ^= 1
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String("secret.txt"),
+		Body:     bytes.NewReader(raw),
+		Metadata: res.Metadata,
+	})
+	if err != nil {
+		t.Fatalf("PutObject: %s", err)
+	}
+	f, err := fs3.Open("secret.txt")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+	if _, err := ioutil.ReadAll(f); !errors.Is(err, s3fs.ErrDecrypt) {
+		t.Errorf("expected ErrDecrypt for a modified object, got %v", err)
+	}
+
+	// So are chunk sizes it wasn't written with
+	for _, size := range []string{"1", "4096", "1152921504606846976"} {
+		meta := map[string]string{"s3fs-chunk-size": size}
+		for k, v := range res.Metadata {
+			if k != "s3fs-chunk-size" {
+				meta[k] = v
+			}
+		}
+		_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket:   aws.String(testBucket),
+			Key:      aws.String("secret.txt"),
+			Body:     bytes.NewReader(raw),
+			Metadata: meta,
+		})
+		if err != nil {
+			t.Fatalf("PutObject: %s", err)
+		}
+		if _, err := fs3.Open("secret.txt"); !errors.Is(err, s3fs.ErrDecrypt) {
+			t.Errorf("expected ErrDecrypt for a chunk size of %s, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package s3fs
+
+// Decoders of inventory data files, for testing
+var (
+	ReadORC     = readORC
+	ReadParquet = readParquet
+	ParseThrift = parseThrift
+	ParseProto  = parseProto
+	ORCInts     = orcInts
+)
@@ -1,15 +1,22 @@
-package main
+package s3fs
 
 import (
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"go.uber.org/atomic"
 )
 
@@ -18,7 +25,10 @@ const (
 )
 
 var (
-	ErrLockNotSupported      = errors.New("lock not supported by s3")
+	// Deprecated: Files can be locked, although locks only apply within
+	// this process. ErrLockNotSupported is no longer returned.
+	ErrLockNotSupported = errors.New("lock not supported by s3")
+
 	ErrTruncateNotSupported  = errors.New("truncate not supported by s3")
 	ErrFileClosed            = errors.New("file is closed")
 	ErrCantWriteToReadOnly   = errors.New("can't write to read-only file")
@@ -29,49 +39,106 @@ var (
 //
 // Upon creation, the file is loaded from S3.
 type s3ReadFile struct {
-	client *s3.Client    // s3 skd client
-	bucket string        // S3 bucket name
-	key    string        // File object's key in S3
-	closed bool          // Is the file closed?
-	reader *bytes.Reader // Buffer for file contents
+	fs     *S3FS        // Filesystem the file was opened from
+	name   string       // Name of the file as presented to Open
+	bucket string       // S3 bucket name
+	key    string       // File object's key in S3
+	closed bool         // Is the file closed?
+	reader readSeekerAt // Buffer for file contents
+	info   os.FileInfo  // File info, from the GetObject response
+	lock   fileLock     // Lock state
+}
+
+// readSeekerAt is implemented by the buffers s3ReadFile reads from.
+type readSeekerAt interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
 }
 
 // newS3ReadFile creates a new s3ReadFile.
-func newS3ReadFile(client *s3.Client, bucket, key string) (*s3ReadFile, error) {
+func newS3ReadFile(fs3 *S3FS, bucket, key string) (*s3ReadFile, error) {
 	// TODO: Check if the file exists
 	// ...
 
 	// Create the context
 	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
 
-	// Run the GetObject operation
-	res, err := client.GetObject(ctx, &s3.GetObjectInput{
+	// Describe the file
+	name := fs3.keyName(key)
+
+	// If the file is open for writing, read the changes as they're made
+	if bucket == fs3.bucket && fs3.file.versionID == "" {
+		if buf, ok := fs3.openFiles.get(key); ok {
+			return &s3ReadFile{
+				fs:     fs3,
+				name:   key,
+				bucket: bucket,
+				key:    key,
+				reader: &memReader{buf: buf},
+				info:   newFileInfo(name, buf.size(), time.Now(), nil),
+				lock:   newFileLock(fs3, bucket, key),
+			}, nil
+		}
+	}
+
+	// Run the GetObject operation. Objects in other buckets (e.g. inventory
+	// reports) aren't encrypted with the filesystem's key.
+	in := &s3.GetObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
-	})
+	}
+	if bucket == fs3.bucket {
+		fs3.enc.applyGet(in)
+		fs3.file.applyGet(in)
+	}
+	res, err := fs3.client.GetObject(ctx, in, fs3.optFns...)
+	if isNotFound(err) {
+		return nil, &os.PathError{Op: "open", Path: key, Err: os.ErrNotExist}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to perform GetObject operation: %w", err)
 	}
 
 	// Read the file contents and store in a bytes reader
+	defer res.Body.Close()
 	buf, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read file body: %w", err)
 	}
-	reader := bytes.NewReader(buf)
+	var reader readSeekerAt = bytes.NewReader(buf)
+	size := int64(len(buf))
+
+	// Decrypt and decompress the contents as they're read
+	if bucket == fs3.bucket {
+		if reader, size, err = fs3.decryptBody(ctx, res.Metadata, buf); err != nil {
+			return nil, &os.PathError{Op: "open", Path: key, Err: err}
+		}
+		reader, size, err = decompressBody(aws.ToString(res.ContentEncoding), reader, size)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: key, Err: err}
+		}
+	}
+	info := newFileInfo(name, size, aws.ToTime(res.LastModified), getObjectInfo(key, res))
+	if bucket == fs3.bucket {
+		info = info.(s3FileInfo).withPOSIX(res.Metadata)
+	}
 
 	// Return the file
 	return &s3ReadFile{
-		client: client,
+		fs:     fs3,
+		name:   key,
 		bucket: bucket,
 		key:    key,
 		reader: reader,
+		info:   info,
+		lock:   newFileLock(fs3, bucket, key),
 	}, nil
 }
 
 // Name returns the name of the file as presented to Open.
 func (f *s3ReadFile) Name() string {
-	return f.key
+	return f.name
 }
 
 // Write implements os.Writer for billy.File
@@ -81,19 +148,33 @@ func (f *s3ReadFile) Write(p []byte) (n int, err error) {
 
 // Read implements os.Reader for billy.File
 func (f *s3ReadFile) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
 	return f.reader.Read(p)
 }
 
 // ReadAt implements io.ReaderAt for billy.File
 func (f *s3ReadFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
 	return f.reader.ReadAt(p, off)
 }
 
 // Seek implements io.Seeker for billy.File
 func (f *s3ReadFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
 	return f.reader.Seek(offset, whence)
 }
 
+// Stat returns a FileInfo describing the file, as of when it was opened.
+func (f *s3ReadFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
 // Close implements io.Closer for billy.File
 func (f *s3ReadFile) Close() error {
 	// Was the file already closed?
@@ -107,18 +188,18 @@ func (f *s3ReadFile) Close() error {
 	// Mark the file as closed
 	f.closed = true
 
-	return nil
+	return f.lock.release()
 }
 
-// Lock locks the file like e.g. flock. It protects against access from
-// other processes.
+// Lock locks the file like e.g. flock. Locks are held in memory, so they
+// only protect against access from the same process.
 func (f *s3ReadFile) Lock() error {
-	return ErrLockNotSupported
+	return f.lock.acquire()
 }
 
 // Unlock unlocks the file.
 func (f *s3ReadFile) Unlock() error {
-	return ErrLockNotSupported
+	return f.lock.release()
 }
 
 // Truncate the file.
@@ -131,34 +212,51 @@ func (f *s3ReadFile) Truncate(size int64) error {
 // Upon creation, a buffer is created to store the file contents. Upon close,
 // the file is uploaded to S3.
 type s3WriteFile struct {
-	client *s3.Client    // s3 skd client
-	bucket string        // S3 bucket name
-	key    string        // File object's key in S3
-	closed bool          // Is the file closed?
-	buf    *bytes.Buffer // Buffer for storing the file before it's uploaded
+	fs     *S3FS             // Filesystem the file was opened from
+	name   string            // Name of the file as presented to Open
+	bucket string            // S3 bucket name
+	key    string            // File object's key in S3
+	closed bool              // Is the file closed?
+	buf    *bytes.Buffer     // Buffer for storing the file before it's uploaded
+	lock   fileLock          // Lock state
+	xattrs map[string]string // Attributes set before the file is uploaded
 }
 
 // newS3WriteFile creates a new s3ReadFile.
-func newS3WriteFile(client *s3.Client, bucket, key string) (*s3WriteFile, error) {
+func newS3WriteFile(fs3 *S3FS, bucket, key string) (*s3WriteFile, error) {
 	// TODO: Validate the key
 	// ...
 
+	// Reserve space for the expected size, within reason
+	buf := bytes.NewBuffer(nil)
+	if n := fs3.file.sizeHint; n > 0 {
+		if n > maxReservedSize {
+			n = maxReservedSize
+		}
+		buf.Grow(int(n))
+	}
+
 	return &s3WriteFile{
-		client: client,
+		fs:     fs3,
+		name:   key,
 		bucket: bucket,
 		key:    key,
-		buf:    bytes.NewBuffer(nil),
+		buf:    buf,
+		lock:   newFileLock(fs3, bucket, key),
 	}, nil
 }
 
 // Name returns the name of the file as presented to Open.
 func (f *s3WriteFile) Name() string {
-	return f.key
+	return f.name
 }
 
 // Write implements os.Writer for billy.File
 func (f *s3WriteFile) Write(p []byte) (n int, err error) {
-	return 0, nil
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	return f.buf.Write(p)
 }
 
 // Read implements os.Reader for billy.File
@@ -184,36 +282,24 @@ func (f *s3WriteFile) Close() error {
 
 	// Set to closed
 	f.closed = true
-
-	// Extract the body from the buffer
-	body := bytes.NewReader(f.buf.Bytes())
+	defer f.lock.release()
 
 	// Create the context
 	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
 
-	// Run the GetObject operation
-	// TODO: Currently `res` is not used. Should it be?
-	_, err := f.client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket: &f.bucket,
-		Key:    &f.key,
-		Body:   body,
-	})
-	if err != nil {
-		return fmt.Errorf("unable to perform GetObject operation: %w", err)
-	}
-
-	return nil
+	// Upload the file
+	return f.fs.putObject(ctx, f.key, f.buf.Bytes(), f.xattrs)
 }
 
-// Lock locks the file like e.g. flock. It protects against access from
-// other processes.
+// Lock locks the file like e.g. flock. Locks are held in memory, so they
+// only protect against access from the same process.
 func (f *s3WriteFile) Lock() error {
-	return ErrLockNotSupported
+	return f.lock.acquire()
 }
 
 // Unlock unlocks the file.
 func (f *s3WriteFile) Unlock() error {
-	return ErrLockNotSupported
+	return f.lock.release()
 }
 
 // Truncate the file.
@@ -221,81 +307,217 @@ func (f *s3WriteFile) Truncate(size int64) error {
 	return ErrTruncateNotSupported
 }
 
-// s3MultipartUploadFile implements billy.File
-type s3MultipartUploadFile struct {
-	client   *s3.Client    // s3 skd client
-	bucket   string        // S3 bucket name
-	key      string        // File object's key in S3
-	closed   bool          // Is the file closed?
-	uploadID string        // S3 multipart upload ID
-	uploadN  *atomic.Int32 // Counter tracking the number of uploads
+// SetXattr sets an attribute of the file, which is stored when the file is
+// uploaded.
+func (f *s3WriteFile) SetXattr(name, value string) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	if f.xattrs == nil {
+		f.xattrs = make(map[string]string)
+	}
+	return setXattr(f.xattrs, name, value)
 }
 
-// newS3MultipartUploadFile creates a new s3ReadFile.
-func newS3MultipartUploadFile(client *s3.Client, bucket, key string) (*s3MultipartUploadFile, error) {
+// s3MultipartUploadFile implements billy.File
+//
+// Writes are buffered until a full part has been collected, at which point
+// the part is uploaded in the background. The multipart upload is created
+// before the first part is uploaded, once the start of the file is known
+// and its Content-Type can be detected. Upon close, the final part is
+// uploaded and the multipart upload is completed.
+type s3MultipartUploadFile struct {
+	fs       *S3FS                 // Filesystem the file was opened from
+	name     string                // Name of the file as presented to Open
+	bucket   string                // S3 bucket name
+	key      string                // File object's key in S3
+	closed   bool                  // Is the file closed?
+	uploadID string                // S3 multipart upload ID
+	uploadN  *atomic.Int32         // Counter tracking the number of uploads
+	buf      *bytes.Buffer         // Buffer for the part currently being written
+	sem      chan struct{}         // Limits the number of concurrent part uploads
+	wg       sync.WaitGroup        // Tracks in-flight part uploads
+	mu       sync.Mutex            // Guards parts and err
+	parts    []types.CompletedPart // Parts that have been uploaded
+	err      error                 // First error returned by a part upload
+	lock     fileLock              // Lock state
+	w        *objectWriter         // Compresses and encrypts writes into buf
+	meta     map[string]string     // Object metadata
+	xattrs   map[string]string     // Attributes set on the file
+	late     bool                  // Were attributes set after the upload was created?
+	head     []byte                // Start of the file, for detecting its Content-Type
+	ctype    string                // Content-Type of the object
+	written  int64                 // Number of bytes written to the file
+	stored   int64                 // Number of bytes uploaded
+}
+
+// newS3MultipartUploadFile creates a new s3MultipartUploadFile.
+func newS3MultipartUploadFile(fs3 *S3FS, bucket, key string) (*s3MultipartUploadFile, error) {
 	// TODO: Check if the file exists
 	// ...
 
 	// Create the context
 	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
 
-	// Run the GetObject operation
-	res, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
-		Bucket: &bucket,
-		Key:    &key,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("unable to create multipart upload: %w", err)
+	// Create a data key, if the file is encrypted
+	var c *chunkCipher
+	var meta map[string]string
+	if fs3.cse != nil {
+		var err error
+		if c, meta, err = fs3.cse.newObjectCipher(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	// Return the file
-	return &s3MultipartUploadFile{
-		client:   client,
-		bucket:   bucket,
-		key:      key,
-		uploadID: *res.UploadId,
-		uploadN:  atomic.NewInt32(1),
-	}, nil
+	f := &s3MultipartUploadFile{
+		fs:      fs3,
+		name:    key,
+		bucket:  bucket,
+		key:     key,
+		uploadN: atomic.NewInt32(1),
+		buf:     bytes.NewBuffer(nil),
+		sem:     make(chan struct{}, fs3.concurrency),
+		lock:    newFileLock(fs3, bucket, key),
+		meta:    meta,
+	}
+	f.w = fs3.newObjectWriter(f.buf, c)
+	return f, nil
 }
 
 // Name returns the name of the file as presented to Open.
 func (f *s3MultipartUploadFile) Name() string {
-	return f.key
+	return f.name
 }
 
 // Write implements os.Writer for billy.File
 func (f *s3MultipartUploadFile) Write(p []byte) (n int, err error) {
-	// Get the size of the data being written
-	n = len(p)
+	if f.closed {
+		return 0, ErrFileClosed
+	}
 
-	// Create a context for the operation
-	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
+	// Fail early if a previous part failed to upload
+	if err := f.uploadErr(); err != nil {
+		return 0, err
+	}
 
-	// Create a reader for the data
-	r := bytes.NewReader(p)
+	// Keep the start of the file for detecting its Content-Type
+	if n := sniffLen - len(f.head); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		f.head = append(f.head, p[:n]...)
+	}
 
-	// Get the part number
-	pn := f.uploadN.Load()
-
-	// Run the UploadPart operation
-	_, err = f.client.UploadPart(ctx, &s3.UploadPartInput{
-		Bucket:     &f.bucket,
-		Key:        &f.key,
-		UploadId:   &f.uploadID,
-		PartNumber: pn,
-		Body:       r,
-	})
+	// Buffer the data
+	n, err = f.w.Write(p)
+	f.written += int64(n)
 	if err != nil {
-		return 0, fmt.Errorf("unable to upload part %d: %w", pn, err)
+		return n, err
 	}
 
-	// Increment the part number
-	f.uploadN.Add(1)
+	// Upload any full parts
+	if int64(f.buf.Len()) >= f.fs.partSize {
+		ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
+		if err := f.create(ctx); err != nil {
+			return 0, err
+		}
+	}
+	for int64(f.buf.Len()) >= f.fs.partSize {
+		part := make([]byte, f.fs.partSize)
+		copy(part, f.buf.Next(len(part)))
+		f.uploadPart(part)
+	}
 
 	// Return the number of bytes written
 	return n, nil
 }
 
+// create creates the multipart upload, if it hasn't been already.
+func (f *s3MultipartUploadFile) create(ctx context.Context) error {
+	if f.uploadID != "" {
+		return nil
+	}
+
+	// Run the CreateMultipartUpload operation. The POSIX attributes are
+	// kept with the other metadata, in case it's replaced when the file's
+	// closed.
+	f.ctype = f.fs.headers.contentType(f.fs.keyName(f.key), f.head)
+	f.meta = mergeMetadata(f.fs.posixMetadata(f.xattrs), f.meta)
+	in := &s3.CreateMultipartUploadInput{
+		Bucket:          &f.bucket,
+		Key:             &f.key,
+		StorageClass:    f.fs.storageClass,
+		Metadata:        mergeMetadata(f.xattrs, f.meta),
+		ContentEncoding: optString(f.fs.compression.contentEncoding()),
+	}
+	f.fs.enc.applyCreateMultipart(in)
+	f.fs.headers.applyCreateMultipart(in, f.ctype)
+	f.fs.file.applyCreateMultipart(in)
+	res, err := f.fs.client.CreateMultipartUpload(ctx, in, f.fs.optFns...)
+	if err != nil {
+		return fmt.Errorf("unable to create multipart upload: %w", err)
+	}
+	f.uploadID = *res.UploadId
+	return nil
+}
+
+// uploadPart uploads the data as the next part in the background.
+func (f *s3MultipartUploadFile) uploadPart(data []byte) {
+	// Get the part number
+	pn := f.uploadN.Inc() - 1
+	f.stored += int64(len(data))
+
+	// Wait for a free upload slot
+	f.sem <- struct{}{}
+	f.wg.Add(1)
+	go func() {
+		defer func() {
+			<-f.sem
+			f.wg.Done()
+		}()
+
+		// Create a context for the operation
+		ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
+
+		// Run the UploadPart operation
+		in := &s3.UploadPartInput{
+			Bucket:     &f.bucket,
+			Key:        &f.key,
+			UploadId:   &f.uploadID,
+			PartNumber: pn,
+			Body:       bytes.NewReader(data),
+		}
+		f.fs.enc.applyUploadPart(in)
+		f.fs.file.applyUploadPart(in)
+		res, err := f.fs.client.UploadPart(ctx, in, f.fs.optFns...)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if err != nil {
+			if f.err == nil {
+				f.err = fmt.Errorf("unable to upload part %d: %w", pn, err)
+			}
+			return
+		}
+		f.parts = append(f.parts, types.CompletedPart{
+			ETag:           res.ETag,
+			PartNumber:     pn,
+			ChecksumCRC32:  res.ChecksumCRC32,
+			ChecksumCRC32C: res.ChecksumCRC32C,
+			ChecksumSHA1:   res.ChecksumSHA1,
+			ChecksumSHA256: res.ChecksumSHA256,
+		})
+	}()
+}
+
+// uploadErr returns the first error returned by a part upload, if any.
+func (f *s3MultipartUploadFile) uploadErr() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
 // Read implements os.Reader for billy.File
 func (f *s3MultipartUploadFile) Read(p []byte) (n int, err error) {
 	return 0, ErrCantReadFromWriteOnly
@@ -320,36 +542,165 @@ func (f *s3MultipartUploadFile) Close() error {
 
 	// Set to closed
 	f.closed = true
+	defer f.lock.release()
 
 	// Create the context
 	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
 
+	// Compress and encrypt the end of the file. If that fails, the upload
+	// is aborted rather than completed with a truncated body.
+	if err := f.w.Close(); err != nil {
+		err = fmt.Errorf("unable to finish writing the file: %w", err)
+		if f.uploadID == "" {
+			return err
+		}
+		f.wg.Wait()
+		return f.abort(ctx, err)
+	}
+
+	// Create the upload, if no parts have been uploaded yet
+	if err := f.create(ctx); err != nil {
+		return err
+	}
+
+	// Upload the final part (S3 requires at least one part) and wait for
+	// the in-flight uploads to finish
+	if f.buf.Len() > 0 || f.uploadN.Load() == 1 {
+		f.uploadPart(f.buf.Bytes())
+	}
+	f.wg.Wait()
+
+	// If any part failed, abort the upload
+	if err := f.uploadErr(); err != nil {
+		return f.abort(ctx, err)
+	}
+
+	// Parts must be listed in order
+	sort.Slice(f.parts, func(i, j int) bool {
+		return f.parts[i].PartNumber < f.parts[j].PartNumber
+	})
+
 	// Complete the multipart upload
 	// TODO: Currently `res` is not used. Should it be?
-	_, err := f.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+	_, err := f.fs.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
 		Bucket:   &f.bucket,
 		Key:      &f.key,
 		UploadId: &f.uploadID,
-	})
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: f.parts,
+		},
+	}, f.fs.optFns...)
 	if err != nil {
 		return fmt.Errorf("unable to complete multipart upload: %w", err)
 	}
 
+	// The uncompressed size isn't known until the file is closed, nor are
+	// attributes set after the upload was created, so they're recorded by
+	// copying the object onto itself with the new metadata, in parts if
+	// it's larger than the copy threshold
+	if f.fs.compression != CompressionNone || f.late {
+		meta := mergeMetadata(f.xattrs, f.meta)
+		if f.fs.compression != CompressionNone {
+			meta = mergeMetadata(meta, map[string]string{
+				metaUncompressedSize: strconv.FormatInt(f.written, 10),
+			})
+		}
+		if err := f.replaceMetadata(ctx, meta); err != nil {
+			return fmt.Errorf("unable to record metadata: %w", err)
+		}
+	}
+
+	// The cached Stat result is now out of date
+	f.fs.statCache.remove(f.key)
+
 	return nil
 }
 
-// Lock locks the file like e.g. flock. It protects against access from
-// other processes.
+// replaceMetadata replaces the metadata of the uploaded object by copying
+// it onto itself, in parts if it's larger than the copy threshold.
+func (f *s3MultipartUploadFile) replaceMetadata(ctx context.Context, meta map[string]string) error {
+	if f.stored > f.fs.copyThreshold {
+		in := &s3.HeadObjectInput{
+			Bucket: &f.bucket,
+			Key:    &f.key,
+		}
+		f.fs.enc.applyHead(in)
+		head, err := f.fs.client.HeadObject(ctx, in, f.fs.optFns...)
+		if err != nil {
+			return fmt.Errorf("unable to perform HeadObject operation: %w", err)
+		}
+		return f.fs.copyInParts(ctx, f.key, f.key, head, meta)
+	}
+
+	in := &s3.CopyObjectInput{
+		Bucket:            &f.bucket,
+		CopySource:        aws.String(copySource(f.bucket, f.key)),
+		Key:               &f.key,
+		StorageClass:      f.fs.storageClass,
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Metadata:          meta,
+		ContentEncoding:   optString(f.fs.compression.contentEncoding()),
+	}
+	f.fs.enc.applyCopy(in, f.fs.enc)
+	f.fs.headers.applyCopy(in, f.ctype)
+	f.fs.file.applyCopy(in)
+	if _, err := f.fs.client.CopyObject(ctx, in, f.fs.optFns...); err != nil {
+		return fmt.Errorf("unable to perform CopyObject operation: %w", err)
+	}
+	return nil
+}
+
+// abort aborts the multipart upload, discarding any uploaded parts, and
+// returns err (annotated if the abort fails).
+func (f *s3MultipartUploadFile) abort(ctx context.Context, err error) error {
+	_, aerr := f.fs.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &f.bucket,
+		Key:      &f.key,
+		UploadId: &f.uploadID,
+	}, f.fs.optFns...)
+	if aerr != nil {
+		return fmt.Errorf("%s (unable to abort multipart upload: %w)", err, aerr)
+	}
+	return err
+}
+
+// Lock locks the file like e.g. flock. Locks are held in memory, so they
+// only protect against access from the same process.
 func (f *s3MultipartUploadFile) Lock() error {
-	return ErrLockNotSupported
+	return f.lock.acquire()
 }
 
 // Unlock unlocks the file.
 func (f *s3MultipartUploadFile) Unlock() error {
-	return ErrLockNotSupported
+	return f.lock.release()
 }
 
 // Truncate the file.
 func (f *s3MultipartUploadFile) Truncate(size int64) error {
 	return ErrTruncateNotSupported
 }
+
+// SetXattr sets an attribute of the file. Attributes set before the first
+// part is uploaded are sent when the upload is created; later ones are
+// recorded when the file is closed, by copying the object onto itself.
+func (f *s3MultipartUploadFile) SetXattr(name, value string) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	if f.xattrs == nil {
+		f.xattrs = make(map[string]string)
+	}
+	if err := setXattr(f.xattrs, name, value); err != nil {
+		return err
+	}
+	f.late = f.late || f.uploadID != ""
+	return nil
+}
+
+// optString returns a pointer to s, or nil if s is empty.
+func optString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,153 @@
+package s3fs_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+)
+
+func TestReadFile(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "0123456789")
+
+	f, err := fs3.Open("foo")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if f.Name() != "foo" {
+		t.Errorf("expected name %q, got %q", "foo", f.Name())
+	}
+
+	// Read
+	buf := make([]byte, 4)
+	if n, err := f.Read(buf); err != nil || string(buf[:n]) != "0123" {
+		t.Errorf("Read: got %q, %v", buf[:n], err)
+	}
+
+	// ReadAt doesn't move the offset
+	if n, err := f.ReadAt(buf, 6); err != nil || string(buf[:n]) != "6789" {
+		t.Errorf("ReadAt: got %q, %v", buf[:n], err)
+	}
+
+	// Seek
+	if off, err := f.Seek(-2, io.SeekEnd); err != nil || off != 8 {
+		t.Errorf("Seek: got %d, %v", off, err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != "89" {
+		t.Errorf("ReadAll after Seek: got %q, %v", rest, err)
+	}
+
+	// Writes are rejected
+	if _, err := f.Write([]byte("x")); err != s3fs.ErrCantWriteToReadOnly {
+		t.Errorf("Write: expected ErrCantWriteToReadOnly, got %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if err := f.Close(); err != s3fs.ErrFileClosed {
+		t.Errorf("second Close: expected ErrFileClosed, got %v", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	f, err := fs3.Create("foo")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	// Multiple writes are concatenated
+	for _, s := range []string{"hello", " ", "world"} {
+		if _, err := f.Write([]byte(s)); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+
+	// Reads are rejected
+	buf := make([]byte, 1)
+	if _, err := f.Read(buf); err != s3fs.ErrCantReadFromWriteOnly {
+		t.Errorf("Read: expected ErrCantReadFromWriteOnly, got %v", err)
+	}
+	if _, err := f.ReadAt(buf, 0); err != s3fs.ErrCantReadFromWriteOnly {
+		t.Errorf("ReadAt: expected ErrCantReadFromWriteOnly, got %v", err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if _, err := f.Write([]byte("x")); err != s3fs.ErrFileClosed {
+		t.Errorf("Write after Close: expected ErrFileClosed, got %v", err)
+	}
+	if got := readFile(t, fs3, "foo"); got != "hello world" {
+		t.Errorf("expected %q, got %q", "hello world", got)
+	}
+}
+
+func TestFileSeekWriteOnly(t *testing.T) {
+	deviation(t, "files opened for writing are streamed to S3 and can't seek")
+}
+
+func TestFileLock(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello")
+
+	f1, err := fs3.OpenFile("foo", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if err := f1.Lock(); err != nil {
+		t.Fatalf("Lock: %s", err)
+	}
+
+	// A second lock waits until the first is released
+	f2, err := fs3.Open("foo")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f2.Close()
+	locked := make(chan error)
+	go func() {
+		locked <- f2.Lock()
+	}()
+	select {
+	case <-locked:
+		t.Fatal("expected Lock to wait while the file is locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Closing a file releases its lock
+	f1.Close()
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("Lock: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Lock to succeed once the file was closed")
+	}
+	if err := f2.Unlock(); err != nil {
+		t.Errorf("Unlock: %s", err)
+	}
+}
+
+func TestFileTruncate(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "foo", "hello")
+
+	f, err := fs3.Open("foo")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+
+	if err := f.Truncate(0); err != s3fs.ErrTruncateNotSupported {
+		t.Errorf("expected ErrTruncateNotSupported, got %v", err)
+	}
+}
@@ -1,25 +1,198 @@
-package main
+package s3fs
 
 import (
 	"io/fs"
+	"net/http"
 	"os"
+	"regexp"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// InventoryFileInfo is implemented by the os.FileInfo values returned by
+// S3FS listings. It reports whether the entry was answered from an S3
+// Inventory report rather than a live listing.
+type InventoryFileInfo interface {
+	os.FileInfo
+
+	// Stale reports whether the entry came from an inventory report and
+	// may no longer reflect the contents of the bucket.
+	Stale() bool
+
+	// AsOf returns the time the inventory report was generated, or the
+	// zero time for entries from a live listing.
+	AsOf() time.Time
+}
+
+// ObjectInfo describes the S3 object behind a file. It's returned by the
+// Sys method of the os.FileInfo values for files described by a listing,
+// HeadObject (Stat) or GetObject (files opened for reading).
+//
+// Fields missing from the response are left empty. Listings don't include
+// the version, content type, checksum values, metadata or restore status,
+// and HeadObject and GetObject responses don't include the owner.
+type ObjectInfo struct {
+	Key                string                             // Object key
+	ETag               string                             // Entity tag, including its quotes
+	VersionID          string                             // Version ID, in buckets with versioning enabled
+	StorageClass       types.StorageClass                 // Storage class
+	ChecksumAlgorithms []types.ChecksumAlgorithm          // Algorithms of the additional checksums
+	Checksums          map[types.ChecksumAlgorithm]string // Base64-encoded additional checksums, by algorithm
+	ContentType        string                             // Content-Type header
+	Metadata           map[string]string                  // User metadata, or nil if it isn't known
+	Owner              *types.Owner                       // Owner, if known
+	UID                int                                // User ID from the POSIX attributes, or -1 if unknown
+	GID                int                                // Group ID from the POSIX attributes, or -1 if unknown
+	Restore            *RestoreStatus                     // Restore status of archived objects, or nil
+}
+
+// RestoreStatus is the status of the restore of an archived object.
+type RestoreStatus struct {
+	InProgress bool      // Is the object still being restored?
+	ExpiryDate time.Time // When the restored copy expires, once it's restored
+}
+
+// restoreParam matches a parameter of an x-amz-restore header.
+var restoreParam = regexp.MustCompile(`([a-z-]+)="([^"]*)"`)
+
+// parseRestore parses an x-amz-restore header, such as
+// `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`.
+func parseRestore(h *string) *RestoreStatus {
+	if h == nil {
+		return nil
+	}
+	rs := &RestoreStatus{}
+	for _, m := range restoreParam.FindAllStringSubmatch(*h, -1) {
+		switch m[1] {
+		case "ongoing-request":
+			rs.InProgress = m[2] == "true"
+		case "expiry-date":
+			rs.ExpiryDate, _ = http.ParseTime(m[2])
+		}
+	}
+	return rs
+}
+
+// checksumMap collects the checksum values of a response.
+func checksumMap(crc32, crc32c, sha1, sha256 *string) map[types.ChecksumAlgorithm]string {
+	var m map[types.ChecksumAlgorithm]string
+	for alg, v := range map[types.ChecksumAlgorithm]*string{
+		types.ChecksumAlgorithmCrc32:  crc32,
+		types.ChecksumAlgorithmCrc32c: crc32c,
+		types.ChecksumAlgorithmSha1:   sha1,
+		types.ChecksumAlgorithmSha256: sha256,
+	} {
+		if v == nil {
+			continue
+		}
+		if m == nil {
+			m = make(map[types.ChecksumAlgorithm]string)
+		}
+		m[alg] = *v
+	}
+	return m
+}
+
+// checksumAlgorithms returns the algorithms of a set of checksums, in a
+// stable order.
+func checksumAlgorithms(m map[types.ChecksumAlgorithm]string) []types.ChecksumAlgorithm {
+	var algs []types.ChecksumAlgorithm
+	for _, alg := range types.ChecksumAlgorithm("").Values() {
+		if _, ok := m[alg]; ok {
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// storageClass returns the storage class of an object, which S3 omits
+// from responses for STANDARD objects.
+func storageClass(c types.StorageClass) types.StorageClass {
+	if c == "" {
+		return types.StorageClassStandard
+	}
+	return c
+}
+
+// listedObject describes an object from a listing.
+func listedObject(o types.Object) *ObjectInfo {
+	return &ObjectInfo{
+		Key:                aws.ToString(o.Key),
+		ETag:               aws.ToString(o.ETag),
+		StorageClass:       storageClass(types.StorageClass(o.StorageClass)),
+		ChecksumAlgorithms: o.ChecksumAlgorithm,
+		Owner:              o.Owner,
+		UID:                -1,
+		GID:                -1,
+	}
+}
+
+// headObjectInfo describes an object from a HeadObject response.
+func headObjectInfo(key string, res *s3.HeadObjectOutput) *ObjectInfo {
+	sums := checksumMap(res.ChecksumCRC32, res.ChecksumCRC32C, res.ChecksumSHA1, res.ChecksumSHA256)
+	return &ObjectInfo{
+		Key:                key,
+		ETag:               aws.ToString(res.ETag),
+		VersionID:          aws.ToString(res.VersionId),
+		StorageClass:       storageClass(res.StorageClass),
+		ChecksumAlgorithms: checksumAlgorithms(sums),
+		Checksums:          sums,
+		ContentType:        aws.ToString(res.ContentType),
+		Metadata:           knownMetadata(res.Metadata),
+		UID:                parseID(res.Metadata[metaUID]),
+		GID:                parseID(res.Metadata[metaGID]),
+		Restore:            parseRestore(res.Restore),
+	}
+}
+
+// getObjectInfo describes an object from a GetObject response.
+func getObjectInfo(key string, res *s3.GetObjectOutput) *ObjectInfo {
+	sums := checksumMap(res.ChecksumCRC32, res.ChecksumCRC32C, res.ChecksumSHA1, res.ChecksumSHA256)
+	return &ObjectInfo{
+		Key:                key,
+		ETag:               aws.ToString(res.ETag),
+		VersionID:          aws.ToString(res.VersionId),
+		StorageClass:       storageClass(res.StorageClass),
+		ChecksumAlgorithms: checksumAlgorithms(sums),
+		Checksums:          sums,
+		ContentType:        aws.ToString(res.ContentType),
+		Metadata:           knownMetadata(res.Metadata),
+		UID:                parseID(res.Metadata[metaUID]),
+		GID:                parseID(res.Metadata[metaGID]),
+		Restore:            parseRestore(res.Restore),
+	}
+}
+
+// knownMetadata returns the user metadata from a response that includes
+// it, which is non-nil even if the object has none.
+func knownMetadata(meta map[string]string) map[string]string {
+	if m := userMetadata(meta); m != nil {
+		return m
+	}
+	return map[string]string{}
+}
+
 // s3FileInfo implements os.FileInfo
 type s3FileInfo struct {
 	name    string
 	size    int64
 	mode    os.FileMode
 	modTime time.Time
+	obj     *ObjectInfo // The object behind a file, if known
+	stale   bool        // Was the entry read from an inventory report?
+	asOf    time.Time   // When the inventory report was generated
 }
 
-func newFileInfo(name string, size int64, modTime time.Time) os.FileInfo {
+func newFileInfo(name string, size int64, modTime time.Time, obj *ObjectInfo) os.FileInfo {
 	return s3FileInfo{
 		name:    name,
 		size:    size,
 		mode:    0666,
 		modTime: modTime,
+		obj:     obj,
 	}
 }
 
@@ -31,6 +204,26 @@ func newDirInfo(name string) os.FileInfo {
 	}
 }
 
+func newInventoryFileInfo(name string, size int64, modTime, asOf time.Time) os.FileInfo {
+	return s3FileInfo{
+		name:    name,
+		size:    size,
+		mode:    0666,
+		modTime: modTime,
+		stale:   true,
+		asOf:    asOf,
+	}
+}
+
+func newInventoryDirInfo(name string, asOf time.Time) os.FileInfo {
+	return s3FileInfo{
+		name:  name,
+		mode:  fs.ModeDir,
+		stale: true,
+		asOf:  asOf,
+	}
+}
+
 func (fi s3FileInfo) Name() string {
 	return fi.name
 }
@@ -47,10 +240,23 @@ func (fi s3FileInfo) IsDir() bool {
 	return fi.mode.IsDir()
 }
 
+// Sys returns the *ObjectInfo describing the object behind a file, or
+// nil for directories and files that haven't been uploaded yet.
 func (fi s3FileInfo) Sys() interface{} {
-	return nil
+	if fi.obj == nil {
+		return nil
+	}
+	return fi.obj
 }
 
 func (fi s3FileInfo) ModTime() time.Time {
 	return fi.modTime
 }
+
+func (fi s3FileInfo) Stale() bool {
+	return fi.stale
+}
+
+func (fi s3FileInfo) AsOf() time.Time {
+	return fi.asOf
+}
@@ -0,0 +1,144 @@
+package s3fs_test
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// restoringClient reports every object as restored from an archive.
+type restoringClient struct {
+	s3fs.S3API
+}
+
+func (c *restoringClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	res, err := c.S3API.HeadObject(ctx, params, optFns...)
+	if err == nil {
+		res.Restore = aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`)
+	}
+	return res, err
+}
+
+// objectInfo returns the *ObjectInfo from a FileInfo's Sys method.
+func objectInfo(t *testing.T, sys interface{}) *s3fs.ObjectInfo {
+	t.Helper()
+
+	obj, ok := sys.(*s3fs.ObjectInfo)
+	if !ok {
+		t.Fatalf("expected Sys to return an *ObjectInfo, got %T", sys)
+	}
+	return obj
+}
+
+func TestObjectInfo(t *testing.T) {
+	client, backend := newTestClient(t)
+	if err := backend.SetVersioning(testBucket, true); err != nil {
+		t.Fatalf("SetVersioning: %s", err)
+	}
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeWithOptions(t, fs3, "dir/data.json", s3fs.O_WRONLY, []byte(`{"a":1}`),
+		s3fs.WithFileMetadata(map[string]string{"build-id": "42"}),
+		s3fs.WithFileChecksum(types.ChecksumAlgorithmSha256),
+		s3fs.WithFileStorageClass(types.StorageClassStandardIa),
+	)
+	head := headObject(t, client, "dir/data.json")
+
+	// Stat describes the object with HeadObject
+	fi, err := fs3.Stat("dir/data.json")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	obj := objectInfo(t, fi.Sys())
+	if obj.Key != "dir/data.json" || obj.ETag != aws.ToString(head.ETag) || obj.VersionID != aws.ToString(head.VersionId) {
+		t.Errorf("Stat: unexpected key, ETag or version in %+v", obj)
+	}
+	if obj.StorageClass != types.StorageClassStandardIa {
+		t.Errorf("Stat: expected storage class %q, got %q", types.StorageClassStandardIa, obj.StorageClass)
+	}
+	if obj.ContentType != "application/json" {
+		t.Errorf("Stat: expected content type %q, got %q", "application/json", obj.ContentType)
+	}
+	if !reflect.DeepEqual(obj.ChecksumAlgorithms, []types.ChecksumAlgorithm{types.ChecksumAlgorithmSha256}) || obj.Checksums[types.ChecksumAlgorithmSha256] == "" {
+		t.Errorf("Stat: expected a SHA-256 checksum, got %v and %v", obj.ChecksumAlgorithms, obj.Checksums)
+	}
+	if !reflect.DeepEqual(obj.Metadata, map[string]string{"build-id": "42"}) {
+		t.Errorf("Stat: unexpected metadata %v", obj.Metadata)
+	}
+	if obj.Owner != nil || obj.Restore != nil {
+		t.Errorf("Stat: expected no owner or restore status, got %v and %v", obj.Owner, obj.Restore)
+	}
+
+	// Listings include the owner, but not the metadata
+	fis, err := fs3.ReadDir("dir")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if len(fis) != 1 {
+		t.Fatalf("ReadDir: expected 1 entry, got %d", len(fis))
+	}
+	obj = objectInfo(t, fis[0].Sys())
+	if obj.ETag != aws.ToString(head.ETag) || obj.StorageClass != types.StorageClassStandardIa {
+		t.Errorf("ReadDir: unexpected ETag or storage class in %+v", obj)
+	}
+	if !reflect.DeepEqual(obj.ChecksumAlgorithms, []types.ChecksumAlgorithm{types.ChecksumAlgorithmSha256}) {
+		t.Errorf("ReadDir: unexpected checksum algorithms %v", obj.ChecksumAlgorithms)
+	}
+	if obj.Owner == nil || aws.ToString(obj.Owner.ID) != s3mem.OwnerID {
+		t.Errorf("ReadDir: expected owner %q, got %v", s3mem.OwnerID, obj.Owner)
+	}
+	if obj.Metadata != nil {
+		t.Errorf("ReadDir: expected unknown metadata, got %v", obj.Metadata)
+	}
+
+	// Files opened for reading are described by GetObject
+	f, err := fs3.Open("dir/data.json")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+	fi, err = f.(interface{ Stat() (os.FileInfo, error) }).Stat()
+	if err != nil {
+		t.Fatalf("f.Stat: %s", err)
+	}
+	if obj := objectInfo(t, fi.Sys()); obj.VersionID != aws.ToString(head.VersionId) {
+		t.Errorf("f.Stat: expected version %q, got %q", aws.ToString(head.VersionId), obj.VersionID)
+	}
+
+	// Directories have no object
+	fi, err = fs3.Stat("dir")
+	if err != nil {
+		t.Fatalf("Stat(dir): %s", err)
+	}
+	if fi.Sys() != nil {
+		t.Errorf("Stat(dir): expected no object, got %v", fi.Sys())
+	}
+}
+
+func TestObjectInfoRestore(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(&restoringClient{S3API: client}, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeFile(t, fs3, "archived", "data")
+
+	fi, err := fs3.Stat("archived")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	expected := &s3fs.RestoreStatus{ExpiryDate: time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC)}
+	if got := objectInfo(t, fi.Sys()).Restore; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected restore status %+v, got %+v", expected, got)
+	}
+}
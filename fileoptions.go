@@ -0,0 +1,282 @@
+// fileoptions.go defines the options accepted by OpenFileWithOptions
+
+package s3fs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	MaxParts = 10000 // Maximum number of parts in a multipart upload
+
+	maxReservedSize = 64 * 1024 * 1024 // Most space reserved for a size hint
+)
+
+// FileOption configures a single file opened with OpenFileWithOptions.
+type FileOption func(*fileConfig) error
+
+// openMode is a set of ways of opening a file that an option applies to.
+type openMode int
+
+const (
+	openRead      openMode = 1 << iota // Read-only
+	openWrite                          // Any mode that writes the file
+	openMultipart                      // O_WRMULTIPART
+)
+
+// fileConfig collects the settings of a file opened with
+// OpenFileWithOptions.
+type fileConfig struct {
+	fs3   *S3FS               // Copy of the filesystem, holding the file's settings
+	modes map[string]openMode // Modes each option applies to, by name
+	perm  os.FileMode         // Permissions to open the file with
+}
+
+// fileSettings holds the settings for a single file, which are applied to
+// the requests made for it.
+type fileSettings struct {
+	metadata  map[string]string       // User metadata
+	tagging   string                  // URL-encoded tags
+	acl       types.ObjectCannedACL   // Canned ACL
+	checksum  types.ChecksumAlgorithm // Additional checksum algorithm
+	sizeHint  int64                   // Expected size of the file (0 if unknown)
+	versionID string                  // Version to read
+	perm      os.FileMode             // Permissions the file was opened with
+}
+
+// WithFileMetadata sets user metadata (x-amz-meta-* headers) on the file
+// when it's written. Keys and values must be valid attributes, as for
+// SetXattr.
+func WithFileMetadata(md map[string]string) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileMetadata"] = openWrite
+		m := make(map[string]string, len(md))
+		for k, v := range md {
+			if err := setXattr(m, k, v); err != nil {
+				return fmt.Errorf("%w: %s", ErrInvalidOption, err)
+			}
+		}
+		c.fs3.file.metadata = m
+		return nil
+	}
+}
+
+// WithFileMode sets the permissions the file is opened with when it's
+// written, like the perm argument of OpenFile. They're stored with the
+// file if the filesystem keeps POSIX attributes (see WithPOSIXMetadata).
+// The default is 0666.
+func WithFileMode(perm os.FileMode) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileMode"] = openWrite
+		if perm&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+			return fmt.Errorf("%w: invalid file mode %v", ErrInvalidOption, perm)
+		}
+		c.perm = perm
+		return nil
+	}
+}
+
+// WithFileTags sets tags on the file when it's written.
+func WithFileTags(tags map[string]string) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileTags"] = openWrite
+		q := make(url.Values, len(tags))
+		for k, v := range tags {
+			if k == "" {
+				return fmt.Errorf("%w: tag keys cannot be empty", ErrInvalidOption)
+			}
+			q.Set(k, v)
+		}
+		c.fs3.file.tagging = q.Encode()
+		return nil
+	}
+}
+
+// WithFileStorageClass sets the storage class of the file when it's
+// written, instead of the filesystem's.
+func WithFileStorageClass(class types.StorageClass) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileStorageClass"] = openWrite
+		return WithStorageClass(class)(c.fs3)
+	}
+}
+
+// WithFileACL sets a canned ACL on the file when it's written.
+func WithFileACL(acl types.ObjectCannedACL) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileACL"] = openWrite
+		for _, a := range acl.Values() {
+			if a == acl {
+				c.fs3.file.acl = acl
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: unknown ACL %q", ErrInvalidOption, acl)
+	}
+}
+
+// WithFileEncryption sets the server-side encryption used to read and
+// write the file, instead of the filesystem's. This is needed, for
+// example, to read an object encrypted with a different SSE-C customer key.
+//
+// The key isn't remembered once the file is closed. Rename only knows the
+// filesystem's encryption settings, so a file written with its own SSE-C
+// key can't be renamed; copy it by opening both files with this option
+// and remove the original instead.
+func WithFileEncryption(enc Encryption) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileEncryption"] = openRead | openWrite
+		return WithEncryption(enc)(c.fs3)
+	}
+}
+
+// WithFileHeaders sets the HTTP headers stored with the file when it's
+// written, instead of the filesystem's (see Headers).
+func WithFileHeaders(h Headers) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileHeaders"] = openWrite
+		return WithHeaders(h)(c.fs3)
+	}
+}
+
+// WithFileChecksum has S3 verify and store an additional checksum of the
+// file when it's written, calculated with the given algorithm.
+func WithFileChecksum(alg types.ChecksumAlgorithm) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileChecksum"] = openWrite
+		for _, a := range alg.Values() {
+			if a == alg {
+				c.fs3.file.checksum = alg
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: unknown checksum algorithm %q", ErrInvalidOption, alg)
+	}
+}
+
+// WithFilePartSize sets the size of each part of a multipart upload,
+// instead of the filesystem's.
+func WithFilePartSize(size int64) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFilePartSize"] = openMultipart
+		return WithPartSize(size)(c.fs3)
+	}
+}
+
+// WithFileSizeHint gives the expected size of the file. Write-only files
+// reserve space for it (up to 64 MiB), and multipart uploads use parts
+// large enough for the file to fit into MaxParts parts.
+func WithFileSizeHint(size int64) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileSizeHint"] = openWrite
+		if size < 0 {
+			return fmt.Errorf("%w: size hint cannot be negative, got %d", ErrInvalidOption, size)
+		}
+		c.fs3.file.sizeHint = size
+		return nil
+	}
+}
+
+// WithFileVersion reads the given version of the file, in a bucket with
+// versioning enabled.
+func WithFileVersion(versionID string) FileOption {
+	return func(c *fileConfig) error {
+		c.modes["WithFileVersion"] = openRead
+		if versionID == "" {
+			return fmt.Errorf("%w: version ID cannot be empty", ErrInvalidOption)
+		}
+		c.fs3.file.versionID = versionID
+		return nil
+	}
+}
+
+// OpenFileWithOptions is like OpenFile, but configures the file with the
+// given options. Options that don't apply to the way the file is opened
+// (e.g. WithFileVersion for a file opened for writing, or
+// WithFilePartSize for one that isn't opened with O_WRMULTIPART) are
+// rejected.
+func (fs3 *S3FS) OpenFileWithOptions(filename string, flag int, opts ...FileOption) (billy.File, error) {
+	nfs := *fs3
+	nfs.file = fileSettings{}
+	c := &fileConfig{fs3: &nfs, modes: make(map[string]openMode), perm: 0666}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check the options against the open mode
+	mode := openRead
+	switch {
+	case flag&O_WRMULTIPART != 0:
+		mode = openWrite | openMultipart
+	case flag&(O_WRONLY|O_RDWR|O_APPEND) != 0:
+		mode = openWrite
+	}
+	for name, m := range c.modes {
+		if m&mode == 0 {
+			return nil, fmt.Errorf("%w: %s can't be used with open flag %#x", ErrInvalidOption, name, flag)
+		}
+	}
+
+	// Use parts large enough for the expected size
+	if hint := nfs.file.sizeHint; hint > 0 && mode&openMultipart != 0 {
+		if size := (hint + MaxParts - 1) / MaxParts; size > nfs.partSize {
+			if size > MaxPartSize {
+				return nil, fmt.Errorf("%w: a file of %d bytes is too large for a multipart upload", ErrInvalidOption, hint)
+			}
+			nfs.partSize = size
+		}
+	}
+
+	return nfs.OpenFile(filename, flag, c.perm)
+}
+
+// applyPut sets the file's settings on a PutObject request.
+func (s fileSettings) applyPut(in *s3.PutObjectInput) {
+	in.Metadata = mergeMetadata(s.metadata, in.Metadata)
+	in.Tagging = optString(s.tagging)
+	in.ACL = s.acl
+	in.ChecksumAlgorithm = s.checksum
+}
+
+// applyCreateMultipart sets the file's settings on a CreateMultipartUpload
+// request.
+func (s fileSettings) applyCreateMultipart(in *s3.CreateMultipartUploadInput) {
+	in.Metadata = mergeMetadata(s.metadata, in.Metadata)
+	in.Tagging = optString(s.tagging)
+	in.ACL = s.acl
+	in.ChecksumAlgorithm = s.checksum
+}
+
+// applyUploadPart sets the file's settings on an UploadPart request.
+func (s fileSettings) applyUploadPart(in *s3.UploadPartInput) {
+	in.ChecksumAlgorithm = s.checksum
+}
+
+// applyCopy sets the file's settings on a CopyObject request that copies
+// the file onto itself. Its tags are kept.
+func (s fileSettings) applyCopy(in *s3.CopyObjectInput) {
+	in.Metadata = mergeMetadata(s.metadata, in.Metadata)
+	in.ACL = s.acl
+	in.ChecksumAlgorithm = s.checksum
+}
+
+// applyCopyParts sets the file's settings on a CreateMultipartUpload
+// request that copies the file onto itself in parts. Its tags are kept.
+func (s fileSettings) applyCopyParts(in *s3.CreateMultipartUploadInput) {
+	in.Metadata = mergeMetadata(s.metadata, in.Metadata)
+	in.ACL = s.acl
+	in.ChecksumAlgorithm = s.checksum
+}
+
+// applyGet sets the file's settings on a GetObject request.
+func (s fileSettings) applyGet(in *s3.GetObjectInput) {
+	in.VersionId = optString(s.versionID)
+}
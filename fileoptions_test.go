@@ -0,0 +1,246 @@
+package s3fs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// writeWithOptions writes a file opened with OpenFileWithOptions.
+func writeWithOptions(t *testing.T, fs3 *s3fs.S3FS, name string, flag int, data []byte, opts ...s3fs.FileOption) {
+	t.Helper()
+
+	f, err := fs3.OpenFileWithOptions(name, flag, opts...)
+	if err != nil {
+		t.Fatalf("OpenFileWithOptions(%q): %s", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+}
+
+func TestOpenFileWithOptions(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	for name, flag := range map[string]int{"single": s3fs.O_WRONLY, "multipart": s3fs.O_WRMULTIPART, "rw": s3fs.O_RDWR | s3fs.O_CREATE} {
+		writeWithOptions(t, fs3, name, flag, []byte("hello"),
+			s3fs.WithFileMetadata(map[string]string{"Build-ID": "42"}),
+			s3fs.WithFileTags(map[string]string{"team": "data", "env": "prod"}),
+			s3fs.WithFileStorageClass(types.StorageClassStandardIa),
+			s3fs.WithFileACL(types.ObjectCannedACLPrivate),
+		)
+
+		res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String(name),
+		})
+		if err != nil {
+			t.Fatalf("GetObject(%q): %s", name, err)
+		}
+		res.Body.Close()
+		if got := res.Metadata["build-id"]; got != "42" {
+			t.Errorf("%s: expected metadata build-id=42, got %v", name, res.Metadata)
+		}
+		if res.TagCount != 2 {
+			t.Errorf("%s: expected 2 tags, got %d", name, res.TagCount)
+		}
+		if res.StorageClass != types.StorageClassStandardIa {
+			t.Errorf("%s: expected storage class %q, got %q", name, types.StorageClassStandardIa, res.StorageClass)
+		}
+		if got := readFile(t, fs3, name); got != "hello" {
+			t.Errorf("%s: expected %q, got %q", name, "hello", got)
+		}
+	}
+}
+
+func TestFileChecksum(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	headChecksums := func(key string) *s3.HeadObjectOutput {
+		res, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket:       aws.String(testBucket),
+			Key:          aws.String(key),
+			ChecksumMode: types.ChecksumModeEnabled,
+		})
+		if err != nil {
+			t.Fatalf("HeadObject(%q): %s", key, err)
+		}
+		return res
+	}
+
+	// Single uploads store the checksum of the whole object
+	data := []byte("checksummed")
+	writeWithOptions(t, fs3, "small", s3fs.O_WRONLY, data, s3fs.WithFileChecksum(types.ChecksumAlgorithmSha256))
+	sum := sha256.Sum256(data)
+	if got, expected := aws.ToString(headChecksums("small").ChecksumSHA256), base64.StdEncoding.EncodeToString(sum[:]); got != expected {
+		t.Errorf("expected SHA-256 checksum %q, got %q", expected, got)
+	}
+
+	// Multipart uploads store a checksum of the parts' checksums
+	big := testData(int(s3fs.MinPartSize) + 1024)
+	writeWithOptions(t, fs3, "big", s3fs.O_WRMULTIPART, big, s3fs.WithFileChecksum(types.ChecksumAlgorithmCrc32))
+	if got := aws.ToString(headChecksums("big").ChecksumCRC32); !strings.HasSuffix(got, "-2") {
+		t.Errorf("expected a composite CRC32 checksum of 2 parts, got %q", got)
+	}
+	if got := readFile(t, fs3, "big"); got != string(big) {
+		t.Errorf("multipart upload contents don't match (got %d bytes, expected %d)", len(got), len(big))
+	}
+}
+
+func TestFilePartSize(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	data := testData(int(3 * s3fs.MinPartSize))
+
+	for _, tc := range []struct {
+		name  string
+		opt   s3fs.FileOption
+		parts int32
+	}{
+		{"default", nil, 3},
+		{"part-size", s3fs.WithFilePartSize(2 * s3fs.MinPartSize), 2},
+		{"size-hint", s3fs.WithFileSizeHint(2 * s3fs.MinPartSize * s3fs.MaxParts), 2},
+	} {
+		var opts []s3fs.FileOption
+		if tc.opt != nil {
+			opts = append(opts, tc.opt)
+		}
+		writeWithOptions(t, fs3, tc.name, s3fs.O_WRMULTIPART, data, opts...)
+		if got := headObject(t, client, tc.name).PartsCount; got != tc.parts {
+			t.Errorf("%s: expected %d parts, got %d", tc.name, tc.parts, got)
+		}
+	}
+
+	// Size hints for write-only files just reserve space, and huge hints
+	// don't reserve all of it
+	for _, hint := range []int64{1024, 1 << 62} {
+		writeWithOptions(t, fs3, "hinted", s3fs.O_WRONLY, []byte("hi"), s3fs.WithFileSizeHint(hint))
+		if got := readFile(t, fs3, "hinted"); got != "hi" {
+			t.Errorf("expected %q, got %q", "hi", got)
+		}
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithPOSIXMetadata(true))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	for name, tc := range map[string]struct {
+		opts []s3fs.FileOption
+		perm os.FileMode
+	}{
+		"default": {nil, 0666},
+		"mode":    {[]s3fs.FileOption{s3fs.WithFileMode(0640)}, 0640},
+	} {
+		writeWithOptions(t, fs3, name, s3fs.O_WRONLY, []byte("data"), tc.opts...)
+		fi, err := fs3.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%q): %s", name, err)
+		}
+		if fi.Mode() != tc.perm {
+			t.Errorf("%s: expected mode %v, got %v", name, tc.perm, fi.Mode())
+		}
+	}
+}
+
+func TestFileVersion(t *testing.T) {
+	client, backend := newTestClient(t)
+	if err := backend.SetVersioning(testBucket, true); err != nil {
+		t.Fatalf("SetVersioning: %s", err)
+	}
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	writeFile(t, fs3, "config.json", "v1")
+	v1 := aws.ToString(headObject(t, client, "config.json").VersionId)
+	writeFile(t, fs3, "config.json", "v2")
+
+	f, err := fs3.OpenFileWithOptions("config.json", s3fs.O_RDONLY, s3fs.WithFileVersion(v1))
+	if err != nil {
+		t.Fatalf("OpenFileWithOptions: %s", err)
+	}
+	defer f.Close()
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(got) != "v1" {
+		t.Errorf("expected the old version %q, got %q", "v1", got)
+	}
+	if got := readFile(t, fs3, "config.json"); got != "v2" {
+		t.Errorf("expected the latest version %q, got %q", "v2", got)
+	}
+}
+
+func TestFileOptionsValidation(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeFile(t, fs3, "exists", "x")
+
+	for name, tc := range map[string]struct {
+		flag int
+		opt  s3fs.FileOption
+	}{
+		"version for writing":     {s3fs.O_WRONLY, s3fs.WithFileVersion("1")},
+		"empty version":           {s3fs.O_RDONLY, s3fs.WithFileVersion("")},
+		"metadata for reading":    {s3fs.O_RDONLY, s3fs.WithFileMetadata(map[string]string{"a": "b"})},
+		"reserved metadata":       {s3fs.O_WRONLY, s3fs.WithFileMetadata(map[string]string{"s3fs-cipher": "x"})},
+		"invalid metadata key":    {s3fs.O_WRONLY, s3fs.WithFileMetadata(map[string]string{"a b": "x"})},
+		"invalid metadata value":  {s3fs.O_WRONLY, s3fs.WithFileMetadata(map[string]string{"a": "line\nbreak"})},
+		"part size without parts": {s3fs.O_WRONLY, s3fs.WithFilePartSize(s3fs.MinPartSize)},
+		"small part size":         {s3fs.O_WRMULTIPART, s3fs.WithFilePartSize(1024)},
+		"unknown checksum":        {s3fs.O_WRONLY, s3fs.WithFileChecksum("MD4")},
+		"unknown ACL":             {s3fs.O_WRONLY, s3fs.WithFileACL("everyone")},
+		"unknown storage class":   {s3fs.O_WRONLY, s3fs.WithFileStorageClass("COLD")},
+		"negative size hint":      {s3fs.O_WRONLY, s3fs.WithFileSizeHint(-1)},
+		"mode for reading":        {s3fs.O_RDONLY, s3fs.WithFileMode(0600)},
+		"invalid mode":            {s3fs.O_WRONLY, s3fs.WithFileMode(os.ModeDir | 0755)},
+		"too large":               {s3fs.O_WRMULTIPART, s3fs.WithFileSizeHint(s3fs.MaxPartSize*s3fs.MaxParts + 1)},
+	} {
+		if _, err := fs3.OpenFileWithOptions("exists", tc.flag, tc.opt); !errors.Is(err, s3fs.ErrInvalidOption) {
+			t.Errorf("%s: expected ErrInvalidOption, got %v", name, err)
+		}
+	}
+
+	// Encryption applies to reads and writes
+	for _, flag := range []int{s3fs.O_RDONLY, s3fs.O_WRONLY} {
+		f, err := fs3.OpenFileWithOptions("exists", flag, s3fs.WithFileEncryption(s3fs.Encryption{Type: types.ServerSideEncryptionAes256}))
+		if err != nil {
+			t.Errorf("OpenFileWithOptions(%#x): %s", flag, err)
+			continue
+		}
+		f.Close()
+	}
+}
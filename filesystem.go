@@ -1,10 +1,12 @@
-package main
+// Package s3fs implements a go-billy Filesystem backed by an S3 bucket.
+package s3fs
 
 import (
 	"fmt"
-	"path"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/go-git/go-billy/v5"
 )
 
@@ -12,42 +14,96 @@ const (
 	DefaultSeparator = "/"
 )
 
+// Ensure S3FS implements billy.Filesystem
+var _ billy.Filesystem = (*S3FS)(nil)
+
+// S3FS is a billy.Filesystem backed by an S3 bucket.
 type S3FS struct {
-	client    *s3.Client
+	client    S3API
 	bucket    string
 	root      string
 	separator string
+	inventory *inventory // Inventory report used for listings (optional)
+
+	verifyChroot bool // Check that Chroot targets are existing directories?
+	posix        bool // Store POSIX attributes in the metadata of new files?
+	readDirStat  bool // Describe files listed by ReadDir with HeadObject?
+
+	dirMarker     DirMarkerStyle      // How directories are represented
+	storageClass  types.StorageClass  // Storage class for new objects
+	enc           encryption          // Encryption settings for objects
+	cse           *clientEncryption   // Client-side encryption (optional, shared with chroots)
+	names         *nameCipher         // Encrypts filenames (optional)
+	compression   Compression         // Compression for new files
+	headers       Headers             // HTTP headers for new files
+	file          fileSettings        // Settings for a single file (see OpenFileWithOptions)
+	partSize      int64               // Size of multipart upload parts
+	copyThreshold int64               // Size above which objects are copied in parts
+	concurrency   int                 // Number of concurrent part uploads
+	statCacheSize int                 // Number of cached Stat results
+	statCache     *statCache          // Cache of Stat results (shared with chroots)
+	locks         *lockTable          // Locks held on open files (shared with chroots)
+	openFiles     *openFiles          // Files open for reading and writing (shared with chroots)
+	optFns        []func(*s3.Options) // Per-request client options (e.g. retry policy)
 }
 
-// NewS3FS creates a new S3FS Filesystem.
-func NewS3FS(client *s3.Client, bucket string) (billy.Filesystem, error) {
+// NewS3FS creates a new S3FS Filesystem, configured with the given options.
+func NewS3FS(client S3API, bucket string, opts ...Option) (*S3FS, error) {
 	// Check for a non-nil client
 	if client == nil {
 		return nil, fmt.Errorf("s3 client cannot be nil")
 	}
-	return &S3FS{
-		client:    client,
-		bucket:    bucket,
-		root:      "",
-		separator: DefaultSeparator,
-	}, nil
-}
+	if bucket == "" {
+		return nil, fmt.Errorf("bucket name cannot be empty")
+	}
 
-// Capabilities returns the filesystem capabilities.
-func (fs3 *S3FS) Capabilities() billy.Capability {
-	return billy.ReadCapability | billy.WriteCapability
-}
+	fs3 := &S3FS{
+		client:        client,
+		bucket:        bucket,
+		root:          "",
+		separator:     DefaultSeparator,
+		dirMarker:     DirMarkerNone,
+		partSize:      DefaultPartSize,
+		copyThreshold: DefaultCopyThreshold,
+		concurrency:   DefaultConcurrency,
+		statCacheSize: DefaultStatCacheSize,
+	}
 
-func (fs3 *S3FS) cleanPath(p ...string) string {
-	// Join the path elements
-	j := path.Join(p...)
+	// Apply the options
+	for _, opt := range opts {
+		if err := opt(fs3); err != nil {
+			return nil, err
+		}
+	}
 
-	// Clean the path before joining to root
-	c := path.Clean(j)
+	// Encrypted names mustn't contain the separator
+	if fs3.names != nil && strings.ContainsAny(fs3.separator, nameAlphabet) {
+		return nil, fmt.Errorf("%w: separator %q can't be used with filename encryption", ErrInvalidOption, fs3.separator)
+	}
+
+	// Normalise the root now that the separator is known
+	root := fs3.root
+	fs3.root = ""
+	r, err := fs3.clean(root)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid root: %s", ErrInvalidOption, err)
+	}
+	fs3.root = r
+
+	// Create the stat cache
+	if fs3.statCacheSize > 0 {
+		fs3.statCache = newStatCache(fs3.statCacheSize)
+	}
 
-	// Join the root and cleaned path
-	f := path.Join(fs3.root, c)
+	// Create the tables of locks and open files
+	fs3.locks = newLockTable()
+	fs3.openFiles = newOpenFiles()
 
-	// Return the full path
-	return path.Clean(f)
+	return fs3, nil
+}
+
+// Capabilities returns the filesystem capabilities.
+func (fs3 *S3FS) Capabilities() billy.Capability {
+	return billy.ReadCapability | billy.WriteCapability | billy.ReadAndWriteCapability |
+		billy.TruncateCapability | billy.LockCapability
 }
@@ -0,0 +1,171 @@
+package s3fs_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const testRepoURL = "file:///source.git"
+
+// newSourceRepo creates an in-memory repository and serves it in-process
+// at testRepoURL.
+func newSourceRepo(t *testing.T) (*git.Repository, billy.Filesystem) {
+	t.Helper()
+
+	st := memory.NewStorage()
+	wt := memfs.New()
+	repo, err := git.Init(st, wt)
+	if err != nil {
+		t.Fatalf("Init: %s", err)
+	}
+
+	old := client.Protocols["file"]
+	client.InstallProtocol("file", server.NewClient(server.MapLoader{testRepoURL: st}))
+	t.Cleanup(func() { client.InstallProtocol("file", old) })
+	return repo, wt
+}
+
+// commitFile writes a file to the source repository and commits it.
+func commitFile(t *testing.T, repo *git.Repository, wt billy.Filesystem, name, content string) plumbing.Hash {
+	t.Helper()
+
+	writeFile(t, wt, name, content)
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Add(name); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	h, err := w.Commit("Add "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	return h
+}
+
+// openRepo opens the repository stored at dir in fs3.
+func openRepo(t *testing.T, fs billy.Filesystem, dir string) *git.Repository {
+	t.Helper()
+
+	dot, err := fs.Chroot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.Open(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), nil)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	return repo
+}
+
+// fileAt returns the contents of a file in a commit.
+func fileAt(t *testing.T, repo *git.Repository, h plumbing.Hash, name string) string {
+	t.Helper()
+
+	c, err := repo.CommitObject(h)
+	if err != nil {
+		t.Fatalf("CommitObject: %s", err)
+	}
+	f, err := c.File(name)
+	if err != nil {
+		t.Fatalf("File(%q): %s", name, err)
+	}
+	content, err := f.Contents()
+	if err != nil {
+		t.Fatalf("Contents: %s", err)
+	}
+	return content
+}
+
+func TestGoGitStorage(t *testing.T) {
+	src, wt := newSourceRepo(t)
+	commitFile(t, src, wt, "README.md", "# Hello")
+	first := commitFile(t, src, wt, "main.go", "package main")
+
+	// Clone into a bare repository in S3
+	fs3 := newTestFS(t)
+	dot, err := fs3.Chroot("repos/test.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = git.Clone(filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), nil, &git.CloneOptions{
+		URL: testRepoURL,
+	})
+	if err != nil {
+		t.Fatalf("Clone: %s", err)
+	}
+
+	// The packfile was moved into place, and no temporary files are left
+	fis, err := fs3.ReadDir("repos/test.git/objects/pack")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if len(names) != 2 || !strings.HasSuffix(names[0], ".idx") || !strings.HasSuffix(names[1], ".pack") {
+		t.Errorf("expected a packfile and its index, got %v", names)
+	}
+
+	// Read it back with a fresh filesystem view
+	repo := openRepo(t, fs3, "repos/test.git")
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Head: %s", err)
+	}
+	if head.Hash() != first {
+		t.Errorf("expected HEAD to be %s, got %s", first, head.Hash())
+	}
+	if got := fileAt(t, repo, head.Hash(), "README.md"); got != "# Hello" {
+		t.Errorf("expected README.md to contain %q, got %q", "# Hello", got)
+	}
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		t.Fatalf("Log: %s", err)
+	}
+	n := 0
+	commits.ForEach(func(*object.Commit) error {
+		n++
+		return nil
+	})
+	if n != 2 {
+		t.Errorf("expected 2 commits, got %d", n)
+	}
+
+	// Fetch a new commit, updating the existing refs
+	second := commitFile(t, src, wt, "main.go", "package main\n\nfunc main() {}")
+	err = repo.Fetch(&git.FetchOptions{
+		RefSpecs: []config.RefSpec{"+refs/heads/*:refs/heads/*"},
+	})
+	if err != nil {
+		t.Fatalf("Fetch: %s", err)
+	}
+	repo = openRepo(t, fs3, "repos/test.git")
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil {
+		t.Fatalf("Reference: %s", err)
+	}
+	if ref.Hash() != second {
+		t.Errorf("expected master to be %s, got %s", second, ref.Hash())
+	}
+	if got := fileAt(t, repo, second, "main.go"); got != "package main\n\nfunc main() {}" {
+		t.Errorf("unexpected contents of main.go: %q", got)
+	}
+}
@@ -0,0 +1,551 @@
+// handler.go implements an http.Handler that serves files from S3FS
+
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	DefaultPresignExpiry = 15 * time.Minute // Default lifetime of presigned URLs
+)
+
+// Presigner creates presigned GetObject requests. It is implemented by
+// *s3.PresignClient.
+type Presigner interface {
+	PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
+}
+
+// Handler is an http.Handler that serves files from an S3FS.
+//
+// Range requests are served with ranged GetObject requests, the object's
+// ETag and Last-Modified time are passed through, and conditional requests
+// (If-None-Match, If-Modified-Since, etc.) are answered from a HeadObject
+// request without downloading the object. Directories are rendered as
+// HTML listings.
+//
+// Compressed objects are served as they're stored, with their
+// Content-Encoding, to clients that accept it. Other clients get the
+// decompressed contents, which means reading the whole object first.
+type Handler struct {
+	fs3 *S3FS
+
+	listings       bool          // Render directory listings?
+	presigner      Presigner     // Presigns redirect URLs (optional)
+	presignMinSize int64         // Minimum object size to redirect
+	presignExpiry  time.Duration // Lifetime of presigned URLs
+}
+
+// HandlerOption configures a Handler.
+type HandlerOption func(*Handler) error
+
+// WithDirectoryListings sets whether directories are rendered as HTML
+// listings. If disabled, requests for directories get a 404. Listings are
+// enabled by default.
+func WithDirectoryListings(enabled bool) HandlerOption {
+	return func(h *Handler) error {
+		h.listings = enabled
+		return nil
+	}
+}
+
+// WithPresignedRedirects makes the handler redirect requests for objects
+// of at least minSize bytes to presigned URLs, so large objects are
+// downloaded directly from S3. If expiry is zero, DefaultPresignExpiry is
+// used.
+func WithPresignedRedirects(p Presigner, minSize int64, expiry time.Duration) HandlerOption {
+	return func(h *Handler) error {
+		if p == nil {
+			return fmt.Errorf("%w: presigner cannot be nil", ErrInvalidOption)
+		}
+		if minSize < 0 {
+			return fmt.Errorf("%w: minimum size can't be negative, got %d", ErrInvalidOption, minSize)
+		}
+		if expiry < 0 {
+			return fmt.Errorf("%w: expiry can't be negative, got %s", ErrInvalidOption, expiry)
+		}
+		if expiry == 0 {
+			expiry = DefaultPresignExpiry
+		}
+		h.presigner = p
+		h.presignMinSize = minSize
+		h.presignExpiry = expiry
+		return nil
+	}
+}
+
+// NewHandler creates a Handler serving files from fs3.
+func NewHandler(fs3 *S3FS, opts ...HandlerOption) (*Handler, error) {
+	if fs3 == nil {
+		return nil, fmt.Errorf("filesystem cannot be nil")
+	}
+	h := &Handler{
+		fs3:      fs3,
+		listings: true,
+	}
+	for _, opt := range opts {
+		if err := opt(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Resolve the request path
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	rel, err := h.fs3.clean(p)
+	if err != nil {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	key := h.fs3.rootKey(rel)
+
+	// Paths with a trailing slash are directories
+	if rel == "" || strings.HasSuffix(p, "/") {
+		h.serveDir(w, r, rel)
+		return
+	}
+
+	// Describe the object
+	ctx := r.Context()
+	hin := &s3.HeadObjectInput{
+		Bucket: &h.fs3.bucket,
+		Key:    &key,
+	}
+	h.fs3.enc.applyHead(hin)
+	head, err := h.fs3.client.HeadObject(ctx, hin, h.fs3.optFns...)
+	if isNotFound(err) {
+		// Redirect directories to their canonical path
+		if ok, err := h.fs3.isDir(ctx, key); err == nil && ok {
+			http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to describe object", http.StatusBadGateway)
+		return
+	}
+
+	// Encrypted objects are decrypted as they're served
+	c, err := h.fs3.objectCipher(ctx, head.Metadata)
+	if err != nil {
+		http.Error(w, "unable to decrypt object", http.StatusBadGateway)
+		return
+	}
+
+	// Pass through the object's headers
+	etag := aws.ToString(head.ETag)
+	modTime := aws.ToTime(head.LastModified)
+	hdr := w.Header()
+	hdr.Set("Accept-Ranges", "bytes")
+	if etag != "" {
+		hdr.Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		hdr.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	setContentHeaders(hdr, rel, head)
+
+	// Decompress objects whose encoding the client doesn't accept
+	encoding := aws.ToString(head.ContentEncoding)
+	decode := false
+	if encoding == encodingGzip || encoding == encodingZstd {
+		hdr.Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, encoding) {
+			hdr.Del("Content-Encoding")
+			decode = true
+		}
+	}
+
+	// Answer conditional requests without downloading the object
+	if status := checkPreconditions(r, etag, modTime); status != 0 {
+		if status == http.StatusNotModified {
+			hdr.Del("Content-Type")
+			hdr.Del("Content-Length")
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	// Redirect large objects to a presigned URL. Objects encrypted with a
+	// customer key can't be, as the key would have to be sent by the client.
+	// Nor can encrypted objects, which must be decrypted here, or objects
+	// that must be decompressed.
+	if h.presigner != nil && head.ContentLength >= h.presignMinSize && h.fs3.enc.customerKey == nil && c == nil && !decode {
+		req, err := h.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: &h.fs3.bucket,
+			Key:    &key,
+		}, s3.WithPresignExpires(h.presignExpiry))
+		if err != nil {
+			http.Error(w, "unable to presign request", http.StatusBadGateway)
+			return
+		}
+		http.Redirect(w, r, req.URL, http.StatusTemporaryRedirect)
+		return
+	}
+
+	if decode {
+		h.serveDecoded(w, r, key, head)
+		return
+	}
+
+	// Work out the range to serve, if any
+	size := head.ContentLength
+	if c != nil {
+		size = plaintextSize(head.Metadata, size)
+	}
+	start, end := int64(0), size-1
+	var rng *string
+	if rh := r.Header.Get("Range"); rh != "" && checkIfRange(r, etag, modTime) {
+		s, e, ok := parseByteRange(rh, size)
+		if !ok {
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if e >= s {
+			start, end = s, e
+			rng = aws.String(fmt.Sprintf("bytes=%d-%d", start, end))
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		hdr.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if c != nil {
+		h.serveEncrypted(ctx, w, c, key, head, start, end, rng != nil)
+		return
+	}
+
+	// Stream the object (or range), pinned to the version described above
+	in := &s3.GetObjectInput{
+		Bucket:  &h.fs3.bucket,
+		Key:     &key,
+		Range:   rng,
+		IfMatch: head.ETag,
+	}
+	h.fs3.enc.applyGet(in)
+	res, err := h.fs3.client.GetObject(ctx, in, h.fs3.optFns...)
+	if err != nil {
+		http.Error(w, "unable to read object", http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	status := http.StatusOK
+	if rng != nil {
+		hdr.Set("Content-Range", aws.ToString(res.ContentRange))
+		status = http.StatusPartialContent
+	}
+	hdr.Set("Content-Length", strconv.FormatInt(res.ContentLength, 10))
+	w.WriteHeader(status)
+	io.Copy(w, res.Body)
+}
+
+// serveEncrypted streams the plaintext of an encrypted object, from start
+// to end inclusive, reading and decrypting a few chunks at a time.
+func (h *Handler) serveEncrypted(ctx context.Context, w http.ResponseWriter, c *chunkCipher, key string, head *s3.HeadObjectOutput, start, end int64, partial bool) {
+	d, err := c.newDecryptReader(&objectReaderAt{
+		ctx:  ctx,
+		fs3:  h.fs3,
+		key:  key,
+		etag: head.ETag,
+	}, head.ContentLength)
+	if err != nil {
+		http.Error(w, "unable to decrypt object", http.StatusBadGateway)
+		return
+	}
+	r := io.NewSectionReader(d, start, end-start+1)
+
+	// Read the first few chunks before sending the headers, so that errors
+	// can still be reported
+	buf := make([]byte, 16*c.chunkSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		http.Error(w, "unable to read object", http.StatusBadGateway)
+		return
+	}
+
+	hdr := w.Header()
+	status := http.StatusOK
+	if partial {
+		hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, d.plain))
+		status = http.StatusPartialContent
+	}
+	hdr.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+	for n > 0 {
+		if _, err := w.Write(buf[:n]); err != nil {
+			return
+		}
+		if n, err = io.ReadFull(r, buf); err != nil && err != io.ErrUnexpectedEOF {
+			n = 0
+		}
+	}
+}
+
+// serveDecoded serves the decompressed (and decrypted) contents of an
+// object, for clients that don't accept its Content-Encoding. The whole
+// object is read, as Open does, and ranges are served from memory.
+func (h *Handler) serveDecoded(w http.ResponseWriter, r *http.Request, key string, head *s3.HeadObjectOutput) {
+	if r.Method == http.MethodHead {
+		if _, err := strconv.ParseInt(head.Metadata[metaUncompressedSize], 10, 64); err == nil {
+			w.Header().Set("Content-Length", head.Metadata[metaUncompressedSize])
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx := r.Context()
+	in := &s3.GetObjectInput{
+		Bucket:  &h.fs3.bucket,
+		Key:     &key,
+		IfMatch: head.ETag,
+	}
+	h.fs3.enc.applyGet(in)
+	res, err := h.fs3.client.GetObject(ctx, in, h.fs3.optFns...)
+	if err != nil {
+		http.Error(w, "unable to read object", http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		http.Error(w, "unable to read object", http.StatusBadGateway)
+		return
+	}
+	reader, size, err := h.fs3.decryptBody(ctx, res.Metadata, buf)
+	if err != nil {
+		http.Error(w, "unable to decrypt object", http.StatusBadGateway)
+		return
+	}
+	reader, size, err = decompressBody(aws.ToString(res.ContentEncoding), reader, size)
+	if err != nil {
+		http.Error(w, "unable to decompress object", http.StatusBadGateway)
+		return
+	}
+
+	// The preconditions have already been checked against the same
+	// ETag, so passing no modification time only skips the date checks
+	http.ServeContent(w, r, "", time.Time{}, io.NewSectionReader(reader, 0, size))
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header
+// accepts the given content coding, either by name or with "*". Requests
+// without the header are assumed to accept only the identity coding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	named, wildcard := -1, -1
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, c := range strings.Split(v, ",") {
+			name, q := c, ""
+			if i := strings.Index(c, ";"); i >= 0 {
+				name, q = c[:i], strings.TrimSpace(c[i+1:])
+			}
+			ok := 1
+			if strings.HasPrefix(q, "q=") {
+				if f, err := strconv.ParseFloat(q[2:], 64); err == nil && f == 0 {
+					ok = 0
+				}
+			}
+			switch name = strings.TrimSpace(name); {
+			case strings.EqualFold(name, encoding):
+				named = ok
+			case name == "*":
+				wildcard = ok
+			}
+		}
+	}
+	if named >= 0 {
+		return named == 1
+	}
+	return wildcard == 1
+}
+
+// serveDir renders an HTML listing of the directory.
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request, rel string) {
+	if !h.listings {
+		http.NotFound(w, r)
+		return
+	}
+
+	fis, err := h.fs3.ReadDir(rel)
+	if err != nil {
+		http.Error(w, "unable to list directory", http.StatusBadGateway)
+		return
+	}
+	if len(fis) == 0 && rel != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// setContentHeaders sets the Content-* headers of the response from the
+// object's metadata, guessing the Content-Type from the file name's
+// extension if the object doesn't have one.
+func setContentHeaders(hdr http.Header, name string, head *s3.HeadObjectOutput) {
+	ct := aws.ToString(head.ContentType)
+	if ct == "" || ct == "binary/octet-stream" || ct == "application/octet-stream" {
+		if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+			ct = t
+		}
+	}
+	if ct != "" {
+		hdr.Set("Content-Type", ct)
+	}
+	for name, v := range map[string]*string{
+		"Cache-Control":       head.CacheControl,
+		"Content-Disposition": head.ContentDisposition,
+		"Content-Encoding":    head.ContentEncoding,
+		"Content-Language":    head.ContentLanguage,
+	} {
+		if v != nil {
+			hdr.Set(name, *v)
+		}
+	}
+	if head.Expires != nil {
+		hdr.Set("Expires", head.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkPreconditions evaluates the request's conditional headers against
+// the object's ETag and modification time, returning the status code to
+// respond with, or 0 if the request should be served normally.
+func checkPreconditions(r *http.Request, etag string, modTime time.Time) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagListMatches(im, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+		if modTime.Truncate(time.Second).After(ius) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagListMatches(inm, etag) {
+			return http.StatusNotModified
+		}
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		if !modTime.Truncate(time.Second).After(ims) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// checkIfRange reports whether the Range header should be honoured, given
+// the request's If-Range header (if any).
+func checkIfRange(r *http.Request, etag string, modTime time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, `W/"`) {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && modTime.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches reports whether etag is in the comma-separated list of
+// ETags in an If-Match or If-None-Match header.
+func etagListMatches(header, etag string) bool {
+	for _, e := range strings.Split(header, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+		if e == "*" || (etag != "" && e == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseByteRange parses a Range header for an object of the given size,
+// returning the inclusive start and end offsets. Multiple ranges aren't
+// supported, so requests for them are served in full (end < start).
+// ok is false if the range is invalid or can't be satisfied.
+func parseByteRange(header string, size int64) (start, end int64, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header {
+		return 0, 0, false
+	}
+	if strings.Contains(spec, ",") {
+		return 0, -1, true
+	}
+
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	switch {
+	case first == "":
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+
+	default:
+		s, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || s < 0 || s >= size {
+			return 0, 0, false
+		}
+		e := size - 1
+		if last != "" {
+			e, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || e < s {
+				return 0, 0, false
+			}
+			if e > size-1 {
+				e = size - 1
+			}
+		}
+		return s, e, true
+	}
+}
@@ -0,0 +1,296 @@
+package s3fs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// serve makes a request to h and returns the response.
+func serve(t *testing.T, h http.Handler, method, target string, hdr map[string]string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+// body returns the response body as a string.
+func body(t *testing.T, res *http.Response) string {
+	t.Helper()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %s", err)
+	}
+	return string(b)
+}
+
+func newTestHandler(t *testing.T, opts ...s3fs.HandlerOption) *s3fs.Handler {
+	t.Helper()
+
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "hello.txt", "Hello, World!")
+	writeFile(t, fs3, "dir/a.txt", "a")
+	writeFile(t, fs3, "dir/sub/b.txt", "b")
+
+	h, err := s3fs.NewHandler(fs3, opts...)
+	if err != nil {
+		t.Fatalf("NewHandler: %s", err)
+	}
+	return h
+}
+
+func TestHandlerGet(t *testing.T) {
+	h := newTestHandler(t)
+
+	res := serve(t, h, http.MethodGet, "/hello.txt", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if got := body(t, res); got != "Hello, World!" {
+		t.Errorf("expected body %q, got %q", "Hello, World!", got)
+	}
+	if res.Header.Get("ETag") == "" {
+		t.Error("expected an ETag header")
+	}
+	if res.Header.Get("Last-Modified") == "" {
+		t.Error("expected a Last-Modified header")
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected a text/plain Content-Type, got %q", ct)
+	}
+
+	res = serve(t, h, http.MethodHead, "/hello.txt", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Length"); got != "13" {
+		t.Errorf("expected Content-Length 13, got %q", got)
+	}
+	if got := body(t, res); got != "" {
+		t.Errorf("expected no body for HEAD, got %q", got)
+	}
+
+	res = serve(t, h, http.MethodGet, "/missing.txt", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", res.StatusCode)
+	}
+
+	res = serve(t, h, http.MethodPut, "/hello.txt", nil)
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", res.StatusCode)
+	}
+}
+
+func TestHandlerRange(t *testing.T) {
+	h := newTestHandler(t)
+
+	cases := []struct {
+		rng          string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=0-4", http.StatusPartialContent, "Hello", "bytes 0-4/13"},
+		{"bytes=7-", http.StatusPartialContent, "World!", "bytes 7-12/13"},
+		{"bytes=-6", http.StatusPartialContent, "World!", "bytes 7-12/13"},
+		{"bytes=7-100", http.StatusPartialContent, "World!", "bytes 7-12/13"},
+		{"bytes=0-1,3-4", http.StatusOK, "Hello, World!", ""},
+		{"bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */13"},
+	}
+	for _, c := range cases {
+		res := serve(t, h, http.MethodGet, "/hello.txt", map[string]string{"Range": c.rng})
+		if res.StatusCode != c.status {
+			t.Errorf("Range %q: expected status %d, got %d", c.rng, c.status, res.StatusCode)
+			continue
+		}
+		if got := res.Header.Get("Content-Range"); got != c.contentRange {
+			t.Errorf("Range %q: expected Content-Range %q, got %q", c.rng, c.contentRange, got)
+		}
+		if c.status != http.StatusRequestedRangeNotSatisfiable {
+			if got := body(t, res); got != c.body {
+				t.Errorf("Range %q: expected body %q, got %q", c.rng, c.body, got)
+			}
+		}
+	}
+
+	// A stale If-Range means the whole file is served
+	res := serve(t, h, http.MethodGet, "/hello.txt", map[string]string{
+		"Range":    "bytes=0-4",
+		"If-Range": `"stale"`,
+	})
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 for stale If-Range, got %d", res.StatusCode)
+	}
+}
+
+func TestHandlerConditional(t *testing.T) {
+	h := newTestHandler(t)
+
+	res := serve(t, h, http.MethodGet, "/hello.txt", nil)
+	etag := res.Header.Get("ETag")
+	lastMod := res.Header.Get("Last-Modified")
+
+	cases := []struct {
+		name   string
+		hdr    map[string]string
+		status int
+	}{
+		{"If-None-Match match", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"If-None-Match list", map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"If-None-Match star", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+		{"If-None-Match mismatch", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"If-Modified-Since same", map[string]string{"If-Modified-Since": lastMod}, http.StatusNotModified},
+		{"If-Modified-Since old", map[string]string{"If-Modified-Since": time.Unix(0, 0).UTC().Format(http.TimeFormat)}, http.StatusOK},
+		{"If-Match match", map[string]string{"If-Match": etag}, http.StatusOK},
+		{"If-Match mismatch", map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since old", map[string]string{"If-Unmodified-Since": time.Unix(0, 0).UTC().Format(http.TimeFormat)}, http.StatusPreconditionFailed},
+	}
+	for _, c := range cases {
+		res := serve(t, h, http.MethodGet, "/hello.txt", c.hdr)
+		if res.StatusCode != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.name, c.status, res.StatusCode)
+		}
+	}
+}
+
+func TestHandlerDirectory(t *testing.T) {
+	h := newTestHandler(t)
+
+	res := serve(t, h, http.MethodGet, "/dir", nil)
+	if res.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("expected status 301, got %d", res.StatusCode)
+	}
+	if loc := res.Header.Get("Location"); loc != "/dir/" {
+		t.Errorf("expected redirect to %q, got %q", "/dir/", loc)
+	}
+
+	res = serve(t, h, http.MethodGet, "/dir/", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	listing := body(t, res)
+	for _, want := range []string{`href="a.txt"`, `href="sub/"`} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("expected listing to contain %q, got %q", want, listing)
+		}
+	}
+
+	res = serve(t, h, http.MethodGet, "/missing/", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", res.StatusCode)
+	}
+
+	h = newTestHandler(t, s3fs.WithDirectoryListings(false))
+	res = serve(t, h, http.MethodGet, "/dir/", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status 404 with listings disabled, got %d", res.StatusCode)
+	}
+}
+
+// fakePresigner returns a fixed URL for every request.
+type fakePresigner struct{}
+
+func (fakePresigner) PresignGetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error) {
+	return &v4.PresignedHTTPRequest{
+		URL:    "https://example.com/" + *params.Key + "?signed",
+		Method: http.MethodGet,
+	}, nil
+}
+
+func TestHandlerPresignedRedirect(t *testing.T) {
+	h := newTestHandler(t, s3fs.WithPresignedRedirects(fakePresigner{}, 10, 0))
+
+	res := serve(t, h, http.MethodGet, "/hello.txt", nil)
+	if res.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("expected status 307, got %d", res.StatusCode)
+	}
+	if loc := res.Header.Get("Location"); loc != "https://example.com/hello.txt?signed" {
+		t.Errorf("unexpected redirect location %q", loc)
+	}
+
+	// Small objects are served directly
+	res = serve(t, h, http.MethodGet, "/dir/a.txt", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200, got %d", res.StatusCode)
+	}
+
+	if _, err := s3fs.NewHandler(newTestFS(t), s3fs.WithPresignedRedirects(nil, 0, 0)); err == nil {
+		t.Error("expected an error for a nil presigner")
+	}
+}
+
+func TestHandlerCompressed(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		compression s3fs.Compression
+		encoding    string
+	}{
+		{"gzip", s3fs.CompressionGzip, "gzip"},
+		{"zstd", s3fs.CompressionZstd, "zstd"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, _ := newTestClient(t)
+			fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCompression(tc.compression))
+			if err != nil {
+				t.Fatalf("NewS3FS: %s", err)
+			}
+			data := string(compressibleData(100000))
+			writeFile(t, fs3, "data.txt", data)
+			stored := headObject(t, client, "data.txt").ContentLength
+
+			h, err := s3fs.NewHandler(fs3)
+			if err != nil {
+				t.Fatalf("NewHandler: %s", err)
+			}
+
+			// Clients that accept the encoding get the object as it's stored
+			res := serve(t, h, http.MethodGet, "/data.txt", map[string]string{"Accept-Encoding": "br, " + tc.encoding})
+			if got := res.Header.Get("Content-Encoding"); got != tc.encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", tc.encoding, got)
+			}
+			if got := body(t, res); int64(len(got)) != stored {
+				t.Errorf("expected %d compressed bytes, got %d", stored, len(got))
+			}
+			if got := res.Header.Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("expected Vary %q, got %q", "Accept-Encoding", got)
+			}
+
+			// Others get it decompressed, with or without a range
+			for _, ae := range []string{"", "identity", tc.encoding + ";q=0", "*, " + tc.encoding + ";q=0"} {
+				res = serve(t, h, http.MethodGet, "/data.txt", map[string]string{"Accept-Encoding": ae})
+				if got := res.Header.Get("Content-Encoding"); got != "" {
+					t.Errorf("Accept-Encoding %q: expected no Content-Encoding, got %q", ae, got)
+				}
+				if got := body(t, res); got != data {
+					t.Errorf("Accept-Encoding %q: contents don't match (got %d bytes, expected %d)", ae, len(got), len(data))
+				}
+			}
+			res = serve(t, h, http.MethodGet, "/data.txt", map[string]string{"Range": "bytes=1000-1009"})
+			if res.StatusCode != http.StatusPartialContent {
+				t.Fatalf("expected status 206, got %d", res.StatusCode)
+			}
+			if got := body(t, res); got != data[1000:1010] {
+				t.Errorf("expected %q, got %q", data[1000:1010], got)
+			}
+			res = serve(t, h, http.MethodHead, "/data.txt", nil)
+			if got, expected := res.Header.Get("Content-Length"), strconv.Itoa(len(data)); got != expected {
+				t.Errorf("expected Content-Length %s, got %q", expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// headers.go implements the HTTP headers stored with objects
+
+package s3fs
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	sniffLen = 512 // Number of bytes used by http.DetectContentType
+)
+
+// Headers holds the HTTP headers stored with objects when they're written,
+// and returned by S3 when they're downloaded. Empty fields aren't set.
+type Headers struct {
+	ContentType        string    // Detected from the file name and contents if empty
+	CacheControl       string    // e.g. "max-age=3600"
+	ContentDisposition string    // e.g. `attachment; filename="report.html"`
+	ContentLanguage    string    // e.g. "en-GB"
+	Expires            time.Time // When the object should no longer be cached
+}
+
+// contentType returns the Content-Type for a file with the given name,
+// whose contents start with head. The Content-Type is taken from the
+// headers if set, then from the name's extension, then from the contents.
+// Empty files of unknown types are left without one.
+func (h Headers) contentType(name string, head []byte) string {
+	if h.ContentType != "" {
+		return h.ContentType
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	if len(head) == 0 {
+		return ""
+	}
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return http.DetectContentType(head)
+}
+
+// expires returns a pointer to the Expires time, or nil if it isn't set.
+func (h Headers) expires() *time.Time {
+	if h.Expires.IsZero() {
+		return nil
+	}
+	t := h.Expires.UTC()
+	return &t
+}
+
+// applyPut sets the headers of a PutObject request.
+func (h Headers) applyPut(in *s3.PutObjectInput, contentType string) {
+	in.ContentType = optString(contentType)
+	in.CacheControl = optString(h.CacheControl)
+	in.ContentDisposition = optString(h.ContentDisposition)
+	in.ContentLanguage = optString(h.ContentLanguage)
+	in.Expires = h.expires()
+}
+
+// applyCreateMultipart sets the headers of a CreateMultipartUpload request.
+func (h Headers) applyCreateMultipart(in *s3.CreateMultipartUploadInput, contentType string) {
+	in.ContentType = optString(contentType)
+	in.CacheControl = optString(h.CacheControl)
+	in.ContentDisposition = optString(h.ContentDisposition)
+	in.ContentLanguage = optString(h.ContentLanguage)
+	in.Expires = h.expires()
+}
+
+// applyCopy sets the headers of a CopyObject request that replaces the
+// object's metadata.
+func (h Headers) applyCopy(in *s3.CopyObjectInput, contentType string) {
+	in.ContentType = optString(contentType)
+	in.CacheControl = optString(h.CacheControl)
+	in.ContentDisposition = optString(h.ContentDisposition)
+	in.ContentLanguage = optString(h.ContentLanguage)
+	in.Expires = h.expires()
+}
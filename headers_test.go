@@ -0,0 +1,142 @@
+package s3fs_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestContentTypeDetection(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	writeFile(t, fs3, "report.html", "<p>hello</p>")
+	writeFile(t, fs3, "styles.css", "body {}")
+	writeFile(t, fs3, "page", "<!DOCTYPE html><html></html>")
+	writeFile(t, fs3, "image", "\x89PNG\r\n\x1a\n....")
+	writeFile(t, fs3, "empty", "")
+
+	for key, expected := range map[string]string{
+		"report.html": "text/html; charset=utf-8",
+		"styles.css":  "text/css; charset=utf-8",
+		"page":        "text/html; charset=utf-8",
+		"image":       "image/png",
+	} {
+		if got := aws.ToString(headObject(t, client, key).ContentType); got != expected {
+			t.Errorf("%s: expected Content-Type %q, got %q", key, expected, got)
+		}
+	}
+
+	// Empty files aren't sniffed as text
+	if got := aws.ToString(headObject(t, client, "empty").ContentType); strings.HasPrefix(got, "text/") {
+		t.Errorf("empty: expected no detected Content-Type, got %q", got)
+	}
+
+	// Multipart uploads are detected from the first bytes written
+	f, err := fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	data := "<html>" + strings.Repeat("x", int(s3fs.MinPartSize))
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		if _, err := f.Write([]byte(data[i:end])); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := aws.ToString(headObject(t, client, "big").ContentType); got != "text/html; charset=utf-8" {
+		t.Errorf("big: expected Content-Type %q, got %q", "text/html; charset=utf-8", got)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	client, _ := newTestClient(t)
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithHeaders(s3fs.Headers{
+		CacheControl: "max-age=60",
+	}))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Filesystem defaults
+	writeFile(t, fs3, "index.html", "<p>hi</p>")
+	head := headObject(t, client, "index.html")
+	if got := aws.ToString(head.CacheControl); got != "max-age=60" {
+		t.Errorf("expected Cache-Control %q, got %q", "max-age=60", got)
+	}
+	if got := aws.ToString(head.ContentType); got != "text/html; charset=utf-8" {
+		t.Errorf("expected the Content-Type to be detected, got %q", got)
+	}
+
+	// Per-open overrides, for each kind of upload
+	h := s3fs.Headers{
+		ContentType:        "text/csv",
+		CacheControl:       "no-cache",
+		ContentDisposition: `attachment; filename="export.csv"`,
+		ContentLanguage:    "en-GB",
+		Expires:            expires,
+	}
+	for _, flag := range []int{s3fs.O_WRONLY, s3fs.O_WRMULTIPART, s3fs.O_RDWR | s3fs.O_CREATE} {
+		f, err := fs3.OpenFileWithOptions("export", flag, s3fs.WithFileHeaders(h))
+		if err != nil {
+			t.Fatalf("OpenFileWithOptions(%#x): %s", flag, err)
+		}
+		if _, err := f.Write([]byte("a,b\n1,2\n")); err != nil {
+			t.Fatalf("Write: %s", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close: %s", err)
+		}
+
+		head := headObject(t, client, "export")
+		for name, got := range map[string]string{
+			"Content-Type":        aws.ToString(head.ContentType),
+			"Cache-Control":       aws.ToString(head.CacheControl),
+			"Content-Disposition": aws.ToString(head.ContentDisposition),
+			"Content-Language":    aws.ToString(head.ContentLanguage),
+		} {
+			expected := map[string]string{
+				"Content-Type":        h.ContentType,
+				"Cache-Control":       h.CacheControl,
+				"Content-Disposition": h.ContentDisposition,
+				"Content-Language":    h.ContentLanguage,
+			}[name]
+			if got != expected {
+				t.Errorf("flag %#x: expected %s %q, got %q", flag, name, expected, got)
+			}
+		}
+		if head.Expires == nil || !head.Expires.Equal(expires) {
+			t.Errorf("flag %#x: expected Expires %s, got %v", flag, expires, head.Expires)
+		}
+	}
+	if _, err := fs3.OpenFileWithOptions("export", s3fs.O_RDONLY, s3fs.WithFileHeaders(h)); !errors.Is(err, s3fs.ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for a read-only file, got %v", err)
+	}
+
+	// The handler serves the stored headers
+	hd, err := s3fs.NewHandler(fs3)
+	if err != nil {
+		t.Fatalf("NewHandler: %s", err)
+	}
+	res := serve(t, hd, "GET", "/export", nil)
+	if got := res.Header.Get("Content-Type"); got != "text/csv" {
+		t.Errorf("expected the handler to serve Content-Type %q, got %q", "text/csv", got)
+	}
+	if got := res.Header.Get("Content-Disposition"); got != h.ContentDisposition {
+		t.Errorf("expected the handler to serve Content-Disposition %q, got %q", h.ContentDisposition, got)
+	}
+}
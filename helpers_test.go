@@ -0,0 +1,103 @@
+package s3fs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+)
+
+const testBucket = "test-bucket"
+
+// newTestClient starts an in-process S3-compatible server backed by
+// s3mem and returns a real S3 client pointed at it, along with the
+// backend.
+func newTestClient(t *testing.T) (*s3.Client, *s3mem.Backend) {
+	t.Helper()
+
+	backend := s3mem.New()
+	backend.CreateBucket(testBucket)
+
+	srv := httptest.NewServer(backend.Handler())
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: s3.EndpointResolverFromURL(srv.URL),
+		UsePathStyle:     true,
+	})
+	return client, backend
+}
+
+// newTestFS creates an S3FS backed by a fresh in-process S3 server.
+func newTestFS(t *testing.T, opts ...s3fs.Option) *s3fs.S3FS {
+	t.Helper()
+
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, opts...)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	return fs3
+}
+
+// headObject describes an object in the test bucket.
+func headObject(t *testing.T, client *s3.Client, key string) *s3.HeadObjectOutput {
+	t.Helper()
+
+	res, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject(%q): %s", key, err)
+	}
+	return res
+}
+
+// writeFile creates the named file with the given contents.
+func writeFile(t *testing.T, fs billy.Filesystem, name, content string) {
+	t.Helper()
+
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatalf("Create(%q): %s", name, err)
+	}
+	if _, err := f.Write([]byte(content)); err != nil {
+		t.Fatalf("Write(%q): %s", name, err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close(%q): %s", name, err)
+	}
+}
+
+// readFile returns the contents of the named file.
+func readFile(t *testing.T, fs billy.Filesystem, name string) string {
+	t.Helper()
+
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatalf("Open(%q): %s", name, err)
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll(%q): %s", name, err)
+	}
+	return string(b)
+}
+
+// deviation skips a test for behaviour that go-billy's test suite expects
+// but S3FS intentionally doesn't provide, because S3 doesn't support it.
+func deviation(t *testing.T, reason string) {
+	t.Helper()
+	t.Skip("S3FS deviates from billy: " + reason)
+}
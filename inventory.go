@@ -0,0 +1,415 @@
+// inventory.go answers directory listings from an S3 Inventory report
+
+package s3fs
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	InventoryFormatCSV     = "CSV"     // CSV inventory report format
+	InventoryFormatORC     = "ORC"     // Apache ORC inventory report format
+	InventoryFormatParquet = "Parquet" // Apache Parquet inventory report format
+)
+
+var (
+	ErrInventoryFormatNotSupported = errors.New("inventory file format not supported")
+	ErrInventoryMissingColumn      = errors.New("inventory schema is missing a required column")
+	ErrInventoryCorrupt            = errors.New("corrupt inventory file")
+)
+
+// inventoryManifest is the manifest.json written alongside an S3 Inventory
+// report.
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	Version           string `json:"version"`
+	CreationTimestamp string `json:"creationTimestamp"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key         string `json:"key"`
+		Size        int64  `json:"size"`
+		MD5Checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+// inventoryEntry is a single object from an inventory report.
+type inventoryEntry struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// inventory is an in-memory index of an S3 Inventory report.
+type inventory struct {
+	created time.Time        // When the report was generated
+	overlay bool             // Overlay live listings on top of the report?
+	entries []inventoryEntry // Objects in the report, sorted by key
+}
+
+// LoadInventory configures the filesystem to answer ReadDir and Walk from
+// the S3 Inventory report described by the manifest at manifestKey in
+// manifestBucket. The report's data files are read from the destination
+// bucket named in the manifest.
+//
+// Entries returned from the report are marked as stale (see
+// InventoryFileInfo). If overlay is true, live listings of each directory
+// are merged on top of the report, replacing any stale entries.
+//
+// Reports can be in any of the CSV, ORC or Parquet formats.
+func (fs3 *S3FS) LoadInventory(manifestBucket, manifestKey string, overlay bool) error {
+	inv, err := loadInventory(fs3, manifestBucket, manifestKey)
+	if err != nil {
+		return err
+	}
+	inv.overlay = overlay
+	fs3.inventory = inv
+	return nil
+}
+
+// loadInventory reads and indexes the inventory report described by the
+// manifest at key in bucket.
+func loadInventory(fs3 *S3FS, bucket, key string) (*inventory, error) {
+	// Read the manifest
+	f, err := newS3ReadFile(fs3, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory manifest: %w", err)
+	}
+	defer f.Close()
+
+	var m inventoryManifest
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, fmt.Errorf("unable to parse inventory manifest: %w", err)
+	}
+
+	switch m.FileFormat {
+	case InventoryFormatCSV, InventoryFormatORC, InventoryFormatParquet:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInventoryFormatNotSupported, m.FileFormat)
+	}
+
+	// Parse the creation time (milliseconds since the epoch)
+	ms, err := strconv.ParseInt(m.CreationTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory creation timestamp %q: %w", m.CreationTimestamp, err)
+	}
+
+	// Find the columns we need in the schema of a CSV report. ORC and
+	// Parquet files describe their own schemas.
+	cols := make(map[string]int)
+	if m.FileFormat == InventoryFormatCSV {
+		for i, c := range strings.Split(m.FileSchema, ",") {
+			cols[strings.TrimSpace(c)] = i
+		}
+		for _, c := range []string{"Key", "Size", "LastModifiedDate"} {
+			if _, ok := cols[c]; !ok {
+				return nil, fmt.Errorf("%w: %q", ErrInventoryMissingColumn, c)
+			}
+		}
+	}
+
+	// The destination bucket is given as an ARN
+	dst := m.DestinationBucket
+	if i := strings.LastIndex(dst, ":"); i >= 0 {
+		dst = dst[i+1:]
+	}
+
+	// Read each of the data files
+	inv := &inventory{created: time.Unix(0, ms*int64(time.Millisecond)).UTC()}
+	for _, df := range m.Files {
+		var entries []inventoryEntry
+		if m.FileFormat == InventoryFormatCSV {
+			entries, err = readInventoryCSV(fs3, dst, df.Key, cols)
+		} else {
+			entries, err = readInventoryColumns(fs3, dst, df.Key, m.FileFormat)
+		}
+		if err != nil {
+			return nil, err
+		}
+		inv.entries = append(inv.entries, entries...)
+	}
+
+	// Sort the entries by key so prefixes can be searched
+	sort.Slice(inv.entries, func(i, j int) bool {
+		return inv.entries[i].key < inv.entries[j].key
+	})
+	return inv, nil
+}
+
+// readInventoryCSV reads a gzipped CSV inventory data file.
+func readInventoryCSV(fs3 *S3FS, bucket, key string, cols map[string]int) ([]inventoryEntry, error) {
+	f, err := newS3ReadFile(fs3, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory file %q: %w", key, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress inventory file %q: %w", key, err)
+	}
+	defer gz.Close()
+
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = len(cols)
+
+	var entries []inventoryEntry
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse inventory file %q: %w", key, err)
+		}
+
+		// Skip delete markers and non-current versions
+		if i, ok := cols["IsDeleteMarker"]; ok && rec[i] == "true" {
+			continue
+		}
+		if i, ok := cols["IsLatest"]; ok && rec[i] == "false" {
+			continue
+		}
+
+		// Keys are URL-encoded in the report
+		k, err := url.QueryUnescape(rec[cols["Key"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in inventory file %q: %w", rec[cols["Key"]], key, err)
+		}
+		size, err := strconv.ParseInt(rec[cols["Size"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for key %q in inventory file %q: %w", k, key, err)
+		}
+		mt, err := time.Parse(time.RFC3339, rec[cols["LastModifiedDate"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid last modified date for key %q in inventory file %q: %w", k, key, err)
+		}
+
+		entries = append(entries, inventoryEntry{
+			key:     k,
+			size:    size,
+			modTime: mt,
+		})
+	}
+	return entries, nil
+}
+
+// Columns of ORC and Parquet inventory reports
+const (
+	inventoryKey          = "key"
+	inventorySize         = "size"
+	inventoryLastModified = "last_modified_date"
+	inventoryIsLatest     = "is_latest"
+	inventoryDeleteMarker = "is_delete_marker"
+)
+
+// Limits on what ORC and Parquet inventory data files can claim, so that a
+// corrupt file is rejected instead of making its reader allocate without
+// bound. Every row holds a key of its own, so real files have far fewer
+// rows for their size.
+const (
+	maxInventoryRowsPerByte = 16               // Rows in a data file, for each of its bytes
+	maxInventoryBlockSize   = 64 * 1024 * 1024 // Decompressed size of an ORC chunk or Parquet page
+)
+
+// readInventoryColumns reads an ORC or Parquet inventory data file.
+func readInventoryColumns(fs3 *S3FS, bucket, key, format string) ([]inventoryEntry, error) {
+	f, err := newS3ReadFile(fs3, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory file %q: %w", key, err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read inventory file %q: %w", key, err)
+	}
+
+	read := readORC
+	if format == InventoryFormatParquet {
+		read = readParquet
+	}
+	n, cols, err := read(data, []string{
+		inventoryKey, inventorySize, inventoryLastModified, inventoryIsLatest, inventoryDeleteMarker,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse inventory file %q: %w", key, err)
+	}
+
+	// Check the columns have the expected types
+	for _, c := range []string{inventoryKey, inventorySize, inventoryLastModified} {
+		if cols[c] == nil {
+			return nil, fmt.Errorf("%w: %q", ErrInventoryMissingColumn, c)
+		}
+	}
+	keys := cols[inventoryKey].strs
+	sizes := cols[inventorySize].ints
+	times := cols[inventoryLastModified].times
+	latest, deleted := cols[inventoryIsLatest], cols[inventoryDeleteMarker]
+	if len(keys) != n || len(sizes) != n || len(times) != n ||
+		(latest != nil && len(latest.bools) != n) || (deleted != nil && len(deleted.bools) != n) {
+		return nil, fmt.Errorf("%w: unexpected column types in inventory file %q", ErrInventoryCorrupt, key)
+	}
+
+	var entries []inventoryEntry
+	for i := 0; i < n; i++ {
+		// Skip delete markers and non-current versions
+		if (deleted != nil && deleted.bools[i]) || (latest != nil && !latest.bools[i]) {
+			continue
+		}
+		entries = append(entries, inventoryEntry{
+			key:     keys[i],
+			size:    sizes[i],
+			modTime: times[i],
+		})
+	}
+	return entries, nil
+}
+
+// readDir lists the entries in the inventory directly under prefix, using
+// sep to group keys into directories, the same way ListObjectsV2 does with
+// a delimiter. Entries are named relative to the prefix.
+func (inv *inventory) readDir(prefix, sep string) []os.FileInfo {
+	// Find the first key with the prefix
+	i := sort.Search(len(inv.entries), func(i int) bool {
+		return inv.entries[i].key >= prefix
+	})
+
+	var dirs []os.FileInfo
+	var files []os.FileInfo
+	for ; i < len(inv.entries) && strings.HasPrefix(inv.entries[i].key, prefix); i++ {
+		e := inv.entries[i]
+
+		// Is the key nested in a sub-directory?
+		rest := e.key[len(prefix):]
+		if j := strings.Index(rest, sep); j >= 0 {
+			d := prefix + rest[:j+len(sep)]
+			dirs = append(dirs, newInventoryDirInfo(rest[:j], inv.created))
+
+			// Skip the rest of the sub-directory's keys
+			for i+1 < len(inv.entries) && strings.HasPrefix(inv.entries[i+1].key, d) {
+				i++
+			}
+			continue
+		}
+		// Skip the directory's own marker object
+		if rest == "" {
+			continue
+		}
+		files = append(files, newInventoryFileInfo(rest, e.size, e.modTime, inv.created))
+	}
+	return append(dirs, files...)
+}
+
+// stat describes the object at key as of the report, or the directory
+// that key is the prefix of, returning nil if the report has neither.
+func (inv *inventory) stat(key, sep, name string) os.FileInfo {
+	i := sort.Search(len(inv.entries), func(i int) bool {
+		return inv.entries[i].key >= key
+	})
+	if i < len(inv.entries) && inv.entries[i].key == key {
+		e := inv.entries[i]
+		return newInventoryFileInfo(name, e.size, e.modTime, inv.created)
+	}
+
+	p := key + sep
+	i = sort.Search(len(inv.entries), func(i int) bool {
+		return inv.entries[i].key >= p
+	})
+	if i < len(inv.entries) && strings.HasPrefix(inv.entries[i].key, p) {
+		return newInventoryDirInfo(name, inv.created)
+	}
+	return nil
+}
+
+// overlayDir merges the live listing on top of the stale listing, with
+// live entries replacing stale entries of the same name.
+func overlayDir(stale, live []os.FileInfo) []os.FileInfo {
+	seen := make(map[string]bool, len(live))
+	for _, fi := range live {
+		seen[fi.Name()] = true
+	}
+
+	res := append([]os.FileInfo(nil), live...)
+	for _, fi := range stale {
+		if !seen[fi.Name()] {
+			res = append(res, fi)
+		}
+	}
+
+	// Sort directories first, then by name
+	sortEntries(res)
+	return res
+}
+
+// Walk walks the file tree rooted at root, calling fn for each file or
+// directory in the tree, including root. Directories are listed with
+// ReadDir, so if an inventory has been loaded (see LoadInventory) the
+// walk is answered from the inventory report. As with filepath.Walk, if
+// root can't be described, fn is called with the error.
+func (fs3 *S3FS) Walk(root string, fn filepath.WalkFunc) error {
+	info, err := fs3.statRoot(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = fs3.walk(root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// statRoot describes the root of a walk, from the inventory report if it
+// doesn't exist in the bucket any more.
+func (fs3 *S3FS) statRoot(root string) (os.FileInfo, error) {
+	fi, err := fs3.Stat(root)
+	if fs3.inventory == nil || !errors.Is(err, os.ErrNotExist) {
+		return fi, err
+	}
+	rel, cerr := fs3.clean(root)
+	if cerr != nil {
+		return nil, cerr
+	}
+	name := rel[strings.LastIndex(rel, fs3.separator)+1:]
+	if fi := fs3.inventory.stat(fs3.rootKey(rel), fs3.separator, name); fi != nil {
+		return fi, nil
+	}
+	return nil, err
+}
+
+// walk recursively descends path, calling fn.
+func (fs3 *S3FS) walk(p string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(p, info, nil)
+	}
+
+	entries, err := fs3.ReadDir(p)
+	err1 := fn(p, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, e := range entries {
+		err := fs3.walk(fs3.Join(p, e.Name()), e, fn)
+		if err != nil {
+			if !e.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
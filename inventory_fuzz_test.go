@@ -0,0 +1,75 @@
+//go:build go1.18
+// +build go1.18
+
+package s3fs_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+)
+
+// inventoryColumns are the columns read from ORC and Parquet inventory
+// data files.
+var inventoryColumns = []string{"key", "size", "last_modified_date", "is_latest", "is_delete_marker"}
+
+// inventorySeeds returns the data files in testdata/inventory with the
+// given extension, and one written by encode.
+func inventorySeeds(f *testing.F, ext string, encode func([]inventoryRow) []byte) [][]byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "inventory", "*"+ext))
+	if err != nil {
+		f.Fatalf("Glob: %s", err)
+	}
+	seeds := [][]byte{encode(inventoryRows[0])}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatalf("ReadFile: %s", err)
+		}
+		seeds = append(seeds, data)
+	}
+	return seeds
+}
+
+func FuzzReadORC(f *testing.F) {
+	for _, data := range inventorySeeds(f, ".orc", orcInventory) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s3fs.ReadORC(data, inventoryColumns)
+	})
+}
+
+func FuzzReadParquet(f *testing.F) {
+	for _, data := range inventorySeeds(f, ".parquet", parquetInventory) {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s3fs.ReadParquet(data, inventoryColumns)
+	})
+}
+
+func FuzzParseThrift(f *testing.F) {
+	// Seed with the file metadata of each Parquet file
+	for _, data := range inventorySeeds(f, ".parquet", parquetInventory) {
+		n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+		f.Add(data[len(data)-8-n : len(data)-8])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s3fs.ParseThrift(data)
+	})
+}
+
+func FuzzParseProto(f *testing.F) {
+	// Seed with the postscript of each ORC file
+	for _, data := range inventorySeeds(f, ".orc", orcInventory) {
+		n := int(data[len(data)-1])
+		f.Add(data[len(data)-1-n : len(data)-1])
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s3fs.ParseProto(data)
+	})
+}
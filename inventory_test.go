@@ -0,0 +1,774 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const inventoryBucket = "inventory-bucket"
+
+// inventoryRow is an object listed in a test inventory report.
+type inventoryRow struct {
+	key          string
+	size         int64
+	modTime      time.Time
+	latest       bool
+	deleteMarker bool
+}
+
+var (
+	inventoryCreated = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	inventoryModTime = time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	// Objects in the test reports, split between two data files
+	inventoryRows = [][]inventoryRow{{
+		{"a.txt", 1, inventoryModTime, true, false},
+		{"dir/b.txt", 2, inventoryModTime, true, false},
+		{"dir/gone.txt", 0, inventoryModTime, true, true},
+	}, {
+		{"dir/old.txt", 4, inventoryModTime, false, false},
+		{"dir/sub/c.txt", 3, inventoryModTime.Add(time.Second), true, false},
+		{"dir/with space.txt", 5, inventoryModTime, true, false},
+	}}
+)
+
+// putObject writes an object to a bucket.
+func putObject(t *testing.T, client *s3.Client, bucket, key string, data []byte) {
+	t.Helper()
+
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		t.Fatalf("PutObject(%q): %s", key, err)
+	}
+}
+
+// writeInventory writes an inventory report of testBucket in the given
+// format to inventoryBucket, with a data file for each group of rows, and
+// returns the key of its manifest.
+func writeInventory(t *testing.T, client *s3.Client, format string, rows [][]inventoryRow) string {
+	t.Helper()
+
+	var files [][]byte
+	for _, rs := range rows {
+		switch format {
+		case s3fs.InventoryFormatCSV:
+			files = append(files, csvInventory(t, rs))
+		case s3fs.InventoryFormatORC:
+			files = append(files, orcInventory(rs))
+		case s3fs.InventoryFormatParquet:
+			files = append(files, parquetInventory(rs))
+		}
+	}
+	return writeManifest(t, client, format, files)
+}
+
+// writeManifest writes the data files of an inventory report of testBucket
+// in the given format to inventoryBucket, along with its manifest, and
+// returns the key of the manifest.
+func writeManifest(t *testing.T, client *s3.Client, format string, files [][]byte) string {
+	t.Helper()
+
+	type file struct {
+		Key string `json:"key"`
+	}
+	m := struct {
+		SourceBucket      string `json:"sourceBucket"`
+		DestinationBucket string `json:"destinationBucket"`
+		FileFormat        string `json:"fileFormat"`
+		FileSchema        string `json:"fileSchema"`
+		CreationTimestamp string `json:"creationTimestamp"`
+		Files             []file `json:"files"`
+	}{
+		SourceBucket:      testBucket,
+		DestinationBucket: "arn:aws:s3:::" + inventoryBucket,
+		FileFormat:        format,
+		FileSchema:        "Bucket, Key, Size, LastModifiedDate, IsLatest, IsDeleteMarker",
+		CreationTimestamp: strconv.FormatInt(inventoryCreated.UnixNano()/int64(time.Millisecond), 10),
+	}
+	for i, data := range files {
+		key := "inventory/data/" + strconv.Itoa(i)
+		putObject(t, client, inventoryBucket, key, data)
+		m.Files = append(m.Files, file{Key: key})
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	putObject(t, client, inventoryBucket, "inventory/manifest.json", b)
+	return "inventory/manifest.json"
+}
+
+// csvInventory encodes rows as a gzipped CSV inventory data file. Keys are
+// URL-encoded, as S3 writes them.
+func csvInventory(t *testing.T, rows []inventoryRow) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := csv.NewWriter(gz)
+	for _, r := range rows {
+		size := strconv.FormatInt(r.size, 10)
+		if r.deleteMarker {
+			size = ""
+		}
+		w.Write([]string{
+			testBucket,
+			url.PathEscape(r.key),
+			size,
+			r.modTime.Format(time.RFC3339),
+			strconv.FormatBool(r.latest),
+			strconv.FormatBool(r.deleteMarker),
+		})
+	}
+	w.Flush()
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// appendUvarint appends the varint encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendUint64 appends v to b in the given byte order.
+func appendUint64(order binary.ByteOrder, b []byte, v uint64) []byte {
+	var buf [8]byte
+	order.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendUint32 appends v to b in little-endian order.
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// protoBuf builds a protocol buffers message.
+type protoBuf []byte
+
+func (p protoBuf) varint(num int, v uint64) protoBuf {
+	p = appendUvarint(p, uint64(num)<<3)
+	return appendUvarint(p, v)
+}
+
+func (p protoBuf) bytes(num int, b []byte) protoBuf {
+	p = appendUvarint(p, uint64(num)<<3|2)
+	p = appendUvarint(p, uint64(len(b)))
+	return append(p, b...)
+}
+
+// orcInts encodes integers with run-length encoding version 2, as runs of
+// directly stored 64-bit values.
+func orcInts(vs []int64, signed bool) []byte {
+	var b []byte
+	for len(vs) > 0 {
+		n := len(vs)
+		if n > 512 {
+			n = 512
+		}
+		b = append(b, 1<<6|31<<1|byte((n-1)>>8), byte(n-1))
+		for _, v := range vs[:n] {
+			u := uint64(v)
+			if signed {
+				u = uint64(v<<1) ^ uint64(v>>63)
+			}
+			b = appendUint64(binary.BigEndian, b, u)
+		}
+		vs = vs[n:]
+	}
+	return b
+}
+
+// orcBools encodes booleans as literal runs of bytes.
+func orcBools(vs []bool) []byte {
+	packed := make([]byte, (len(vs)+7)/8)
+	for i, v := range vs {
+		if v {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	var b []byte
+	for len(packed) > 0 {
+		n := len(packed)
+		if n > 128 {
+			n = 128
+		}
+		b = append(b, byte(-n))
+		b = append(b, packed[:n]...)
+		packed = packed[n:]
+	}
+	return b
+}
+
+// orcZlib compresses an ORC stream as a single zlib chunk.
+func orcZlib(b []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write(b)
+	w.Close()
+	l := buf.Len() << 1
+	return append([]byte{byte(l), byte(l >> 8), byte(l >> 16)}, buf.Bytes()...)
+}
+
+// orcInventory encodes rows as a zlib-compressed ORC inventory data file
+// with a single stripe. Bucket names are dictionary encoded, and the sizes
+// of delete markers are null.
+func orcInventory(rows []inventoryRow) []byte {
+	var keys []byte
+	var lengths, sizes, secs, nanos []int64
+	var present, latest, deleted []bool
+	for _, r := range rows {
+		keys = append(keys, r.key...)
+		lengths = append(lengths, int64(len(r.key)))
+		present = append(present, !r.deleteMarker)
+		if !r.deleteMarker {
+			sizes = append(sizes, r.size)
+		}
+		secs = append(secs, r.modTime.Unix()-1420070400)
+		nanos = append(nanos, int64(r.modTime.Nanosecond())<<3)
+		latest = append(latest, r.latest)
+		deleted = append(deleted, r.deleteMarker)
+	}
+	bucketIdx := make([]int64, len(rows))
+
+	// Streams by column, with the column encoding (direct or dictionary)
+	type stream struct {
+		col, kind int
+		data      []byte
+	}
+	streams := []stream{
+		{1, 1, orcInts(bucketIdx, false)},                       // bucket: DATA
+		{1, 2, orcInts([]int64{int64(len(testBucket))}, false)}, // bucket: LENGTH
+		{1, 3, []byte(testBucket)},                              // bucket: DICTIONARY_DATA
+		{2, 1, keys},                                            // key: DATA
+		{2, 2, orcInts(lengths, false)},                         // key: LENGTH
+		{3, 0, orcBools(present)},                               // size: PRESENT
+		{3, 1, orcInts(sizes, true)},                            // size: DATA
+		{4, 1, orcInts(secs, true)},                             // last_modified_date: DATA
+		{4, 5, orcInts(nanos, false)},                           // last_modified_date: SECONDARY
+		{5, 1, orcBools(latest)},                                // is_latest: DATA
+		{6, 1, orcBools(deleted)},                               // is_delete_marker: DATA
+	}
+	encodings := []protoBuf{
+		protoBuf{}.varint(1, 0),                          // root: DIRECT
+		protoBuf{}.varint(1, 3).varint(2, 1),             // bucket: DICTIONARY_V2
+		protoBuf{}.varint(1, 2),                          // key: DIRECT_V2
+		protoBuf{}.varint(1, 2),                          // size: DIRECT_V2
+		protoBuf{}.varint(1, 2),                          // last_modified_date: DIRECT_V2
+		protoBuf{}.varint(1, 0), protoBuf{}.varint(1, 0), // booleans: DIRECT
+	}
+
+	file := []byte("ORC")
+	var stripeFooter protoBuf
+	dataLen := 0
+	for _, s := range streams {
+		data := orcZlib(s.data)
+		file = append(file, data...)
+		dataLen += len(data)
+		stripeFooter = stripeFooter.bytes(1, protoBuf{}.varint(1, uint64(s.kind)).varint(2, uint64(s.col)).varint(3, uint64(len(data))))
+	}
+	for _, e := range encodings {
+		stripeFooter = stripeFooter.bytes(2, e)
+	}
+	stripeFooter = stripeFooter.bytes(3, []byte("UTC"))
+	sf := orcZlib(stripeFooter)
+	file = append(file, sf...)
+
+	// The root struct, then a type for each column
+	names := []string{"bucket", "key", "size", "last_modified_date", "is_latest", "is_delete_marker"}
+	kinds := []uint64{7, 7, 4, 9, 0, 0}
+	root := protoBuf{}.varint(1, 12)
+	for i, n := range names {
+		root = root.varint(2, uint64(i+1)).bytes(3, []byte(n))
+	}
+	footer := protoBuf{}.varint(1, 3).varint(2, uint64(len(file)-3))
+	footer = footer.bytes(3, protoBuf{}.varint(1, 3).varint(2, 0).varint(3, uint64(dataLen)).varint(4, uint64(len(sf))).varint(5, uint64(len(rows))))
+	footer = footer.bytes(4, root)
+	for _, k := range kinds {
+		footer = footer.bytes(4, protoBuf{}.varint(1, k))
+	}
+	footer = footer.varint(6, uint64(len(rows)))
+	f := orcZlib(footer)
+	file = append(file, f...)
+
+	ps := protoBuf{}.varint(1, uint64(len(f))).varint(2, 1).varint(3, 256*1024).bytes(8000, []byte("ORC"))
+	file = append(file, ps...)
+	return append(file, byte(len(ps)))
+}
+
+// thriftBuf builds a struct with Thrift's compact protocol.
+type thriftBuf struct {
+	b    []byte
+	last []int // ID of the last field of each open struct
+}
+
+func (t *thriftBuf) field(id, typ int) {
+	last := &t.last[len(t.last)-1]
+	t.b = append(t.b, byte((id-*last)<<4|typ))
+	*last = id
+}
+
+func (t *thriftBuf) i64(id int, v int64) {
+	t.field(id, 6)
+	t.b = appendUvarint(t.b, uint64(v<<1)^uint64(v>>63))
+}
+
+func (t *thriftBuf) i32(id int, v int64) {
+	t.field(id, 5)
+	t.b = appendUvarint(t.b, uint64(v<<1)^uint64(v>>63))
+}
+
+func (t *thriftBuf) binary(id int, s string) {
+	t.field(id, 8)
+	t.b = appendUvarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// list starts a list of n elements of the given type. Structs in the
+// list are written with begin and end.
+func (t *thriftBuf) list(id, typ, n int) {
+	t.field(id, 9)
+	t.b = append(t.b, byte(n<<4|typ))
+}
+
+func (t *thriftBuf) begin(id int) {
+	if id != 0 {
+		t.field(id, 12)
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftBuf) end() {
+	t.b = append(t.b, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// parquetInventory encodes rows as an uncompressed Parquet inventory data
+// file with a single row group. The sizes and modification times of delete
+// markers are null.
+func parquetInventory(rows []inventoryRow) []byte {
+	type column struct {
+		name      string
+		typ       int // Physical type
+		optional  bool
+		converted int // Converted type, or -1
+	}
+	cols := []column{
+		{"key", 6, false, 0},
+		{"size", 2, true, -1},
+		{"last_modified_date", 2, true, 9},
+		{"is_latest", 0, false, -1},
+		{"is_delete_marker", 0, false, -1},
+	}
+
+	// Write a data page for each column, with its definition levels (as
+	// runs of one) if it's optional
+	file := []byte("PAR1")
+	var offsets, sizes []int
+	for _, c := range cols {
+		var page, defs []byte
+		var bools byte
+		for i, r := range rows {
+			if c.optional {
+				defs = append(defs, 2, 0)
+				if r.deleteMarker {
+					continue
+				}
+				defs[len(defs)-1] = 1
+			}
+			switch c.name {
+			case "key":
+				page = appendUint32(page, uint32(len(r.key)))
+				page = append(page, r.key...)
+			case "size":
+				page = appendUint64(binary.LittleEndian, page, uint64(r.size))
+			case "last_modified_date":
+				page = appendUint64(binary.LittleEndian, page, uint64(r.modTime.UnixNano()/int64(time.Millisecond)))
+			case "is_latest", "is_delete_marker":
+				if (c.name == "is_latest" && r.latest) || (c.name == "is_delete_marker" && r.deleteMarker) {
+					bools |= 1 << (i % 8)
+				}
+				if i%8 == 7 || i == len(rows)-1 {
+					page = append(page, bools)
+					bools = 0
+				}
+			}
+		}
+		if c.optional {
+			page = append(appendUint32(nil, uint32(len(defs))), append(defs, page...)...)
+		}
+
+		h := &thriftBuf{}
+		h.begin(0)
+		h.i32(1, 0)
+		h.i32(2, int64(len(page)))
+		h.i32(3, int64(len(page)))
+		h.begin(5)
+		h.i32(1, int64(len(rows)))
+		h.i32(2, 0)
+		h.i32(3, 3)
+		h.i32(4, 3)
+		h.end()
+		h.end()
+		offsets = append(offsets, len(file))
+		file = append(file, h.b...)
+		file = append(file, page...)
+		sizes = append(sizes, len(h.b)+len(page))
+	}
+
+	m := &thriftBuf{}
+	m.begin(0)
+	m.i32(1, 1)
+	m.list(2, 12, len(cols)+1)
+	m.begin(0)
+	m.binary(4, "s3")
+	m.i32(5, int64(len(cols)))
+	m.end()
+	for _, c := range cols {
+		m.begin(0)
+		m.i32(1, int64(c.typ))
+		rep := int64(0)
+		if c.optional {
+			rep = 1
+		}
+		m.i32(3, rep)
+		m.binary(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, int64(c.converted))
+		}
+		m.end()
+	}
+	m.i64(3, int64(len(rows)))
+	m.list(4, 12, 1)
+	m.begin(0)
+	m.list(1, 12, len(cols))
+	for i, c := range cols {
+		m.begin(0)
+		m.i64(2, int64(offsets[i]))
+		m.begin(3)
+		m.i32(1, int64(c.typ))
+		m.list(2, 5, 1)
+		m.b = append(m.b, 0)
+		m.list(3, 8, 1)
+		m.b = append(m.b, byte(len(c.name)))
+		m.b = append(m.b, c.name...)
+		m.i32(4, 0)
+		m.i64(5, int64(len(rows)))
+		m.i64(6, int64(sizes[i]))
+		m.i64(7, int64(sizes[i]))
+		m.i64(9, int64(offsets[i]))
+		m.end()
+		m.end()
+	}
+	m.i64(2, int64(len(file)))
+	m.i64(3, int64(len(rows)))
+	m.end()
+	m.end()
+
+	file = append(file, m.b...)
+	file = appendUint32(file, uint32(len(m.b)))
+	return append(file, "PAR1"...)
+}
+
+// newInventoryFS creates an S3FS with an inventory report in the given
+// format loaded.
+func newInventoryFS(t *testing.T, format string, overlay bool) (*s3fs.S3FS, *s3.Client) {
+	t.Helper()
+
+	client, backend := newTestClient(t)
+	backend.CreateBucket(inventoryBucket)
+	manifest := writeInventory(t, client, format, inventoryRows)
+
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	if err := fs3.LoadInventory(inventoryBucket, manifest, overlay); err != nil {
+		t.Fatalf("LoadInventory: %s", err)
+	}
+	return fs3, client
+}
+
+// entryNames returns the names of the entries, with a trailing "/" for
+// directories.
+func entryNames(fis []os.FileInfo) []string {
+	var names []string
+	for _, fi := range fis {
+		n := fi.Name()
+		if fi.IsDir() {
+			n += "/"
+		}
+		names = append(names, n)
+	}
+	return names
+}
+
+func TestInventory(t *testing.T) {
+	for _, format := range []string{s3fs.InventoryFormatCSV, s3fs.InventoryFormatORC, s3fs.InventoryFormatParquet} {
+		t.Run(format, func(t *testing.T) {
+			fs3, _ := newInventoryFS(t, format, false)
+
+			// The bucket is empty, so the entries come from the report,
+			// without delete markers or non-current versions
+			fis, err := fs3.ReadDir("dir")
+			if err != nil {
+				t.Fatalf("ReadDir: %s", err)
+			}
+			if got, expected := entryNames(fis), []string{"sub/", "b.txt", "with space.txt"}; !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected entries %v, got %v", expected, got)
+			}
+			for _, fi := range fis {
+				ifi, ok := fi.(s3fs.InventoryFileInfo)
+				if !ok || !ifi.Stale() || !ifi.AsOf().Equal(inventoryCreated) {
+					t.Errorf("expected %s to be stale as of %v", fi.Name(), inventoryCreated)
+				}
+			}
+			if fis[1].Size() != 2 || !fis[1].ModTime().Equal(inventoryModTime) {
+				t.Errorf("expected b.txt of 2 bytes modified at %v, got %d bytes at %v", inventoryModTime, fis[1].Size(), fis[1].ModTime())
+			}
+
+			// Walk is answered from the report too
+			var walked []string
+			err = fs3.Walk("", func(p string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					walked = append(walked, p+":"+strconv.FormatInt(info.Size(), 10))
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk: %s", err)
+			}
+			expected := []string{"dir/sub/c.txt:3", "dir/b.txt:2", "dir/with space.txt:5", "a.txt:1"}
+			if !reflect.DeepEqual(walked, expected) {
+				t.Errorf("expected Walk to visit %v, got %v", expected, walked)
+			}
+		})
+	}
+}
+
+func TestInventoryWalkRoot(t *testing.T) {
+	fs3, _ := newInventoryFS(t, s3fs.InventoryFormatCSV, false)
+
+	type visit struct {
+		path string
+		dir  bool
+		err  error
+	}
+	walk := func(root string) ([]visit, error) {
+		var visits []visit
+		err := fs3.Walk(root, func(p string, info os.FileInfo, err error) error {
+			v := visit{path: p, err: err}
+			if info != nil {
+				v.dir = info.IsDir()
+			}
+			visits = append(visits, v)
+			return err
+		})
+		return visits, err
+	}
+
+	// Roots are described from the report, like their contents
+	visits, err := walk("dir/sub")
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+	if expected := []visit{{"dir/sub", true, nil}, {"dir/sub/c.txt", false, nil}}; !reflect.DeepEqual(visits, expected) {
+		t.Errorf("expected Walk to visit %v, got %v", expected, visits)
+	}
+	visits, err = walk("a.txt")
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+	if expected := []visit{{"a.txt", false, nil}}; !reflect.DeepEqual(visits, expected) {
+		t.Errorf("expected Walk to visit %v, got %v", expected, visits)
+	}
+
+	// Missing roots are passed to fn with the error
+	visits, err = walk("missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if len(visits) != 1 || visits[0].path != "missing" || !errors.Is(visits[0].err, os.ErrNotExist) {
+		t.Errorf("expected fn to be called once with ErrNotExist, got %v", visits)
+	}
+}
+
+// walkInventory loads the inventory report in the given format from the
+// data file in testdata/inventory, and returns the files visited by Walk
+// with their sizes and modification times.
+func walkInventory(t *testing.T, format, name string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "inventory", name))
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	client, backend := newTestClient(t)
+	backend.CreateBucket(inventoryBucket)
+	manifest := writeManifest(t, client, format, [][]byte{data})
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	if err := fs3.LoadInventory(inventoryBucket, manifest, false); err != nil {
+		t.Fatalf("LoadInventory: %s", err)
+	}
+
+	var walked []string
+	err = fs3.Walk("", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			walked = append(walked, fmt.Sprintf("%s:%d:%s", p, info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+	return walked
+}
+
+// The files in testdata/inventory were written by other ORC and Parquet
+// implementations (see testdata/inventory/gen), from the same objects as
+// the CSV report.
+func TestInventoryFiles(t *testing.T) {
+	expected := walkInventory(t, s3fs.InventoryFormatCSV, "inventory.csv.gz")
+	if len(expected) < 1000 {
+		t.Fatalf("expected the CSV report to list at least 1000 files, got %d", len(expected))
+	}
+
+	for _, tc := range []struct {
+		format string
+		name   string
+	}{
+		{s3fs.InventoryFormatORC, "inventory.orc"},
+		{s3fs.InventoryFormatORC, "inventory.zlib.orc"},
+		{s3fs.InventoryFormatParquet, "inventory.snappy.parquet"},
+		{s3fs.InventoryFormatParquet, "inventory.zstd.v2.parquet"},
+		{s3fs.InventoryFormatParquet, "inventory.gzip.int96.parquet"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := walkInventory(t, tc.format, tc.name)
+			if len(got) != len(expected) {
+				t.Fatalf("expected Walk to visit %d files, got %d", len(expected), len(got))
+			}
+			for i := range got {
+				if got[i] != expected[i] {
+					t.Fatalf("expected Walk to visit %s, got %s", expected[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// The examples of run-length encoding version 2 from the ORC specification
+func TestInventoryORCIntegers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		expected []int64
+	}{
+		{"short repeat", []byte{0x0a, 0x27, 0x10}, []int64{10000, 10000, 10000, 10000, 10000}},
+		{"direct", []byte{0x5e, 0x03, 0x5c, 0xa1, 0xab, 0x1e, 0xde, 0xad, 0xbe, 0xef}, []int64{23713, 43806, 57005, 48879}},
+		{"patched base", []byte{
+			0x8e, 0x13, 0x2b, 0x21, 0x07, 0xd0, 0x1e, 0x00, 0x14, 0x70, 0x28, 0x32, 0x3c, 0x46, 0x50, 0x5a,
+			0x64, 0x6e, 0x78, 0x82, 0x8c, 0x96, 0xa0, 0xaa, 0xb4, 0xbe, 0xfc, 0xe8,
+		}, []int64{
+			2030, 2000, 2020, 1000000, 2040, 2050, 2060, 2070, 2080, 2090,
+			2100, 2110, 2120, 2130, 2140, 2150, 2160, 2170, 2180, 2190,
+		}},
+		{"delta", []byte{0xc6, 0x09, 0x02, 0x02, 0x22, 0x42, 0x42, 0x46}, []int64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}},
+	} {
+		got, err := s3fs.ORCInts(tc.data, len(tc.expected), false, true)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+		} else if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestInventoryOverlay(t *testing.T) {
+	fs3, client := newInventoryFS(t, s3fs.InventoryFormatCSV, true)
+	putObject(t, client, testBucket, "dir/b.txt", []byte("changed"))
+	putObject(t, client, testBucket, "dir/new.txt", []byte("new"))
+
+	// Live entries replace stale ones, and new objects are listed
+	fis, err := fs3.ReadDir("dir")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	if got, expected := entryNames(fis), []string{"sub/", "b.txt", "new.txt", "with space.txt"}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected entries %v, got %v", expected, got)
+	}
+	for _, fi := range fis {
+		stale := fi.(s3fs.InventoryFileInfo).Stale()
+		if expected := fi.Name() == "sub" || fi.Name() == "with space.txt"; stale != expected {
+			t.Errorf("%s: expected stale=%t, got %t", fi.Name(), expected, stale)
+		}
+	}
+	if fis[1].Size() != int64(len("changed")) {
+		t.Errorf("expected the live size of b.txt, got %d", fis[1].Size())
+	}
+}
+
+func TestInventoryErrors(t *testing.T) {
+	client, backend := newTestClient(t)
+	backend.CreateBucket(inventoryBucket)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Unknown formats
+	putObject(t, client, inventoryBucket, "json.json", []byte(`{"fileFormat":"JSON","creationTimestamp":"0"}`))
+	if err := fs3.LoadInventory(inventoryBucket, "json.json", false); !errors.Is(err, s3fs.ErrInventoryFormatNotSupported) {
+		t.Errorf("expected ErrInventoryFormatNotSupported, got %v", err)
+	}
+
+	// CSV schemas without the key
+	manifest := `{"fileFormat":"CSV","fileSchema":"Bucket, Size, LastModifiedDate","creationTimestamp":"0"}`
+	putObject(t, client, inventoryBucket, "nokey.json", []byte(manifest))
+	if err := fs3.LoadInventory(inventoryBucket, "nokey.json", false); !errors.Is(err, s3fs.ErrInventoryMissingColumn) {
+		t.Errorf("expected ErrInventoryMissingColumn, got %v", err)
+	}
+
+	// Corrupt data files
+	for _, format := range []string{s3fs.InventoryFormatORC, s3fs.InventoryFormatParquet} {
+		manifest := writeInventory(t, client, format, inventoryRows)
+		putObject(t, client, inventoryBucket, "inventory/data/0", []byte("not an inventory file"))
+		if err := fs3.LoadInventory(inventoryBucket, manifest, false); !errors.Is(err, s3fs.ErrInventoryCorrupt) {
+			t.Errorf("%s: expected ErrInventoryCorrupt, got %v", format, err)
+		}
+	}
+}
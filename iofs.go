@@ -0,0 +1,265 @@
+// iofs.go implements an io/fs.FS view of S3FS
+
+package s3fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Ensure ioFS implements the io/fs interfaces
+var (
+	_ fs.StatFS     = (*ioFS)(nil)
+	_ fs.ReadDirFS  = (*ioFS)(nil)
+	_ fs.ReadFileFS = (*ioFS)(nil)
+	_ fs.GlobFS     = (*ioFS)(nil)
+	_ fs.SubFS      = (*ioFS)(nil)
+)
+
+// IOFS returns a read-only io/fs.FS view of the filesystem, for use with
+// the standard library (e.g. http.FS, template.ParseFS or fs.WalkDir).
+//
+// The returned FS also implements fs.StatFS, fs.ReadDirFS, fs.ReadFileFS,
+// fs.GlobFS and fs.SubFS. Paths use "/" as a separator, regardless of the
+// filesystem's separator, and fs.Sub is implemented with Chroot. Errors
+// are returned as *fs.PathError values wrapping the standard fs errors
+// where applicable.
+func (fs3 *S3FS) IOFS() fs.FS {
+	return &ioFS{fs3: fs3}
+}
+
+// ioFS implements fs.FS for an S3FS.
+type ioFS struct {
+	fs3 *S3FS
+}
+
+// path converts a valid io/fs path into an S3FS path.
+func (f *ioFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return "", nil
+	}
+	return strings.ReplaceAll(name, "/", f.fs3.separator), nil
+}
+
+// Open opens the named file or directory.
+func (f *ioFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to open it as a file
+	if p != "" {
+		key, err := f.fs3.resolve(p)
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+		rf, err := newS3ReadFile(f.fs3, f.fs3.bucket, key)
+		if err == nil {
+			return &ioFile{s3ReadFile: rf}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, pathError("open", name, err)
+		}
+	}
+
+	// Otherwise, it must be a directory
+	info, err := f.fs3.Stat(p)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &ioDir{fs: f, name: name, info: info}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fs3.Stat(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return info, nil
+}
+
+// ReadDir reads the named directory and returns its entries, sorted by
+// filename.
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// S3 "directories" always exist, so check there is something there
+	info, err := f.fs3.Stat(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDirectory}
+	}
+
+	fis, err := f.fs3.ReadDir(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, len(fis))
+	for i, fi := range fis {
+		entries[i] = fs.FileInfoToDirEntry(fi)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*ioDir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDirectory}
+	}
+	return ioutil.ReadAll(file)
+}
+
+// Glob returns the names of all files matching pattern.
+func (f *ioFS) Glob(pattern string) ([]string, error) {
+	// Use the standard implementation, without recursing into this method
+	return fs.Glob(ioFSNoGlob{f}, pattern)
+}
+
+// Sub returns an FS rooted at dir, using Chroot.
+func (f *ioFS) Sub(dir string) (fs.FS, error) {
+	p, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return f, nil
+	}
+	nfs, err := f.fs3.Chroot(p)
+	if err != nil {
+		return nil, pathError("sub", dir, err)
+	}
+	return &ioFS{fs3: nfs.(*S3FS)}, nil
+}
+
+// ioFSNoGlob hides ioFS's Glob method, so fs.Glob uses ReadDir and Stat.
+type ioFSNoGlob struct {
+	f *ioFS
+}
+
+func (g ioFSNoGlob) Open(name string) (fs.File, error) {
+	return g.f.Open(name)
+}
+
+func (g ioFSNoGlob) Stat(name string) (fs.FileInfo, error) {
+	return g.f.Stat(name)
+}
+
+func (g ioFSNoGlob) ReadDir(name string) ([]fs.DirEntry, error) {
+	return g.f.ReadDir(name)
+}
+
+// ioFile implements fs.File for a file opened in read mode.
+type ioFile struct {
+	*s3ReadFile
+}
+
+// Stat returns a FileInfo describing the file.
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	return f.s3ReadFile.Stat()
+}
+
+// ioDir implements fs.ReadDirFile for a directory.
+type ioDir struct {
+	fs      *ioFS
+	name    string        // Name as passed to Open
+	info    fs.FileInfo   // Directory info
+	entries []fs.DirEntry // Directory entries, read on first ReadDir call
+	read    bool          // Have the entries been read?
+	closed  bool          // Is the directory closed?
+}
+
+// Stat returns a FileInfo describing the directory.
+func (d *ioDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read always fails, since directories can't be read.
+func (d *ioDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: ErrIsDirectory}
+}
+
+// Close closes the directory.
+func (d *ioDir) Close() error {
+	if d.closed {
+		return &fs.PathError{Op: "close", Path: d.name, Err: fs.ErrClosed}
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, following the
+// semantics of fs.ReadDirFile.
+func (d *ioDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: fs.ErrClosed}
+	}
+
+	// Read the entries on the first call
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	res := d.entries[:n]
+	d.entries = d.entries[n:]
+	return res, nil
+}
+
+// pathError wraps err in an *fs.PathError, replacing any existing
+// *fs.PathError so the op and path match the io/fs call. Errors for paths
+// that escape the root are reported as fs.ErrInvalid.
+func pathError(op, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	if errors.Is(err, ErrPathEscapesRoot) {
+		err = fs.ErrInvalid
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
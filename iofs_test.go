@@ -0,0 +1,49 @@
+package s3fs_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "a.txt", "a")
+	writeFile(t, fs3, "dir/b.txt", "bb")
+	writeFile(t, fs3, "dir/sub/c.txt", "ccc")
+
+	if err := fstest.TestFS(fs3.IOFS(), "a.txt", "dir/b.txt", "dir/sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIOFSSub(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "dir/sub/c.txt", "ccc")
+
+	sub, err := fs.Sub(fs3.IOFS(), "dir")
+	if err != nil {
+		t.Fatalf("Sub: %s", err)
+	}
+	b, err := fs.ReadFile(sub, "sub/c.txt")
+	if err != nil || string(b) != "ccc" {
+		t.Errorf("ReadFile: got %q, %v", b, err)
+	}
+}
+
+func TestIOFSErrors(t *testing.T) {
+	fs3 := newTestFS(t)
+	fsys := fs3.IOFS()
+
+	var pe *fs.PathError
+	if _, err := fsys.Open("missing"); !errors.As(err, &pe) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open missing: expected a *fs.PathError wrapping fs.ErrNotExist, got %v", err)
+	}
+	if _, err := fsys.Open("../escape"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open invalid: expected fs.ErrInvalid, got %v", err)
+	}
+	if _, err := fs.Stat(fsys, "/abs"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Stat invalid: expected fs.ErrInvalid, got %v", err)
+	}
+}
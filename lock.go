@@ -0,0 +1,110 @@
+// lock.go implements billy.File locking
+
+package s3fs
+
+import (
+	"sync"
+	"time"
+)
+
+// lockTable holds the locks taken with billy.File.Lock, along with any
+// WebDAV locks (see WebDAVLockSystem). S3 has no locking, so locks only
+// coordinate files opened from the same S3FS (and its chroots) in this
+// process.
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{} // Closed when the lock is released
+	dav   map[string]*davLock      // WebDAV locks by token
+}
+
+// newLockTable creates an empty lockTable.
+func newLockTable() *lockTable {
+	return &lockTable{
+		locks: make(map[string]chan struct{}),
+		dav:   make(map[string]*davLock),
+	}
+}
+
+// lock blocks until the lock on key is acquired. Keys covered by a WebDAV
+// lock can't be locked until the WebDAV lock is removed or expires.
+func (t *lockTable) lock(key string) {
+	for {
+		t.mu.Lock()
+		t.expire(time.Now())
+		released, held := t.locks[key]
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !held {
+			if l := t.davLockOn(key); l != nil {
+				released, held = l.released, true
+				// Wake up when the lock expires. Expired locks held by
+				// Confirm calls are removed when they are released.
+				if d := time.Until(l.expiry); !l.expiry.IsZero() && d > 0 {
+					timer = time.NewTimer(d)
+					expired = timer.C
+				}
+			}
+		}
+		if !held {
+			t.locks[key] = make(chan struct{})
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-expired:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// unlock releases the lock on key.
+func (t *lockTable) unlock(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if released, ok := t.locks[key]; ok {
+		close(released)
+		delete(t.locks, key)
+	}
+}
+
+// fileLock is the lock state of an open file. Like flock, locking a file
+// that's already locked is a no-op, and closing a file releases its lock.
+type fileLock struct {
+	locks *lockTable
+	key   string
+	held  bool
+}
+
+// newFileLock creates the lock state for a file in fs3.
+func newFileLock(fs3 *S3FS, bucket, key string) fileLock {
+	return fileLock{locks: fs3.locks, key: lockKey(bucket, key)}
+}
+
+// lockKey returns the key of an object in the lock table.
+func lockKey(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// acquire locks the file, waiting for any other holder to unlock it.
+func (l *fileLock) acquire() error {
+	if !l.held {
+		l.locks.lock(l.key)
+		l.held = true
+	}
+	return nil
+}
+
+// release unlocks the file, if it's locked.
+func (l *fileLock) release() error {
+	if l.held {
+		l.locks.unlock(l.key)
+		l.held = false
+	}
+	return nil
+}
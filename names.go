@@ -0,0 +1,150 @@
+// names.go implements filename encryption
+
+package s3fs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	FilenameKeySize = 32 // Size of the key used for filename encryption
+
+	nameIVSize = 16 // Size of the synthetic IV prepended to encrypted names
+)
+
+var (
+	ErrInvalidName = errors.New("invalid encrypted name")
+)
+
+// nameEncoding encodes encrypted names. Base32 (with the "extended hex"
+// alphabet, in lower case) keeps them safe for case-insensitive systems
+// and preserves their sort order.
+var nameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// nameAlphabet holds the characters of encoded names.
+const nameAlphabet = "0123456789abcdefghijklmnopqrstuv"
+
+// nameCipher deterministically encrypts path segments, so that the same
+// name always maps to the same key and paths can still be looked up. Each
+// segment is encrypted with AES-CTR, using the truncated HMAC-SHA256 of
+// the name as the IV (a synthetic IV, as in AES-SIV), which also
+// authenticates it. Only names that are equal can be told apart.
+type nameCipher struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+// newNameCipher creates a nameCipher, deriving the encryption and MAC keys
+// from key.
+func newNameCipher(key []byte) (*nameCipher, error) {
+	if len(key) != FilenameKeySize {
+		return nil, fmt.Errorf("filename keys must be %d bytes, got %d", FilenameKeySize, len(key))
+	}
+	block, err := aes.NewCipher(deriveKey(key, "s3fs filename encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &nameCipher{
+		block:  block,
+		macKey: deriveKey(key, "s3fs filename authentication"),
+	}, nil
+}
+
+// deriveKey derives a subkey for the given purpose.
+func deriveKey(key []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// iv returns the synthetic IV for a name.
+func (c *nameCipher) iv(name []byte) []byte {
+	m := hmac.New(sha256.New, c.macKey)
+	m.Write(name)
+	return m.Sum(nil)[:nameIVSize]
+}
+
+// encrypt encrypts a single path segment.
+func (c *nameCipher) encrypt(name string) string {
+	iv := c.iv([]byte(name))
+	out := make([]byte, nameIVSize+len(name))
+	copy(out, iv)
+	cipher.NewCTR(c.block, iv).XORKeyStream(out[nameIVSize:], []byte(name))
+	return strings.ToLower(nameEncoding.EncodeToString(out))
+}
+
+// decrypt decrypts a single path segment, checking that it's authentic.
+func (c *nameCipher) decrypt(enc string) (string, error) {
+	b, err := nameEncoding.DecodeString(strings.ToUpper(enc))
+	if err != nil || len(b) < nameIVSize {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, enc)
+	}
+	iv := b[:nameIVSize]
+	name := make([]byte, len(b)-nameIVSize)
+	cipher.NewCTR(c.block, iv).XORKeyStream(name, b[nameIVSize:])
+	if !hmac.Equal(iv, c.iv(name)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, enc)
+	}
+	return string(name), nil
+}
+
+// encryptPath encrypts each segment of a cleaned relative path.
+func (c *nameCipher) encryptPath(rel, sep string) string {
+	segs := strings.Split(rel, sep)
+	for i, s := range segs {
+		segs[i] = c.encrypt(s)
+	}
+	return strings.Join(segs, sep)
+}
+
+// keyName returns the name of the file or directory with the given key
+// (its last segment), decrypted if filenames are encrypted.
+func (fs3 *S3FS) keyName(key string) string {
+	name := key[strings.LastIndex(key, fs3.separator)+1:]
+	if fs3.names != nil {
+		if n, err := fs3.names.decrypt(name); err == nil {
+			return n
+		}
+	}
+	return name
+}
+
+// decryptEntries decrypts the names of directory entries, dropping any
+// that weren't encrypted with the filesystem's key, and sorts them again.
+func (fs3 *S3FS) decryptEntries(fis []os.FileInfo) []os.FileInfo {
+	res := fis[:0]
+	for _, fi := range fis {
+		s, ok := fi.(s3FileInfo)
+		if !ok {
+			continue
+		}
+		name, err := fs3.names.decrypt(s.name)
+		if err != nil {
+			continue
+		}
+		s.name = name
+		res = append(res, s)
+	}
+	sortEntries(res)
+	return res
+}
+
+// sortEntries sorts directory entries with directories first, then by
+// name.
+func sortEntries(fis []os.FileInfo) {
+	sort.SliceStable(fis, func(i, j int) bool {
+		if fis[i].IsDir() != fis[j].IsDir() {
+			return fis[i].IsDir()
+		}
+		return fis[i].Name() < fis[j].Name()
+	})
+}
@@ -0,0 +1,175 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// listKeys returns every key in the test bucket.
+func listKeys(t *testing.T, client *s3.Client) []string {
+	t.Helper()
+
+	res, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket: aws.String(testBucket),
+	})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %s", err)
+	}
+	var keys []string
+	for _, o := range res.Contents {
+		keys = append(keys, aws.ToString(o.Key))
+	}
+	return keys
+}
+
+func TestFilenameEncryption(t *testing.T) {
+	client, _ := newTestClient(t)
+	key := bytes.Repeat([]byte{1}, s3fs.FilenameKeySize)
+	fs3, err := s3fs.NewS3FS(client, testBucket,
+		s3fs.WithRoot("customers"),
+		s3fs.WithFilenameEncryption(key),
+	)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	writeFile(t, fs3, "acme/report.txt", "report")
+	writeFile(t, fs3, "acme/invoices/2021.pdf", "invoice")
+	writeFile(t, fs3, "globex/notes.txt", "notes")
+
+	// The root is left as it is, but the names below it are encrypted
+	keys := listKeys(t, client)
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 keys, got %v", keys)
+	}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "customers/") {
+			t.Errorf("expected %q to be under the root", k)
+		}
+		for _, name := range []string{"acme", "globex", "report", "invoices", "notes"} {
+			if strings.Contains(k, name) {
+				t.Errorf("key %q contains %q", k, name)
+			}
+		}
+	}
+
+	// Names are decrypted by ReadDir and Stat
+	fis, err := fs3.ReadDir("acme")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"invoices", "report.txt"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+	fi, err := fs3.Stat("acme/report.txt")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Name() != "report.txt" || fi.Size() != 6 {
+		t.Errorf("unexpected file info: name=%q size=%d", fi.Name(), fi.Size())
+	}
+	f, err := fs3.Open("acme/report.txt")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if fi, _ := f.(interface{ Stat() (os.FileInfo, error) }).Stat(); fi.Name() != "report.txt" {
+		t.Errorf("expected the open file to be named %q, got %q", "report.txt", fi.Name())
+	}
+	f.Close()
+
+	// And by Walk
+	var walked []string
+	err = fs3.Walk("", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			walked = append(walked, filepath.ToSlash(p))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: %s", err)
+	}
+	expected := []string{"acme/invoices/2021.pdf", "acme/report.txt", "globex/notes.txt"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Errorf("expected Walk to visit %v, got %v", expected, walked)
+	}
+
+	// Chroots encrypt the paths beneath them
+	sub, err := fs3.Chroot("acme")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	if got := readFile(t, sub, "invoices/2021.pdf"); got != "invoice" {
+		t.Errorf("expected %q, got %q", "invoice", got)
+	}
+	writeFile(t, sub, "new.txt", "new")
+	if got := readFile(t, fs3, "acme/new.txt"); got != "new" {
+		t.Errorf("expected %q, got %q", "new", got)
+	}
+
+	// Objects that weren't encrypted with the key are left out
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("customers/plain.txt"),
+		Body:   strings.NewReader("plain"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject: %s", err)
+	}
+	fis, err = fs3.ReadDir("")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	names = nil
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"acme", "globex"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+
+	// Another filesystem with the same key sees the same names
+	other, err := s3fs.NewS3FS(client, testBucket,
+		s3fs.WithRoot("customers"),
+		s3fs.WithFilenameEncryption(key),
+	)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	if got := readFile(t, other, "globex/notes.txt"); got != "notes" {
+		t.Errorf("expected %q, got %q", "notes", got)
+	}
+}
+
+func TestFilenameEncryptionOptions(t *testing.T) {
+	client, _ := newTestClient(t)
+
+	_, err := s3fs.NewS3FS(client, testBucket, s3fs.WithFilenameEncryption([]byte("short")))
+	if !errors.Is(err, s3fs.ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for a short key, got %v", err)
+	}
+
+	_, err = s3fs.NewS3FS(client, testBucket,
+		s3fs.WithSeparator("a"),
+		s3fs.WithFilenameEncryption(bytes.Repeat([]byte{1}, s3fs.FilenameKeySize)),
+	)
+	if !errors.Is(err, s3fs.ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for a separator in the name alphabet, got %v", err)
+	}
+}
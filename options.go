@@ -0,0 +1,299 @@
+// options.go defines the functional options accepted by NewS3FS
+
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	MinPartSize          int64 = 5 * 1024 * 1024        // Minimum size of a multipart upload part (5 MiB)
+	MaxPartSize          int64 = 5 * 1024 * 1024 * 1024 // Maximum size of a multipart upload part (5 GiB)
+	DefaultPartSize            = MinPartSize            // Default size of a multipart upload part
+	DefaultConcurrency         = 4                      // Default number of concurrent part uploads
+	DefaultStatCacheSize       = 0                      // Default number of cached Stat results (disabled)
+	DefaultCopyThreshold       = 5 * 1024 * 1024 * 1024 // Default size above which objects are copied in parts (5 GiB)
+)
+
+var (
+	ErrInvalidOption = errors.New("invalid option")
+)
+
+// DirMarkerStyle determines how MkdirAll represents directories in S3,
+// which has no native concept of a directory.
+type DirMarkerStyle int
+
+const (
+	DirMarkerNone              DirMarkerStyle = iota // Directories only exist implicitly, as key prefixes
+	DirMarkerTrailingSeparator                       // Directories are empty objects named "dir/"
+	DirMarkerFolderSuffix                            // Directories are empty objects named "dir_$folder$"
+)
+
+// FolderSuffix is the suffix used for directory markers with the
+// DirMarkerFolderSuffix style.
+const FolderSuffix = "_$folder$"
+
+// Option configures an S3FS. Options are applied in order by NewS3FS and
+// the resulting configuration is validated once all have been applied.
+type Option func(*S3FS) error
+
+// WithRoot sets the key prefix used as the root of the filesystem. The
+// root is resolved using the filesystem's separator.
+func WithRoot(root string) Option {
+	return func(fs3 *S3FS) error {
+		fs3.root = root
+		return nil
+	}
+}
+
+// WithSeparator sets the separator used to split keys into directories.
+// The default is DefaultSeparator.
+func WithSeparator(sep string) Option {
+	return func(fs3 *S3FS) error {
+		if sep == "" {
+			return fmt.Errorf("%w: separator cannot be empty", ErrInvalidOption)
+		}
+		fs3.separator = sep
+		return nil
+	}
+}
+
+// WithDirMarkerStyle sets how directories created with MkdirAll are
+// represented. The default is DirMarkerNone.
+func WithDirMarkerStyle(style DirMarkerStyle) Option {
+	return func(fs3 *S3FS) error {
+		switch style {
+		case DirMarkerNone, DirMarkerTrailingSeparator, DirMarkerFolderSuffix:
+		default:
+			return fmt.Errorf("%w: unknown directory marker style %d", ErrInvalidOption, style)
+		}
+		fs3.dirMarker = style
+		return nil
+	}
+}
+
+// WithStorageClass sets the storage class used for new objects.
+func WithStorageClass(class types.StorageClass) Option {
+	return func(fs3 *S3FS) error {
+		for _, c := range class.Values() {
+			if c == class {
+				fs3.storageClass = class
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: unknown storage class %q", ErrInvalidOption, class)
+	}
+}
+
+// WithServerSideEncryption sets the server-side encryption used for new
+// objects. A KMS key ID may only be given with types.ServerSideEncryptionAwsKms
+// (if it is empty, the bucket's default KMS key is used).
+func WithServerSideEncryption(sse types.ServerSideEncryption, kmsKeyID string) Option {
+	return func(fs3 *S3FS) error {
+		if sse == "" {
+			return fmt.Errorf("%w: unknown server-side encryption %q", ErrInvalidOption, sse)
+		}
+		return WithEncryption(Encryption{Type: sse, KMSKeyID: kmsKeyID})(fs3)
+	}
+}
+
+// WithEncryption sets the encryption used for objects written and read by
+// the filesystem, including SSE-KMS encryption contexts and SSE-C customer
+// keys. It can be overridden for a single file with WithFileEncryption.
+func WithEncryption(enc Encryption) Option {
+	return func(fs3 *S3FS) error {
+		e, err := newEncryption(enc)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidOption, err)
+		}
+		fs3.enc = e
+		return nil
+	}
+}
+
+// WithClientSideEncryption encrypts the contents of files before they're
+// uploaded, and decrypts them when they're read. Each object is encrypted
+// with its own data key using AES-GCM, in chunks of EncryptionChunkSize so
+// that ranges can be read on their own, and the data key is stored in the
+// object's metadata after being wrapped by keys.
+//
+// Objects that aren't encrypted can still be read. Listings don't say
+// whether objects are encrypted, so ReadDir assumes that all files are and
+// reports the size of their plaintext; unencrypted files sharing a
+// directory with encrypted ones are reported as smaller than they are. Use
+// WithReadDirStat to have ReadDir report the same sizes as Stat.
+func WithClientSideEncryption(keys KeyProvider) Option {
+	return func(fs3 *S3FS) error {
+		if keys == nil {
+			return fmt.Errorf("%w: key provider cannot be nil", ErrInvalidOption)
+		}
+		fs3.cse = &clientEncryption{keys: keys}
+		return nil
+	}
+}
+
+// WithFilenameEncryption encrypts each segment of the paths below the root
+// (see WithRoot) before they're used as S3 keys, using a 256-bit key. Names
+// are encrypted deterministically, so files can still be looked up by
+// name, and are decrypted by ReadDir and Walk. Objects whose names weren't
+// encrypted with the key are left out of listings.
+//
+// Encrypted names are longer than the originals (by 26 characters, plus
+// 60% of their length), so deep paths may hit S3's 1024 byte key limit.
+func WithFilenameEncryption(key []byte) Option {
+	return func(fs3 *S3FS) error {
+		c, err := newNameCipher(key)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidOption, err)
+		}
+		fs3.names = c
+		return nil
+	}
+}
+
+// WithCompression compresses files when they're written, setting their
+// Content-Encoding and recording their uncompressed size in their metadata.
+// Files are decompressed when they're opened, based on their
+// Content-Encoding, whatever the filesystem's compression. Files
+// compressed with CompressionZstd use the seekable format, so ranges can
+// be read without decompressing the whole file.
+//
+// Stat reports the uncompressed size of files, but listings don't include
+// metadata, so ReadDir reports their compressed size.
+func WithCompression(c Compression) Option {
+	return func(fs3 *S3FS) error {
+		switch c {
+		case CompressionNone, CompressionGzip, CompressionZstd:
+		default:
+			return fmt.Errorf("%w: unknown compression %d", ErrInvalidOption, c)
+		}
+		fs3.compression = c
+		return nil
+	}
+}
+
+// WithHeaders sets the HTTP headers stored with files when they're written
+// (see Headers). Use WithFileHeaders to override them for a single file.
+// If no Content-Type is given, it's detected from each file's extension
+// or, failing that, its contents.
+func WithHeaders(h Headers) Option {
+	return func(fs3 *S3FS) error {
+		fs3.headers = h
+		return nil
+	}
+}
+
+// WithPartSize sets the size of each part of a multipart upload. It must
+// be between MinPartSize and MaxPartSize.
+func WithPartSize(size int64) Option {
+	return func(fs3 *S3FS) error {
+		if size < MinPartSize || size > MaxPartSize {
+			return fmt.Errorf("%w: part size must be between %d and %d bytes, got %d", ErrInvalidOption, MinPartSize, MaxPartSize, size)
+		}
+		fs3.partSize = size
+		return nil
+	}
+}
+
+// WithCopyThreshold sets the size above which objects are copied in parts
+// (with UploadPartCopy) when their metadata is replaced, e.g. by SetXattr
+// or Chmod. It must be between MinPartSize and DefaultCopyThreshold, the
+// size of the largest object a single CopyObject request can copy.
+func WithCopyThreshold(size int64) Option {
+	return func(fs3 *S3FS) error {
+		if size < MinPartSize || size > DefaultCopyThreshold {
+			return fmt.Errorf("%w: copy threshold must be between %d and %d bytes, got %d", ErrInvalidOption, MinPartSize, DefaultCopyThreshold, size)
+		}
+		fs3.copyThreshold = size
+		return nil
+	}
+}
+
+// WithConcurrency sets the maximum number of parts of a multipart upload
+// that are uploaded concurrently.
+func WithConcurrency(n int) Option {
+	return func(fs3 *S3FS) error {
+		if n < 1 {
+			return fmt.Errorf("%w: concurrency must be at least 1, got %d", ErrInvalidOption, n)
+		}
+		fs3.concurrency = n
+		return nil
+	}
+}
+
+// WithStatCacheSize sets the number of Stat results that are cached. A
+// size of 0 disables the cache.
+func WithStatCacheSize(n int) Option {
+	return func(fs3 *S3FS) error {
+		if n < 0 {
+			return fmt.Errorf("%w: stat cache size can't be negative, got %d", ErrInvalidOption, n)
+		}
+		fs3.statCacheSize = n
+		return nil
+	}
+}
+
+// WithRetryer sets the retry policy used for all S3 requests made by the
+// filesystem, overriding the one configured on the client.
+func WithRetryer(r aws.Retryer) Option {
+	return func(fs3 *S3FS) error {
+		if r == nil {
+			return fmt.Errorf("%w: retryer cannot be nil", ErrInvalidOption)
+		}
+		fs3.optFns = append(fs3.optFns, func(o *s3.Options) {
+			o.Retryer = r
+		})
+		return nil
+	}
+}
+
+// WithRetryMaxAttempts sets the maximum number of attempts made for each
+// S3 request, using the SDK's standard retry policy.
+func WithRetryMaxAttempts(n int) Option {
+	return func(fs3 *S3FS) error {
+		if n < 1 {
+			return fmt.Errorf("%w: max attempts must be at least 1, got %d", ErrInvalidOption, n)
+		}
+		return WithRetryer(retry.NewStandard(func(o *retry.StandardOptions) {
+			o.MaxAttempts = n
+		}))(fs3)
+	}
+}
+
+// WithPOSIXMetadata sets whether new files are written with their mode
+// (from the permissions passed to OpenFile), owner and modification time
+// in their metadata, as s3fs-fuse and rclone do. Attributes stored this
+// way are always read back by Stat.
+func WithPOSIXMetadata(enabled bool) Option {
+	return func(fs3 *S3FS) error {
+		fs3.posix = enabled
+		return nil
+	}
+}
+
+// WithReadDirStat sets whether ReadDir describes each file it lists with
+// a HeadObject request, like Stat, so that its entries include the files'
+// POSIX attributes and metadata, and the sizes of encrypted and compressed
+// files are exact. The requests are made concurrently (see
+// WithConcurrency).
+func WithReadDirStat(enabled bool) Option {
+	return func(fs3 *S3FS) error {
+		fs3.readDirStat = enabled
+		return nil
+	}
+}
+
+// WithChrootVerification sets whether Chroot checks that the new root is
+// an existing directory.
+func WithChrootVerification(verify bool) Option {
+	return func(fs3 *S3FS) error {
+		fs3.verifyChroot = verify
+		return nil
+	}
+}
@@ -0,0 +1,919 @@
+// orc.go reads the columns of Apache ORC inventory data files
+
+package s3fs
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// ORC file format, from https://orc.apache.org/specification/ORCv1/
+const (
+	orcMagic = "ORC"
+
+	// Compression kinds
+	orcCompressionNone   = 0
+	orcCompressionZlib   = 1
+	orcCompressionSnappy = 2
+	orcCompressionZstd   = 5
+
+	// Type kinds
+	orcBoolean          = 0
+	orcByte             = 1
+	orcShort            = 2
+	orcInt              = 3
+	orcLong             = 4
+	orcString           = 7
+	orcBinary           = 8
+	orcTimestamp        = 9
+	orcStruct           = 12
+	orcVarchar          = 16
+	orcChar             = 17
+	orcTimestampInstant = 18
+
+	// Stream kinds
+	orcPresent        = 0
+	orcData           = 1
+	orcLength         = 2
+	orcDictionaryData = 3
+	orcSecondary      = 5
+
+	// Column encodings
+	orcDirect       = 0
+	orcDictionary   = 1
+	orcDirectV2     = 2
+	orcDictionaryV2 = 3
+
+	orcTimestampEpoch = 1420070400 // 2015-01-01 00:00:00 UTC, the base of timestamps
+)
+
+// inventoryColumn holds the values of one column of an ORC or Parquet
+// inventory data file. Only the slice for the column's type is set, and
+// null values are left as zero values.
+type inventoryColumn struct {
+	strs  []string
+	ints  []int64
+	bools []bool
+	times []time.Time
+}
+
+// readORC reads the named top-level columns of an ORC file, returning the
+// number of rows and the columns that were found.
+func readORC(data []byte, names []string) (int, map[string]*inventoryColumn, error) {
+	// Read the postscript, which is stored uncompressed at the end
+	if len(data) < len(orcMagic)+1 || string(data[:len(orcMagic)]) != orcMagic {
+		return 0, nil, fmt.Errorf("%w: missing magic number", ErrInventoryCorrupt)
+	}
+	psEnd := len(data) - 1
+	psLen := int(data[psEnd])
+	if psLen > psEnd {
+		return 0, nil, fmt.Errorf("%w: invalid postscript length", ErrInventoryCorrupt)
+	}
+	ps, err := parseProto(data[psEnd-psLen : psEnd])
+	if err != nil {
+		return 0, nil, err
+	}
+	footerLen := ps.uint(1)
+	codec := ps.uint(2)
+	if footerLen > uint64(psEnd-psLen) {
+		return 0, nil, fmt.Errorf("%w: invalid footer length", ErrInventoryCorrupt)
+	}
+
+	// Read the footer
+	raw, err := orcDecompress(codec, data[psEnd-psLen-int(footerLen):psEnd-psLen])
+	if err != nil {
+		return 0, nil, err
+	}
+	footer, err := parseProto(raw)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Find the columns in the schema. The first type is the root struct,
+	// whose fields are the top-level columns.
+	var types []protoFields
+	for _, b := range footer.all(4) {
+		t, err := parseProto(b.b)
+		if err != nil {
+			return 0, nil, err
+		}
+		types = append(types, t)
+	}
+	if len(types) == 0 || types[0].uint(1) != orcStruct {
+		return 0, nil, fmt.Errorf("%w: the schema isn't a struct", ErrInventoryCorrupt)
+	}
+	ids := types[0].uints(2)
+	fields := types[0].all(3)
+	colIDs := make(map[string]int)
+	for i, f := range fields {
+		if i < len(ids) && ids[i] < uint64(len(types)) {
+			colIDs[string(f.b)] = int(ids[i])
+		}
+	}
+
+	cols := make(map[string]*inventoryColumn)
+	for _, name := range names {
+		if _, ok := colIDs[name]; ok {
+			cols[name] = &inventoryColumn{}
+		}
+	}
+
+	// Read each stripe
+	rows := 0
+	maxRows := len(data) * maxInventoryRowsPerByte
+	for _, sf := range footer.all(3) {
+		si, err := parseProto(sf.b)
+		if err != nil {
+			return 0, nil, err
+		}
+		offset, indexLen, dataLen, footLen := si.uint(1), si.uint(2), si.uint(3), si.uint(4)
+		size := uint64(len(data))
+		if offset > size || indexLen > size-offset || dataLen > size-offset-indexLen || footLen > size-offset-indexLen-dataLen {
+			return 0, nil, fmt.Errorf("%w: stripe out of range", ErrInventoryCorrupt)
+		}
+		end := offset + indexLen + dataLen + footLen
+		if si.uint(5) > uint64(maxRows-rows) {
+			return 0, nil, fmt.Errorf("%w: invalid row count", ErrInventoryCorrupt)
+		}
+		n := int(si.uint(5))
+
+		raw, err := orcDecompress(codec, data[offset+indexLen+dataLen:end])
+		if err != nil {
+			return 0, nil, err
+		}
+		sfoot, err := parseProto(raw)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// Streams are stored one after the other, in the order they're
+		// listed in the stripe footer
+		s := &orcStripe{
+			codec:     codec,
+			rows:      n,
+			streams:   make(map[[2]int][]byte),
+			encodings: sfoot.all(2),
+			tz:        string(sfoot.bytes(3)),
+		}
+		pos := offset
+		for _, b := range sfoot.all(1) {
+			st, err := parseProto(b.b)
+			if err != nil {
+				return 0, nil, err
+			}
+			l := st.uint(3)
+			if pos+l > end || pos+l < pos {
+				return 0, nil, fmt.Errorf("%w: stream out of range", ErrInventoryCorrupt)
+			}
+			s.streams[[2]int{int(st.uint(2)), int(st.uint(1))}] = data[pos : pos+l]
+			pos += l
+		}
+
+		for name, col := range cols {
+			id := colIDs[name]
+			if err := s.readColumn(col, id, types[id].uint(1)); err != nil {
+				return 0, nil, fmt.Errorf("column %q: %w", name, err)
+			}
+		}
+		rows += n
+	}
+	return rows, cols, nil
+}
+
+// orcStripe holds the streams of a stripe of an ORC file.
+type orcStripe struct {
+	codec     uint64            // Compression kind
+	rows      int               // Number of rows
+	streams   map[[2]int][]byte // Compressed streams, by column and kind
+	encodings []protoField      // Column encodings, by column
+	tz        string            // Time zone timestamps were written in
+}
+
+// stream returns the decompressed stream of a column, or nil if it has
+// none.
+func (s *orcStripe) stream(col, kind int) ([]byte, error) {
+	b, ok := s.streams[[2]int{col, kind}]
+	if !ok {
+		return nil, nil
+	}
+	return orcDecompress(s.codec, b)
+}
+
+// encoding returns the encoding kind and dictionary size of a column.
+func (s *orcStripe) encoding(col int) (uint64, int, error) {
+	if col >= len(s.encodings) {
+		return orcDirect, 0, nil
+	}
+	enc, err := parseProto(s.encodings[col].b)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Each entry of a dictionary is used by at least one row
+	if enc.uint(2) > uint64(s.rows) {
+		return 0, 0, fmt.Errorf("%w: invalid dictionary size", ErrInventoryCorrupt)
+	}
+	return enc.uint(1), int(enc.uint(2)), nil
+}
+
+// readColumn appends the stripe's values of a column of the given type
+// kind to col.
+func (s *orcStripe) readColumn(col *inventoryColumn, id int, kind uint64) error {
+	// Find the rows that aren't null
+	present := make([]bool, s.rows)
+	nonNull := s.rows
+	if b, err := s.stream(id, orcPresent); err != nil {
+		return err
+	} else if b != nil {
+		if present, err = orcBools(b, s.rows); err != nil {
+			return err
+		}
+		nonNull = 0
+		for _, p := range present {
+			if p {
+				nonNull++
+			}
+		}
+	} else {
+		for i := range present {
+			present[i] = true
+		}
+	}
+
+	enc, dictSize, err := s.encoding(id)
+	if err != nil {
+		return err
+	}
+	v2 := enc == orcDirectV2 || enc == orcDictionaryV2
+	data, err := s.stream(id, orcData)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case orcString, orcVarchar, orcChar, orcBinary:
+		var strs []string
+		switch enc {
+		case orcDirect, orcDirectV2:
+			lb, err := s.stream(id, orcLength)
+			if err != nil {
+				return err
+			}
+			if strs, err = orcStrings(data, lb, nonNull, v2); err != nil {
+				return err
+			}
+		case orcDictionary, orcDictionaryV2:
+			db, err := s.stream(id, orcDictionaryData)
+			if err != nil {
+				return err
+			}
+			lb, err := s.stream(id, orcLength)
+			if err != nil {
+				return err
+			}
+			dict, err := orcStrings(db, lb, dictSize, v2)
+			if err != nil {
+				return err
+			}
+			idx, err := orcInts(data, nonNull, false, v2)
+			if err != nil {
+				return err
+			}
+			strs = make([]string, nonNull)
+			for i, j := range idx {
+				if j < 0 || j >= int64(len(dict)) {
+					return fmt.Errorf("%w: dictionary index out of range", ErrInventoryCorrupt)
+				}
+				strs[i] = dict[j]
+			}
+		default:
+			return fmt.Errorf("%w: unknown string encoding %d", ErrInventoryFormatNotSupported, enc)
+		}
+		j := 0
+		for _, p := range present {
+			var v string
+			if p {
+				v = strs[j]
+				j++
+			}
+			col.strs = append(col.strs, v)
+		}
+
+	case orcByte, orcShort, orcInt, orcLong:
+		var ints []int64
+		if kind == orcByte {
+			b, err := orcBytes(data, nonNull)
+			if err != nil {
+				return err
+			}
+			for _, v := range b {
+				ints = append(ints, int64(int8(v)))
+			}
+		} else if ints, err = orcInts(data, nonNull, true, v2); err != nil {
+			return err
+		}
+		j := 0
+		for _, p := range present {
+			var v int64
+			if p {
+				v = ints[j]
+				j++
+			}
+			col.ints = append(col.ints, v)
+		}
+
+	case orcBoolean:
+		bools, err := orcBools(data, nonNull)
+		if err != nil {
+			return err
+		}
+		j := 0
+		for _, p := range present {
+			var v bool
+			if p {
+				v = bools[j]
+				j++
+			}
+			col.bools = append(col.bools, v)
+		}
+
+	case orcTimestamp, orcTimestampInstant:
+		secs, err := orcInts(data, nonNull, true, v2)
+		if err != nil {
+			return err
+		}
+		nb, err := s.stream(id, orcSecondary)
+		if err != nil {
+			return err
+		}
+		nanos, err := orcInts(nb, nonNull, false, v2)
+		if err != nil {
+			return err
+		}
+
+		// Timestamps are relative to the start of 2015 in the writer's
+		// time zone, unless they're instants
+		base := int64(orcTimestampEpoch)
+		if kind == orcTimestamp && s.tz != "" {
+			loc, err := time.LoadLocation(s.tz)
+			if err != nil {
+				return fmt.Errorf("unknown writer time zone %q: %w", s.tz, err)
+			}
+			base = time.Date(2015, 1, 1, 0, 0, 0, 0, loc).Unix()
+		}
+		j := 0
+		for _, p := range present {
+			var v time.Time
+			if p {
+				v = orcTime(base+secs[j], nanos[j])
+				j++
+			}
+			col.times = append(col.times, v)
+		}
+
+	default:
+		return fmt.Errorf("%w: unsupported column type %d", ErrInventoryFormatNotSupported, kind)
+	}
+	return nil
+}
+
+// orcTime converts the seconds and encoded nanoseconds of an ORC
+// timestamp to a time.
+func orcTime(sec, enc int64) time.Time {
+	// The lowest 3 bits give the number of trailing zeros removed, less one
+	nanos := enc >> 3
+	if z := enc & 7; z != 0 {
+		for i := int64(0); i <= z; i++ {
+			nanos *= 10
+		}
+	}
+
+	// Writers round negative times towards zero
+	if sec < 0 && nanos > 999999 {
+		sec--
+	}
+	return time.Unix(sec, nanos).UTC()
+}
+
+// orcDecompress decompresses an ORC stream, which is stored as a series
+// of chunks that are each compressed or stored as they are.
+func orcDecompress(codec uint64, b []byte) ([]byte, error) {
+	if codec == orcCompressionNone {
+		return b, nil
+	}
+
+	var res []byte
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("%w: truncated chunk header", ErrInventoryCorrupt)
+		}
+		h := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		l := h >> 1
+		if 3+l > len(b) {
+			return nil, fmt.Errorf("%w: truncated chunk", ErrInventoryCorrupt)
+		}
+		chunk := b[3 : 3+l]
+		b = b[3+l:]
+
+		// Chunks that didn't get smaller are stored as they are
+		if h&1 != 0 {
+			res = append(res, chunk...)
+			continue
+		}
+		var out []byte
+		var err error
+		switch codec {
+		case orcCompressionZlib:
+			out, err = ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(chunk)), maxInventoryBlockSize+1))
+		case orcCompressionSnappy:
+			var n int
+			if n, err = snappy.DecodedLen(chunk); err == nil && n <= maxInventoryBlockSize {
+				out, err = snappy.Decode(nil, chunk)
+			}
+		case orcCompressionZstd:
+			out, err = zstdDecodeFrame(chunk)
+		default:
+			return nil, fmt.Errorf("%w: ORC compression kind %d", ErrInventoryFormatNotSupported, codec)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInventoryCorrupt, err)
+		}
+		if len(out) > maxInventoryBlockSize {
+			return nil, fmt.Errorf("%w: chunk too large", ErrInventoryCorrupt)
+		}
+		res = append(res, out...)
+	}
+	return res, nil
+}
+
+// orcStrings splits the data of a string stream into n strings, with
+// their lengths read from the length stream.
+func orcStrings(data, lengths []byte, n int, v2 bool) ([]string, error) {
+	ls, err := orcInts(lengths, n, false, v2)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, n)
+	for i, l := range ls {
+		if l < 0 || l > int64(len(data)) {
+			return nil, fmt.Errorf("%w: string length out of range", ErrInventoryCorrupt)
+		}
+		strs[i] = string(data[:l])
+		data = data[l:]
+	}
+	return strs, nil
+}
+
+// orcBytes decodes n bytes of a byte run-length encoded stream.
+func orcBytes(b []byte, n int) ([]byte, error) {
+	var res []byte
+	for len(res) < n {
+		if len(b) == 0 {
+			return nil, fmt.Errorf("%w: truncated byte stream", ErrInventoryCorrupt)
+		}
+		h := int8(b[0])
+		b = b[1:]
+		if h >= 0 {
+			// A run of the same byte
+			if len(b) == 0 {
+				return nil, fmt.Errorf("%w: truncated byte stream", ErrInventoryCorrupt)
+			}
+			for i := 0; i < int(h)+3; i++ {
+				res = append(res, b[0])
+			}
+			b = b[1:]
+		} else {
+			// Literal bytes
+			l := -int(h)
+			if l > len(b) {
+				return nil, fmt.Errorf("%w: truncated byte stream", ErrInventoryCorrupt)
+			}
+			res = append(res, b[:l]...)
+			b = b[l:]
+		}
+	}
+	return res[:n], nil
+}
+
+// orcBools decodes n booleans of a boolean stream, which is byte
+// run-length encoded with 8 booleans to a byte, most significant first.
+func orcBools(b []byte, n int) ([]bool, error) {
+	bs, err := orcBytes(b, (n+7)/8)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, n)
+	for i := range res {
+		res[i] = bs[i/8]&(0x80>>(i%8)) != 0
+	}
+	return res, nil
+}
+
+// orcInts decodes n integers of an integer stream, using run-length
+// encoding version 1 or 2. Signed integers are zigzag encoded. They're
+// appended as they're decoded rather than allocated up front, as n may
+// come from a corrupt file.
+func orcInts(b []byte, n int, signed, v2 bool) ([]int64, error) {
+	d := &orcIntDecoder{b: b, signed: signed}
+	var res []int64
+	for len(res) < n {
+		if d.i >= len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		var err error
+		if v2 {
+			res, err = d.runV2(res)
+		} else {
+			res, err = d.runV1(res)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res[:n], nil
+}
+
+// orcIntDecoder decodes the runs of an integer stream.
+type orcIntDecoder struct {
+	b      []byte // Stream
+	i      int    // Offset of the next run
+	signed bool   // Are the integers signed?
+}
+
+// varint reads a base 128 varint, zigzag decoding it if the stream is
+// signed or zigzag is set.
+func (d *orcIntDecoder) varint(zigzag bool) (int64, error) {
+	var v uint64
+	for s := uint(0); ; s += 7 {
+		if d.i >= len(d.b) || s > 63 {
+			return 0, fmt.Errorf("%w: invalid varint", ErrInventoryCorrupt)
+		}
+		c := d.b[d.i]
+		d.i++
+		v |= uint64(c&0x7f) << s
+		if c < 0x80 {
+			break
+		}
+	}
+	if d.signed || zigzag {
+		return unzigzag(v), nil
+	}
+	return int64(v), nil
+}
+
+// runV1 decodes a run of version 1 run-length encoding.
+func (d *orcIntDecoder) runV1(res []int64) ([]int64, error) {
+	h := int8(d.b[d.i])
+	d.i++
+	if h < 0 {
+		// Literal values
+		for j := 0; j < -int(h); j++ {
+			v, err := d.varint(false)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	}
+
+	// A run with a fixed delta
+	if d.i >= len(d.b) {
+		return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+	}
+	delta := int64(int8(d.b[d.i]))
+	d.i++
+	base, err := d.varint(false)
+	if err != nil {
+		return nil, err
+	}
+	for j := int64(0); j < int64(h)+3; j++ {
+		res = append(res, base+j*delta)
+	}
+	return res, nil
+}
+
+// runV2 decodes a run of version 2 run-length encoding.
+func (d *orcIntDecoder) runV2(res []int64) ([]int64, error) {
+	h := d.b[d.i]
+	switch h >> 6 {
+	case 0: // Short repeat
+		w := int(h>>3&7) + 1
+		n := int(h&7) + 3
+		if d.i+1+w > len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		var u uint64
+		for _, c := range d.b[d.i+1 : d.i+1+w] {
+			u = u<<8 | uint64(c)
+		}
+		d.i += 1 + w
+		v := int64(u)
+		if d.signed {
+			v = unzigzag(u)
+		}
+		for j := 0; j < n; j++ {
+			res = append(res, v)
+		}
+		return res, nil
+
+	case 1: // Direct
+		if d.i+2 > len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		w := orcWidth(h >> 1 & 0x1f)
+		n := (int(h&1)<<8 | int(d.b[d.i+1])) + 1
+		d.i += 2
+		vs, err := d.packed(n, w)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range vs {
+			v := int64(u)
+			if d.signed {
+				v = unzigzag(u)
+			}
+			res = append(res, v)
+		}
+		return res, nil
+
+	case 2: // Patched base
+		if d.i+4 > len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		w := orcWidth(h >> 1 & 0x1f)
+		n := (int(h&1)<<8 | int(d.b[d.i+1])) + 1
+		bw := int(d.b[d.i+2]>>5) + 1
+		pw := orcWidth(d.b[d.i+2] & 0x1f)
+		pgw := int(d.b[d.i+3]>>5) + 1
+		pll := int(d.b[d.i+3] & 0x1f)
+		d.i += 4
+
+		// The base is big-endian, with its top bit as the sign
+		if d.i+bw > len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		var u uint64
+		for _, c := range d.b[d.i : d.i+bw] {
+			u = u<<8 | uint64(c)
+		}
+		d.i += bw
+		sign := uint64(1) << (bw*8 - 1)
+		base := int64(u &^ sign)
+		if u&sign != 0 {
+			base = -base
+		}
+
+		vs, err := d.packed(n, w)
+		if err != nil {
+			return nil, err
+		}
+		patches, err := d.packed(pll, orcClosestWidth(pw+pgw))
+		if err != nil {
+			return nil, err
+		}
+
+		// Each patch gives the gap since the last patched value and the
+		// high bits to add to the value. Gaps too large for the gap width
+		// are made up of patches with no bits.
+		pos := 0
+		for _, p := range patches {
+			gap := p >> uint(pw)
+			if gap >= uint64(len(vs)) {
+				return nil, fmt.Errorf("%w: patch out of range", ErrInventoryCorrupt)
+			}
+			pos += int(gap)
+			if bits := p & (1<<uint(pw) - 1); bits != 0 {
+				if pos >= len(vs) {
+					return nil, fmt.Errorf("%w: patch out of range", ErrInventoryCorrupt)
+				}
+				vs[pos] |= bits << uint(w)
+			}
+		}
+		for _, u := range vs {
+			res = append(res, base+int64(u))
+		}
+		return res, nil
+
+	default: // Delta
+		if d.i+2 > len(d.b) {
+			return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+		}
+		w := 0
+		if e := h >> 1 & 0x1f; e != 0 {
+			w = orcWidth(e)
+		}
+		n := (int(h&1)<<8 | int(d.b[d.i+1])) + 1
+		d.i += 2
+		base, err := d.varint(false)
+		if err != nil {
+			return nil, err
+		}
+		delta, err := d.varint(true)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, base)
+		if n == 1 {
+			return res, nil
+		}
+		v := base + delta
+		res = append(res, v)
+
+		// With no width, every delta is the same
+		if w == 0 {
+			for j := 2; j < n; j++ {
+				v += delta
+				res = append(res, v)
+			}
+			return res, nil
+		}
+
+		// Otherwise the rest of the deltas are stored without their signs,
+		// which are the same as the first delta's
+		ds, err := d.packed(n-2, w)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range ds {
+			if delta < 0 {
+				v -= int64(u)
+			} else {
+				v += int64(u)
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	}
+}
+
+// packed reads n big-endian bit-packed values of width w, starting at the
+// next byte.
+func (d *orcIntDecoder) packed(n, w int) ([]uint64, error) {
+	if d.i+(n*w+7)/8 > len(d.b) {
+		return nil, fmt.Errorf("%w: truncated integer stream", ErrInventoryCorrupt)
+	}
+	res := make([]uint64, n)
+	bit := d.i * 8
+	for j := range res {
+		var v uint64
+		for r := w; r > 0; {
+			off := bit % 8
+			take := 8 - off
+			if take > r {
+				take = r
+			}
+			c := uint64(d.b[bit/8]) >> uint(8-off-take) & (1<<uint(take) - 1)
+			v = v<<uint(take) | c
+			bit += take
+			r -= take
+		}
+		res[j] = v
+	}
+	d.i = (bit + 7) / 8
+	return res, nil
+}
+
+// orcWidth decodes the 5-bit encoded bit width of run-length encoding
+// version 2.
+func orcWidth(e byte) int {
+	if e < 24 {
+		return int(e) + 1
+	}
+	return [...]int{26, 28, 30, 32, 40, 48, 56, 64}[e-24]
+}
+
+// orcClosestWidth returns the smallest bit width that can be encoded that
+// is at least w.
+func orcClosestWidth(w int) int {
+	if w <= 24 {
+		if w == 0 {
+			return 1
+		}
+		return w
+	}
+	for _, c := range []int{26, 28, 30, 32, 40, 48, 56} {
+		if w <= c {
+			return c
+		}
+	}
+	return 64
+}
+
+// unzigzag decodes a zigzag encoded integer.
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// protoFields holds the fields of a protocol buffers message, by number.
+type protoFields map[int][]protoField
+
+// protoField is a single field of a protocol buffers message.
+type protoField struct {
+	v uint64 // Value of a varint or fixed-size field
+	b []byte // Value of a length-delimited field
+}
+
+// parseProto parses a protocol buffers message.
+func parseProto(b []byte) (protoFields, error) {
+	m := make(protoFields)
+	d := &orcIntDecoder{b: b}
+	for d.i < len(b) {
+		tag, err := d.varint(false)
+		if err != nil {
+			return nil, err
+		}
+		num := int(uint64(tag) >> 3)
+		var f protoField
+		switch tag & 7 {
+		case 0: // Varint
+			v, err := d.varint(false)
+			if err != nil {
+				return nil, err
+			}
+			f.v = uint64(v)
+		case 1: // 64-bit
+			if d.i+8 > len(b) {
+				return nil, fmt.Errorf("%w: truncated message", ErrInventoryCorrupt)
+			}
+			for j := 7; j >= 0; j-- {
+				f.v = f.v<<8 | uint64(b[d.i+j])
+			}
+			d.i += 8
+		case 2: // Length-delimited
+			l, err := d.varint(false)
+			if err != nil {
+				return nil, err
+			}
+			if l < 0 || l > int64(len(b)-d.i) {
+				return nil, fmt.Errorf("%w: truncated message", ErrInventoryCorrupt)
+			}
+			f.b = b[d.i : d.i+int(l)]
+			d.i += int(l)
+		case 5: // 32-bit
+			if d.i+4 > len(b) {
+				return nil, fmt.Errorf("%w: truncated message", ErrInventoryCorrupt)
+			}
+			for j := 3; j >= 0; j-- {
+				f.v = f.v<<8 | uint64(b[d.i+j])
+			}
+			d.i += 4
+		default:
+			return nil, fmt.Errorf("%w: unknown wire type %d", ErrInventoryCorrupt, tag&7)
+		}
+		m[num] = append(m[num], f)
+	}
+	return m, nil
+}
+
+// all returns every value of a field.
+func (m protoFields) all(num int) []protoField {
+	return m[num]
+}
+
+// uint returns the last value of a varint field, or 0 if it's missing.
+func (m protoFields) uint(num int) uint64 {
+	fs := m[num]
+	if len(fs) == 0 {
+		return 0
+	}
+	return fs[len(fs)-1].v
+}
+
+// bytes returns the last value of a length-delimited field, or nil if
+// it's missing.
+func (m protoFields) bytes(num int) []byte {
+	fs := m[num]
+	if len(fs) == 0 {
+		return nil
+	}
+	return fs[len(fs)-1].b
+}
+
+// uints returns the values of a repeated varint field, which may be
+// packed.
+func (m protoFields) uints(num int) []uint64 {
+	var res []uint64
+	for _, f := range m[num] {
+		if f.b == nil {
+			res = append(res, f.v)
+			continue
+		}
+		d := &orcIntDecoder{b: f.b}
+		for d.i < len(f.b) {
+			v, err := d.varint(false)
+			if err != nil {
+				break
+			}
+			res = append(res, uint64(v))
+		}
+	}
+	return res
+}
@@ -0,0 +1,778 @@
+// parquet.go reads the columns of Apache Parquet inventory data files
+
+package s3fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// Parquet file format, from https://github.com/apache/parquet-format
+const (
+	parquetMagic = "PAR1"
+
+	// Physical types
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetInt96     = 3
+	parquetByteArray = 6
+
+	// Repetition types
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	// Converted types
+	parquetTimestampMillis = 9
+	parquetTimestampMicros = 10
+
+	// Compression codecs
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+	parquetZstd         = 6
+
+	// Page types
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+
+	// Encodings
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+	parquetRLEDictionary   = 8
+
+	parquetJulianEpoch = 2440588 // Julian day of 1970-01-01, for INT96 timestamps
+)
+
+// parquetColumn describes a leaf column of a Parquet schema.
+type parquetColumn struct {
+	index    int           // Index of the column in each row group
+	physical int64         // Physical type
+	unit     time.Duration // Unit of a timestamp column (0 if it isn't one)
+	maxDef   int           // Maximum definition level
+}
+
+// readParquet reads the named top-level columns of a Parquet file,
+// returning the number of rows and the columns that were found. Only
+// columns that aren't nested or repeated can be read.
+func readParquet(data []byte, names []string) (int, map[string]*inventoryColumn, error) {
+	// Read the file metadata, stored before the footer
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		return 0, nil, fmt.Errorf("%w: missing magic number", ErrInventoryCorrupt)
+	}
+	metaLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if metaLen > len(data)-12 {
+		return 0, nil, fmt.Errorf("%w: invalid metadata length", ErrInventoryCorrupt)
+	}
+	meta, _, err := parseThrift(data[len(data)-8-metaLen : len(data)-8])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Find the columns in the schema. The first element is the root, and
+	// leaf columns are numbered in depth-first order.
+	schema := meta.list(2)
+	if len(schema) == 0 {
+		return 0, nil, fmt.Errorf("%w: empty schema", ErrInventoryCorrupt)
+	}
+	byName := make(map[string]parquetColumn)
+	leaf := 0
+	for i := 1; i < len(schema); i++ {
+		el, _ := schema[i].(thriftStruct)
+		if n := el.int(5); n > 0 {
+			// Nested groups can't be read, but their leaves are counted
+			i, leaf = skipParquetGroup(schema, i, leaf)
+			continue
+		}
+		col := parquetColumn{index: leaf, physical: el.int(1)}
+		switch el.int(3) {
+		case parquetOptional:
+			col.maxDef = 1
+		case parquetRepeated:
+			col.maxDef = -1
+		}
+		switch el.int(6) {
+		case parquetTimestampMillis:
+			col.unit = time.Millisecond
+		case parquetTimestampMicros:
+			col.unit = time.Microsecond
+		}
+		if ts := el.strct(10).strct(8); ts != nil {
+			switch {
+			case ts.strct(2).has(1):
+				col.unit = time.Millisecond
+			case ts.strct(2).has(2):
+				col.unit = time.Microsecond
+			case ts.strct(2).has(3):
+				col.unit = time.Nanosecond
+			}
+		}
+		if col.physical == parquetInt96 {
+			col.unit = time.Nanosecond
+		}
+		byName[el.str(4)] = col
+		leaf++
+	}
+
+	cols := make(map[string]*inventoryColumn)
+	for _, name := range names {
+		if c, ok := byName[name]; ok {
+			if c.maxDef < 0 {
+				return 0, nil, fmt.Errorf("%w: repeated column %q", ErrInventoryFormatNotSupported, name)
+			}
+			cols[name] = &inventoryColumn{}
+		}
+	}
+
+	// Read each row group
+	rows := 0
+	maxRows := len(data) * maxInventoryRowsPerByte
+	for _, g := range meta.list(4) {
+		rg, _ := g.(thriftStruct)
+		n := rg.int(3)
+		if n < 0 || n > int64(maxRows-rows) {
+			return 0, nil, fmt.Errorf("%w: invalid row count", ErrInventoryCorrupt)
+		}
+		chunks := rg.list(1)
+		for name, col := range cols {
+			c := byName[name]
+			if c.index >= len(chunks) {
+				return 0, nil, fmt.Errorf("%w: missing column chunk", ErrInventoryCorrupt)
+			}
+			cc, _ := chunks[c.index].(thriftStruct)
+			if err := readParquetChunk(data, cc.strct(3), n, c, col); err != nil {
+				return 0, nil, fmt.Errorf("column %q: %w", name, err)
+			}
+		}
+		rows += int(n)
+	}
+	return rows, cols, nil
+}
+
+// skipParquetGroup skips the group at index i of a schema, returning the
+// index of its last element and the number of the next leaf column.
+func skipParquetGroup(schema []interface{}, i, leaf int) (int, int) {
+	el, _ := schema[i].(thriftStruct)
+	for n := el.int(5); n > 0; n-- {
+		i++
+		if i >= len(schema) {
+			break
+		}
+		child, _ := schema[i].(thriftStruct)
+		if child.int(5) > 0 {
+			i, leaf = skipParquetGroup(schema, i, leaf)
+		} else {
+			leaf++
+		}
+	}
+	return i, leaf
+}
+
+// readParquetChunk appends the values of a column chunk of a row group with
+// the given number of rows, described by the given column metadata, to col.
+// Counts given by the file are checked before anything is allocated for
+// them.
+func readParquetChunk(data []byte, md thriftStruct, rows int64, c parquetColumn, col *inventoryColumn) error {
+	codec := md.int(4)
+	total := md.int(5)
+	if total < 0 || total > rows {
+		return fmt.Errorf("%w: invalid value count", ErrInventoryCorrupt)
+	}
+	start := md.int(9)
+	if dict := md.int(11); dict > 0 && dict < start {
+		start = dict
+	}
+	end := start + md.int(7)
+	if start < 0 || end > int64(len(data)) || end < start {
+		return fmt.Errorf("%w: column chunk out of range", ErrInventoryCorrupt)
+	}
+	b := data[start:end]
+
+	var dict []interface{}
+	for read := int64(0); read < total; {
+		h, n, err := parseThrift(b)
+		if err != nil {
+			return err
+		}
+		size, usize := h.int(3), h.int(2)
+		if size < 0 || size > int64(len(b)-n) {
+			return fmt.Errorf("%w: page out of range", ErrInventoryCorrupt)
+		}
+		if usize < 0 || usize > maxInventoryBlockSize {
+			return fmt.Errorf("%w: invalid page size", ErrInventoryCorrupt)
+		}
+		page := b[n : n+int(size)]
+		b = b[n+int(size):]
+
+		switch h.int(1) {
+		case parquetDictionaryPage:
+			raw, err := parquetDecompress(codec, page, int(usize))
+			if err != nil {
+				return err
+			}
+			// Each value of the dictionary is used at least once
+			n, err := parquetPageValues(h.strct(7).int(1), total)
+			if err != nil {
+				return err
+			}
+			if dict, _, err = parquetValues(raw, c.physical, int64(n)); err != nil {
+				return err
+			}
+
+		case parquetDataPage:
+			raw, err := parquetDecompress(codec, page, int(usize))
+			if err != nil {
+				return err
+			}
+			dh := h.strct(5)
+			n, err := parquetPageValues(dh.int(1), total-read)
+			if err != nil {
+				return err
+			}
+
+			// Definition levels are prefixed with their length
+			var defs []int
+			if c.maxDef > 0 {
+				if len(raw) < 4 {
+					return fmt.Errorf("%w: truncated page", ErrInventoryCorrupt)
+				}
+				l := int(binary.LittleEndian.Uint32(raw))
+				if 4+l > len(raw) {
+					return fmt.Errorf("%w: truncated page", ErrInventoryCorrupt)
+				}
+				if defs, err = parquetHybrid(raw[4:4+l], 1, n); err != nil {
+					return err
+				}
+				raw = raw[4+l:]
+			}
+			if err := appendParquetValues(col, c, dh.int(2), raw, defs, n, dict); err != nil {
+				return err
+			}
+			read += int64(n)
+
+		case parquetDataPageV2:
+			dh := h.strct(8)
+			n, err := parquetPageValues(dh.int(1), total-read)
+			if err != nil {
+				return err
+			}
+			repLen, defLen := dh.int(6), dh.int(5)
+			if repLen < 0 || defLen < 0 || repLen > int64(len(page)) || defLen > int64(len(page))-repLen || repLen+defLen > usize {
+				return fmt.Errorf("%w: truncated page", ErrInventoryCorrupt)
+			}
+
+			// Levels are stored uncompressed, before the values
+			var defs []int
+			if c.maxDef > 0 {
+				if defs, err = parquetHybrid(page[repLen:repLen+defLen], 1, n); err != nil {
+					return err
+				}
+			}
+			raw := page[repLen+defLen:]
+			if !dh.has(7) || dh.bool(7) {
+				if raw, err = parquetDecompress(codec, raw, int(usize-repLen-defLen)); err != nil {
+					return err
+				}
+			}
+			if err := appendParquetValues(col, c, dh.int(4), raw, defs, n, dict); err != nil {
+				return err
+			}
+			read += int64(n)
+		}
+	}
+	return nil
+}
+
+// parquetPageValues checks the number of values a page claims to have
+// against the most it can have.
+func parquetPageValues(n, left int64) (int, error) {
+	if n < 0 || n > left {
+		return 0, fmt.Errorf("%w: invalid page value count", ErrInventoryCorrupt)
+	}
+	return int(n), nil
+}
+
+// appendParquetValues decodes the values of a data page with n rows and
+// appends them to col. Rows whose definition level is 0 are null.
+func appendParquetValues(col *inventoryColumn, c parquetColumn, enc int64, b []byte, defs []int, n int, dict []interface{}) error {
+	nonNull := n
+	if defs != nil {
+		nonNull = 0
+		for _, d := range defs {
+			if d == c.maxDef {
+				nonNull++
+			}
+		}
+	}
+
+	var vals []interface{}
+	var err error
+	switch enc {
+	case parquetPlain:
+		vals, _, err = parquetValues(b, c.physical, int64(nonNull))
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(b) == 0 {
+			return fmt.Errorf("%w: truncated page", ErrInventoryCorrupt)
+		}
+		idx, err := parquetHybrid(b[1:], int(b[0]), nonNull)
+		if err != nil {
+			return err
+		}
+		vals = make([]interface{}, nonNull)
+		for i, j := range idx {
+			if j >= len(dict) {
+				return fmt.Errorf("%w: dictionary index out of range", ErrInventoryCorrupt)
+			}
+			vals[i] = dict[j]
+		}
+	case parquetRLE:
+		// Booleans, prefixed with their length
+		if c.physical != parquetBoolean || len(b) < 4 {
+			return fmt.Errorf("%w: RLE encoded values", ErrInventoryFormatNotSupported)
+		}
+		bs, err := parquetHybrid(b[4:], 1, nonNull)
+		if err != nil {
+			return err
+		}
+		for _, v := range bs {
+			vals = append(vals, v != 0)
+		}
+	default:
+		return fmt.Errorf("%w: Parquet encoding %d", ErrInventoryFormatNotSupported, enc)
+	}
+	if err != nil {
+		return err
+	}
+
+	j := 0
+	for i := 0; i < n; i++ {
+		var v interface{}
+		if defs == nil || defs[i] == c.maxDef {
+			v = vals[j]
+			j++
+		}
+		if err := col.appendParquet(v, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendParquet appends a value decoded from a Parquet file, or nil for a
+// null value.
+func (col *inventoryColumn) appendParquet(v interface{}, c parquetColumn) error {
+	switch {
+	case c.unit != 0:
+		var t time.Time
+		switch v := v.(type) {
+		case int64:
+			t = time.Unix(0, v*int64(c.unit)).UTC()
+		case []byte:
+			// INT96: nanoseconds in the day, then the Julian day
+			if len(v) != 12 {
+				return fmt.Errorf("%w: invalid timestamp", ErrInventoryCorrupt)
+			}
+			nanos := int64(binary.LittleEndian.Uint64(v))
+			day := int64(binary.LittleEndian.Uint32(v[8:]))
+			t = time.Unix((day-parquetJulianEpoch)*86400, nanos).UTC()
+		}
+		col.times = append(col.times, t)
+	case c.physical == parquetByteArray:
+		s, _ := v.([]byte)
+		col.strs = append(col.strs, string(s))
+	case c.physical == parquetInt32 || c.physical == parquetInt64:
+		i, _ := v.(int64)
+		col.ints = append(col.ints, i)
+	case c.physical == parquetBoolean:
+		b, _ := v.(bool)
+		col.bools = append(col.bools, b)
+	default:
+		return fmt.Errorf("%w: Parquet type %d", ErrInventoryFormatNotSupported, c.physical)
+	}
+	return nil
+}
+
+// parquetValues decodes n plain encoded values of the given physical type,
+// returning them and the number of bytes read. Integers are returned as
+// int64s, and byte arrays and INT96s as []byte.
+func parquetValues(b []byte, physical int64, n int64) ([]interface{}, int, error) {
+	// Check the values fit before allocating them. Byte arrays take at
+	// least their 4 byte length.
+	var bits int64
+	switch physical {
+	case parquetBoolean:
+		bits = 1
+	case parquetInt32, parquetByteArray:
+		bits = 32
+	case parquetInt64:
+		bits = 64
+	case parquetInt96:
+		bits = 96
+	default:
+		return nil, 0, fmt.Errorf("%w: Parquet type %d", ErrInventoryFormatNotSupported, physical)
+	}
+	short := fmt.Errorf("%w: truncated values", ErrInventoryCorrupt)
+	if n < 0 || n > int64(len(b))*8/bits {
+		return nil, 0, short
+	}
+
+	vals := make([]interface{}, n)
+	i := 0
+	for j := range vals {
+		switch physical {
+		case parquetBoolean:
+			if j/8 >= len(b) {
+				return nil, 0, short
+			}
+			vals[j] = b[j/8]&(1<<uint(j%8)) != 0
+			i = (j + 8) / 8
+		case parquetInt32:
+			if i+4 > len(b) {
+				return nil, 0, short
+			}
+			vals[j] = int64(int32(binary.LittleEndian.Uint32(b[i:])))
+			i += 4
+		case parquetInt64:
+			if i+8 > len(b) {
+				return nil, 0, short
+			}
+			vals[j] = int64(binary.LittleEndian.Uint64(b[i:]))
+			i += 8
+		case parquetInt96:
+			if i+12 > len(b) {
+				return nil, 0, short
+			}
+			vals[j] = b[i : i+12]
+			i += 12
+		case parquetByteArray:
+			if i+4 > len(b) {
+				return nil, 0, short
+			}
+			l := int(binary.LittleEndian.Uint32(b[i:]))
+			i += 4
+			if l < 0 || i+l > len(b) {
+				return nil, 0, short
+			}
+			vals[j] = b[i : i+l]
+			i += l
+		}
+	}
+	return vals, i, nil
+}
+
+// parquetHybrid decodes n values of width w bits, stored with Parquet's
+// hybrid of run-length encoding and bit-packing. Runs may not go past the
+// n values, other than to pad bit-packed values to a group of 8.
+func parquetHybrid(b []byte, w, n int) ([]int, error) {
+	if w > 32 {
+		return nil, fmt.Errorf("%w: invalid bit width %d", ErrInventoryCorrupt, w)
+	}
+	var res []int
+	short := fmt.Errorf("%w: truncated run", ErrInventoryCorrupt)
+	long := fmt.Errorf("%w: run too long", ErrInventoryCorrupt)
+	for len(res) < n {
+		h, l := binary.Uvarint(b)
+		if l <= 0 {
+			return nil, short
+		}
+		b = b[l:]
+
+		if h&1 == 0 {
+			// A run of the same value, in as few bytes as it fits
+			if h>>1 > uint64(n-len(res)) {
+				return nil, long
+			}
+			vb := (w + 7) / 8
+			if vb > len(b) {
+				return nil, short
+			}
+			v := 0
+			for k := vb - 1; k >= 0; k-- {
+				v = v<<8 | int(b[k])
+			}
+			b = b[vb:]
+			for k := uint64(0); k < h>>1; k++ {
+				res = append(res, v)
+			}
+			continue
+		}
+
+		// Groups of 8 values packed least significant bit first
+		if h>>1 > uint64(n-len(res)+7)/8 {
+			return nil, long
+		}
+		count := int(h>>1) * 8
+		if (count*w+7)/8 > len(b) {
+			return nil, short
+		}
+		for k := 0; k < count; k++ {
+			v := 0
+			for bit := 0; bit < w; bit++ {
+				p := k*w + bit
+				if b[p/8]&(1<<uint(p%8)) != 0 {
+					v |= 1 << uint(bit)
+				}
+			}
+			res = append(res, v)
+		}
+		b = b[(count*w+7)/8:]
+	}
+	return res[:n], nil
+}
+
+// parquetDecompress decompresses a page with the given codec, checking it
+// has the size given in its header, which is at most maxInventoryBlockSize.
+func parquetDecompress(codec int64, b []byte, size int) ([]byte, error) {
+	var res []byte
+	var err error
+	switch codec {
+	case parquetUncompressed:
+		res = b
+	case parquetSnappy:
+		var n int
+		if n, err = snappy.DecodedLen(b); err == nil && n == size {
+			res, err = snappy.Decode(nil, b)
+		}
+	case parquetGzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(b)); err == nil {
+			res, err = ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+		}
+	case parquetZstd:
+		res, err = zstdDecodeFrame(b)
+	default:
+		return nil, fmt.Errorf("%w: Parquet codec %d", ErrInventoryFormatNotSupported, codec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInventoryCorrupt, err)
+	}
+	if len(res) != size {
+		return nil, fmt.Errorf("%w: page doesn't match its size", ErrInventoryCorrupt)
+	}
+	return res, nil
+}
+
+// thriftStruct holds the fields of a struct read with Thrift's compact
+// protocol, by ID. Integers are read as int64s, binary fields as []byte,
+// lists and sets as []interface{} and structs as thriftStructs. Maps are
+// skipped.
+type thriftStruct map[int16]interface{}
+
+// Thrift compact protocol types
+const (
+	thriftTrue       = 1
+	thriftFalse      = 2
+	thriftByte       = 3
+	thriftI16        = 4
+	thriftI32        = 5
+	thriftI64        = 6
+	thriftDouble     = 7
+	thriftBinary     = 8
+	thriftList       = 9
+	thriftSet        = 10
+	thriftMap        = 11
+	thriftStructType = 12
+)
+
+// parseThrift reads a struct with Thrift's compact protocol, returning it
+// and the number of bytes read.
+func parseThrift(b []byte) (thriftStruct, int, error) {
+	r := &thriftReader{b: b}
+	s, err := r.readStruct(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s, r.i, nil
+}
+
+// thriftReader reads values with Thrift's compact protocol.
+type thriftReader struct {
+	b []byte
+	i int
+}
+
+// maxThriftDepth limits the nesting of structs and lists.
+const maxThriftDepth = 32
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.i >= len(r.b) {
+		return 0, fmt.Errorf("%w: truncated metadata", ErrInventoryCorrupt)
+	}
+	c := r.b[r.i]
+	r.i++
+	return c, nil
+}
+
+func (r *thriftReader) uvarint() (uint64, error) {
+	v, l := binary.Uvarint(r.b[r.i:])
+	if l <= 0 {
+		return 0, fmt.Errorf("%w: invalid varint", ErrInventoryCorrupt)
+	}
+	r.i += l
+	return v, nil
+}
+
+func (r *thriftReader) readStruct(depth int) (thriftStruct, error) {
+	if depth > maxThriftDepth {
+		return nil, fmt.Errorf("%w: metadata nested too deeply", ErrInventoryCorrupt)
+	}
+	s := make(thriftStruct)
+	var id int16
+	for {
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if h == 0 {
+			return s, nil
+		}
+
+		// The ID is either a delta from the last one or follows the header
+		if d := h >> 4; d != 0 {
+			id += int16(d)
+		} else {
+			u, err := r.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(unzigzag(u))
+		}
+
+		var v interface{}
+		switch t := h & 0x0f; t {
+		case thriftTrue:
+			v = true
+		case thriftFalse:
+			v = false
+		default:
+			if v, err = r.readValue(t, depth); err != nil {
+				return nil, err
+			}
+		}
+		s[id] = v
+	}
+}
+
+func (r *thriftReader) readValue(t byte, depth int) (interface{}, error) {
+	switch t {
+	case thriftTrue, thriftFalse:
+		// Booleans in lists are stored as bytes
+		c, err := r.byte()
+		return c == thriftTrue, err
+	case thriftByte:
+		c, err := r.byte()
+		return int64(int8(c)), err
+	case thriftI16, thriftI32, thriftI64:
+		u, err := r.uvarint()
+		return unzigzag(u), err
+	case thriftDouble:
+		if r.i+8 > len(r.b) {
+			return nil, fmt.Errorf("%w: truncated metadata", ErrInventoryCorrupt)
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.i:]))
+		r.i += 8
+		return v, nil
+	case thriftBinary:
+		l, err := r.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(len(r.b)-r.i) {
+			return nil, fmt.Errorf("%w: truncated metadata", ErrInventoryCorrupt)
+		}
+		v := r.b[r.i : r.i+int(l)]
+		r.i += int(l)
+		return v, nil
+	case thriftList, thriftSet:
+		h, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		n := uint64(h >> 4)
+		if n == 15 {
+			if n, err = r.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Every element takes at least a byte, so a longer list can't fit
+		// in what's left
+		if n > uint64(len(r.b)-r.i) {
+			return nil, fmt.Errorf("%w: truncated metadata", ErrInventoryCorrupt)
+		}
+		vs := make([]interface{}, n)
+		for j := range vs {
+			if vs[j], err = r.readValue(h&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return vs, nil
+	case thriftMap:
+		n, err := r.uvarint()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		kv, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < n; j++ {
+			if _, err := r.readValue(kv>>4, depth+1); err != nil {
+				return nil, err
+			}
+			if _, err := r.readValue(kv&0x0f, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStructType:
+		return r.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("%w: unknown metadata type %d", ErrInventoryCorrupt, t)
+}
+
+// has reports whether a field is set.
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// int returns an integer field, or 0 if it's missing.
+func (s thriftStruct) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+// bool returns a boolean field, or false if it's missing.
+func (s thriftStruct) bool(id int16) bool {
+	v, _ := s[id].(bool)
+	return v
+}
+
+// str returns a binary field as a string, or "" if it's missing.
+func (s thriftStruct) str(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+// list returns a list field, or nil if it's missing.
+func (s thriftStruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+// strct returns a struct field, or nil if it's missing.
+func (s thriftStruct) strct(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
@@ -0,0 +1,134 @@
+// path.go resolves filesystem paths into S3 object keys
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	ErrPathEscapesRoot = errors.New("path escapes filesystem root")
+	ErrNotDirectory    = errors.New("not a directory")
+	ErrIsDirectory     = errors.New("is a directory")
+)
+
+// EscapeError records a path that resolved outside of the filesystem's
+// root, e.g. by way of "../" segments.
+//
+// EscapeError unwraps to ErrPathEscapesRoot.
+type EscapeError struct {
+	Path string // The path as supplied by the caller
+	Root string // The root of the filesystem it was resolved against
+}
+
+func (e *EscapeError) Error() string {
+	return fmt.Sprintf("%s: %q (root %q)", ErrPathEscapesRoot, e.Path, e.Root)
+}
+
+func (e *EscapeError) Unwrap() error {
+	return ErrPathEscapesRoot
+}
+
+// clean normalises a path relative to the filesystem's root, splitting it
+// on the separator and resolving "." and ".." segments. Leading separators
+// are ignored, so absolute paths are treated as relative to the root.
+//
+// If the path would resolve above the root, an *EscapeError is returned.
+func (fs3 *S3FS) clean(name string) (string, error) {
+	var segs []string
+	for _, s := range strings.Split(name, fs3.separator) {
+		switch s {
+		case "", ".":
+			continue
+		case "..":
+			if len(segs) == 0 {
+				return "", &EscapeError{Path: name, Root: fs3.root}
+			}
+			segs = segs[:len(segs)-1]
+		default:
+			segs = append(segs, s)
+		}
+	}
+	return strings.Join(segs, fs3.separator), nil
+}
+
+// resolve converts a path relative to the filesystem's root into an S3
+// object key. All entry points that accept a path should go through
+// resolve so that paths can't escape the root.
+func (fs3 *S3FS) resolve(name string) (string, error) {
+	rel, err := fs3.clean(name)
+	if err != nil {
+		return "", err
+	}
+	return fs3.rootKey(rel), nil
+}
+
+// rootKey joins an already-cleaned relative path onto the root, encrypting
+// it if filenames are encrypted.
+func (fs3 *S3FS) rootKey(rel string) string {
+	if fs3.names != nil && rel != "" {
+		rel = fs3.names.encryptPath(rel, fs3.separator)
+	}
+	switch {
+	case fs3.root == "":
+		return rel
+	case rel == "":
+		return fs3.root
+	default:
+		return fs3.root + fs3.separator + rel
+	}
+}
+
+// dirPrefix returns the listing prefix for the directory with the given
+// key. The bucket root has an empty prefix.
+func (fs3 *S3FS) dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + fs3.separator
+}
+
+// isDir reports whether any objects (including a directory marker) exist
+// under the directory with the given key. The bucket root is always a
+// directory.
+func (fs3 *S3FS) isDir(ctx context.Context, key string) (bool, error) {
+	if key == "" {
+		return true, nil
+	}
+
+	p := fs3.dirPrefix(key)
+	res, err := fs3.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  &fs3.bucket,
+		Prefix:  &p,
+		MaxKeys: 1,
+	}, fs3.optFns...)
+	if err != nil {
+		return false, err
+	}
+	if res.KeyCount > 0 {
+		return true, nil
+	}
+
+	// Check for a "_$folder$" style directory marker
+	if fs3.dirMarker == DirMarkerFolderSuffix {
+		in := &s3.HeadObjectInput{
+			Bucket: &fs3.bucket,
+			Key:    aws.String(key + FolderSuffix),
+		}
+		fs3.enc.applyHead(in)
+		_, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+		if err == nil {
+			return true, nil
+		}
+		if !isNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
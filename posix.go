@@ -0,0 +1,224 @@
+// posix.go stores POSIX file attributes in object metadata
+
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Metadata keys of the POSIX attributes, as used by s3fs-fuse, rclone and
+// goofys.
+const (
+	metaMode  = "mode"  // st_mode, in decimal
+	metaUID   = "uid"   // Owner's user ID
+	metaGID   = "gid"   // Owner's group ID
+	metaMtime = "mtime" // Modification time, in seconds since the epoch
+	metaAtime = "atime" // Access time, in seconds since the epoch
+)
+
+// File type and mode bits of st_mode.
+const (
+	sIFREG = 0100000 // Regular file
+	sISUID = 04000   // Set user ID
+	sISGID = 02000   // Set group ID
+	sISVTX = 01000   // Sticky
+)
+
+// posixMode converts a FileMode to the st_mode of a regular file.
+func posixMode(m os.FileMode) uint64 {
+	mode := uint64(sIFREG | m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= sISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= sISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= sISVTX
+	}
+	return mode
+}
+
+// fileMode converts an st_mode to a FileMode. The file type is ignored,
+// since objects are always described as regular files.
+func fileMode(mode uint64) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&sISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&sISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&sISVTX != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// formatMtime formats a modification time as seconds since the epoch,
+// with nanoseconds if it has any.
+func formatMtime(t time.Time) string {
+	if t.Nanosecond() == 0 {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
+}
+
+// parseMtime parses a modification time in seconds since the epoch, with
+// an optional fractional part.
+func parseMtime(s string) (time.Time, bool) {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// parseID parses a user or group ID, returning -1 if it's missing or
+// invalid.
+func parseID(s string) int {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return -1
+	}
+	return int(id)
+}
+
+// posixMetadata returns the POSIX attributes of a file being uploaded, if
+// the filesystem stores them. Its mode and owner are kept from meta if
+// they're set there (e.g. for an existing file opened for reading and
+// writing), and otherwise come from the permissions it was opened with
+// and the current process. Its modification time is the current time.
+//
+// If the filesystem doesn't store the attributes, only a modification
+// time that's already in meta is updated.
+func (fs3 *S3FS) posixMetadata(meta map[string]string) map[string]string {
+	if !fs3.posix {
+		if _, ok := meta[metaMtime]; ok {
+			return map[string]string{metaMtime: formatMtime(time.Now())}
+		}
+		return nil
+	}
+	attrs := map[string]string{
+		metaMode:  strconv.FormatUint(posixMode(fs3.file.perm), 10),
+		metaMtime: formatMtime(time.Now()),
+	}
+	if uid := os.Getuid(); uid >= 0 {
+		attrs[metaUID] = strconv.Itoa(uid)
+	}
+	if gid := os.Getgid(); gid >= 0 {
+		attrs[metaGID] = strconv.Itoa(gid)
+	}
+	for _, k := range []string{metaMode, metaUID, metaGID} {
+		if v, ok := meta[k]; ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+// headFileInfo describes a file from a HeadObject response, using its
+// POSIX attributes if it has any.
+func headFileInfo(name, key string, res *s3.HeadObjectOutput) s3FileInfo {
+	fi := s3FileInfo{
+		name:    name,
+		size:    logicalSize(res.Metadata, res.ContentLength),
+		mode:    0666,
+		modTime: aws.ToTime(res.LastModified),
+		obj:     headObjectInfo(key, res),
+	}
+	return fi.withPOSIX(res.Metadata)
+}
+
+// withPOSIX returns the FileInfo with the mode and modification time
+// stored in the object's metadata, if there are any.
+func (fi s3FileInfo) withPOSIX(meta map[string]string) s3FileInfo {
+	if mode, err := strconv.ParseUint(meta[metaMode], 0, 32); err == nil {
+		fi.mode = fileMode(mode)
+	}
+	if mtime, ok := parseMtime(meta[metaMtime]); ok {
+		fi.modTime = mtime
+	}
+	return fi
+}
+
+// describeFiles replaces the entries for files listed under the prefix p
+// with descriptions from HeadObject requests, made concurrently. Files
+// deleted since they were listed keep their listed entries.
+func (fs3 *S3FS) describeFiles(ctx context.Context, p string, files []os.FileInfo) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, fs3.concurrency)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i, fi := range files {
+		listed, ok := fi.(s3FileInfo)
+		if !ok || listed.obj == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			break // A request failed
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, listed s3FileInfo) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			key := p + listed.name
+			in := &s3.HeadObjectInput{
+				Bucket:       &fs3.bucket,
+				Key:          &key,
+				ChecksumMode: types.ChecksumModeEnabled,
+			}
+			fs3.enc.applyHead(in)
+			res, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+			if isNotFound(err) {
+				return
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("unable to perform HeadObject operation: %w", err)
+					cancel()
+				})
+				return
+			}
+
+			// Listings include the owner, but HeadObject doesn't
+			fi := headFileInfo(listed.name, key, res)
+			fi.obj.Owner = listed.obj.Owner
+			files[i] = fi
+		}(i, listed)
+	}
+	wg.Wait()
+	return firstErr
+}
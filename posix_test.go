@@ -0,0 +1,226 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// writeMode writes a file opened with the given flag and permissions.
+func writeMode(t *testing.T, fs3 *s3fs.S3FS, name string, flag int, perm os.FileMode, data []byte) {
+	t.Helper()
+
+	f, err := fs3.OpenFile(name, flag, perm)
+	if err != nil {
+		t.Fatalf("OpenFile(%q): %s", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+}
+
+func TestPOSIXMetadata(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithPOSIXMetadata(true))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	for name, tc := range map[string]struct {
+		flag int
+		perm os.FileMode
+		data []byte
+	}{
+		"single":    {s3fs.O_WRONLY, 0640, []byte("data")},
+		"multipart": {s3fs.O_WRMULTIPART, 0600, testData(int(s3fs.MinPartSize) + 10)},
+		"rw":        {s3fs.O_RDWR | s3fs.O_CREATE, 0750 | os.ModeSetgid, []byte("data")},
+	} {
+		writeMode(t, fs3, name, tc.flag, tc.perm, tc.data)
+
+		fi, err := fs3.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%q): %s", name, err)
+		}
+		if fi.Mode() != tc.perm {
+			t.Errorf("%s: expected mode %v, got %v", name, tc.perm, fi.Mode())
+		}
+		if fi.ModTime().Before(start) || fi.ModTime().After(time.Now()) {
+			t.Errorf("%s: unexpected modification time %v", name, fi.ModTime())
+		}
+		obj := fi.Sys().(*s3fs.ObjectInfo)
+		if obj.UID != os.Getuid() || obj.GID != os.Getgid() {
+			t.Errorf("%s: expected owner %d:%d, got %d:%d", name, os.Getuid(), os.Getgid(), obj.UID, obj.GID)
+		}
+	}
+
+	// The attributes are stored as s3fs-fuse stores them
+	res := headObject(t, client, "single")
+	if got, expected := res.Metadata["mode"], strconv.Itoa(0100640); got != expected {
+		t.Errorf("expected x-amz-meta-mode %q, got %q", expected, got)
+	}
+	if got, expected := res.Metadata["uid"], strconv.Itoa(os.Getuid()); got != expected {
+		t.Errorf("expected x-amz-meta-uid %q, got %q", expected, got)
+	}
+
+	// Existing files opened for reading and writing keep their mode
+	writeMode(t, fs3, "rw", s3fs.O_RDWR|s3fs.O_APPEND, 0666, []byte("more"))
+	fi, err := fs3.Stat("rw")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode() != 0750|os.ModeSetgid {
+		t.Errorf("expected the mode to be kept, got %v", fi.Mode())
+	}
+
+	// The attributes aren't extended attributes, and can't be replaced as
+	// if they were
+	names, err := fs3.ListXattr("single")
+	if err != nil {
+		t.Fatalf("ListXattr: %s", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected no extended attributes, got %v", names)
+	}
+	for _, name := range []string{"mode", "uid", "gid", "mtime", "atime"} {
+		if err := fs3.SetXattr("single", name, "0"); !errors.Is(err, s3fs.ErrInvalidXattr) {
+			t.Errorf("SetXattr(%q): expected ErrInvalidXattr, got %v", name, err)
+		}
+	}
+	_, err = fs3.OpenFileWithOptions("other", s3fs.O_WRONLY, s3fs.WithFileMetadata(map[string]string{"mtime": "0"}))
+	if !errors.Is(err, s3fs.ErrInvalidOption) {
+		t.Errorf("WithFileMetadata: expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestPOSIXMetadataRead(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Objects written by s3fs-fuse and rclone
+	for key, meta := range map[string]map[string]string{
+		"fuse":   {"mode": strconv.Itoa(0100755), "uid": "1000", "gid": "100", "mtime": "1600000000"},
+		"rclone": {"mtime": "1600000000.5"},
+		"octal":  {"mode": "0100600"},
+	} {
+		if _, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket:   aws.String(testBucket),
+			Key:      aws.String(key),
+			Body:     bytes.NewReader([]byte("data")),
+			Metadata: meta,
+		}); err != nil {
+			t.Fatalf("PutObject(%q): %s", key, err)
+		}
+	}
+
+	for key, expected := range map[string]struct {
+		mode  os.FileMode
+		mtime time.Time
+		uid   int
+	}{
+		"fuse":   {0755, time.Unix(1600000000, 0), 1000},
+		"rclone": {0666, time.Unix(1600000000, 5e8), -1},
+		"octal":  {0600, time.Time{}, -1},
+	} {
+		fi, err := fs3.Stat(key)
+		if err != nil {
+			t.Fatalf("Stat(%q): %s", key, err)
+		}
+		if fi.Mode() != expected.mode {
+			t.Errorf("%s: expected mode %v, got %v", key, expected.mode, fi.Mode())
+		}
+		if !expected.mtime.IsZero() && !fi.ModTime().Equal(expected.mtime) {
+			t.Errorf("%s: expected modification time %v, got %v", key, expected.mtime, fi.ModTime())
+		}
+		if uid := fi.Sys().(*s3fs.ObjectInfo).UID; uid != expected.uid {
+			t.Errorf("%s: expected UID %d, got %d", key, expected.uid, uid)
+		}
+	}
+
+	// Writes don't add attributes unless they're enabled, but keep the
+	// modification time up to date
+	writeFile(t, fs3, "plain", "data")
+	if res := headObject(t, client, "plain"); res.Metadata["mode"] != "" || res.Metadata["mtime"] != "" {
+		t.Errorf("expected no POSIX attributes, got %v", res.Metadata)
+	}
+	writeMode(t, fs3, "rclone", s3fs.O_RDWR, 0666, []byte("new"))
+	fi, err := fs3.Stat("rclone")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.ModTime().Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("expected the modification time to be updated, got %v", fi.ModTime())
+	}
+}
+
+func TestReadDirStat(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		client, _ := newTestClient(t)
+		fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithPOSIXMetadata(true), s3fs.WithReadDirStat(enabled))
+		if err != nil {
+			t.Fatalf("NewS3FS: %s", err)
+		}
+		for i := 0; i < 5; i++ {
+			writeMode(t, fs3, "dir/file"+strconv.Itoa(i), s3fs.O_WRONLY, 0600, []byte("data"))
+		}
+
+		fis, err := fs3.ReadDir("dir")
+		if err != nil {
+			t.Fatalf("ReadDir: %s", err)
+		}
+		if len(fis) != 5 {
+			t.Fatalf("expected 5 entries, got %d", len(fis))
+		}
+		for _, fi := range fis {
+			expected := os.FileMode(0666)
+			if enabled {
+				expected = 0600
+			}
+			if fi.Mode() != expected {
+				t.Errorf("enabled=%t: expected mode %v for %s, got %v", enabled, expected, fi.Name(), fi.Mode())
+			}
+			obj := fi.Sys().(*s3fs.ObjectInfo)
+			if obj.Owner == nil {
+				t.Errorf("enabled=%t: expected the listed owner to be kept for %s", enabled, fi.Name())
+			}
+			if enabled && obj.Metadata == nil {
+				t.Errorf("expected the metadata of %s", fi.Name())
+			}
+		}
+	}
+}
+
+func TestSyncKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret"), []byte("data"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	fs3 := newTestFS(t, s3fs.WithPOSIXMetadata(true))
+
+	if _, err := s3fs.Sync(osfs.New(dir), fs3, s3fs.SyncOptions{}); err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+	fi, err := fs3.Stat("secret")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("expected mode %v, got %v", os.FileMode(0600), fi.Mode())
+	}
+}
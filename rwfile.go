@@ -0,0 +1,435 @@
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// memBuffer holds the contents of a file that's open for reading and
+// writing. It's shared by every file open on the same key, so they see
+// each other's writes before the file is uploaded.
+type memBuffer struct {
+	mu    sync.RWMutex
+	data  []byte
+	meta  map[string]string // User metadata of the object
+	dirty bool              // Modified since it was last uploaded?
+}
+
+// ReadAt implements io.ReaderAt.
+func (b *memBuffer) ReadAt(p []byte, off int64) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(b.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt, growing the buffer as needed.
+func (b *memBuffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	b.dirty = true
+	return copy(b.data[off:], p), nil
+}
+
+// truncate changes the size of the buffer.
+func (b *memBuffer) truncate(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if size < int64(len(b.data)) {
+		b.data = b.data[:size]
+	} else {
+		b.data = append(b.data, make([]byte, size-int64(len(b.data)))...)
+	}
+	b.dirty = true
+}
+
+// size returns the size of the buffer.
+func (b *memBuffer) size() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return int64(len(b.data))
+}
+
+// memReader reads from a memBuffer, seeing any data written while it's
+// open. It's used to read files that are open for writing.
+type memReader struct {
+	buf *memBuffer
+	pos int64
+}
+
+// Read implements io.Reader.
+func (r *memReader) Read(p []byte) (int, error) {
+	n, err := r.buf.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *memReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.buf.ReadAt(p, off)
+}
+
+// Seek implements io.Seeker.
+func (r *memReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.buf.size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// openFiles tracks the files open for reading and writing, so that files
+// opened on the same key share their contents.
+type openFiles struct {
+	mu    sync.Mutex
+	files map[string]*openFile
+}
+
+// openFile is an entry in openFiles.
+type openFile struct {
+	buf  *memBuffer
+	refs int // Number of open files sharing buf
+}
+
+// newOpenFiles creates an empty openFiles.
+func newOpenFiles() *openFiles {
+	return &openFiles{files: make(map[string]*openFile)}
+}
+
+// get returns the buffer of a file that's open for writing, if any.
+func (o *openFiles) get(key string) (*memBuffer, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if of, ok := o.files[key]; ok {
+		return of.buf, true
+	}
+	return nil, false
+}
+
+// open returns the buffer for key, calling load to create it if the key
+// isn't already open.
+func (o *openFiles) open(key string, load func() (*memBuffer, error)) (*memBuffer, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if of, ok := o.files[key]; ok {
+		of.refs++
+		return of.buf, nil
+	}
+	buf, err := load()
+	if err != nil {
+		return nil, err
+	}
+	o.files[key] = &openFile{buf: buf, refs: 1}
+	return buf, nil
+}
+
+// close releases a reference to the buffer for key.
+func (o *openFiles) close(key string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if of, ok := o.files[key]; ok {
+		of.refs--
+		if of.refs == 0 {
+			delete(o.files, key)
+		}
+	}
+}
+
+// s3ReadWriteFile implements billy.File for a file opened for reading and
+// writing (O_RDWR or O_APPEND).
+//
+// S3 objects can't be modified in place, so the file is loaded into memory
+// when it's opened and uploaded when it's closed, if it was changed. Files
+// open on the same key share their contents, and reads of the key see the
+// changes before they're uploaded.
+type s3ReadWriteFile struct {
+	fs     *S3FS      // Filesystem the file was opened from
+	name   string     // Name of the file as presented to OpenFile
+	bucket string     // S3 bucket name
+	key    string     // File object's key in S3
+	buf    *memBuffer // File contents, shared with other open files
+	pos    int64      // Current offset
+	append bool       // Write at the end of the file?
+	closed bool       // Is the file closed?
+	lock   fileLock   // Lock state
+}
+
+// newS3ReadWriteFile opens a file for reading and writing. If the file
+// doesn't exist and the O_CREATE flag is set, an empty object is created
+// straight away, as os.OpenFile would.
+func newS3ReadWriteFile(fs3 *S3FS, name, key string, flag int) (*s3ReadWriteFile, error) {
+	// Create the context
+	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
+
+	buf, err := fs3.openFiles.open(key, func() (*memBuffer, error) {
+		in := &s3.GetObjectInput{
+			Bucket: &fs3.bucket,
+			Key:    &key,
+		}
+		fs3.enc.applyGet(in)
+		res, err := fs3.client.GetObject(ctx, in, fs3.optFns...)
+		switch {
+		case isNotFound(err) && flag&O_CREATE == 0:
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		case isNotFound(err):
+			// Keep the new file's POSIX attributes for when it's rewritten
+			meta := fs3.posixMetadata(nil)
+			if err := fs3.putObject(ctx, key, nil, meta); err != nil {
+				return nil, err
+			}
+			return &memBuffer{meta: meta}, nil
+		case err != nil:
+			return nil, fmt.Errorf("unable to perform GetObject operation: %w", err)
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file body: %w", err)
+		}
+		r, size, err := fs3.decryptBody(ctx, res.Metadata, body)
+		if err != nil {
+			return nil, err
+		}
+		if r, _, err = decompressBody(aws.ToString(res.ContentEncoding), r, size); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the POSIX attributes, but not the metadata describing the
+		// stored contents, which is replaced when the file's rewritten
+		meta := filterMetadata(res.Metadata, internalMetaKey)
+		return &memBuffer{data: data, meta: meta}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &s3ReadWriteFile{
+		fs:     fs3,
+		name:   name,
+		bucket: fs3.bucket,
+		key:    key,
+		buf:    buf,
+		append: flag&O_APPEND != 0,
+		lock:   newFileLock(fs3, fs3.bucket, key),
+	}
+	if flag&O_TRUNC != 0 {
+		buf.truncate(0)
+	}
+	return f, nil
+}
+
+// Name returns the name of the file as presented to Open.
+func (f *s3ReadWriteFile) Name() string {
+	return f.name
+}
+
+// Write implements io.Writer for billy.File
+func (f *s3ReadWriteFile) Write(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	if f.append {
+		f.pos = f.buf.size()
+	}
+	n, err = f.buf.WriteAt(p, f.pos)
+	f.pos += int64(n)
+	return n, err
+}
+
+// Read implements io.Reader for billy.File
+func (f *s3ReadWriteFile) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	n, err = f.buf.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt for billy.File
+func (f *s3ReadWriteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	return f.buf.ReadAt(p, off)
+}
+
+// Seek implements io.Seeker for billy.File
+func (f *s3ReadWriteFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, ErrFileClosed
+	}
+	r := memReader{buf: f.buf, pos: f.pos}
+	pos, err := r.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	f.pos = pos
+	return pos, nil
+}
+
+// Close uploads the file, if it was changed, and closes it.
+func (f *s3ReadWriteFile) Close() error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.closed = true
+	defer f.lock.release()
+	defer f.fs.openFiles.close(f.key)
+
+	// Create the context
+	ctx := context.TODO() // TODO: How can user-supplied contexts be supported?
+
+	// Upload the contents, unless another file already has
+	f.buf.mu.Lock()
+	defer f.buf.mu.Unlock()
+	if !f.buf.dirty {
+		return nil
+	}
+	if err := f.fs.putObject(ctx, f.key, f.buf.data, f.buf.meta); err != nil {
+		return err
+	}
+	f.buf.dirty = false
+	return nil
+}
+
+// SetXattr sets an attribute of the file, which is stored when the file is
+// uploaded. Attributes are shared with other files open on the same key.
+func (f *s3ReadWriteFile) SetXattr(name, value string) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.buf.mu.Lock()
+	defer f.buf.mu.Unlock()
+	meta := make(map[string]string, len(f.buf.meta)+1)
+	for k, v := range f.buf.meta {
+		meta[k] = v
+	}
+	if err := setXattr(meta, name, value); err != nil {
+		return err
+	}
+	f.buf.meta = meta
+	f.buf.dirty = true
+	return nil
+}
+
+// Lock locks the file like e.g. flock. Locks are held in memory, so they
+// only protect against access from the same process.
+func (f *s3ReadWriteFile) Lock() error {
+	return f.lock.acquire()
+}
+
+// Unlock unlocks the file.
+func (f *s3ReadWriteFile) Unlock() error {
+	return f.lock.release()
+}
+
+// Truncate changes the size of the file.
+func (f *s3ReadWriteFile) Truncate(size int64) error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	f.buf.truncate(size)
+	return nil
+}
+
+// Stat describes the file, including any changes that haven't been
+// uploaded yet.
+func (f *s3ReadWriteFile) Stat() (os.FileInfo, error) {
+	name := f.fs.keyName(f.key)
+	return newFileInfo(name, f.buf.size(), time.Now(), nil), nil
+}
+
+// putObject uploads data to key with the given user metadata, using the
+// filesystem's storage class and encryption settings.
+func (fs3 *S3FS) putObject(ctx context.Context, key string, data []byte, xattrs map[string]string) error {
+	// Detect the Content-Type before the contents are compressed or encrypted
+	ct := fs3.headers.contentType(fs3.keyName(key), data)
+
+	var meta map[string]string
+	if fs3.compression != CompressionNone {
+		data, meta = fs3.compression.compressObject(data)
+	}
+	if fs3.cse != nil {
+		var emeta map[string]string
+		var err error
+		if data, emeta, err = fs3.cse.encryptObject(ctx, data); err != nil {
+			return err
+		}
+		meta = mergeMetadata(meta, emeta)
+	}
+
+	// TODO: Currently `res` is not used. Should it be?
+	in := &s3.PutObjectInput{
+		Bucket:          &fs3.bucket,
+		Key:             &key,
+		Body:            bytes.NewReader(data),
+		StorageClass:    fs3.storageClass,
+		Metadata:        mergeMetadata(mergeMetadata(xattrs, fs3.posixMetadata(xattrs)), meta),
+		ContentEncoding: optString(fs3.compression.contentEncoding()),
+	}
+	fs3.enc.applyPut(in)
+	fs3.headers.applyPut(in, ct)
+	fs3.file.applyPut(in)
+	_, err := fs3.client.PutObject(ctx, in, fs3.optFns...)
+	if err != nil {
+		return fmt.Errorf("unable to perform PutObject operation: %w", err)
+	}
+
+	// The cached Stat result is now out of date
+	fs3.statCache.remove(key)
+	return nil
+}
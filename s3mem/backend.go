@@ -0,0 +1,186 @@
+// Package s3mem implements an in-memory S3 backend for tests and local
+// development.
+//
+// A Backend implements the subset of the S3 client's operations used by
+// s3fs (see s3fs.S3API), with support for versioning, multipart uploads,
+// conditional requests, ETags and paginated listings with delimiters. It
+// never makes network requests and behaves deterministically, and faults
+// (latency, throttling and internal errors) can be injected to test how
+// callers handle them.
+package s3mem
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	DefaultMinPartSize int64 = 5 * 1024 * 1024 // S3's minimum size for all but the last part of a multipart upload
+	DefaultMaxKeys     int32 = 1000            // S3's default (and maximum) number of keys returned by ListObjectsV2
+
+	OwnerID   = "s3mem" // Canonical user ID of the owner of every object
+	OwnerName = "s3mem" // Display name of the owner of every object
+)
+
+// Backend is an in-memory S3 backend. It is safe for concurrent use.
+//
+// Buckets must be created with CreateBucket before they can be used.
+type Backend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	seq     int64 // Sequence used to generate version and upload IDs
+	faults  Faults
+	reqs    int64 // Number of requests made, used for fault injection
+
+	// Now returns the current time. It defaults to time.Now and can be
+	// replaced to make timestamps deterministic.
+	Now func() time.Time
+
+	// MinPartSize is the minimum size of all but the last part of a
+	// multipart upload. It defaults to DefaultMinPartSize.
+	MinPartSize int64
+}
+
+// bucket is an S3 bucket.
+type bucket struct {
+	versioning bool                 // Is versioning enabled?
+	objects    map[string][]*object // Object versions by key, oldest first
+	uploads    map[string]*upload   // In-progress multipart uploads by ID
+}
+
+// object is a single version of an S3 object (or a delete marker).
+type object struct {
+	key          string
+	versionID    string
+	deleteMarker bool
+	data         []byte
+	etag         string
+	lastModified time.Time
+	partsCount   int32
+
+	metadata           map[string]string
+	cacheControl       *string
+	contentDisposition *string
+	contentEncoding    *string
+	contentLanguage    *string
+	contentType        *string
+	expires            *time.Time
+	storageClass       types.StorageClass
+	tagging            *string
+	checksum           checksum
+
+	sse                  types.ServerSideEncryption
+	sseKMSKeyID          *string
+	sseKMSContext        *string
+	sseCustomerAlgorithm *string
+	sseCustomerKey       *string
+}
+
+// New creates an empty Backend.
+func New() *Backend {
+	return &Backend{
+		buckets:     make(map[string]*bucket),
+		Now:         time.Now,
+		MinPartSize: DefaultMinPartSize,
+	}
+}
+
+// CreateBucket creates a new, empty bucket. If the bucket already exists,
+// CreateBucket does nothing.
+func (b *Backend) CreateBucket(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.buckets[name]; ok {
+		return
+	}
+	b.buckets[name] = &bucket{
+		objects: make(map[string][]*object),
+		uploads: make(map[string]*upload),
+	}
+}
+
+// SetVersioning enables or disables versioning for the named bucket. When
+// disabled, existing versions are kept but new writes replace the latest
+// version.
+func (b *Backend) SetVersioning(name string, enabled bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(name)
+	if err != nil {
+		return err
+	}
+	bkt.versioning = enabled
+	return nil
+}
+
+// bucket returns the named bucket. The caller must hold b.mu.
+func (b *Backend) bucket(name string) (*bucket, error) {
+	bkt, ok := b.buckets[name]
+	if !ok {
+		return nil, errNoSuchBucket(name)
+	}
+	return bkt, nil
+}
+
+// nextID returns a new unique ID. The caller must hold b.mu.
+func (b *Backend) nextID() string {
+	b.seq++
+	return fmt.Sprintf("%016x", b.seq)
+}
+
+// now returns the current time, truncated to the second precision S3
+// uses for LastModified.
+func (b *Backend) now() time.Time {
+	return b.Now().UTC().Truncate(time.Second)
+}
+
+// latest returns the latest version of key, or nil if it doesn't exist.
+func (bkt *bucket) latest(key string) *object {
+	vs := bkt.objects[key]
+	if len(vs) == 0 {
+		return nil
+	}
+	return vs[len(vs)-1]
+}
+
+// version returns the given version of key, or nil if it doesn't exist.
+func (bkt *bucket) version(key, versionID string) *object {
+	for _, o := range bkt.objects[key] {
+		if o.versionID == versionID {
+			return o
+		}
+	}
+	return nil
+}
+
+// put stores a new version of an object in the bucket, replacing the
+// "null" version if versioning is disabled. The caller must hold b.mu.
+func (b *Backend) put(bkt *bucket, o *object) {
+	if !bkt.versioning {
+		o.versionID = "null"
+		vs := bkt.objects[o.key]
+		for i, v := range vs {
+			if v.versionID == "null" {
+				vs = append(vs[:i], vs[i+1:]...)
+				break
+			}
+		}
+		bkt.objects[o.key] = append(vs, o)
+		return
+	}
+	o.versionID = b.nextID()
+	bkt.objects[o.key] = append(bkt.objects[o.key], o)
+}
+
+// etag returns the quoted MD5 hex digest of data.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
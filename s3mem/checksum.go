@@ -0,0 +1,159 @@
+package s3mem
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// checksum is the additional checksum of an object or part.
+type checksum struct {
+	algorithm types.ChecksumAlgorithm
+	value     string // Base64-encoded, suffixed with "-N" for multipart objects
+}
+
+// checksums holds the checksum values of a request or response, of which
+// at most one is normally set.
+type checksums struct {
+	crc32, crc32c, sha1, sha256 *string
+}
+
+// newHash returns a hash for the checksum algorithm.
+func newHash(alg types.ChecksumAlgorithm) (hash.Hash, error) {
+	switch alg {
+	case types.ChecksumAlgorithmCrc32:
+		return crc32.NewIEEE(), nil
+	case types.ChecksumAlgorithmCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case types.ChecksumAlgorithmSha1:
+		return sha1.New(), nil
+	case types.ChecksumAlgorithmSha256:
+		return sha256.New(), nil
+	}
+	return nil, errInvalidRequest(fmt.Sprintf("unsupported checksum algorithm %q", alg))
+}
+
+// computeChecksum returns the base64-encoded checksum of data.
+func computeChecksum(alg types.ChecksumAlgorithm, data []byte) (string, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return "", err
+	}
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// get returns the value supplied for the algorithm, or nil.
+func (cs checksums) get(alg types.ChecksumAlgorithm) *string {
+	switch alg {
+	case types.ChecksumAlgorithmCrc32:
+		return cs.crc32
+	case types.ChecksumAlgorithmCrc32c:
+		return cs.crc32c
+	case types.ChecksumAlgorithmSha1:
+		return cs.sha1
+	case types.ChecksumAlgorithmSha256:
+		return cs.sha256
+	}
+	return nil
+}
+
+// algorithm returns the algorithm of the supplied value, or alg if none
+// was supplied.
+func (cs checksums) algorithm(alg types.ChecksumAlgorithm) types.ChecksumAlgorithm {
+	for _, a := range []types.ChecksumAlgorithm{
+		types.ChecksumAlgorithmCrc32,
+		types.ChecksumAlgorithmCrc32c,
+		types.ChecksumAlgorithmSha1,
+		types.ChecksumAlgorithmSha256,
+	} {
+		if cs.get(a) != nil {
+			return a
+		}
+	}
+	return alg
+}
+
+// verifyChecksum computes the checksum of uploaded data with the requested
+// algorithm (or the one whose value was supplied), checking it against the
+// supplied value, if any.
+func verifyChecksum(alg types.ChecksumAlgorithm, cs checksums, data []byte) (checksum, error) {
+	alg = cs.algorithm(alg)
+	if alg == "" {
+		return checksum{}, nil
+	}
+	v, err := computeChecksum(alg, data)
+	if err != nil {
+		return checksum{}, err
+	}
+	if want := cs.get(alg); want != nil && *want != v {
+		return checksum{}, genericError(http.StatusBadRequest, "BadDigest", fmt.Sprintf("the %s you specified did not match the calculated checksum", alg))
+	}
+	return checksum{algorithm: alg, value: v}, nil
+}
+
+// compositeChecksum returns the checksum of a multipart object: the
+// checksum of the parts' (decoded) checksums, suffixed with the number of
+// parts.
+func compositeChecksum(alg types.ChecksumAlgorithm, parts []checksum) (checksum, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return checksum{}, err
+	}
+	for _, p := range parts {
+		b, err := base64.StdEncoding.DecodeString(p.value)
+		if err != nil {
+			return checksum{}, err
+		}
+		h.Write(b)
+	}
+	v := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return checksum{algorithm: alg, value: fmt.Sprintf("%s-%d", v, len(parts))}, nil
+}
+
+// values returns the checksum as response fields.
+func (c checksum) values() checksums {
+	var cs checksums
+	v := &c.value
+	switch c.algorithm {
+	case types.ChecksumAlgorithmCrc32:
+		cs.crc32 = v
+	case types.ChecksumAlgorithmCrc32c:
+		cs.crc32c = v
+	case types.ChecksumAlgorithmSha1:
+		cs.sha1 = v
+	case types.ChecksumAlgorithmSha256:
+		cs.sha256 = v
+	}
+	return cs
+}
+
+// checksumHeaders reads the x-amz-checksum-* request headers.
+func checksumHeaders(r *http.Request) checksums {
+	return checksums{
+		crc32:  header(r, "x-amz-checksum-crc32"),
+		crc32c: header(r, "x-amz-checksum-crc32c"),
+		sha1:   header(r, "x-amz-checksum-sha1"),
+		sha256: header(r, "x-amz-checksum-sha256"),
+	}
+}
+
+// writeChecksumHeaders writes the x-amz-checksum-* response headers.
+func writeChecksumHeaders(h http.Header, cs checksums) {
+	setHeader(h, "x-amz-checksum-crc32", cs.crc32)
+	setHeader(h, "x-amz-checksum-crc32c", cs.crc32c)
+	setHeader(h, "x-amz-checksum-sha1", cs.sha1)
+	setHeader(h, "x-amz-checksum-sha256", cs.sha256)
+}
+
+// checksumAlgorithm reads a checksum algorithm request header.
+func checksumAlgorithm(r *http.Request, name string) types.ChecksumAlgorithm {
+	return types.ChecksumAlgorithm(strings.ToUpper(r.Header.Get(name)))
+}
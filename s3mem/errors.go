@@ -0,0 +1,88 @@
+package s3mem
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// apiError wraps err in a response error with the given HTTP status code,
+// the same way the S3 client does, so callers can inspect both the API
+// error code and the status code.
+func apiError(status int, err error) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{
+			Response: &http.Response{
+				StatusCode: status,
+				Status:     http.StatusText(status),
+				Header:     http.Header{},
+			},
+		},
+		Err: err,
+	}
+}
+
+// genericError creates an API error with the given code and message.
+func genericError(status int, code, msg string) error {
+	return apiError(status, &smithy.GenericAPIError{
+		Code:    code,
+		Message: msg,
+	})
+}
+
+func errNoSuchBucket(name string) error {
+	return apiError(http.StatusNotFound, &types.NoSuchBucket{
+		Message: strPtr(fmt.Sprintf("the specified bucket does not exist: %s", name)),
+	})
+}
+
+func errNoSuchKey(key string) error {
+	return apiError(http.StatusNotFound, &types.NoSuchKey{
+		Message: strPtr(fmt.Sprintf("the specified key does not exist: %s", key)),
+	})
+}
+
+// errNotFound is returned by HeadObject, which has no response body and
+// so can't return a NoSuchKey error.
+func errNotFound(key string) error {
+	return apiError(http.StatusNotFound, &types.NotFound{
+		Message: strPtr(fmt.Sprintf("not found: %s", key)),
+	})
+}
+
+func errNoSuchVersion(key, versionID string) error {
+	return genericError(http.StatusNotFound, "NoSuchVersion", fmt.Sprintf("the specified version %q of %q does not exist", versionID, key))
+}
+
+func errNoSuchUpload(id string) error {
+	return apiError(http.StatusNotFound, &types.NoSuchUpload{
+		Message: strPtr(fmt.Sprintf("the specified upload does not exist: %s", id)),
+	})
+}
+
+func errPreconditionFailed() error {
+	return genericError(http.StatusPreconditionFailed, "PreconditionFailed", "at least one of the preconditions you specified did not hold")
+}
+
+func errNotModified() error {
+	return genericError(http.StatusNotModified, "NotModified", "not modified")
+}
+
+func errInvalidRange(r string) error {
+	return genericError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", fmt.Sprintf("the requested range is not satisfiable: %s", r))
+}
+
+func errInvalidArgument(msg string) error {
+	return genericError(http.StatusBadRequest, "InvalidArgument", msg)
+}
+
+func errInvalidRequest(msg string) error {
+	return genericError(http.StatusBadRequest, "InvalidRequest", msg)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,84 @@
+package s3mem
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Faults configures the faults injected into requests made to a Backend.
+// Faults are injected deterministically, based on a count of the requests
+// made since the faults were set.
+type Faults struct {
+	// Latency is added to every request, before it is handled. If the
+	// request's context is cancelled while waiting, its error is returned.
+	Latency time.Duration
+
+	// ThrottleEvery causes every nth request to fail with a 503 SlowDown
+	// error. Zero disables throttling.
+	ThrottleEvery int
+
+	// ErrorEvery causes every nth request to fail with a 500
+	// InternalError. Zero disables errors.
+	ErrorEvery int
+
+	// Operations restricts the faults to the named operations (e.g.
+	// "GetObject"). If empty, faults apply to all operations.
+	Operations []string
+}
+
+// SetFaults sets the faults injected into subsequent requests and resets
+// the request count.
+func (b *Backend) SetFaults(f Faults) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.faults = f
+	b.reqs = 0
+}
+
+// inject applies any configured faults to a request for the named
+// operation, returning the error the request should fail with, if any.
+//
+// inject must be called without holding b.mu.
+func (b *Backend) inject(ctx context.Context, op string) error {
+	b.mu.Lock()
+	f := b.faults
+	applies := len(f.Operations) == 0
+	for _, o := range f.Operations {
+		if o == op {
+			applies = true
+			break
+		}
+	}
+	var n int64
+	if applies {
+		b.reqs++
+		n = b.reqs
+	}
+	b.mu.Unlock()
+
+	if !applies {
+		return nil
+	}
+
+	// Add latency
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// Fail the request
+	if f.ThrottleEvery > 0 && n%int64(f.ThrottleEvery) == 0 {
+		return genericError(http.StatusServiceUnavailable, "SlowDown", "please reduce your request rate")
+	}
+	if f.ErrorEvery > 0 && n%int64(f.ErrorEvery) == 0 {
+		return genericError(http.StatusInternalServerError, "InternalError", "we encountered an internal error, please try again")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package s3mem_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestFaults(t *testing.T) {
+	b := newTestBackend(t)
+	put(t, b, "key", "data")
+
+	// Every 2nd request is throttled and every 3rd fails, counting from
+	// when the faults are set
+	b.SetFaults(s3mem.Faults{ThrottleEvery: 2, ErrorEvery: 3})
+	expected := []string{"", "SlowDown", "InternalError", "SlowDown", "", "SlowDown"}
+	for i, code := range expected {
+		_, err := get(b, "key", nil)
+		status := 0
+		switch code {
+		case "SlowDown":
+			status = 503
+		case "InternalError":
+			status = 500
+		}
+		if code == "" && err != nil {
+			t.Errorf("request %d: expected no error, got %v", i+1, err)
+		} else if code != "" && !hasCode(err, code, status) {
+			t.Errorf("request %d: expected %s, got %v", i+1, code, err)
+		}
+	}
+
+	// Faults can be restricted to operations, and only those operations
+	// are counted
+	b.SetFaults(s3mem.Faults{ErrorEvery: 2, Operations: []string{"PutObject"}})
+	for i := 0; i < 3; i++ {
+		if _, err := get(b, "key", nil); err != nil {
+			t.Errorf("GetObject: expected no error, got %v", err)
+		}
+	}
+	put(t, b, "key", "data")
+	_, err := b.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+		Body:   strings.NewReader("data"),
+	})
+	if !hasCode(err, "InternalError", 500) {
+		t.Errorf("expected InternalError, got %v", err)
+	}
+
+	// Clearing the faults stops them
+	b.SetFaults(s3mem.Faults{})
+	for i := 0; i < 3; i++ {
+		put(t, b, "key", "data")
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	b := newTestBackend(t)
+	put(t, b, "key", "data")
+
+	b.SetFaults(s3mem.Faults{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := get(b, "key", nil); err != nil {
+		t.Fatalf("GetObject: %s", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected a latency of at least 50ms, got %v", d)
+	}
+
+	// Cancelled requests return the context's error
+	b.SetFaults(s3mem.Faults{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := b.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
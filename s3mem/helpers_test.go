@@ -0,0 +1,67 @@
+package s3mem_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const testBucket = "test-bucket"
+
+// newTestBackend creates a Backend with an empty test bucket.
+func newTestBackend(t *testing.T) *s3mem.Backend {
+	t.Helper()
+
+	b := s3mem.New()
+	b.CreateBucket(testBucket)
+	return b
+}
+
+// put writes an object with the given contents, returning its version ID.
+func put(t *testing.T, b *s3mem.Backend, key, data string) string {
+	t.Helper()
+
+	res, err := b.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte(data)),
+	})
+	if err != nil {
+		t.Fatalf("PutObject(%q): %s", key, err)
+	}
+	return aws.ToString(res.VersionId)
+}
+
+// get returns the contents of an object (or a version of it, if versionID
+// isn't nil).
+func get(b *s3mem.Backend, key string, versionID *string) (string, error) {
+	res, err := b.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket:    aws.String(testBucket),
+		Key:       aws.String(key),
+		VersionId: versionID,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	return string(data), err
+}
+
+// hasCode reports whether err is an API error with the given error code
+// and HTTP status code.
+func hasCode(err error, code string, status int) bool {
+	var apiErr smithy.APIError
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code &&
+		errors.As(err, &respErr) && respErr.HTTPStatusCode() == status
+}
@@ -0,0 +1,125 @@
+package s3mem
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ListObjectsV2 lists the latest versions of the objects in a bucket, in
+// key order. If a delimiter is given, keys sharing a prefix up to the
+// delimiter are grouped into common prefixes. Results are paginated with
+// opaque continuation tokens.
+func (b *Backend) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	if err := b.inject(ctx, "ListObjectsV2"); err != nil {
+		return nil, err
+	}
+
+	maxKeys := params.MaxKeys
+	if maxKeys <= 0 || maxKeys > DefaultMaxKeys {
+		maxKeys = DefaultMaxKeys
+	}
+	prefix := aws.ToString(params.Prefix)
+	delim := aws.ToString(params.Delimiter)
+
+	// Resume after the continuation token, or the StartAfter key
+	after := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		tok, err := base64.StdEncoding.DecodeString(*params.ContinuationToken)
+		if err != nil {
+			return nil, errInvalidArgument("the continuation token provided is incorrect")
+		}
+		after = string(tok)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the matching keys, in order
+	var keys []string
+	for k := range bkt.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			if o := bkt.latest(k); o != nil && !o.deleteMarker {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsV2Output{
+		ContinuationToken: params.ContinuationToken,
+		Delimiter:         params.Delimiter,
+		MaxKeys:           maxKeys,
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		StartAfter:        params.StartAfter,
+	}
+
+	var last string
+	for i := 0; i < len(keys); i++ {
+		k := keys[i]
+
+		// Stop once a full page has been collected
+		if out.KeyCount == maxKeys {
+			out.IsTruncated = true
+			out.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(last)))
+			break
+		}
+
+		// Group keys into common prefixes
+		if delim != "" {
+			if j := strings.Index(k[len(prefix):], delim); j >= 0 {
+				cp := k[:len(prefix)+j+len(delim)]
+
+				// Skip the rest of the keys with the prefix. The last one is
+				// used as the continuation token, so the next page skips
+				// them too.
+				for i+1 < len(keys) && strings.HasPrefix(keys[i+1], cp) {
+					i++
+				}
+				out.CommonPrefixes = append(out.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(cp)})
+				out.KeyCount++
+				last = keys[i]
+				continue
+			}
+		}
+
+		o := bkt.latest(k)
+		obj := types.Object{
+			ETag:         aws.String(o.etag),
+			Key:          aws.String(k),
+			LastModified: aws.Time(o.lastModified),
+			Size:         int64(len(o.data)),
+			StorageClass: types.ObjectStorageClass(storageClassOrDefault(o.storageClass)),
+		}
+		if o.checksum.algorithm != "" {
+			obj.ChecksumAlgorithm = []types.ChecksumAlgorithm{o.checksum.algorithm}
+		}
+		if params.FetchOwner {
+			obj.Owner = &types.Owner{ID: aws.String(OwnerID), DisplayName: aws.String(OwnerName)}
+		}
+		out.Contents = append(out.Contents, obj)
+		out.KeyCount++
+		last = k
+	}
+	return out, nil
+}
+
+// storageClassOrDefault returns the storage class, defaulting to
+// STANDARD.
+func storageClassOrDefault(c types.StorageClass) types.StorageClass {
+	if c == "" {
+		return types.StorageClassStandard
+	}
+	return c
+}
@@ -0,0 +1,124 @@
+package s3mem_test
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	b := newTestBackend(t)
+	for _, k := range []string{"a/1", "a/2", "a/3/x", "b", "c/1", "c/2", "d", "e/f/g"} {
+		put(t, b, k, k)
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		maxKeys   int32
+		pages     [][]string // Keys and common prefixes on each page
+	}{
+		{"all", "", "", 3, [][]string{
+			{"a/1", "a/2", "a/3/x"},
+			{"b", "c/1", "c/2"},
+			{"d", "e/f/g"},
+		}},
+		{"delimiter", "", "/", 2, [][]string{
+			{"a/", "b"},
+			{"c/", "d"},
+			{"e/"},
+		}},
+		{"common prefixes only", "", "/", 1, [][]string{
+			{"a/"}, {"b"}, {"c/"}, {"d"}, {"e/"},
+		}},
+		{"prefix", "a/", "/", 2, [][]string{
+			{"a/1", "a/2"},
+			{"a/3/"},
+		}},
+		{"nested", "e/", "/", 1000, [][]string{
+			{"e/f/"},
+		}},
+		{"no matches", "z", "/", 1000, [][]string{
+			nil,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &s3.ListObjectsV2Input{
+				Bucket:  aws.String(testBucket),
+				MaxKeys: tt.maxKeys,
+			}
+			if tt.prefix != "" {
+				in.Prefix = aws.String(tt.prefix)
+			}
+			if tt.delimiter != "" {
+				in.Delimiter = aws.String(tt.delimiter)
+			}
+
+			var pages [][]string
+			for {
+				res, err := b.ListObjectsV2(context.Background(), in)
+				if err != nil {
+					t.Fatalf("ListObjectsV2: %s", err)
+				}
+
+				// Objects and common prefixes are returned separately
+				var page []string
+				for _, o := range res.Contents {
+					page = append(page, aws.ToString(o.Key))
+				}
+				for _, cp := range res.CommonPrefixes {
+					page = append(page, aws.ToString(cp.Prefix))
+				}
+				if int(res.KeyCount) != len(page) {
+					t.Errorf("expected a key count of %d, got %d", len(page), res.KeyCount)
+				}
+				sort.Strings(page)
+				pages = append(pages, page)
+
+				if !res.IsTruncated {
+					break
+				}
+				if res.NextContinuationToken == nil {
+					t.Fatalf("truncated page without a continuation token")
+				}
+				in.ContinuationToken = res.NextContinuationToken
+			}
+			if !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("expected pages %q, got %q", tt.pages, pages)
+			}
+		})
+	}
+}
+
+func TestListObjectsV2StartAfter(t *testing.T) {
+	b := newTestBackend(t)
+	for _, k := range []string{"a", "b", "c"} {
+		put(t, b, k, k)
+	}
+
+	res, err := b.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:     aws.String(testBucket),
+		StartAfter: aws.String("a"),
+	})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %s", err)
+	}
+	if len(res.Contents) != 2 || aws.ToString(res.Contents[0].Key) != "b" {
+		t.Errorf("expected the keys after \"a\", got %d keys", len(res.Contents))
+	}
+
+	// Bad continuation tokens are rejected
+	_, err = b.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(testBucket),
+		ContinuationToken: aws.String("!"),
+	})
+	if !hasCode(err, "InvalidArgument", 400) {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+}
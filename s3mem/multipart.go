@@ -0,0 +1,351 @@
+package s3mem
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// maxPartNumber is the largest part number S3 accepts.
+const maxPartNumber = 10000
+
+// upload is an in-progress multipart upload.
+type upload struct {
+	id    string
+	proto *object         // Object the upload will create, without data
+	parts map[int32]*part // Uploaded parts by part number
+}
+
+// part is a single part of a multipart upload.
+type part struct {
+	data     []byte
+	etag     string
+	checksum checksum
+}
+
+// CreateMultipartUpload starts a multipart upload.
+func (b *Backend) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	if err := b.inject(ctx, "CreateMultipartUpload"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if aws.ToString(params.Key) == "" {
+		return nil, errInvalidArgument("key cannot be empty")
+	}
+
+	u := &upload{
+		id: b.nextID(),
+		proto: &object{
+			key:                  aws.ToString(params.Key),
+			metadata:             copyMetadata(params.Metadata),
+			cacheControl:         params.CacheControl,
+			contentDisposition:   params.ContentDisposition,
+			contentEncoding:      params.ContentEncoding,
+			contentLanguage:      params.ContentLanguage,
+			contentType:          params.ContentType,
+			expires:              params.Expires,
+			storageClass:         params.StorageClass,
+			tagging:              params.Tagging,
+			checksum:             checksum{algorithm: params.ChecksumAlgorithm},
+			sse:                  params.ServerSideEncryption,
+			sseKMSKeyID:          params.SSEKMSKeyId,
+			sseKMSContext:        params.SSEKMSEncryptionContext,
+			sseCustomerAlgorithm: params.SSECustomerAlgorithm,
+			sseCustomerKey:       params.SSECustomerKey,
+		},
+		parts: make(map[int32]*part),
+	}
+	bkt.uploads[u.id] = u
+
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:                  params.Bucket,
+		ChecksumAlgorithm:       params.ChecksumAlgorithm,
+		Key:                     params.Key,
+		UploadId:                aws.String(u.id),
+		SSECustomerAlgorithm:    params.SSECustomerAlgorithm,
+		SSEKMSEncryptionContext: params.SSEKMSEncryptionContext,
+		SSEKMSKeyId:             params.SSEKMSKeyId,
+		ServerSideEncryption:    params.ServerSideEncryption,
+	}, nil
+}
+
+// UploadPart uploads a part of a multipart upload. Uploading a part with
+// the same number as an existing part replaces it.
+func (b *Backend) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if err := b.inject(ctx, "UploadPart"); err != nil {
+		return nil, err
+	}
+	if params.PartNumber < 1 || params.PartNumber > maxPartNumber {
+		return nil, errInvalidArgument(fmt.Sprintf("part number must be between 1 and %d", maxPartNumber))
+	}
+
+	// Read the body before taking the lock
+	data, err := readAll(params.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u, err := b.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCustomerKey(u.proto, params.SSECustomerKey); err != nil {
+		return nil, err
+	}
+
+	// Parts must use the upload's checksum algorithm, if it has one
+	alg := u.proto.checksum.algorithm
+	cs := checksums{
+		crc32:  params.ChecksumCRC32,
+		crc32c: params.ChecksumCRC32C,
+		sha1:   params.ChecksumSHA1,
+		sha256: params.ChecksumSHA256,
+	}
+	if a := cs.algorithm(params.ChecksumAlgorithm); a != "" && alg != "" && a != alg {
+		return nil, errInvalidRequest(fmt.Sprintf("checksum type mismatch: the upload uses %s, but the part uses %s", alg, a))
+	}
+	sum, err := verifyChecksum(alg, cs, data)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &part{data: data, etag: etag(data), checksum: sum}
+	u.parts[params.PartNumber] = p
+
+	cs = sum.values()
+	return &s3.UploadPartOutput{
+		ChecksumCRC32:        cs.crc32,
+		ChecksumCRC32C:       cs.crc32c,
+		ChecksumSHA1:         cs.sha1,
+		ChecksumSHA256:       cs.sha256,
+		ETag:                 aws.String(p.etag),
+		SSECustomerAlgorithm: u.proto.sseCustomerAlgorithm,
+		SSEKMSKeyId:          u.proto.sseKMSKeyID,
+		ServerSideEncryption: u.proto.sse,
+	}, nil
+}
+
+// UploadPartCopy uploads a part of a multipart upload, copying it from a
+// range of an existing object (or the whole object, if no range is given).
+func (b *Backend) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	if err := b.inject(ctx, "UploadPartCopy"); err != nil {
+		return nil, err
+	}
+	if params.PartNumber < 1 || params.PartNumber > maxPartNumber {
+		return nil, errInvalidArgument(fmt.Sprintf("part number must be between 1 and %d", maxPartNumber))
+	}
+	srcBucket, srcKey, srcVersion, err := parseCopySource(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u, err := b.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCustomerKey(u.proto, params.SSECustomerKey); err != nil {
+		return nil, err
+	}
+
+	// Find the source object
+	sbkt, err := b.bucket(srcBucket)
+	if err != nil {
+		return nil, err
+	}
+	src, err := sbkt.lookup(srcKey, srcVersion, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConditions(src, params.CopySourceIfMatch, params.CopySourceIfNoneMatch, params.CopySourceIfModifiedSince, params.CopySourceIfUnmodifiedSince); err != nil {
+		return nil, errPreconditionFailed()
+	}
+	if err := checkCustomerKey(src, params.CopySourceSSECustomerKey); err != nil {
+		return nil, err
+	}
+
+	// Copy the range
+	data := src.data
+	if r := aws.ToString(params.CopySourceRange); r != "" {
+		start, end, err := parseRange(r, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		data = data[start : end+1]
+	}
+	sum, err := verifyChecksum(u.proto.checksum.algorithm, checksums{}, data)
+	if err != nil {
+		return nil, err
+	}
+	p := &part{data: data, etag: etag(data), checksum: sum}
+	u.parts[params.PartNumber] = p
+
+	cs := sum.values()
+	return &s3.UploadPartCopyOutput{
+		CopyPartResult: &types.CopyPartResult{
+			ChecksumCRC32:  cs.crc32,
+			ChecksumCRC32C: cs.crc32c,
+			ChecksumSHA1:   cs.sha1,
+			ChecksumSHA256: cs.sha256,
+			ETag:           aws.String(p.etag),
+			LastModified:   aws.Time(b.now()),
+		},
+		CopySourceVersionId:  src.versionIDPtr(),
+		SSECustomerAlgorithm: u.proto.sseCustomerAlgorithm,
+		SSEKMSKeyId:          u.proto.sseKMSKeyID,
+		ServerSideEncryption: u.proto.sse,
+	}, nil
+}
+
+// CompleteMultipartUpload assembles the listed parts into an object.
+func (b *Backend) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	if err := b.inject(ctx, "CompleteMultipartUpload"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	u, err := b.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	if params.MultipartUpload == nil || len(params.MultipartUpload.Parts) == 0 {
+		return nil, genericError(http.StatusBadRequest, "MalformedXML", "you must specify at least one part")
+	}
+
+	// Assemble the parts, checking they are in order, match the uploaded
+	// parts and are large enough
+	var data []byte
+	var sums []byte
+	var parts []checksum
+	cps := params.MultipartUpload.Parts
+	for i, cp := range cps {
+		if i > 0 && cp.PartNumber <= cps[i-1].PartNumber {
+			return nil, genericError(http.StatusBadRequest, "InvalidPartOrder", "the list of parts was not in ascending order")
+		}
+		p, ok := u.parts[cp.PartNumber]
+		if !ok || strings.Trim(aws.ToString(cp.ETag), `"`) != strings.Trim(p.etag, `"`) {
+			return nil, genericError(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d could not be found or its etag did not match", cp.PartNumber))
+		}
+		if alg := u.proto.checksum.algorithm; alg != "" {
+			v := checksums{
+				crc32:  cp.ChecksumCRC32,
+				crc32c: cp.ChecksumCRC32C,
+				sha1:   cp.ChecksumSHA1,
+				sha256: cp.ChecksumSHA256,
+			}.get(alg)
+			if v == nil || *v != p.checksum.value {
+				return nil, genericError(http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d is missing its %s checksum or it did not match", cp.PartNumber, alg))
+			}
+			parts = append(parts, p.checksum)
+		}
+		if i < len(cps)-1 && int64(len(p.data)) < b.MinPartSize {
+			return nil, genericError(http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is smaller than the minimum allowed size", cp.PartNumber))
+		}
+		data = append(data, p.data...)
+		sum := md5.Sum(p.data)
+		sums = append(sums, sum[:]...)
+	}
+
+	// Multipart ETags are the MD5 of the parts' MD5s, suffixed with the
+	// number of parts
+	sum := md5.Sum(sums)
+	o := *u.proto
+	o.data = data
+	o.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(cps))
+	o.lastModified = b.now()
+	o.partsCount = int32(len(cps))
+	if alg := u.proto.checksum.algorithm; alg != "" {
+		if o.checksum, err = compositeChecksum(alg, parts); err != nil {
+			return nil, err
+		}
+	}
+	b.put(bkt, &o)
+	delete(bkt.uploads, u.id)
+
+	cs := o.checksum.values()
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:               params.Bucket,
+		ChecksumCRC32:        cs.crc32,
+		ChecksumCRC32C:       cs.crc32c,
+		ChecksumSHA1:         cs.sha1,
+		ChecksumSHA256:       cs.sha256,
+		ETag:                 aws.String(o.etag),
+		Key:                  params.Key,
+		SSEKMSKeyId:          o.sseKMSKeyID,
+		ServerSideEncryption: o.sse,
+		VersionId:            o.versionIDPtr(),
+	}, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts.
+func (b *Backend) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if err := b.inject(ctx, "AbortMultipartUpload"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	u, err := b.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, err
+	}
+	delete(bkt.uploads, u.id)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// Uploads returns the number of in-progress multipart uploads in the named
+// bucket.
+func (b *Backend) Uploads(name string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(name)
+	if err != nil {
+		return 0, err
+	}
+	return len(bkt.uploads), nil
+}
+
+// upload finds the multipart upload with the given ID for the key. The
+// caller must hold b.mu.
+func (b *Backend) upload(bucketName, key, id *string) (*upload, error) {
+	bkt, err := b.bucket(aws.ToString(bucketName))
+	if err != nil {
+		return nil, err
+	}
+	u, ok := bkt.uploads[aws.ToString(id)]
+	if !ok || u.proto.key != aws.ToString(key) {
+		return nil, errNoSuchUpload(aws.ToString(id))
+	}
+	return u, nil
+}
@@ -0,0 +1,146 @@
+package s3mem_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// startUpload starts a multipart upload and uploads the given parts (by
+// part number), returning the upload ID and the parts' ETags.
+func startUpload(t *testing.T, b *s3mem.Backend, key string, parts map[int32]string) (*string, map[int32]*string) {
+	t.Helper()
+
+	ctx := context.Background()
+	res, err := b.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %s", err)
+	}
+
+	etags := make(map[int32]*string)
+	for n, data := range parts {
+		p, err := b.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(testBucket),
+			Key:        aws.String(key),
+			UploadId:   res.UploadId,
+			PartNumber: n,
+			Body:       bytes.NewReader([]byte(data)),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart(%d): %s", n, err)
+		}
+		etags[n] = p.ETag
+	}
+	return res.UploadId, etags
+}
+
+// complete completes a multipart upload with the given parts, in order.
+func complete(b *s3mem.Backend, key string, id *string, etags map[int32]*string, order ...int32) (*s3.CompleteMultipartUploadOutput, error) {
+	var parts []types.CompletedPart
+	for _, n := range order {
+		parts = append(parts, types.CompletedPart{ETag: etags[n], PartNumber: n})
+	}
+	return b.CompleteMultipartUpload(context.Background(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(testBucket),
+		Key:             aws.String(key),
+		UploadId:        id,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+}
+
+func TestMultipartUpload(t *testing.T) {
+	b := newTestBackend(t)
+	b.MinPartSize = 4
+
+	// Parts can be uploaded in any order, and are assembled by part number
+	id, etags := startUpload(t, b, "key", map[int32]string{3: "cc", 1: "aaaa", 2: "bbbb"})
+	res, err := complete(b, "key", id, etags, 1, 2, 3)
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %s", err)
+	}
+	if etag := aws.ToString(res.ETag); !strings.HasSuffix(etag, `-3"`) {
+		t.Errorf("expected a multipart ETag of 3 parts, got %s", etag)
+	}
+	if got, err := get(b, "key", nil); err != nil || got != "aaaabbbbcc" {
+		t.Errorf("expected the parts in order, got %q (%v)", got, err)
+	}
+	head, err := b.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject: %s", err)
+	}
+	if head.PartsCount != 3 || head.ContentLength != 10 {
+		t.Errorf("expected 3 parts of 10 bytes, got %d parts of %d bytes", head.PartsCount, head.ContentLength)
+	}
+	if n, err := b.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+
+	// Parts may be skipped, but must be listed in ascending order
+	id, etags = startUpload(t, b, "gaps", map[int32]string{1: "aaaa", 5: "bbbb", 9: "unused"})
+	if _, err := complete(b, "gaps", id, etags, 5, 1); !hasCode(err, "InvalidPartOrder", 400) {
+		t.Errorf("expected InvalidPartOrder, got %v", err)
+	}
+	if _, err := complete(b, "gaps", id, etags, 1, 1); !hasCode(err, "InvalidPartOrder", 400) {
+		t.Errorf("expected InvalidPartOrder for repeated parts, got %v", err)
+	}
+	if _, err := complete(b, "gaps", id, etags, 1, 5); err != nil {
+		t.Fatalf("CompleteMultipartUpload: %s", err)
+	}
+	if got, err := get(b, "gaps", nil); err != nil || got != "aaaabbbb" {
+		t.Errorf("expected only the listed parts, got %q (%v)", got, err)
+	}
+}
+
+func TestMultipartUploadErrors(t *testing.T) {
+	b := newTestBackend(t)
+	b.MinPartSize = 4
+
+	id, etags := startUpload(t, b, "key", map[int32]string{1: "aa", 2: "bbbb"})
+
+	// All but the last part must be large enough
+	if _, err := complete(b, "key", id, etags, 1, 2); !hasCode(err, "EntityTooSmall", 400) {
+		t.Errorf("expected EntityTooSmall, got %v", err)
+	}
+
+	// ETags must match the uploaded parts
+	if _, err := complete(b, "key", id, map[int32]*string{2: aws.String(`"wrong"`)}, 2); !hasCode(err, "InvalidPart", 400) {
+		t.Errorf("expected InvalidPart for a wrong ETag, got %v", err)
+	}
+	if _, err := complete(b, "key", id, etags, 2, 3); !hasCode(err, "InvalidPart", 400) {
+		t.Errorf("expected InvalidPart for a missing part, got %v", err)
+	}
+	if _, err := complete(b, "key", id, etags); !hasCode(err, "MalformedXML", 400) {
+		t.Errorf("expected MalformedXML without parts, got %v", err)
+	}
+
+	// Aborted uploads are discarded
+	_, err := b.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String("key"),
+		UploadId: id,
+	})
+	if err != nil {
+		t.Fatalf("AbortMultipartUpload: %s", err)
+	}
+	if n, err := b.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+	if _, err := complete(b, "key", id, etags, 2); !hasCode(err, "NoSuchUpload", 404) {
+		t.Errorf("expected NoSuchUpload, got %v", err)
+	}
+	if _, err := get(b, "key", nil); !hasCode(err, "NoSuchKey", 404) {
+		t.Errorf("expected no object, got %v", err)
+	}
+}
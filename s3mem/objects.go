@@ -0,0 +1,663 @@
+package s3mem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// GetObject retrieves an object, or a range of bytes from it.
+func (b *Backend) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if err := b.inject(ctx, "GetObject"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	o, err := bkt.lookup(aws.ToString(params.Key), params.VersionId, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConditions(o, params.IfMatch, params.IfNoneMatch, params.IfModifiedSince, params.IfUnmodifiedSince); err != nil {
+		return nil, err
+	}
+	if err := checkCustomerKey(o, params.SSECustomerKey); err != nil {
+		return nil, err
+	}
+
+	// Select the requested range
+	data := o.data
+	var contentRange *string
+	if params.Range != nil {
+		start, end, err := parseRange(*params.Range, int64(len(o.data)))
+		if err != nil {
+			return nil, err
+		}
+		data = o.data[start : end+1]
+		contentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.data)))
+	}
+
+	// Checksums are only returned for whole objects
+	h := o.head()
+	var cs checksums
+	if params.ChecksumMode == types.ChecksumModeEnabled && params.Range == nil {
+		cs = o.checksum.values()
+	}
+	return &s3.GetObjectOutput{
+		AcceptRanges:         h.AcceptRanges,
+		Body:                 ioutil.NopCloser(bytes.NewReader(append([]byte(nil), data...))),
+		CacheControl:         h.CacheControl,
+		ChecksumCRC32:        cs.crc32,
+		ChecksumCRC32C:       cs.crc32c,
+		ChecksumSHA1:         cs.sha1,
+		ChecksumSHA256:       cs.sha256,
+		ContentDisposition:   h.ContentDisposition,
+		ContentEncoding:      h.ContentEncoding,
+		ContentLanguage:      h.ContentLanguage,
+		ContentLength:        int64(len(data)),
+		ContentRange:         contentRange,
+		ContentType:          h.ContentType,
+		ETag:                 h.ETag,
+		Expires:              h.Expires,
+		LastModified:         h.LastModified,
+		Metadata:             h.Metadata,
+		PartsCount:           h.PartsCount,
+		SSECustomerAlgorithm: h.SSECustomerAlgorithm,
+		SSEKMSKeyId:          h.SSEKMSKeyId,
+		ServerSideEncryption: h.ServerSideEncryption,
+		StorageClass:         h.StorageClass,
+		TagCount:             o.tagCount(),
+		VersionId:            h.VersionId,
+	}, nil
+}
+
+// HeadObject retrieves an object's metadata without its contents.
+func (b *Backend) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if err := b.inject(ctx, "HeadObject"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	o, err := bkt.lookup(aws.ToString(params.Key), params.VersionId, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConditions(o, params.IfMatch, params.IfNoneMatch, params.IfModifiedSince, params.IfUnmodifiedSince); err != nil {
+		return nil, err
+	}
+	if err := checkCustomerKey(o, params.SSECustomerKey); err != nil {
+		return nil, err
+	}
+	h := o.head()
+	if params.ChecksumMode == types.ChecksumModeEnabled {
+		cs := o.checksum.values()
+		h.ChecksumCRC32, h.ChecksumCRC32C, h.ChecksumSHA1, h.ChecksumSHA256 = cs.crc32, cs.crc32c, cs.sha1, cs.sha256
+	}
+	return h, nil
+}
+
+// GetObjectTagging retrieves an object's tags, sorted by key.
+func (b *Backend) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	if err := b.inject(ctx, "GetObjectTagging"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	o, err := bkt.lookup(aws.ToString(params.Key), params.VersionId, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var q url.Values
+	if o.tagging != nil {
+		if q, err = url.ParseQuery(*o.tagging); err != nil {
+			return nil, errInvalidArgument("the object's tags are invalid")
+		}
+	}
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		tags = append(tags, types.Tag{Key: aws.String(k), Value: aws.String(q.Get(k))})
+	}
+	return &s3.GetObjectTaggingOutput{
+		TagSet:    tags,
+		VersionId: o.versionIDPtr(),
+	}, nil
+}
+
+// PutObject stores an object.
+func (b *Backend) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if err := b.inject(ctx, "PutObject"); err != nil {
+		return nil, err
+	}
+
+	// Read the body before taking the lock
+	data, err := readAll(params.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	if aws.ToString(params.Key) == "" {
+		return nil, errInvalidArgument("key cannot be empty")
+	}
+	sum, err := verifyChecksum(params.ChecksumAlgorithm, checksums{
+		crc32:  params.ChecksumCRC32,
+		crc32c: params.ChecksumCRC32C,
+		sha1:   params.ChecksumSHA1,
+		sha256: params.ChecksumSHA256,
+	}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &object{
+		key:                  aws.ToString(params.Key),
+		data:                 data,
+		etag:                 etag(data),
+		checksum:             sum,
+		lastModified:         b.now(),
+		metadata:             copyMetadata(params.Metadata),
+		cacheControl:         params.CacheControl,
+		contentDisposition:   params.ContentDisposition,
+		contentEncoding:      params.ContentEncoding,
+		contentLanguage:      params.ContentLanguage,
+		contentType:          params.ContentType,
+		expires:              params.Expires,
+		storageClass:         params.StorageClass,
+		tagging:              params.Tagging,
+		sse:                  params.ServerSideEncryption,
+		sseKMSKeyID:          params.SSEKMSKeyId,
+		sseKMSContext:        params.SSEKMSEncryptionContext,
+		sseCustomerAlgorithm: params.SSECustomerAlgorithm,
+		sseCustomerKey:       params.SSECustomerKey,
+	}
+	b.put(bkt, o)
+
+	cs := sum.values()
+	return &s3.PutObjectOutput{
+		ChecksumCRC32:        cs.crc32,
+		ChecksumCRC32C:       cs.crc32c,
+		ChecksumSHA1:         cs.sha1,
+		ChecksumSHA256:       cs.sha256,
+		ETag:                 aws.String(o.etag),
+		SSECustomerAlgorithm: o.sseCustomerAlgorithm,
+		SSEKMSKeyId:          o.sseKMSKeyID,
+		ServerSideEncryption: o.sse,
+		VersionId:            o.versionIDPtr(),
+	}, nil
+}
+
+// CopyObject copies an object, optionally replacing its metadata.
+func (b *Backend) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	if err := b.inject(ctx, "CopyObject"); err != nil {
+		return nil, err
+	}
+
+	srcBucket, srcKey, srcVersion, err := parseCopySource(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Find the source object
+	sbkt, err := b.bucket(srcBucket)
+	if err != nil {
+		return nil, err
+	}
+	src, err := sbkt.lookup(srcKey, srcVersion, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCopyConditions(src, params); err != nil {
+		return nil, err
+	}
+	if err := checkCustomerKey(src, params.CopySourceSSECustomerKey); err != nil {
+		return nil, err
+	}
+
+	// Find the destination bucket
+	dbkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	dstKey := aws.ToString(params.Key)
+
+	// S3 refuses to copy an object onto itself without changing anything
+	replace := params.MetadataDirective == types.MetadataDirectiveReplace
+	if sbkt == dbkt && srcKey == dstKey && !replace &&
+		params.StorageClass == "" && params.ServerSideEncryption == "" &&
+		params.SSECustomerKey == nil && params.WebsiteRedirectLocation == nil {
+		return nil, errInvalidRequest("this copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes")
+	}
+
+	o := &object{
+		key:                  dstKey,
+		data:                 src.data,
+		etag:                 src.etag,
+		lastModified:         b.now(),
+		partsCount:           src.partsCount,
+		checksum:             src.checksum,
+		storageClass:         params.StorageClass,
+		sse:                  params.ServerSideEncryption,
+		sseKMSKeyID:          params.SSEKMSKeyId,
+		sseKMSContext:        params.SSEKMSEncryptionContext,
+		sseCustomerAlgorithm: params.SSECustomerAlgorithm,
+		sseCustomerKey:       params.SSECustomerKey,
+	}
+
+	// Copy or replace the metadata
+	if replace {
+		o.metadata = copyMetadata(params.Metadata)
+		o.cacheControl = params.CacheControl
+		o.contentDisposition = params.ContentDisposition
+		o.contentEncoding = params.ContentEncoding
+		o.contentLanguage = params.ContentLanguage
+		o.contentType = params.ContentType
+		o.expires = params.Expires
+	} else {
+		o.metadata = copyMetadata(src.metadata)
+		o.cacheControl = src.cacheControl
+		o.contentDisposition = src.contentDisposition
+		o.contentEncoding = src.contentEncoding
+		o.contentLanguage = src.contentLanguage
+		o.contentType = src.contentType
+		o.expires = src.expires
+	}
+
+	// Recalculate the checksum with a new algorithm, if requested
+	if params.ChecksumAlgorithm != "" {
+		if o.checksum, err = verifyChecksum(params.ChecksumAlgorithm, checksums{}, o.data); err != nil {
+			return nil, err
+		}
+	}
+
+	// Copy or replace the tags
+	if params.TaggingDirective == types.TaggingDirectiveReplace {
+		o.tagging = params.Tagging
+	} else {
+		o.tagging = src.tagging
+	}
+
+	b.put(dbkt, o)
+
+	cs := o.checksum.values()
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &types.CopyObjectResult{
+			ChecksumCRC32:  cs.crc32,
+			ChecksumCRC32C: cs.crc32c,
+			ChecksumSHA1:   cs.sha1,
+			ChecksumSHA256: cs.sha256,
+			ETag:           aws.String(o.etag),
+			LastModified:   aws.Time(o.lastModified),
+		},
+		CopySourceVersionId:     src.versionIDPtr(),
+		SSECustomerAlgorithm:    o.sseCustomerAlgorithm,
+		SSEKMSEncryptionContext: o.sseKMSContext,
+		SSEKMSKeyId:             o.sseKMSKeyID,
+		ServerSideEncryption:    o.sse,
+		VersionId:               o.versionIDPtr(),
+	}, nil
+}
+
+// DeleteObject deletes an object. In a versioned bucket, a delete marker
+// is added unless a specific version is deleted.
+func (b *Backend) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	if err := b.inject(ctx, "DeleteObject"); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+	marker, versionID := b.delete(bkt, aws.ToString(params.Key), params.VersionId)
+	return &s3.DeleteObjectOutput{
+		DeleteMarker: marker,
+		VersionId:    versionID,
+	}, nil
+}
+
+// DeleteObjects deletes multiple objects in a single request.
+func (b *Backend) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if err := b.inject(ctx, "DeleteObjects"); err != nil {
+		return nil, err
+	}
+	if params.Delete == nil {
+		return nil, errInvalidArgument("delete cannot be nil")
+	}
+	if len(params.Delete.Objects) > int(DefaultMaxKeys) {
+		return nil, errInvalidArgument(fmt.Sprintf("cannot delete more than %d objects in one request", DefaultMaxKeys))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bkt, err := b.bucket(aws.ToString(params.Bucket))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &s3.DeleteObjectsOutput{}
+	for _, id := range params.Delete.Objects {
+		marker, versionID := b.delete(bkt, aws.ToString(id.Key), id.VersionId)
+		if params.Delete.Quiet {
+			continue
+		}
+		d := types.DeletedObject{
+			Key:          id.Key,
+			VersionId:    id.VersionId,
+			DeleteMarker: marker,
+		}
+		if marker {
+			d.DeleteMarkerVersionId = versionID
+		}
+		out.Deleted = append(out.Deleted, d)
+	}
+	return out, nil
+}
+
+// delete deletes key (or a version of it) from the bucket, returning
+// whether a delete marker was involved and its version ID. The caller must
+// hold b.mu.
+func (b *Backend) delete(bkt *bucket, key string, versionID *string) (bool, *string) {
+	// Delete a specific version
+	if versionID != nil {
+		vs := bkt.objects[key]
+		for i, o := range vs {
+			if o.versionID == *versionID {
+				bkt.objects[key] = append(vs[:i], vs[i+1:]...)
+				if len(bkt.objects[key]) == 0 {
+					delete(bkt.objects, key)
+				}
+				return o.deleteMarker, versionID
+			}
+		}
+		return false, versionID
+	}
+
+	// Without versioning, the object is removed
+	if !bkt.versioning {
+		delete(bkt.objects, key)
+		return false, nil
+	}
+
+	// With versioning, a delete marker is added
+	o := &object{
+		key:          key,
+		deleteMarker: true,
+		lastModified: b.now(),
+	}
+	b.put(bkt, o)
+	return true, aws.String(o.versionID)
+}
+
+// lookup finds the requested version of key (or the latest version, if
+// versionID is nil). HeadObject requests get a NotFound error rather than
+// NoSuchKey.
+func (bkt *bucket) lookup(key string, versionID *string, head bool) (*object, error) {
+	var o *object
+	if versionID != nil {
+		o = bkt.version(key, *versionID)
+		if o == nil && !head {
+			return nil, errNoSuchVersion(key, *versionID)
+		}
+	} else {
+		o = bkt.latest(key)
+	}
+	if o == nil || o.deleteMarker {
+		if head {
+			return nil, errNotFound(key)
+		}
+		return nil, errNoSuchKey(key)
+	}
+	return o, nil
+}
+
+// head describes the object as a HeadObjectOutput.
+func (o *object) head() *s3.HeadObjectOutput {
+	return &s3.HeadObjectOutput{
+		AcceptRanges:         aws.String("bytes"),
+		CacheControl:         o.cacheControl,
+		ContentDisposition:   o.contentDisposition,
+		ContentEncoding:      o.contentEncoding,
+		ContentLanguage:      o.contentLanguage,
+		ContentLength:        int64(len(o.data)),
+		ContentType:          o.contentType,
+		ETag:                 aws.String(o.etag),
+		Expires:              o.expires,
+		LastModified:         aws.Time(o.lastModified),
+		Metadata:             copyMetadata(o.metadata),
+		PartsCount:           o.partsCount,
+		SSECustomerAlgorithm: o.sseCustomerAlgorithm,
+		SSEKMSKeyId:          o.sseKMSKeyID,
+		ServerSideEncryption: o.sse,
+		StorageClass:         o.storageClass,
+		VersionId:            o.versionIDPtr(),
+	}
+}
+
+// versionIDPtr returns the object's version ID, or nil if the object was
+// written without versioning.
+func (o *object) versionIDPtr() *string {
+	if o.versionID == "null" {
+		return nil
+	}
+	return aws.String(o.versionID)
+}
+
+// tagCount returns the number of tags on the object.
+func (o *object) tagCount() int32 {
+	if o.tagging == nil || *o.tagging == "" {
+		return 0
+	}
+	q, err := url.ParseQuery(*o.tagging)
+	if err != nil {
+		return 0
+	}
+	return int32(len(q))
+}
+
+// checkConditions evaluates the conditional request headers, following
+// the precedence rules S3 uses: If-Match takes precedence over
+// If-Unmodified-Since, and If-None-Match over If-Modified-Since.
+func checkConditions(o *object, ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) error {
+	if ifMatch != nil {
+		if !etagMatches(*ifMatch, o.etag) {
+			return errPreconditionFailed()
+		}
+	} else if ifUnmodifiedSince != nil && o.lastModified.After(*ifUnmodifiedSince) {
+		return errPreconditionFailed()
+	}
+
+	if ifNoneMatch != nil {
+		if etagMatches(*ifNoneMatch, o.etag) {
+			return errNotModified()
+		}
+	} else if ifModifiedSince != nil && !o.lastModified.After(*ifModifiedSince) {
+		return errNotModified()
+	}
+	return nil
+}
+
+// checkCopyConditions evaluates the CopySourceIf* headers. Unlike
+// GetObject, any failed condition results in a 412 error.
+func checkCopyConditions(o *object, params *s3.CopyObjectInput) error {
+	err := checkConditions(o, params.CopySourceIfMatch, params.CopySourceIfNoneMatch, params.CopySourceIfModifiedSince, params.CopySourceIfUnmodifiedSince)
+	if err != nil {
+		return errPreconditionFailed()
+	}
+	return nil
+}
+
+// checkCustomerKey checks that the customer-provided encryption key (if
+// any) matches the one the object was stored with.
+func checkCustomerKey(o *object, key *string) error {
+	switch {
+	case o.sseCustomerKey == nil && key == nil:
+		return nil
+	case o.sseCustomerKey == nil:
+		return errInvalidRequest("the encryption parameters are not applicable to this object")
+	case key == nil:
+		return errInvalidRequest("the object was stored using a form of server side encryption, the correct parameters must be provided to retrieve the object")
+	case *key != *o.sseCustomerKey:
+		return genericError(403, "AccessDenied", "the provided customer encryption key does not match the one the object was stored with")
+	}
+	return nil
+}
+
+// etagMatches reports whether the ETag matches the comma-separated list of
+// ETags in an If-Match or If-None-Match header.
+func etagMatches(header, etag string) bool {
+	for _, e := range strings.Split(header, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" || strings.Trim(e, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a single HTTP byte range ("bytes=a-b", "bytes=a-" or
+// "bytes=-n") for an object of the given size, returning the inclusive
+// start and end offsets.
+func parseRange(r string, size int64) (int64, int64, error) {
+	spec := strings.TrimPrefix(r, "bytes=")
+	if spec == r || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange(r)
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, errInvalidRange(r)
+	}
+	first, last := spec[:i], spec[i+1:]
+
+	var start, end int64
+	switch {
+	case first == "" && last == "":
+		return 0, 0, errInvalidRange(r)
+
+	case first == "":
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, errInvalidRange(r)
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+
+	default:
+		var err error
+		start, err = strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, errInvalidRange(r)
+		}
+		end = size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, errInvalidRange(r)
+			}
+			if end > size-1 {
+				end = size - 1
+			}
+		}
+	}
+
+	if start >= size || start > end {
+		return 0, 0, errInvalidRange(r)
+	}
+	return start, end, nil
+}
+
+// parseCopySource parses a CopyObject CopySource header of the form
+// "bucket/key" or "bucket/key?versionId=id", where the key is URL-encoded.
+func parseCopySource(src string) (string, string, *string, error) {
+	var versionID *string
+	if i := strings.Index(src, "?"); i >= 0 {
+		q, err := url.ParseQuery(src[i+1:])
+		if err != nil {
+			return "", "", nil, errInvalidArgument(fmt.Sprintf("invalid copy source %q", src))
+		}
+		if v := q.Get("versionId"); v != "" {
+			versionID = aws.String(v)
+		}
+		src = src[:i]
+	}
+
+	src, err := url.PathUnescape(strings.TrimPrefix(src, "/"))
+	if err != nil {
+		return "", "", nil, errInvalidArgument(fmt.Sprintf("invalid copy source %q", src))
+	}
+	i := strings.Index(src, "/")
+	if i <= 0 || i == len(src)-1 {
+		return "", "", nil, errInvalidArgument(fmt.Sprintf("invalid copy source %q", src))
+	}
+	return src[:i], src[i+1:], versionID, nil
+}
+
+// copyMetadata returns a copy of the metadata map, with keys lower-cased
+// the way S3 returns them.
+func copyMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+	res := make(map[string]string, len(md))
+	for k, v := range md {
+		res[strings.ToLower(k)] = v
+	}
+	return res
+}
+
+// readAll reads r fully, treating a nil reader as empty.
+func readAll(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return ioutil.ReadAll(r)
+}
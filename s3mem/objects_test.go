@@ -0,0 +1,123 @@
+package s3mem_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestVersioning(t *testing.T) {
+	b := newTestBackend(t)
+	if err := b.SetVersioning(testBucket, true); err != nil {
+		t.Fatalf("SetVersioning: %s", err)
+	}
+	ctx := context.Background()
+
+	v1 := put(t, b, "key", "one")
+	v2 := put(t, b, "key", "two")
+	if v1 == v2 || v1 == "null" {
+		t.Fatalf("expected distinct version IDs, got %q and %q", v1, v2)
+	}
+	if got, err := get(b, "key", nil); err != nil || got != "two" {
+		t.Errorf("expected the latest version, got %q (%v)", got, err)
+	}
+	if got, err := get(b, "key", aws.String(v1)); err != nil || got != "one" {
+		t.Errorf("expected the first version, got %q (%v)", got, err)
+	}
+	if _, err := get(b, "key", aws.String("missing")); !hasCode(err, "NoSuchVersion", 404) {
+		t.Errorf("expected NoSuchVersion, got %v", err)
+	}
+
+	// Deleting the object adds a delete marker, hiding it
+	res, err := b.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+	})
+	if err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+	if !res.DeleteMarker || res.VersionId == nil {
+		t.Fatalf("expected a delete marker, got %+v", res)
+	}
+	if _, err := get(b, "key", nil); !hasCode(err, "NoSuchKey", 404) {
+		t.Errorf("expected NoSuchKey, got %v", err)
+	}
+	_, err = b.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+	})
+	if !hasCode(err, "NotFound", 404) {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	list, err := b.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(testBucket)})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %s", err)
+	}
+	if len(list.Contents) != 0 {
+		t.Errorf("expected deleted objects not to be listed, got %d objects", len(list.Contents))
+	}
+
+	// Older versions can still be read
+	if got, err := get(b, "key", aws.String(v2)); err != nil || got != "two" {
+		t.Errorf("expected the second version, got %q (%v)", got, err)
+	}
+
+	// Deleting the delete marker restores the object
+	res, err = b.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(testBucket),
+		Key:       aws.String("key"),
+		VersionId: res.VersionId,
+	})
+	if err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+	if !res.DeleteMarker {
+		t.Errorf("expected the delete marker to be removed")
+	}
+	if got, err := get(b, "key", nil); err != nil || got != "two" {
+		t.Errorf("expected the object to be restored, got %q (%v)", got, err)
+	}
+
+	// Deleting a version removes only that version
+	_, err = b.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(testBucket),
+		Key:       aws.String("key"),
+		VersionId: aws.String(v2),
+	})
+	if err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+	if got, err := get(b, "key", nil); err != nil || got != "one" {
+		t.Errorf("expected the first version to be the latest, got %q (%v)", got, err)
+	}
+}
+
+func TestVersioningDisabled(t *testing.T) {
+	b := newTestBackend(t)
+
+	// Writes have no version ID, and replace the "null" version
+	if v := put(t, b, "key", "one"); v != "" {
+		t.Errorf("expected no version ID, got %q", v)
+	}
+	put(t, b, "key", "two")
+	if got, err := get(b, "key", aws.String("null")); err != nil || got != "two" {
+		t.Errorf("expected the null version to be replaced, got %q (%v)", got, err)
+	}
+
+	// Deletes remove the object without a delete marker
+	res, err := b.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("key"),
+	})
+	if err != nil {
+		t.Fatalf("DeleteObject: %s", err)
+	}
+	if res.DeleteMarker {
+		t.Errorf("expected no delete marker")
+	}
+	if _, err := get(b, "key", nil); !hasCode(err, "NoSuchKey", 404) {
+		t.Errorf("expected NoSuchKey, got %v", err)
+	}
+}
@@ -0,0 +1,760 @@
+package s3mem
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// xmlNS is the namespace used in S3's XML responses.
+const xmlNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// xmlTimeFormat is the timestamp format used in S3's XML responses.
+const xmlTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Handler returns an http.Handler that serves the Backend over S3's REST
+// API, so it can be used with a real S3 client (e.g. via an
+// httptest.Server). Only path-style requests are supported, and requests
+// are not authenticated.
+//
+// The handler supports the same operations as the Backend itself.
+func (b *Backend) Handler() http.Handler {
+	return http.HandlerFunc(b.serveHTTP)
+}
+
+// serveHTTP routes a request to the matching operation.
+func (b *Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Split the path into the bucket and key
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	bkt, key := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		bkt, key = p[:i], p[i+1:]
+	}
+	if bkt == "" {
+		writeError(w, r, errInvalidRequest("missing bucket name"))
+		return
+	}
+
+	q := r.URL.Query()
+	var err error
+	switch {
+	case key == "" && r.Method == http.MethodGet && q.Get("list-type") == "2":
+		err = b.serveListObjectsV2(w, r, bkt)
+	case key == "" && r.Method == http.MethodPost && q.Has("delete"):
+		err = b.serveDeleteObjects(w, r, bkt)
+	case key == "":
+		err = genericError(http.StatusNotImplemented, "NotImplemented", "the requested bucket operation is not implemented")
+
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		err = b.serveCreateMultipartUpload(w, r, bkt, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		err = b.serveCompleteMultipartUpload(w, r, bkt, key)
+	case r.Method == http.MethodPut && q.Has("uploadId") && r.Header.Get("x-amz-copy-source") != "":
+		err = b.serveUploadPartCopy(w, r, bkt, key)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		err = b.serveUploadPart(w, r, bkt, key)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		err = b.serveAbortMultipartUpload(w, r, bkt, key)
+
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		err = b.serveCopyObject(w, r, bkt, key)
+	case r.Method == http.MethodPut:
+		err = b.servePutObject(w, r, bkt, key)
+	case r.Method == http.MethodGet && q.Has("tagging"):
+		err = b.serveGetObjectTagging(w, r, bkt, key)
+	case r.Method == http.MethodGet:
+		err = b.serveGetObject(w, r, bkt, key)
+	case r.Method == http.MethodHead:
+		err = b.serveHeadObject(w, r, bkt, key)
+	case r.Method == http.MethodDelete:
+		err = b.serveDeleteObject(w, r, bkt, key)
+	default:
+		err = genericError(http.StatusMethodNotAllowed, "MethodNotAllowed", "the specified method is not allowed against this resource")
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (b *Backend) serveGetObject(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	in := &s3.GetObjectInput{
+		Bucket:            aws.String(bkt),
+		Key:               aws.String(key),
+		IfMatch:           header(r, "If-Match"),
+		IfNoneMatch:       header(r, "If-None-Match"),
+		IfModifiedSince:   headerTime(r, "If-Modified-Since"),
+		IfUnmodifiedSince: headerTime(r, "If-Unmodified-Since"),
+		Range:             header(r, "Range"),
+		SSECustomerKey:    header(r, "x-amz-server-side-encryption-customer-key"),
+		VersionId:         query(r, "versionId"),
+		ChecksumMode:      types.ChecksumMode(strings.ToUpper(r.Header.Get("x-amz-checksum-mode"))),
+	}
+	out, err := b.GetObject(r.Context(), in)
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	h := w.Header()
+	writeObjectHeaders(h, &s3.HeadObjectOutput{
+		AcceptRanges:         out.AcceptRanges,
+		CacheControl:         out.CacheControl,
+		ChecksumCRC32:        out.ChecksumCRC32,
+		ChecksumCRC32C:       out.ChecksumCRC32C,
+		ChecksumSHA1:         out.ChecksumSHA1,
+		ChecksumSHA256:       out.ChecksumSHA256,
+		ContentDisposition:   out.ContentDisposition,
+		ContentEncoding:      out.ContentEncoding,
+		ContentLanguage:      out.ContentLanguage,
+		ContentLength:        out.ContentLength,
+		ContentType:          out.ContentType,
+		ETag:                 out.ETag,
+		Expires:              out.Expires,
+		LastModified:         out.LastModified,
+		Metadata:             out.Metadata,
+		PartsCount:           out.PartsCount,
+		SSECustomerAlgorithm: out.SSECustomerAlgorithm,
+		SSEKMSKeyId:          out.SSEKMSKeyId,
+		ServerSideEncryption: out.ServerSideEncryption,
+		StorageClass:         out.StorageClass,
+		VersionId:            out.VersionId,
+	})
+	if out.TagCount > 0 {
+		h.Set("x-amz-tagging-count", strconv.Itoa(int(out.TagCount)))
+	}
+	status := http.StatusOK
+	if out.ContentRange != nil {
+		h.Set("Content-Range", *out.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+func (b *Backend) serveHeadObject(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	out, err := b.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket:            aws.String(bkt),
+		Key:               aws.String(key),
+		IfMatch:           header(r, "If-Match"),
+		IfNoneMatch:       header(r, "If-None-Match"),
+		IfModifiedSince:   headerTime(r, "If-Modified-Since"),
+		IfUnmodifiedSince: headerTime(r, "If-Unmodified-Since"),
+		SSECustomerKey:    header(r, "x-amz-server-side-encryption-customer-key"),
+		VersionId:         query(r, "versionId"),
+		ChecksumMode:      types.ChecksumMode(strings.ToUpper(r.Header.Get("x-amz-checksum-mode"))),
+	})
+	if err != nil {
+		return err
+	}
+	writeObjectHeaders(w.Header(), out)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *Backend) serveGetObjectTagging(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	out, err := b.GetObjectTagging(r.Context(), &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(bkt),
+		Key:       aws.String(key),
+		VersionId: query(r, "versionId"),
+	})
+	if err != nil {
+		return err
+	}
+	type tag struct {
+		Key   string `xml:"Key"`
+		Value string `xml:"Value"`
+	}
+	res := struct {
+		XMLName xml.Name `xml:"Tagging"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		TagSet  []tag    `xml:"TagSet>Tag"`
+	}{XMLNS: xmlNS}
+	for _, t := range out.TagSet {
+		res.TagSet = append(res.TagSet, tag{Key: aws.ToString(t.Key), Value: aws.ToString(t.Value)})
+	}
+	setHeader(w.Header(), "x-amz-version-id", out.VersionId)
+	return writeXML(w, res)
+}
+
+func (b *Backend) servePutObject(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	cs := checksumHeaders(r)
+	out, err := b.PutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:                  aws.String(bkt),
+		Key:                     aws.String(key),
+		Body:                    r.Body,
+		ChecksumAlgorithm:       checksumAlgorithm(r, "x-amz-sdk-checksum-algorithm"),
+		ChecksumCRC32:           cs.crc32,
+		ChecksumCRC32C:          cs.crc32c,
+		ChecksumSHA1:            cs.sha1,
+		ChecksumSHA256:          cs.sha256,
+		CacheControl:            header(r, "Cache-Control"),
+		ContentDisposition:      header(r, "Content-Disposition"),
+		ContentEncoding:         header(r, "Content-Encoding"),
+		ContentLanguage:         header(r, "Content-Language"),
+		ContentType:             header(r, "Content-Type"),
+		Expires:                 headerTime(r, "Expires"),
+		Metadata:                metadata(r),
+		StorageClass:            types.StorageClass(r.Header.Get("x-amz-storage-class")),
+		Tagging:                 header(r, "x-amz-tagging"),
+		ServerSideEncryption:    types.ServerSideEncryption(r.Header.Get("x-amz-server-side-encryption")),
+		SSEKMSKeyId:             header(r, "x-amz-server-side-encryption-aws-kms-key-id"),
+		SSEKMSEncryptionContext: header(r, "x-amz-server-side-encryption-context"),
+		SSECustomerAlgorithm:    header(r, "x-amz-server-side-encryption-customer-algorithm"),
+		SSECustomerKey:          header(r, "x-amz-server-side-encryption-customer-key"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	setHeader(h, "ETag", out.ETag)
+	setHeader(h, "x-amz-version-id", out.VersionId)
+	writeChecksumHeaders(h, checksums{out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256})
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, out.SSECustomerAlgorithm)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *Backend) serveCopyObject(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	out, err := b.CopyObject(r.Context(), &s3.CopyObjectInput{
+		Bucket:                      aws.String(bkt),
+		Key:                         aws.String(key),
+		CopySource:                  header(r, "x-amz-copy-source"),
+		ChecksumAlgorithm:           checksumAlgorithm(r, "x-amz-checksum-algorithm"),
+		CopySourceIfMatch:           header(r, "x-amz-copy-source-if-match"),
+		CopySourceIfNoneMatch:       header(r, "x-amz-copy-source-if-none-match"),
+		CopySourceIfModifiedSince:   headerTime(r, "x-amz-copy-source-if-modified-since"),
+		CopySourceIfUnmodifiedSince: headerTime(r, "x-amz-copy-source-if-unmodified-since"),
+		CopySourceSSECustomerKey:    header(r, "x-amz-copy-source-server-side-encryption-customer-key"),
+		CacheControl:                header(r, "Cache-Control"),
+		ContentDisposition:          header(r, "Content-Disposition"),
+		ContentEncoding:             header(r, "Content-Encoding"),
+		ContentLanguage:             header(r, "Content-Language"),
+		ContentType:                 header(r, "Content-Type"),
+		Expires:                     headerTime(r, "Expires"),
+		Metadata:                    metadata(r),
+		MetadataDirective:           types.MetadataDirective(r.Header.Get("x-amz-metadata-directive")),
+		StorageClass:                types.StorageClass(r.Header.Get("x-amz-storage-class")),
+		Tagging:                     header(r, "x-amz-tagging"),
+		TaggingDirective:            types.TaggingDirective(r.Header.Get("x-amz-tagging-directive")),
+		ServerSideEncryption:        types.ServerSideEncryption(r.Header.Get("x-amz-server-side-encryption")),
+		SSEKMSKeyId:                 header(r, "x-amz-server-side-encryption-aws-kms-key-id"),
+		SSEKMSEncryptionContext:     header(r, "x-amz-server-side-encryption-context"),
+		SSECustomerAlgorithm:        header(r, "x-amz-server-side-encryption-customer-algorithm"),
+		SSECustomerKey:              header(r, "x-amz-server-side-encryption-customer-key"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	setHeader(h, "x-amz-version-id", out.VersionId)
+	setHeader(h, "x-amz-copy-source-version-id", out.CopySourceVersionId)
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, out.SSECustomerAlgorithm)
+	res := out.CopyObjectResult
+	return writeXML(w, struct {
+		XMLName        xml.Name `xml:"CopyObjectResult"`
+		XMLNS          string   `xml:"xmlns,attr"`
+		ETag           string   `xml:"ETag"`
+		LastModified   string   `xml:"LastModified"`
+		ChecksumCRC32  *string  `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C *string  `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   *string  `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 *string  `xml:"ChecksumSHA256,omitempty"`
+	}{
+		XMLNS:          xmlNS,
+		ETag:           aws.ToString(res.ETag),
+		LastModified:   aws.ToTime(res.LastModified).Format(xmlTimeFormat),
+		ChecksumCRC32:  res.ChecksumCRC32,
+		ChecksumCRC32C: res.ChecksumCRC32C,
+		ChecksumSHA1:   res.ChecksumSHA1,
+		ChecksumSHA256: res.ChecksumSHA256,
+	})
+}
+
+func (b *Backend) serveDeleteObject(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	out, err := b.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket:    aws.String(bkt),
+		Key:       aws.String(key),
+		VersionId: query(r, "versionId"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	if out.DeleteMarker {
+		h.Set("x-amz-delete-marker", "true")
+	}
+	setHeader(h, "x-amz-version-id", out.VersionId)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (b *Backend) serveDeleteObjects(w http.ResponseWriter, r *http.Request, bkt string) error {
+	var req struct {
+		Quiet   bool `xml:"Quiet"`
+		Objects []struct {
+			Key       string  `xml:"Key"`
+			VersionId *string `xml:"VersionId"`
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return genericError(http.StatusBadRequest, "MalformedXML", err.Error())
+	}
+	del := &types.Delete{Quiet: req.Quiet}
+	for _, o := range req.Objects {
+		del.Objects = append(del.Objects, types.ObjectIdentifier{
+			Key:       aws.String(o.Key),
+			VersionId: o.VersionId,
+		})
+	}
+
+	out, err := b.DeleteObjects(r.Context(), &s3.DeleteObjectsInput{
+		Bucket: aws.String(bkt),
+		Delete: del,
+	})
+	if err != nil {
+		return err
+	}
+
+	type deleted struct {
+		Key                   string  `xml:"Key"`
+		VersionId             *string `xml:"VersionId,omitempty"`
+		DeleteMarker          bool    `xml:"DeleteMarker,omitempty"`
+		DeleteMarkerVersionId *string `xml:"DeleteMarkerVersionId,omitempty"`
+	}
+	res := struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		XMLNS   string    `xml:"xmlns,attr"`
+		Deleted []deleted `xml:"Deleted"`
+	}{XMLNS: xmlNS}
+	for _, d := range out.Deleted {
+		res.Deleted = append(res.Deleted, deleted{
+			Key:                   aws.ToString(d.Key),
+			VersionId:             d.VersionId,
+			DeleteMarker:          d.DeleteMarker,
+			DeleteMarkerVersionId: d.DeleteMarkerVersionId,
+		})
+	}
+	return writeXML(w, res)
+}
+
+func (b *Backend) serveListObjectsV2(w http.ResponseWriter, r *http.Request, bkt string) error {
+	var maxKeys int32
+	if v := r.URL.Query().Get("max-keys"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return errInvalidArgument(fmt.Sprintf("invalid max-keys %q", v))
+		}
+		maxKeys = int32(n)
+	}
+	out, err := b.ListObjectsV2(r.Context(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(bkt),
+		ContinuationToken: query(r, "continuation-token"),
+		Delimiter:         query(r, "delimiter"),
+		FetchOwner:        r.URL.Query().Get("fetch-owner") == "true",
+		MaxKeys:           maxKeys,
+		Prefix:            query(r, "prefix"),
+		StartAfter:        query(r, "start-after"),
+	})
+	if err != nil {
+		return err
+	}
+
+	type owner struct {
+		ID          string `xml:"ID"`
+		DisplayName string `xml:"DisplayName"`
+	}
+	type content struct {
+		Key               string   `xml:"Key"`
+		LastModified      string   `xml:"LastModified"`
+		ETag              string   `xml:"ETag"`
+		ChecksumAlgorithm []string `xml:"ChecksumAlgorithm"`
+		Size              int64    `xml:"Size"`
+		Owner             *owner   `xml:"Owner,omitempty"`
+		StorageClass      string   `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	res := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		XMLNS                 string         `xml:"xmlns,attr"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             *string        `xml:"Delimiter,omitempty"`
+		MaxKeys               int32          `xml:"MaxKeys"`
+		KeyCount              int32          `xml:"KeyCount"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		ContinuationToken     *string        `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken *string        `xml:"NextContinuationToken,omitempty"`
+		StartAfter            *string        `xml:"StartAfter,omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		XMLNS:                 xmlNS,
+		Name:                  bkt,
+		Prefix:                aws.ToString(out.Prefix),
+		Delimiter:             out.Delimiter,
+		MaxKeys:               out.MaxKeys,
+		KeyCount:              out.KeyCount,
+		IsTruncated:           out.IsTruncated,
+		ContinuationToken:     out.ContinuationToken,
+		NextContinuationToken: out.NextContinuationToken,
+		StartAfter:            out.StartAfter,
+	}
+	for _, o := range out.Contents {
+		c := content{
+			Key:          aws.ToString(o.Key),
+			LastModified: aws.ToTime(o.LastModified).Format(xmlTimeFormat),
+			ETag:         aws.ToString(o.ETag),
+			Size:         o.Size,
+			StorageClass: string(o.StorageClass),
+		}
+		for _, alg := range o.ChecksumAlgorithm {
+			c.ChecksumAlgorithm = append(c.ChecksumAlgorithm, string(alg))
+		}
+		if o.Owner != nil {
+			c.Owner = &owner{ID: aws.ToString(o.Owner.ID), DisplayName: aws.ToString(o.Owner.DisplayName)}
+		}
+		res.Contents = append(res.Contents, c)
+	}
+	for _, cp := range out.CommonPrefixes {
+		res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: aws.ToString(cp.Prefix)})
+	}
+	return writeXML(w, res)
+}
+
+func (b *Backend) serveCreateMultipartUpload(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	out, err := b.CreateMultipartUpload(r.Context(), &s3.CreateMultipartUploadInput{
+		Bucket:                  aws.String(bkt),
+		Key:                     aws.String(key),
+		ChecksumAlgorithm:       checksumAlgorithm(r, "x-amz-checksum-algorithm"),
+		CacheControl:            header(r, "Cache-Control"),
+		ContentDisposition:      header(r, "Content-Disposition"),
+		ContentEncoding:         header(r, "Content-Encoding"),
+		ContentLanguage:         header(r, "Content-Language"),
+		ContentType:             header(r, "Content-Type"),
+		Expires:                 headerTime(r, "Expires"),
+		Metadata:                metadata(r),
+		StorageClass:            types.StorageClass(r.Header.Get("x-amz-storage-class")),
+		Tagging:                 header(r, "x-amz-tagging"),
+		ServerSideEncryption:    types.ServerSideEncryption(r.Header.Get("x-amz-server-side-encryption")),
+		SSEKMSKeyId:             header(r, "x-amz-server-side-encryption-aws-kms-key-id"),
+		SSEKMSEncryptionContext: header(r, "x-amz-server-side-encryption-context"),
+		SSECustomerAlgorithm:    header(r, "x-amz-server-side-encryption-customer-algorithm"),
+		SSECustomerKey:          header(r, "x-amz-server-side-encryption-customer-key"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	if out.ChecksumAlgorithm != "" {
+		h.Set("x-amz-checksum-algorithm", string(out.ChecksumAlgorithm))
+	}
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, out.SSECustomerAlgorithm)
+	return writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadId string   `xml:"UploadId"`
+	}{
+		XMLNS:    xmlNS,
+		Bucket:   bkt,
+		Key:      key,
+		UploadId: aws.ToString(out.UploadId),
+	})
+}
+
+func (b *Backend) serveUploadPart(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	pn, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+	if err != nil {
+		return errInvalidArgument("invalid part number")
+	}
+	cs := checksumHeaders(r)
+	out, err := b.UploadPart(r.Context(), &s3.UploadPartInput{
+		Bucket:            aws.String(bkt),
+		Key:               aws.String(key),
+		PartNumber:        int32(pn),
+		UploadId:          query(r, "uploadId"),
+		Body:              r.Body,
+		ChecksumAlgorithm: checksumAlgorithm(r, "x-amz-sdk-checksum-algorithm"),
+		ChecksumCRC32:     cs.crc32,
+		ChecksumCRC32C:    cs.crc32c,
+		ChecksumSHA1:      cs.sha1,
+		ChecksumSHA256:    cs.sha256,
+		SSECustomerKey:    header(r, "x-amz-server-side-encryption-customer-key"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	setHeader(h, "ETag", out.ETag)
+	writeChecksumHeaders(h, checksums{out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256})
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, out.SSECustomerAlgorithm)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *Backend) serveUploadPartCopy(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	pn, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+	if err != nil {
+		return errInvalidArgument("invalid part number")
+	}
+	out, err := b.UploadPartCopy(r.Context(), &s3.UploadPartCopyInput{
+		Bucket:                      aws.String(bkt),
+		Key:                         aws.String(key),
+		PartNumber:                  int32(pn),
+		UploadId:                    query(r, "uploadId"),
+		CopySource:                  header(r, "x-amz-copy-source"),
+		CopySourceRange:             header(r, "x-amz-copy-source-range"),
+		CopySourceIfMatch:           header(r, "x-amz-copy-source-if-match"),
+		CopySourceIfNoneMatch:       header(r, "x-amz-copy-source-if-none-match"),
+		CopySourceIfModifiedSince:   headerTime(r, "x-amz-copy-source-if-modified-since"),
+		CopySourceIfUnmodifiedSince: headerTime(r, "x-amz-copy-source-if-unmodified-since"),
+		CopySourceSSECustomerKey:    header(r, "x-amz-copy-source-server-side-encryption-customer-key"),
+		SSECustomerKey:              header(r, "x-amz-server-side-encryption-customer-key"),
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	setHeader(h, "x-amz-copy-source-version-id", out.CopySourceVersionId)
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, out.SSECustomerAlgorithm)
+	res := out.CopyPartResult
+	return writeXML(w, struct {
+		XMLName        xml.Name `xml:"CopyPartResult"`
+		XMLNS          string   `xml:"xmlns,attr"`
+		ETag           string   `xml:"ETag"`
+		LastModified   string   `xml:"LastModified"`
+		ChecksumCRC32  *string  `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C *string  `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   *string  `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 *string  `xml:"ChecksumSHA256,omitempty"`
+	}{
+		XMLNS:          xmlNS,
+		ETag:           aws.ToString(res.ETag),
+		LastModified:   aws.ToTime(res.LastModified).Format(xmlTimeFormat),
+		ChecksumCRC32:  res.ChecksumCRC32,
+		ChecksumCRC32C: res.ChecksumCRC32C,
+		ChecksumSHA1:   res.ChecksumSHA1,
+		ChecksumSHA256: res.ChecksumSHA256,
+	})
+}
+
+func (b *Backend) serveCompleteMultipartUpload(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	var req struct {
+		Parts []struct {
+			PartNumber     int32   `xml:"PartNumber"`
+			ETag           string  `xml:"ETag"`
+			ChecksumCRC32  *string `xml:"ChecksumCRC32"`
+			ChecksumCRC32C *string `xml:"ChecksumCRC32C"`
+			ChecksumSHA1   *string `xml:"ChecksumSHA1"`
+			ChecksumSHA256 *string `xml:"ChecksumSHA256"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		return genericError(http.StatusBadRequest, "MalformedXML", err.Error())
+	}
+	mu := &types.CompletedMultipartUpload{}
+	for _, p := range req.Parts {
+		mu.Parts = append(mu.Parts, types.CompletedPart{
+			PartNumber:     p.PartNumber,
+			ETag:           aws.String(p.ETag),
+			ChecksumCRC32:  p.ChecksumCRC32,
+			ChecksumCRC32C: p.ChecksumCRC32C,
+			ChecksumSHA1:   p.ChecksumSHA1,
+			ChecksumSHA256: p.ChecksumSHA256,
+		})
+	}
+
+	out, err := b.CompleteMultipartUpload(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bkt),
+		Key:             aws.String(key),
+		UploadId:        query(r, "uploadId"),
+		MultipartUpload: mu,
+	})
+	if err != nil {
+		return err
+	}
+	h := w.Header()
+	setHeader(h, "x-amz-version-id", out.VersionId)
+	writeSSEHeaders(h, out.ServerSideEncryption, out.SSEKMSKeyId, nil)
+	return writeXML(w, struct {
+		XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+		XMLNS          string   `xml:"xmlns,attr"`
+		Location       string   `xml:"Location"`
+		Bucket         string   `xml:"Bucket"`
+		Key            string   `xml:"Key"`
+		ETag           string   `xml:"ETag"`
+		ChecksumCRC32  *string  `xml:"ChecksumCRC32,omitempty"`
+		ChecksumCRC32C *string  `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA1   *string  `xml:"ChecksumSHA1,omitempty"`
+		ChecksumSHA256 *string  `xml:"ChecksumSHA256,omitempty"`
+	}{
+		XMLNS:          xmlNS,
+		Location:       "/" + bkt + "/" + key,
+		Bucket:         bkt,
+		Key:            key,
+		ETag:           aws.ToString(out.ETag),
+		ChecksumCRC32:  out.ChecksumCRC32,
+		ChecksumCRC32C: out.ChecksumCRC32C,
+		ChecksumSHA1:   out.ChecksumSHA1,
+		ChecksumSHA256: out.ChecksumSHA256,
+	})
+}
+
+func (b *Backend) serveAbortMultipartUpload(w http.ResponseWriter, r *http.Request, bkt, key string) error {
+	_, err := b.AbortMultipartUpload(r.Context(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bkt),
+		Key:      aws.String(key),
+		UploadId: query(r, "uploadId"),
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// writeObjectHeaders writes an object's metadata as response headers.
+func writeObjectHeaders(h http.Header, o *s3.HeadObjectOutput) {
+	setHeader(h, "Accept-Ranges", o.AcceptRanges)
+	setHeader(h, "Cache-Control", o.CacheControl)
+	setHeader(h, "Content-Disposition", o.ContentDisposition)
+	setHeader(h, "Content-Encoding", o.ContentEncoding)
+	setHeader(h, "Content-Language", o.ContentLanguage)
+	setHeader(h, "Content-Type", o.ContentType)
+	setHeader(h, "ETag", o.ETag)
+	setHeader(h, "x-amz-version-id", o.VersionId)
+	writeChecksumHeaders(h, checksums{o.ChecksumCRC32, o.ChecksumCRC32C, o.ChecksumSHA1, o.ChecksumSHA256})
+	h.Set("Content-Length", strconv.FormatInt(o.ContentLength, 10))
+	if o.Expires != nil {
+		h.Set("Expires", o.Expires.UTC().Format(http.TimeFormat))
+	}
+	if o.LastModified != nil {
+		h.Set("Last-Modified", o.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if o.PartsCount > 0 {
+		h.Set("x-amz-mp-parts-count", strconv.Itoa(int(o.PartsCount)))
+	}
+	if o.StorageClass != "" && o.StorageClass != types.StorageClassStandard {
+		h.Set("x-amz-storage-class", string(o.StorageClass))
+	}
+	for k, v := range o.Metadata {
+		h.Set("x-amz-meta-"+k, v)
+	}
+	writeSSEHeaders(h, o.ServerSideEncryption, o.SSEKMSKeyId, o.SSECustomerAlgorithm)
+}
+
+// writeSSEHeaders writes the server-side encryption response headers.
+func writeSSEHeaders(h http.Header, sse types.ServerSideEncryption, kmsKeyID, customerAlgorithm *string) {
+	if sse != "" {
+		h.Set("x-amz-server-side-encryption", string(sse))
+	}
+	setHeader(h, "x-amz-server-side-encryption-aws-kms-key-id", kmsKeyID)
+	setHeader(h, "x-amz-server-side-encryption-customer-algorithm", customerAlgorithm)
+}
+
+// writeXML writes v as an XML response body.
+func writeXML(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as an S3 XML error response.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	var se interface{ HTTPStatusCode() int }
+	if errors.As(err, &se) {
+		status = se.HTTPStatusCode()
+	}
+	code, msg := "InternalError", err.Error()
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		code, msg = ae.ErrorCode(), ae.ErrorMessage()
+	}
+
+	// Responses to HEAD requests and 304s have no body
+	if r.Method == http.MethodHead || status == http.StatusNotModified {
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: msg})
+}
+
+// header returns the named request header, or nil if it isn't set.
+func header(r *http.Request, name string) *string {
+	if v := r.Header.Get(name); v != "" {
+		return &v
+	}
+	return nil
+}
+
+// headerTime parses the named request header as an HTTP date, returning
+// nil if it isn't set or is invalid.
+func headerTime(r *http.Request, name string) *time.Time {
+	v := r.Header.Get(name)
+	if v == "" {
+		return nil
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// query returns the named query parameter, or nil if it isn't set.
+func query(r *http.Request, name string) *string {
+	q := r.URL.Query()
+	if !q.Has(name) {
+		return nil
+	}
+	v := q.Get(name)
+	return &v
+}
+
+// metadata collects the x-amz-meta-* request headers.
+func metadata(r *http.Request) map[string]string {
+	var md map[string]string
+	for k := range r.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-meta-") {
+			if md == nil {
+				md = make(map[string]string)
+			}
+			md[strings.TrimPrefix(lk, "x-amz-meta-")] = r.Header.Get(k)
+		}
+	}
+	return md
+}
+
+// setHeader sets the header if v is non-nil.
+func setHeader(h http.Header, name string, v *string) {
+	if v != nil {
+		h.Set(name, *v)
+	}
+}
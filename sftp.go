@@ -0,0 +1,326 @@
+// sftp.go implements github.com/pkg/sftp request-server handlers for S3FS
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+var (
+	ErrDirectoryNotEmpty = errors.New("directory not empty")
+	ErrWriteOutOfOrder   = errors.New("write out of order")
+)
+
+// SFTPHandlers returns github.com/pkg/sftp request-server handlers that
+// serve the filesystem, for use with sftp.NewRequestServer.
+//
+// Reads, writes, listings, renames and removes go straight to S3. Uploads
+// are streamed using multipart uploads, so writes must be sequential,
+// although writes that arrive out of order (e.g. from clients that send
+// several writes at once) are held in memory until the gap before them is
+// filled, as long as they're within sftpWriteWindow parts of it. Setstat
+// requests change the mode, owner and times of files as Chmod, Chown and
+// Chtimes do, but files can't be truncated or extended and directories
+// have no attributes to change. Links aren't supported.
+func (fs3 *S3FS) SFTPHandlers() sftp.Handlers {
+	h := &sftpHandlers{fs3: fs3}
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// sftpHandlers implements the sftp request-server handler interfaces for
+// an S3FS.
+type sftpHandlers struct {
+	fs3 *S3FS
+}
+
+// path converts a slash-separated SFTP path into an S3FS path.
+func (h *sftpHandlers) path(name string) string {
+	name = strings.Trim(name, "/")
+	return strings.ReplaceAll(name, "/", h.fs3.separator)
+}
+
+// Fileread opens a file for reading.
+func (h *sftpHandlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	p := h.path(r.Filepath)
+	if p == "" {
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: ErrIsDirectory}
+	}
+	key, err := h.fs3.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return newS3ReadFile(h.fs3, h.fs3.bucket, key)
+}
+
+// Filewrite opens a file for writing. The file is uploaded when it is
+// closed.
+func (h *sftpHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if r.Pflags().Append {
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: ErrOpenFlagNotSupported}
+	}
+	p := h.path(r.Filepath)
+	if info, err := h.fs3.Stat(p); err == nil && info.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: ErrIsDirectory}
+	}
+	key, err := h.fs3.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newS3MultipartUploadFile(h.fs3, h.fs3.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return &sftpWriter{f: f, pending: make(map[int64][]byte)}, nil
+}
+
+// Filecmd handles commands that modify the filesystem.
+func (h *sftpHandlers) Filecmd(r *sftp.Request) error {
+	ctx := context.TODO() // TODO: Get a context from the request?
+
+	p := h.path(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r, p)
+
+	case "Mkdir":
+		return h.fs3.MkdirAll(p, 0777)
+
+	case "Rename":
+		info, err := h.fs3.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return h.fs3.Rename(p, h.path(r.Target))
+		}
+		src, err := h.fs3.resolve(p)
+		if err != nil {
+			return err
+		}
+		dst, err := h.fs3.resolve(h.path(r.Target))
+		if err != nil {
+			return err
+		}
+		if src == "" || strings.HasPrefix(dst+h.fs3.separator, src+h.fs3.separator) {
+			return &os.LinkError{Op: "rename", Old: r.Filepath, New: r.Target, Err: os.ErrInvalid}
+		}
+		return h.fs3.renameDir(ctx, src, dst)
+
+	case "Rmdir":
+		info, err := h.fs3.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: ErrNotDirectory}
+		}
+		fis, err := h.fs3.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(fis) > 0 {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: ErrDirectoryNotEmpty}
+		}
+		key, err := h.fs3.resolve(p)
+		if err != nil {
+			return err
+		}
+		return h.fs3.removeAll(ctx, key)
+
+	case "Remove":
+		info, err := h.fs3.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return &os.PathError{Op: "remove", Path: r.Filepath, Err: ErrIsDirectory}
+		}
+		return h.fs3.Remove(p)
+
+	default:
+		return sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// setstat applies the attributes of a Setstat request to a file, the same
+// way as Chmod, Chown and Chtimes, with a single metadata update. Sizes
+// can't be changed, and directories have no object to store attributes in.
+func (h *sftpHandlers) setstat(r *sftp.Request, p string) error {
+	ctx := context.TODO() // TODO: Get a context from the request?
+
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	info, err := h.fs3.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || (flags.Size && int64(attrs.Size) != info.Size()) {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	if !flags.Permissions && !flags.UidGid && !flags.Acmodtime {
+		return nil
+	}
+
+	return h.fs3.updateMetadata(ctx, "setstat", p, func(meta map[string]string) error {
+		if flags.Permissions {
+			meta[metaMode] = strconv.FormatUint(posixMode(fileMode(uint64(attrs.Mode))), 10)
+		}
+		if flags.UidGid {
+			meta[metaUID] = strconv.FormatUint(uint64(attrs.UID), 10)
+			meta[metaGID] = strconv.FormatUint(uint64(attrs.GID), 10)
+		}
+		if flags.Acmodtime {
+			meta[metaAtime] = formatMtime(time.Unix(int64(attrs.Atime), 0))
+			meta[metaMtime] = formatMtime(time.Unix(int64(attrs.Mtime), 0))
+		}
+		return nil
+	})
+}
+
+// Filelist handles listing and stat requests.
+func (h *sftpHandlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p := h.path(r.Filepath)
+	switch r.Method {
+	case "List":
+		info, err := h.fs3.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, &os.PathError{Op: "readdir", Path: r.Filepath, Err: ErrNotDirectory}
+		}
+		fis, err := h.fs3.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister(fis), nil
+
+	case "Stat":
+		info, err := h.fs3.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return sftpLister{info}, nil
+
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+}
+
+// sftpLister implements sftp.ListerAt for a list of files.
+type sftpLister []os.FileInfo
+
+// ListAt copies the entries starting at offset into ls.
+func (l sftpLister) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// sftpWriteWindow is how many parts ahead of the data written so far an
+// SFTP write can be, so that a client can't make the server hold any
+// amount of data in memory.
+const sftpWriteWindow = 4
+
+// sftpWriter adapts a multipart upload to io.WriterAt, holding writes
+// that arrive ahead of the current offset in memory until they can be
+// written in order.
+type sftpWriter struct {
+	mu      sync.Mutex
+	f       *s3MultipartUploadFile
+	off     int64            // Offset of the next byte to write to f
+	pending map[int64][]byte // Writes waiting for earlier data, by offset
+	held    int64            // Bytes held in pending
+	err     error            // First error returned by f
+}
+
+// WriteAt writes p at offset off.
+func (w *sftpWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	switch {
+	case off < w.off:
+		return 0, fmt.Errorf("%w: can't overwrite data that has already been written", ErrWriteOutOfOrder)
+	case off > w.off:
+		window := sftpWriteWindow * w.f.fs.partSize
+		if off-w.off > window-int64(len(p)) || w.held+int64(len(p)) > window {
+			return 0, fmt.Errorf("%w: write too far ahead", ErrWriteOutOfOrder)
+		}
+		if old, ok := w.pending[off]; ok {
+			w.held -= int64(len(old))
+		}
+		w.pending[off] = append([]byte(nil), p...)
+		w.held += int64(len(p))
+		return len(p), nil
+	}
+
+	// Write the data, along with any pending writes that follow it
+	n := len(p)
+	for {
+		if _, err := w.f.Write(p); err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.off += int64(len(p))
+
+		next, ok := w.pending[w.off]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.off)
+		w.held -= int64(len(next))
+		p = next
+	}
+	return n, nil
+}
+
+// Close completes the upload, or aborts it if a write failed or the file
+// has gaps.
+func (w *sftpWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 && w.err == nil {
+		w.err = fmt.Errorf("%w: file has gaps", ErrWriteOutOfOrder)
+	}
+	if w.err != nil {
+		w.f.closed = true
+		w.f.wg.Wait()
+		return w.f.abort(context.TODO(), w.err)
+	}
+	return w.f.Close()
+}
+
+// TransferError is called if the connection fails while the file is open,
+// so the upload is aborted rather than completed with partial data.
+func (w *sftpWriter) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,351 @@
+package s3fs_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner generates an ed25519 SSH key.
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %s", err)
+	}
+	return signer
+}
+
+// newTestSFTPServer serves fs3 over SFTP on localhost and returns the
+// server's address.
+func newTestSFTPServer(t *testing.T, fs3 *s3fs.S3FS, users []s3fs.SFTPUser) string {
+	t.Helper()
+
+	srv, err := s3fs.NewSFTPServer(fs3, newTestSigner(t), users)
+	if err != nil {
+		t.Fatalf("NewSFTPServer: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; !errors.Is(err, s3fs.ErrSFTPServerClosed) {
+			t.Errorf("Serve: expected ErrSFTPServerClosed, got %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// dialSFTP connects to the SFTP server at addr. Writes are sent
+// concurrently, so they can reach the server out of order.
+func dialSFTP(addr, user string, key ssh.Signer) (*sftp.Client, error) {
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func TestSFTPServer(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator))
+	writeFile(t, fs3, "partners/acme/existing.txt", "already here")
+	writeFile(t, fs3, "partners/other/secret.txt", "secret")
+
+	key := newTestSigner(t)
+	addr := newTestSFTPServer(t, fs3, []s3fs.SFTPUser{{
+		Name:           "acme",
+		Root:           "partners/acme",
+		AuthorizedKeys: []ssh.PublicKey{key.PublicKey()},
+	}})
+
+	c, err := dialSFTP(addr, "acme", key)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+
+	// Upload a file larger than a single write
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	f, err := c.Create("/upload.bin")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if _, err := f.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadFrom: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got := readFile(t, fs3, "partners/acme/upload.bin"); got != string(data) {
+		t.Errorf("expected %d uploaded bytes, got %d", len(data), len(got))
+	}
+
+	// Read it back
+	f, err = c.Open("/upload.bin")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	got, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("expected to read back %d bytes, got %d", len(data), len(got))
+	}
+
+	// List the user's root
+	if err := c.Mkdir("/inbox"); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	fis, err := c.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir: %s", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	want := []string{"existing.txt", "inbox", "upload.bin"}
+	if len(names) != len(want) {
+		t.Fatalf("expected entries %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("expected entries %v, got %v", want, names)
+		}
+	}
+
+	// Rename and remove
+	if err := c.Rename("/upload.bin", "/inbox/upload.bin"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if fi, err := c.Stat("/inbox/upload.bin"); err != nil || fi.Size() != int64(len(data)) {
+		t.Errorf("Stat after rename: expected size %d, got %v, %v", len(data), fi, err)
+	}
+	if err := c.RemoveDirectory("/inbox"); err == nil {
+		t.Error("expected RemoveDirectory of a non-empty directory to fail")
+	}
+	if err := c.Remove("/inbox/upload.bin"); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if err := c.RemoveDirectory("/inbox"); err != nil {
+		t.Fatalf("RemoveDirectory: %s", err)
+	}
+	if _, err := c.Stat("/inbox"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist after RemoveDirectory, got %v", err)
+	}
+
+	// The user can't see outside their root
+	if _, err := c.Stat("/../other/secret.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist outside the root, got %v", err)
+	}
+}
+
+func TestSFTPWriteWindow(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithPartSize(s3fs.MinPartSize))
+	key := newTestSigner(t)
+	addr := newTestSFTPServer(t, fs3, []s3fs.SFTPUser{{
+		Name:           "acme",
+		AuthorizedKeys: []ssh.PublicKey{key.PublicKey()},
+	}})
+	c, err := dialSFTP(addr, "acme", key)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+
+	f, err := c.Create("/upload.bin")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+
+	// Writes a few parts ahead are held until the gap is filled, but
+	// writes further ahead are refused
+	if _, err := f.WriteAt([]byte("later"), 10); err != nil {
+		t.Fatalf("WriteAt: %s", err)
+	}
+	if _, err := f.WriteAt([]byte("x"), 1<<40); err == nil {
+		t.Errorf("expected a write far ahead to fail")
+	}
+	if _, err := f.WriteAt([]byte("0123456789"), 0); err != nil {
+		t.Fatalf("WriteAt: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if got, expected := readFile(t, fs3, "upload.bin"), "0123456789later"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSFTPRenameDirMultipartCopy(t *testing.T) {
+	client, backend := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCopyThreshold(s3fs.MinPartSize))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	data := testData(int(2*s3fs.MinPartSize) + 10)
+	writeFile(t, fs3, "dir/big", string(data))
+	writeFile(t, fs3, "dir/small", "hello")
+
+	key := newTestSigner(t)
+	addr := newTestSFTPServer(t, fs3, []s3fs.SFTPUser{{
+		Name:           "acme",
+		AuthorizedKeys: []ssh.PublicKey{key.PublicKey()},
+	}})
+	c, err := dialSFTP(addr, "acme", key)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+
+	if err := c.Rename("/dir", "/moved"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if _, err := fs3.Stat("dir/big"); !os.IsNotExist(err) {
+		t.Errorf("expected old path not to exist, got %v", err)
+	}
+
+	// The large object was copied in parts, the small one in one go
+	if res := headObject(t, client, "moved/big"); res.PartsCount != 3 {
+		t.Errorf("expected a copy of 3 parts, got %d", res.PartsCount)
+	}
+	if got := readFile(t, fs3, "moved/big"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+	if got := readFile(t, fs3, "moved/small"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+	if n, err := backend.Uploads(testBucket); err != nil || n != 0 {
+		t.Errorf("expected no uploads in progress, got %d (%v)", n, err)
+	}
+}
+
+func TestSFTPServerAuth(t *testing.T) {
+	fs3 := newTestFS(t)
+	key := newTestSigner(t)
+	addr := newTestSFTPServer(t, fs3, []s3fs.SFTPUser{{
+		Name:           "acme",
+		AuthorizedKeys: []ssh.PublicKey{key.PublicKey()},
+	}})
+
+	if _, err := dialSFTP(addr, "acme", newTestSigner(t)); err == nil {
+		t.Error("expected login with an unknown key to fail")
+	}
+	if _, err := dialSFTP(addr, "someone", key); err == nil {
+		t.Error("expected login as an unknown user to fail")
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	k1, k2 := newTestSigner(t).PublicKey(), newTestSigner(t).PublicKey()
+	data := "# comment\n" +
+		string(ssh.MarshalAuthorizedKey(k1)) +
+		"\n" +
+		`no-pty ` + string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(k2))) + " user@host\n" +
+		"# trailing comment\n"
+
+	keys, err := s3fs.ParseAuthorizedKeys([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKeys: %s", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if !bytes.Equal(keys[0].Marshal(), k1.Marshal()) || !bytes.Equal(keys[1].Marshal(), k2.Marshal()) {
+		t.Error("parsed keys don't match")
+	}
+}
+
+func TestSFTPSetstat(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator))
+	writeFile(t, fs3, "home/file.txt", "data")
+	if err := fs3.MkdirAll("home/dir", 0755); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+
+	key := newTestSigner(t)
+	addr := newTestSFTPServer(t, fs3, []s3fs.SFTPUser{{
+		Name:           "acme",
+		Root:           "home",
+		AuthorizedKeys: []ssh.PublicKey{key.PublicKey()},
+	}})
+	c, err := dialSFTP(addr, "acme", key)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := c.Chmod("/file.txt", 0600); err != nil {
+		t.Fatalf("Chmod: %s", err)
+	}
+	if err := c.Chown("/file.txt", 1000, 100); err != nil {
+		t.Fatalf("Chown: %s", err)
+	}
+	if err := c.Chtimes("/file.txt", mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+
+	fi, err := fs3.Stat("home/file.txt")
+	if err != nil {
+		t.Fatalf("Stat: %s", err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("expected mode %v, got %v", os.FileMode(0600), fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("expected modification time %v, got %v", mtime, fi.ModTime())
+	}
+	if obj := fi.Sys().(*s3fs.ObjectInfo); obj.UID != 1000 || obj.GID != 100 {
+		t.Errorf("expected owner 1000:100, got %d:%d", obj.UID, obj.GID)
+	}
+	if got := readFile(t, fs3, "home/file.txt"); got != "data" {
+		t.Errorf("expected the contents to be kept, got %q", got)
+	}
+
+	// Sizes can't be changed, and directories have no attributes
+	if err := c.Truncate("/file.txt", 2); err == nil {
+		t.Error("Truncate: expected an error")
+	}
+	if err := c.Truncate("/file.txt", 4); err != nil {
+		t.Errorf("Truncate to the same size: %s", err)
+	}
+	if err := c.Chmod("/dir", 0700); err == nil {
+		t.Error("Chmod directory: expected an error")
+	}
+	if err := c.Chmod("/missing", 0600); !os.IsNotExist(err) {
+		t.Errorf("Chmod missing file: expected a not-exist error, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+// sftpserver.go implements an SSH server that serves S3FS over SFTP
+
+package s3fs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrSFTPServerClosed = errors.New("sftp server closed")
+)
+
+// SFTPUser is a user who can log in to an SFTPServer.
+type SFTPUser struct {
+	Name           string          // Username
+	Root           string          // Directory the user is chrooted to ("" for the filesystem's root)
+	AuthorizedKeys []ssh.PublicKey // Public keys the user can log in with
+}
+
+// SFTPServer serves an S3FS over SFTP. Users log in with public keys, and
+// each user only sees the part of the filesystem under their root.
+type SFTPServer struct {
+	config *ssh.ServerConfig
+	users  map[string]*sftpUser
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+}
+
+// sftpUser is an SFTPUser along with their chrooted filesystem.
+type sftpUser struct {
+	keys [][]byte // Marshalled public keys
+	fs3  *S3FS
+}
+
+// NewSFTPServer creates an SFTPServer serving fs3 to the given users,
+// identifying itself with hostKey.
+func NewSFTPServer(fs3 *S3FS, hostKey ssh.Signer, users []SFTPUser) (*SFTPServer, error) {
+	if fs3 == nil {
+		return nil, errors.New("filesystem cannot be nil")
+	}
+	if hostKey == nil {
+		return nil, errors.New("host key cannot be nil")
+	}
+
+	s := &SFTPServer{
+		users:     make(map[string]*sftpUser),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	for _, u := range users {
+		if u.Name == "" {
+			return nil, errors.New("username cannot be empty")
+		}
+		if _, ok := s.users[u.Name]; ok {
+			return nil, fmt.Errorf("duplicate user %q", u.Name)
+		}
+		if len(u.AuthorizedKeys) == 0 {
+			return nil, fmt.Errorf("user %q has no authorized keys", u.Name)
+		}
+
+		nfs, err := fs3.Chroot(u.Root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root for user %q: %w", u.Name, err)
+		}
+		su := &sftpUser{fs3: nfs.(*S3FS)}
+		for _, k := range u.AuthorizedKeys {
+			su.keys = append(su.keys, k.Marshal())
+		}
+		s.users[u.Name] = su
+	}
+
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+	}
+	s.config.AddHostKey(hostKey)
+	return s, nil
+}
+
+// ParseAuthorizedKeys parses public keys in the OpenSSH authorized_keys
+// format. Options and comments are ignored.
+func ParseAuthorizedKeys(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		k, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// authenticate checks that key is one of the user's authorized keys.
+func (s *SFTPServer) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	u, ok := s.users[meta.User()]
+	if ok {
+		k := key.Marshal()
+		for _, ak := range u.keys {
+			if bytes.Equal(k, ak) {
+				return &ssh.Permissions{}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown public key for %q", meta.User())
+}
+
+// Serve accepts connections on l until the server is closed, serving each
+// one in a new goroutine. It always returns a non-nil error, which is
+// ErrSFTPServerClosed after Close is called.
+func (s *SFTPServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSFTPServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrSFTPServerClosed
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// on it. See Serve.
+func (s *SFTPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close stops the server's listeners and closes any open connections.
+func (s *SFTPServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+// serveConn performs the SSH handshake on conn and serves its sessions.
+func (s *SFTPServer) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	u := s.users[sconn.User()]
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, creqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(u.fs3, ch, creqs)
+	}
+}
+
+// serveSession serves SFTP on a session channel once the client requests
+// the "sftp" subsystem. Other requests (e.g. for a shell) are refused.
+func serveSession(fs3 *S3FS, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	started := false
+	for req := range reqs {
+		ok := !started && req.Type == "subsystem" && isSFTPSubsystem(req.Payload)
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		started = true
+
+		go func() {
+			srv := sftp.NewRequestServer(ch, fs3.SFTPHandlers())
+			srv.Serve()
+			srv.Close()
+			ch.Close()
+		}()
+	}
+}
+
+// isSFTPSubsystem reports whether the payload of a "subsystem" request
+// names the "sftp" subsystem.
+func isSFTPSubsystem(payload []byte) bool {
+	var msg struct{ Name string }
+	if err := ssh.Unmarshal(payload, &msg); err != nil {
+		return false
+	}
+	return msg.Name == "sftp"
+}
@@ -0,0 +1,103 @@
+// statcache.go implements a bounded LRU cache of Stat results
+
+package s3fs
+
+import (
+	"container/list"
+	"os"
+	"strings"
+	"sync"
+)
+
+// statCache is a fixed-size, least-recently-used cache of os.FileInfo
+// values keyed by full S3 object key. It is safe for concurrent use and is
+// shared between a filesystem and its chroots.
+type statCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// statCacheEntry is an element of the statCache's list.
+type statCacheEntry struct {
+	key  string
+	info os.FileInfo
+}
+
+// newStatCache creates a statCache holding at most size entries.
+func newStatCache(size int) *statCache {
+	return &statCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached FileInfo for key, if present.
+func (c *statCache) get(key string) (os.FileInfo, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*statCacheEntry).info, true
+}
+
+// put adds the FileInfo for key to the cache, evicting the least recently
+// used entry if the cache is full.
+func (c *statCache) put(key string, info os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*statCacheEntry).info = info
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&statCacheEntry{key: key, info: info})
+	if c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*statCacheEntry).key)
+	}
+}
+
+// remove drops key from the cache.
+func (c *statCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+// removePrefix drops every key starting with prefix from the cache.
+func (c *statCache) removePrefix(prefix string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package s3fs_test
+
+import (
+	"testing"
+
+	"github.com/a-poor/s3fs"
+)
+
+func TestSymlink(t *testing.T) {
+	fs3 := newTestFS(t)
+
+	if err := fs3.Symlink("foo", "bar"); err != s3fs.ErrSymLinkNotSupported {
+		t.Errorf("Symlink: expected ErrSymLinkNotSupported, got %v", err)
+	}
+	if _, err := fs3.Readlink("bar"); err != s3fs.ErrSymLinkNotSupported {
+		t.Errorf("Readlink: expected ErrSymLinkNotSupported, got %v", err)
+	}
+	if _, err := fs3.Lstat("bar"); err != s3fs.ErrSymLinkNotSupported {
+		t.Errorf("Lstat: expected ErrSymLinkNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,538 @@
+// sync.go implements rsync-style syncing between billy filesystems
+
+package s3fs
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+const (
+	DefaultSyncConcurrency = 8 // Default number of files copied concurrently by Sync
+
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024 // Largest object CopyObject can copy
+)
+
+var (
+	ErrSyncConflict = errors.New("file and directory conflict")
+)
+
+// SyncOp is the kind of change Sync makes to the destination.
+type SyncOp string
+
+const (
+	SyncCopy   SyncOp = "copy"   // A file was copied from the source
+	SyncMkdir  SyncOp = "mkdir"  // A directory was created
+	SyncDelete SyncOp = "delete" // A file or directory was removed
+)
+
+// SyncAction describes a change Sync made (or, in a dry run, would make)
+// to the destination.
+type SyncAction struct {
+	Op   SyncOp `json:"op"`
+	Path string `json:"path"`           // Slash-separated path, relative to the filesystem roots
+	Size int64  `json:"size,omitempty"` // Size of a copied file
+}
+
+// SyncOptions configures Sync. The zero value copies new and changed
+// files, compared by size and modification time.
+type SyncOptions struct {
+	// Checksum compares files by their MD5 checksums rather than by size
+	// and modification time. S3 ETags are used as checksums where they
+	// are MD5s, and anything else is read and hashed.
+	Checksum bool
+
+	// Delete removes files and directories from the destination that
+	// aren't in the source. Excluded files are never deleted.
+	Delete bool
+
+	// DryRun reports what would be changed without changing anything.
+	DryRun bool
+
+	// Include, if set, limits the files synced to those matching at
+	// least one of the glob patterns. Exclude skips files and directories
+	// matching any of the patterns. Patterns use path.Match syntax, and
+	// are matched against the file name unless they contain a "/", in
+	// which case they're matched against the path relative to the root.
+	Include []string
+	Exclude []string
+
+	// Concurrency is the number of files compared and copied at once.
+	// Defaults to DefaultSyncConcurrency.
+	Concurrency int
+
+	// DisableServerSideCopy streams files through this process even when
+	// both filesystems are S3FS. Server-side copies are sent with the
+	// destination's client, so this is needed if it can't read the
+	// source bucket (e.g. it's in another account or S3 service).
+	DisableServerSideCopy bool
+
+	// Report, if set, is called for each change as it's made.
+	Report func(SyncAction)
+}
+
+// SyncStats summarises the changes made by Sync.
+type SyncStats struct {
+	Copied  int   `json:"copied"`  // Number of files copied
+	Bytes   int64 `json:"bytes"`   // Number of bytes copied
+	Created int   `json:"created"` // Number of directories created
+	Deleted int   `json:"deleted"` // Number of files and directories deleted
+	Skipped int   `json:"skipped"` // Number of files that were already up to date
+}
+
+// Sync makes dst a copy of src, copying files that are missing from dst or
+// differ from src. Files are considered the same if they have the same
+// size and the destination isn't older than the source, or, with the
+// Checksum option, if they have the same MD5 checksum. When dst supports
+// billy.Change, copied files are given the source's modification time.
+//
+// Files are copied concurrently. If both filesystems are S3FS, they're
+// copied server-side with CopyObject. Sync stops at the first error,
+// returning the changes made so far.
+func Sync(src, dst billy.Filesystem, opts SyncOptions) (SyncStats, error) {
+	// Create a context
+	ctx := context.TODO() // TODO: Get user-supplied context?
+
+	s := &syncer{src: src, dst: dst, opts: opts}
+	if s.opts.Concurrency < 1 {
+		s.opts.Concurrency = DefaultSyncConcurrency
+	}
+	for _, p := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return SyncStats{}, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	// List both sides
+	srcEntries, err := s.list(src)
+	if err != nil {
+		return SyncStats{}, fmt.Errorf("unable to list source: %w", err)
+	}
+	dstEntries, err := s.list(dst)
+	if err != nil {
+		return SyncStats{}, fmt.Errorf("unable to list destination: %w", err)
+	}
+
+	// Work out what needs to change. Entries are sorted, so parents
+	// come before their children.
+	var mkdirs, deletes []string
+	var candidates []syncPair
+	for _, rel := range sortedKeys(srcEntries) {
+		si, di := srcEntries[rel], dstEntries[rel]
+		if di != nil && si.IsDir() != di.IsDir() {
+			if !opts.Delete {
+				return SyncStats{}, fmt.Errorf("%s: %w (set Delete to replace it)", rel, ErrSyncConflict)
+			}
+			deletes = append(deletes, rel)
+			di = nil
+		}
+		switch {
+		case si.IsDir() && di == nil:
+			mkdirs = append(mkdirs, rel)
+		case !si.IsDir():
+			candidates = append(candidates, syncPair{rel: rel, src: si, dst: di})
+		}
+	}
+	if opts.Delete {
+		for _, rel := range sortedKeys(dstEntries) {
+			if srcEntries[rel] == nil && !hasParentIn(rel, deletes) {
+				deletes = append(deletes, rel)
+			}
+		}
+		sort.Strings(deletes)
+	}
+
+	// Replace conflicting entries first, then create directories
+	for _, rel := range deletes {
+		if srcEntries[rel] == nil {
+			continue // Removed once the copies are done
+		}
+		if err := s.remove(rel); err != nil {
+			return s.stats, err
+		}
+	}
+	for _, rel := range mkdirs {
+		if !s.opts.DryRun {
+			if err := dst.MkdirAll(fsPath(dst, rel), 0777); err != nil {
+				return s.stats, fmt.Errorf("%s: %w", rel, err)
+			}
+		}
+		s.record(SyncAction{Op: SyncMkdir, Path: rel})
+	}
+
+	// Compare and copy files concurrently
+	if err := s.copyAll(ctx, candidates); err != nil {
+		return s.stats, err
+	}
+
+	// Remove extraneous files
+	for _, rel := range deletes {
+		if srcEntries[rel] != nil {
+			continue // Already replaced
+		}
+		if err := s.remove(rel); err != nil {
+			return s.stats, err
+		}
+	}
+	return s.stats, nil
+}
+
+// syncer holds the state of a Sync.
+type syncer struct {
+	src, dst billy.Filesystem
+	opts     SyncOptions
+
+	mu    sync.Mutex // Guards stats and calls to Report
+	stats SyncStats
+}
+
+// syncPair is a source file and the destination entry it may replace.
+type syncPair struct {
+	rel      string
+	src, dst os.FileInfo // dst is nil if the file doesn't exist
+}
+
+// record counts an action and reports it.
+func (s *syncer) record(a SyncAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch a.Op {
+	case SyncCopy:
+		s.stats.Copied++
+		s.stats.Bytes += a.Size
+	case SyncMkdir:
+		s.stats.Created++
+	case SyncDelete:
+		s.stats.Deleted++
+	}
+	if s.opts.Report != nil {
+		s.opts.Report(a)
+	}
+}
+
+// list returns every file and directory in fs that isn't filtered out,
+// keyed by slash-separated path (whatever separator fs uses). A missing
+// root is treated as empty.
+func (s *syncer) list(fs billy.Filesystem) (map[string]os.FileInfo, error) {
+	entries := make(map[string]os.FileInfo)
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		fis, err := fs.ReadDir(dir)
+		if err != nil {
+			if dir == "" && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, fi := range fis {
+			r := path.Join(rel, fi.Name())
+			if !s.included(r, fi.IsDir()) {
+				continue
+			}
+			entries[r] = fi
+			if fi.IsDir() {
+				if err := walk(fs.Join(dir, fi.Name()), r); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return entries, walk("", "")
+}
+
+// fsPath converts a slash-separated path from a listing into a path in fs,
+// joined with its own separator.
+func fsPath(fs billy.Filesystem, rel string) string {
+	return fs.Join(strings.Split(rel, "/")...)
+}
+
+// included reports whether a path passes the Include and Exclude filters.
+// Include only applies to files, so that directories are still searched.
+func (s *syncer) included(rel string, dir bool) bool {
+	for _, p := range s.opts.Exclude {
+		if matchPattern(p, rel) {
+			return false
+		}
+	}
+	if dir || len(s.opts.Include) == 0 {
+		return true
+	}
+	for _, p := range s.opts.Include {
+		if matchPattern(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern matches a glob against a path, or just its last element if
+// the pattern has no "/".
+func matchPattern(pattern, rel string) bool {
+	name := rel
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(rel)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// copyAll compares and, where needed, copies files with a pool of workers.
+func (s *syncer) copyAll(ctx context.Context, pairs []syncPair) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	work := make(chan syncPair)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < s.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				if err := s.syncFile(ctx, p); err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("%s: %w", p.rel, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, p := range pairs {
+		if ctx.Err() != nil {
+			break // A copy failed
+		}
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// syncFile copies a file if the destination is missing or different.
+func (s *syncer) syncFile(ctx context.Context, p syncPair) error {
+	if p.dst != nil {
+		same, err := s.same(ctx, p)
+		if err != nil {
+			return err
+		}
+		if same {
+			s.mu.Lock()
+			s.stats.Skipped++
+			s.mu.Unlock()
+			return nil
+		}
+	}
+
+	if !s.opts.DryRun {
+		if err := s.copy(ctx, p); err != nil {
+			return err
+		}
+	}
+	s.record(SyncAction{Op: SyncCopy, Path: p.rel, Size: p.src.Size()})
+	return nil
+}
+
+// same reports whether the source and destination files match.
+func (s *syncer) same(ctx context.Context, p syncPair) (bool, error) {
+	srcSize, err := fileSize(s.src, p.rel, p.src)
+	if err != nil {
+		return false, err
+	}
+	dstSize, err := fileSize(s.dst, p.rel, p.dst)
+	if err != nil {
+		return false, err
+	}
+	if srcSize != dstSize {
+		return false, nil
+	}
+	if !s.opts.Checksum {
+		// Destinations that can't set modification times make copies
+		// newer than their sources
+		return !p.dst.ModTime().Before(p.src.ModTime().Truncate(time.Second)), nil
+	}
+
+	srcSum, err := checksum(ctx, s.src, p.rel)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := checksum(ctx, s.dst, p.rel)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == dstSum, nil
+}
+
+// fileSize returns the size of a listed file. Listings of compressed
+// S3FS files report their compressed size, so they're described with Stat.
+func fileSize(fs billy.Filesystem, rel string, fi os.FileInfo) (int64, error) {
+	if fs3, ok := fs.(*S3FS); ok && fs3.compression != CompressionNone {
+		fi, err := fs3.Stat(fsPath(fs, rel))
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	return fi.Size(), nil
+}
+
+// checksum returns the hex MD5 checksum of a file, using the object's
+// ETag if it's an MD5 (i.e. it wasn't a multipart upload, encrypted with
+// KMS or a customer key, or compressed).
+func checksum(ctx context.Context, fs billy.Filesystem, rel string) (string, error) {
+	if fs3, ok := fs.(*S3FS); ok {
+		key, err := fs3.resolve(fsPath(fs, rel))
+		if err != nil {
+			return "", err
+		}
+		in := &s3.HeadObjectInput{
+			Bucket: &fs3.bucket,
+			Key:    &key,
+		}
+		fs3.enc.applyHead(in)
+		res, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+		if err != nil {
+			return "", fmt.Errorf("failed to stat file: %w", err)
+		}
+		etag := strings.Trim(aws.ToString(res.ETag), `"`)
+		if _, err := hex.DecodeString(etag); err == nil && len(etag) == 2*md5.Size && fs3.enc.etagIsMD5() && fs3.cse == nil && res.ContentEncoding == nil {
+			return etag, nil
+		}
+	}
+
+	f, err := fs.Open(fsPath(fs, rel))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copy copies a file from the source to the destination.
+func (s *syncer) copy(ctx context.Context, p syncPair) error {
+	src3, srcOK := s.src.(*S3FS)
+	dst3, dstOK := s.dst.(*S3FS)
+	if srcOK && dstOK && !s.opts.DisableServerSideCopy && p.src.Size() <= maxCopyObjectSize && src3.cse == dst3.cse {
+		return copyObject(ctx, src3, dst3, p.rel)
+	}
+
+	in, err := s.src.Open(fsPath(s.src, p.rel))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Keep the permissions, for filesystems that store them
+	var out billy.File
+	perm := p.src.Mode().Perm()
+	if perm == 0 {
+		perm = 0666
+	}
+	if dstOK && p.src.Size() >= dst3.partSize {
+		out, err = s.dst.OpenFile(fsPath(s.dst, p.rel), O_WRMULTIPART, perm)
+	} else {
+		out, err = s.dst.OpenFile(fsPath(s.dst, p.rel), O_WRONLY|O_CREATE|O_TRUNC, perm)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// Keep the modification time, so the files compare as the same
+	if ch, ok := s.dst.(billy.Change); ok && !p.src.ModTime().IsZero() {
+		mtime := p.src.ModTime()
+		if err := ch.Chtimes(fsPath(s.dst, p.rel), mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyObject copies a file between S3 filesystems server-side, using the
+// destination's client and settings.
+func copyObject(ctx context.Context, src, dst *S3FS, rel string) error {
+	srcKey, err := src.resolve(fsPath(src, rel))
+	if err != nil {
+		return err
+	}
+	dstKey, err := dst.resolve(fsPath(dst, rel))
+	if err != nil {
+		return err
+	}
+
+	in := &s3.CopyObjectInput{
+		Bucket:       &dst.bucket,
+		CopySource:   aws.String(copySource(src.bucket, srcKey)),
+		Key:          &dstKey,
+		StorageClass: dst.storageClass,
+	}
+	dst.enc.applyCopy(in, src.enc)
+	_, err = dst.client.CopyObject(ctx, in, dst.optFns...)
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+
+	// The cached Stat result is now out of date
+	dst.statCache.remove(dstKey)
+	return nil
+}
+
+// remove deletes a file or directory from the destination.
+func (s *syncer) remove(rel string) error {
+	if !s.opts.DryRun {
+		if err := util.RemoveAll(s.dst, fsPath(s.dst, rel)); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	s.record(SyncAction{Op: SyncDelete, Path: rel})
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasParentIn reports whether any of dirs is a parent of rel.
+func hasParentIn(rel string, dirs []string) bool {
+	for _, d := range dirs {
+		if strings.HasPrefix(rel, d+"/") {
+			return true
+		}
+	}
+	return false
+}
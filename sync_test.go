@@ -0,0 +1,247 @@
+package s3fs_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// copyCountingClient counts CopyObject requests.
+type copyCountingClient struct {
+	s3fs.S3API
+	copies int32
+}
+
+func (c *copyCountingClient) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	atomic.AddInt32(&c.copies, 1)
+	return c.S3API.CopyObject(ctx, params, optFns...)
+}
+
+// syncActions runs Sync and returns the actions it reported, as sorted
+// "op path" strings.
+func syncActions(t *testing.T, src, dst billy.Filesystem, opts s3fs.SyncOptions) []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var actions []string
+	opts.Report = func(a s3fs.SyncAction) {
+		mu.Lock()
+		defer mu.Unlock()
+		actions = append(actions, string(a.Op)+" "+a.Path)
+	}
+	if _, err := s3fs.Sync(src, dst, opts); err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// newTestSyncFS returns source and destination directories in the same
+// bucket, so that files can be copied server-side.
+func newTestSyncFS(t *testing.T) (billy.Filesystem, billy.Filesystem) {
+	t.Helper()
+
+	fs3 := newTestFS(t)
+	src, err := fs3.Chroot("src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := fs3.Chroot("dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src, dst
+}
+
+func TestSyncLocalToS3(t *testing.T) {
+	dir := t.TempDir()
+	local := osfs.New(dir)
+	writeFile(t, local, "a.txt", "a")
+	writeFile(t, local, "sub/b.txt", "bb")
+	writeFile(t, local, "sub/c.log", "ccc")
+	fs3 := newTestFS(t)
+
+	// The first sync copies everything
+	got := syncActions(t, local, fs3, s3fs.SyncOptions{})
+	if want := "copy a.txt,copy sub/b.txt,copy sub/c.log,mkdir sub"; strings.Join(got, ",") != want {
+		t.Errorf("first sync: expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if got := readFile(t, fs3, "sub/b.txt"); got != "bb" {
+		t.Errorf("expected sub/b.txt to contain %q, got %q", "bb", got)
+	}
+
+	// Nothing has changed
+	if got := syncActions(t, local, fs3, s3fs.SyncOptions{}); len(got) != 0 {
+		t.Errorf("second sync: expected no changes, got %v", got)
+	}
+
+	// A newer file of the same size is copied
+	writeFile(t, local, "a.txt", "A")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "a.txt"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	got = syncActions(t, local, fs3, s3fs.SyncOptions{})
+	if want := "copy a.txt"; strings.Join(got, ",") != want {
+		t.Errorf("sync after a change: expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if got := readFile(t, fs3, "a.txt"); got != "A" {
+		t.Errorf("expected a.txt to contain %q, got %q", "A", got)
+	}
+}
+
+func TestSyncS3ToLocal(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "a.txt", "a")
+	writeFile(t, fs3, "sub/b.txt", "bb")
+	local := osfs.New(filepath.Join(t.TempDir(), "missing"))
+
+	syncActions(t, fs3, local, s3fs.SyncOptions{})
+	if got := readFile(t, local, "sub/b.txt"); got != "bb" {
+		t.Errorf("expected sub/b.txt to contain %q, got %q", "bb", got)
+	}
+	if got := syncActions(t, fs3, local, s3fs.SyncOptions{}); len(got) != 0 {
+		t.Errorf("second sync: expected no changes, got %v", got)
+	}
+}
+
+func TestSyncSeparator(t *testing.T) {
+	local := osfs.New(t.TempDir())
+	writeFile(t, local, "a.txt", "a")
+	writeFile(t, local, "sub/deeper/b.txt", "bb")
+	writeFile(t, local, "sub/c.log", "ccc")
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithSeparator(":"))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Paths are reported and filtered with slashes, but the objects are
+	// named with the filesystem's separator
+	got := syncActions(t, local, fs3, s3fs.SyncOptions{Exclude: []string{"sub/*.log"}})
+	if want := "copy a.txt,copy sub/deeper/b.txt,mkdir sub,mkdir sub/deeper"; strings.Join(got, ",") != want {
+		t.Errorf("first sync: expected %s, got %s", want, strings.Join(got, ","))
+	}
+	headObject(t, client, "sub:deeper:b.txt")
+	if got := syncActions(t, local, fs3, s3fs.SyncOptions{Exclude: []string{"sub/*.log"}}); len(got) != 0 {
+		t.Errorf("second sync: expected no changes, got %v", got)
+	}
+
+	// And back again
+	back := osfs.New(t.TempDir())
+	syncActions(t, fs3, back, s3fs.SyncOptions{})
+	if got := readFile(t, back, "sub/deeper/b.txt"); got != "bb" {
+		t.Errorf("expected sub/deeper/b.txt to contain %q, got %q", "bb", got)
+	}
+}
+
+func TestSyncChecksum(t *testing.T) {
+	src, dst := newTestSyncFS(t)
+	writeFile(t, dst, "same.txt", "same")
+	writeFile(t, dst, "diff.txt", "old!")
+	writeFile(t, src, "same.txt", "same")
+	writeFile(t, src, "diff.txt", "new!")
+
+	// The source files are newer, but only one has changed
+	got := syncActions(t, src, dst, s3fs.SyncOptions{Checksum: true})
+	if want := "copy diff.txt"; strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if got := readFile(t, dst, "diff.txt"); got != "new!" {
+		t.Errorf("expected diff.txt to contain %q, got %q", "new!", got)
+	}
+}
+
+func TestSyncDeleteAndFilters(t *testing.T) {
+	src, dst := newTestSyncFS(t)
+	writeFile(t, src, "keep.txt", "1")
+	writeFile(t, src, "skip.log", "2")
+	writeFile(t, src, "build/out.txt", "3")
+	writeFile(t, dst, "extra.txt", "4")
+	writeFile(t, dst, "old/gone.txt", "5")
+	writeFile(t, dst, "kept.log", "6")
+
+	opts := s3fs.SyncOptions{
+		Delete:  true,
+		Exclude: []string{"*.log", "build"},
+	}
+
+	// A dry run reports the changes without making them
+	dry := opts
+	dry.DryRun = true
+	got := syncActions(t, src, dst, dry)
+	want := "copy keep.txt,delete extra.txt,delete old"
+	if strings.Join(got, ",") != want {
+		t.Errorf("dry run: expected %s, got %s", want, strings.Join(got, ","))
+	}
+	if _, err := dst.Stat("keep.txt"); !os.IsNotExist(err) {
+		t.Errorf("dry run: expected keep.txt not to be copied, got %v", err)
+	}
+
+	if got := syncActions(t, src, dst, opts); strings.Join(got, ",") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, ","))
+	}
+	for name, exists := range map[string]bool{
+		"keep.txt":     true,
+		"kept.log":     true, // Excluded files aren't deleted
+		"skip.log":     false,
+		"build":        false,
+		"extra.txt":    false,
+		"old/gone.txt": false,
+	} {
+		if _, err := dst.Stat(name); (err == nil) != exists {
+			t.Errorf("%s: expected exists=%t, got %v", name, exists, err)
+		}
+	}
+
+	// Include limits the files copied
+	got = syncActions(t, src, newTestFS(t), s3fs.SyncOptions{
+		Include:               []string{"build/*"},
+		DisableServerSideCopy: true, // The filesystems are on different servers
+	})
+	if want := "copy build/out.txt,mkdir build"; strings.Join(got, ",") != want {
+		t.Errorf("include: expected %s, got %s", want, strings.Join(got, ","))
+	}
+
+	if _, err := s3fs.Sync(src, dst, s3fs.SyncOptions{Exclude: []string{"["}}); err == nil {
+		t.Error("expected an invalid pattern to fail")
+	}
+}
+
+func TestSyncServerSideCopy(t *testing.T) {
+	client, _ := newTestClient(t)
+	counting := &copyCountingClient{S3API: client}
+	fs3, err := s3fs.NewS3FS(counting, testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, fs3, "src/a.txt", "a")
+	writeFile(t, fs3, "src/b/c.txt", "c")
+	src, _ := fs3.Chroot("src")
+	dst, _ := fs3.Chroot("dst")
+
+	stats, err := s3fs.Sync(src, dst, s3fs.SyncOptions{})
+	if err != nil {
+		t.Fatalf("Sync: %s", err)
+	}
+	if stats.Copied != 2 || stats.Bytes != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if n := atomic.LoadInt32(&counting.copies); n != 2 {
+		t.Errorf("expected 2 CopyObject requests, got %d", n)
+	}
+	if got := readFile(t, fs3, "dst/b/c.txt"); got != "c" {
+		t.Errorf("expected dst/b/c.txt to contain %q, got %q", "c", got)
+	}
+}
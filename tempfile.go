@@ -1,8 +1,14 @@
 // tempfile.go implements the interface billy.TempFile
 
-package main
+package s3fs
 
-import "github.com/go-git/go-billy/v5"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+)
 
 // TempFile creates a new temporary file in the directory dir with a name
 // beginning with prefix, opens the file for reading and writing, and
@@ -12,6 +18,23 @@ import "github.com/go-git/go-billy/v5"
 // same file. The caller can use f.Name() to find the pathname of the file.
 // It is the caller's responsibility to remove the file when no longer
 // needed.
+//
+// NOTE: S3 has no temporary directory, so if dir is empty the file is
+// created in the root of the filesystem. The file is opened with O_RDWR,
+// so it's held in memory until it's closed (see OpenFile), and is usually
+// moved into place with Rename.
 func (fs3 *S3FS) TempFile(dir, prefix string) (billy.File, error) {
-	return nil, nil
+	for try := 0; ; try++ {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		name := fs3.Join(dir, prefix+hex.EncodeToString(b))
+
+		f, err := fs3.OpenFile(name, O_RDWR|O_CREATE|O_EXCL, 0600)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return f, err
+	}
 }
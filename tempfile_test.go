@@ -0,0 +1,49 @@
+package s3fs_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTempFile(t *testing.T) {
+	fs3 := newTestFS(t)
+	sub, err := fs3.Chroot("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := sub.TempFile("tmp", "foo_")
+	if err != nil {
+		t.Fatalf("TempFile: %s", err)
+	}
+	if !strings.HasPrefix(f.Name(), "tmp/foo_") {
+		t.Errorf("expected the name to start with %q, got %q", "tmp/foo_", f.Name())
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	// The name can be used to move the file into place
+	if err := sub.Rename(f.Name(), "foo"); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+	if got := readFile(t, fs3, "sub/foo"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+	if _, err := sub.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be gone, got %v", err)
+	}
+
+	// Temporary files have unique names
+	f1, _ := sub.TempFile("", "x")
+	f2, _ := sub.TempFile("", "x")
+	if f1.Name() == f2.Name() {
+		t.Errorf("expected unique names, got %q twice", f1.Name())
+	}
+	f1.Close()
+	f2.Close()
+}
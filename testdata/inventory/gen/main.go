@@ -0,0 +1,224 @@
+// Command gen writes the inventory data files in testdata/inventory with
+// independent ORC and Parquet writers, so that the readers in s3fs aren't
+// only tested against the encoders in its own tests.
+//
+// Run it from this directory with "go run .".
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/scritchley/orc"
+)
+
+// row is an object in the inventory, with the columns of an S3 Inventory
+// report.
+type row struct {
+	bucket       string
+	key          string
+	versionID    *string
+	latest       bool
+	deleteMarker bool
+	size         *int64
+	modTime      time.Time
+	etag         *string
+	storageClass string
+}
+
+// rows returns the objects in the inventory. Sizes include runs of the
+// same value, sequences, small values with outliers and random values, so
+// that every integer encoding is used.
+func rows() []row {
+	rnd := rand.New(rand.NewSource(1))
+	base := time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)
+	var rs []row
+	for i := 0; i < 3000; i++ {
+		r := row{
+			bucket:       "source-bucket",
+			key:          fmt.Sprintf("dir%d/sub%d/file-%05d.txt", i%7, i%13, i),
+			latest:       i%9 != 0,
+			deleteMarker: i%17 == 0,
+			modTime:      base.Add(time.Duration(rnd.Int63n(int64(365 * 24 * time.Hour)))).Truncate(time.Millisecond),
+			storageClass: []string{"STANDARD", "STANDARD_IA", "GLACIER"}[i%3],
+		}
+		if i%50 == 0 {
+			r.key = fmt.Sprintf("space dir/file %d+%%.txt", i)
+		}
+		if i%3 != 0 {
+			v := fmt.Sprintf("v%08x", rnd.Uint32())
+			r.versionID = &v
+		}
+		if !r.deleteMarker {
+			var size int64
+			switch (i / 100) % 4 {
+			case 0:
+				size = 4096
+			case 1:
+				size = int64(i * 10)
+			case 2:
+				size = rnd.Int63n(100)
+				if i%37 == 0 {
+					size = 1 << 40
+				}
+			default:
+				size = rnd.Int63n(1 << 33)
+			}
+			r.size = &size
+			etag := fmt.Sprintf("%032x", rnd.Uint64())
+			r.etag = &etag
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func main() {
+	rs := rows()
+	write("inventory.csv.gz", csvFile(rs))
+	write("inventory.zlib.orc", orcFile(rs, orc.CompressionZlib{}))
+	write("inventory.orc", orcFile(rs, orc.CompressionNone{}))
+	write("inventory.snappy.parquet", parquetFile(rs, compress.Codecs.Snappy, parquet.DataPageV1, true, false))
+	write("inventory.zstd.v2.parquet", parquetFile(rs, compress.Codecs.Zstd, parquet.DataPageV2, true, false))
+	write("inventory.gzip.int96.parquet", parquetFile(rs, compress.Codecs.Gzip, parquet.DataPageV1, false, true))
+}
+
+func write(name string, data []byte) {
+	if err := os.WriteFile("../"+name, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// csvFile writes the rows as a gzipped CSV report, with URL-encoded keys.
+func csvFile(rs []row) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := csv.NewWriter(gz)
+	for _, r := range rs {
+		size := ""
+		if r.size != nil {
+			size = strconv.FormatInt(*r.size, 10)
+		}
+		w.Write([]string{
+			r.bucket,
+			url.QueryEscape(r.key),
+			size,
+			r.modTime.Format(time.RFC3339Nano),
+			strconv.FormatBool(r.latest),
+			strconv.FormatBool(r.deleteMarker),
+		})
+	}
+	w.Flush()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// orcFile writes the rows as an ORC report with small stripes.
+func orcFile(rs []row, codec orc.CompressionCodec) []byte {
+	schema, err := orc.ParseSchema("struct<bucket:string,key:string,version_id:string,is_latest:boolean,is_delete_marker:boolean,size:bigint,last_modified_date:timestamp,e_tag:string,storage_class:string>")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := orc.NewWriter(&buf, orc.SetSchema(schema), orc.SetCompression(codec), orc.SetStripeTargetSize(32*1024))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, r := range rs {
+		var version, size, etag interface{}
+		if r.versionID != nil {
+			version = *r.versionID
+		}
+		if r.size != nil {
+			size = *r.size
+		}
+		if r.etag != nil {
+			etag = *r.etag
+		}
+		if err := w.Write(r.bucket, r.key, version, r.latest, r.deleteMarker, size, r.modTime, etag, r.storageClass); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// parquetFile writes the rows as a Parquet report with several row groups
+// and small pages.
+func parquetFile(rs []row, codec compress.Compression, version parquet.DataPageVersion, dict, int96 bool) []byte {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "bucket", Type: arrow.BinaryTypes.String},
+		{Name: "key", Type: arrow.BinaryTypes.String},
+		{Name: "version_id", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "is_latest", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "is_delete_marker", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "size", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "last_modified_date", Type: &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}},
+		{Name: "e_tag", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "storage_class", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+	for _, r := range rs {
+		b.Field(0).(*array.StringBuilder).Append(r.bucket)
+		b.Field(1).(*array.StringBuilder).Append(r.key)
+		if r.versionID != nil {
+			b.Field(2).(*array.StringBuilder).Append(*r.versionID)
+		} else {
+			b.Field(2).AppendNull()
+		}
+		b.Field(3).(*array.BooleanBuilder).Append(r.latest)
+		b.Field(4).(*array.BooleanBuilder).Append(r.deleteMarker)
+		if r.size != nil {
+			b.Field(5).(*array.Int64Builder).Append(*r.size)
+		} else {
+			b.Field(5).AppendNull()
+		}
+		b.Field(6).(*array.TimestampBuilder).Append(arrow.Timestamp(r.modTime.UnixNano() / int64(time.Millisecond)))
+		if r.etag != nil {
+			b.Field(7).(*array.StringBuilder).Append(*r.etag)
+		} else {
+			b.Field(7).AppendNull()
+		}
+		b.Field(8).(*array.StringBuilder).Append(r.storageClass)
+	}
+	rec := b.NewRecord()
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(codec),
+		parquet.WithDataPageVersion(version),
+		parquet.WithDictionaryDefault(dict),
+		parquet.WithMaxRowGroupLength(1000),
+		parquet.WithDataPageSize(4*1024),
+	)
+	w, err := pqarrow.NewFileWriter(schema, &buf, props, pqarrow.NewArrowWriterProperties(pqarrow.WithDeprecatedInt96Timestamps(int96)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Write(rec); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,105 @@
+// url.go constructs filesystems from s3:// URLs
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var (
+	ErrInvalidURL = errors.New("invalid s3 url")
+)
+
+// NewS3FSFromURL creates a new S3FS from a URL of the form
+//
+//	s3://bucket/prefix?region=us-west-2&endpoint=https://...&profile=dev&path_style=true
+//
+// The S3 client is created with config.LoadDefaultConfig, overridden by
+// any of the following query parameters:
+//
+//	region      the AWS region of the bucket
+//	endpoint    a custom S3 endpoint URL (e.g. for S3-compatible services)
+//	profile     the shared config profile to load credentials from
+//	path_style  use path-style addressing instead of virtual-hosted-style
+//
+// Each parameter may only be given once.
+//
+// The returned filesystem is chrooted to the URL's prefix (if any) and
+// configured with the given options.
+func NewS3FSFromURL(ctx context.Context, rawURL string, opts ...Option) (*S3FS, error) {
+	// Parse the URL
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, err)
+	}
+	if u.Scheme != "s3" {
+		return nil, fmt.Errorf("%w: scheme must be \"s3\", got %q", ErrInvalidURL, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing bucket name", ErrInvalidURL)
+	}
+
+	// Parse the query parameters
+	q := u.Query()
+	var cfgOpts []func(*config.LoadOptions) error
+	var clientOpts []func(*s3.Options)
+	for k, vs := range q {
+		if len(vs) > 1 {
+			return nil, fmt.Errorf("%w: repeated parameter %q", ErrInvalidURL, k)
+		}
+		v := vs[0]
+		switch k {
+		case "region":
+			cfgOpts = append(cfgOpts, config.WithRegion(v))
+		case "profile":
+			cfgOpts = append(cfgOpts, config.WithSharedConfigProfile(v))
+		case "endpoint":
+			if _, err := url.ParseRequestURI(v); err != nil {
+				return nil, fmt.Errorf("%w: invalid endpoint %q: %s", ErrInvalidURL, v, err)
+			}
+			clientOpts = append(clientOpts, func(o *s3.Options) {
+				o.EndpointResolver = s3.EndpointResolverFromURL(v)
+			})
+		case "path_style":
+			ps, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid path_style %q: %s", ErrInvalidURL, v, err)
+			}
+			clientOpts = append(clientOpts, func(o *s3.Options) {
+				o.UsePathStyle = ps
+			})
+		default:
+			return nil, fmt.Errorf("%w: unknown parameter %q", ErrInvalidURL, k)
+		}
+	}
+
+	// Create the client
+	cfg, err := config.LoadDefaultConfig(ctx, cfgOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aws config: %w", err)
+	}
+	client := s3.NewFromConfig(cfg, clientOpts...)
+
+	// Create the filesystem
+	fs3, err := NewS3FS(client, u.Host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		return fs3, nil
+	}
+
+	// Chroot to the prefix
+	nfs, err := fs3.Chroot(u.Path)
+	if err != nil {
+		return nil, err
+	}
+	return nfs.(*S3FS), nil
+}
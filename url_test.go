@@ -0,0 +1,84 @@
+package s3fs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/a-poor/s3fs/s3mem"
+)
+
+func TestNewS3FSFromURL(t *testing.T) {
+	backend := s3mem.New()
+	backend.CreateBucket(testBucket)
+	srv := httptest.NewServer(backend.Handler())
+	t.Cleanup(srv.Close)
+
+	// Load credentials and profiles from a test config, not the
+	// environment
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(cfg, []byte("[profile dev]\nregion = us-east-1\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", cfg)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	endpoint := "endpoint=" + srv.URL + "&path_style=true"
+	tests := []struct {
+		name string
+		url  string
+		root string // Expected root of the filesystem
+		err  error  // Expected error, if any
+	}{
+		{"bucket", "s3://" + testBucket + "?region=us-east-1&" + endpoint, "", nil},
+		{"prefix", "s3://" + testBucket + "/some/prefix?region=us-east-1&" + endpoint, "some/prefix", nil},
+		{"trailing slash", "s3://" + testBucket + "/?region=us-east-1&" + endpoint, "", nil},
+		{"profile", "s3://" + testBucket + "/p?profile=dev&" + endpoint, "p", nil},
+		{"bad url", "s3://" + testBucket + "/%zz", "", s3fs.ErrInvalidURL},
+		{"bad scheme", "https://" + testBucket + "/", "", s3fs.ErrInvalidURL},
+		{"no scheme", testBucket + "/prefix", "", s3fs.ErrInvalidURL},
+		{"no bucket", "s3:///prefix", "", s3fs.ErrInvalidURL},
+		{"bad endpoint", "s3://" + testBucket + "?endpoint=not-a-url", "", s3fs.ErrInvalidURL},
+		{"bad path_style", "s3://" + testBucket + "?path_style=maybe", "", s3fs.ErrInvalidURL},
+		{"unknown parameter", "s3://" + testBucket + "?regoin=us-east-1", "", s3fs.ErrInvalidURL},
+		{"repeated parameter", "s3://" + testBucket + "?region=us-east-1&region=eu-west-1", "", s3fs.ErrInvalidURL},
+		{"bad option", "s3://" + testBucket + "?region=us-east-1", "", s3fs.ErrInvalidOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []s3fs.Option
+			if tt.err == s3fs.ErrInvalidOption {
+				opts = append(opts, s3fs.WithPartSize(1))
+			}
+			fs3, err := s3fs.NewS3FSFromURL(context.Background(), tt.url, opts...)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewS3FSFromURL: %s", err)
+			}
+			if fs3.Root() != tt.root {
+				t.Errorf("expected root %q, got %q", tt.root, fs3.Root())
+			}
+
+			// The client talks to the endpoint
+			writeFile(t, fs3, "a.txt", tt.name)
+			if got := readFile(t, fs3, "a.txt"); got != tt.name {
+				t.Errorf("expected %q, got %q", tt.name, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// webdav.go implements a golang.org/x/net/webdav view of S3FS
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Ensure webdavFS implements webdav.FileSystem
+var _ webdav.FileSystem = (*webdavFS)(nil)
+
+// WebDAV returns a webdav.FileSystem view of the filesystem, for serving
+// with a webdav.Handler.
+//
+// Files are read into memory when opened and uploaded when closed, as
+// with OpenFile. Directories are removed and renamed by copying and
+// deleting every object beneath them, so large directories are slow to
+// move and a failed move can leave objects in both places. Empty
+// directories only exist if the filesystem uses a directory marker style
+// other than DirMarkerNone.
+//
+// Serve it with the LockSystem returned by WebDAVLockSystem, so WebDAV
+// locks and file locks (see billy.File.Lock) apply to each other.
+func (fs3 *S3FS) WebDAV() webdav.FileSystem {
+	return &webdavFS{fs3: fs3}
+}
+
+// webdavFS implements webdav.FileSystem for an S3FS.
+type webdavFS struct {
+	fs3 *S3FS
+}
+
+// path converts a slash-separated WebDAV path into an S3FS path.
+func (f *webdavFS) path(name string) string {
+	name = strings.Trim(name, "/")
+	return strings.ReplaceAll(name, "/", f.fs3.separator)
+}
+
+// Mkdir creates the named directory. Its parent must already exist.
+func (f *webdavFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := f.path(name)
+	if _, err := f.fs3.Stat(p); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// Check the parent directory exists
+	if i := strings.LastIndex(p, f.fs3.separator); i >= 0 {
+		info, err := f.fs3.Stat(p[:i])
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: ErrNotDirectory}
+		}
+	}
+	return f.fs3.MkdirAll(p, perm)
+}
+
+// OpenFile opens the named file or directory. Files opened for writing
+// replace the whole object when they are closed, so O_APPEND isn't
+// supported.
+func (f *webdavFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := f.path(name)
+
+	// Open the file for writing
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if flag&os.O_APPEND != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrOpenFlagNotSupported}
+		}
+		info, err := f.fs3.Stat(p)
+		switch {
+		case err == nil && info.IsDir():
+			return nil, &os.PathError{Op: "open", Path: name, Err: ErrIsDirectory}
+		case err == nil && flag&os.O_EXCL != 0 && flag&os.O_CREATE != 0:
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE == 0:
+			return nil, err
+		case err != nil && !errors.Is(err, os.ErrNotExist):
+			return nil, err
+		}
+
+		key, err := f.fs3.resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		wf, err := newS3WriteFile(f.fs3, f.fs3.bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		return &webdavWriteFile{s3WriteFile: wf, name: name}, nil
+	}
+
+	// Try to open it as a file
+	if p != "" {
+		key, err := f.fs3.resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		rf, err := newS3ReadFile(f.fs3, f.fs3.bucket, key)
+		if err == nil {
+			return &webdavFile{s3ReadFile: rf}, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	// Otherwise, it must be a directory
+	info, err := f.fs3.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return &webdavDir{fs3: f.fs3, path: p, name: name, info: info}, nil
+}
+
+// RemoveAll removes the named file or directory, along with everything
+// beneath it. Like webdav.Dir, it refuses to remove the root.
+func (f *webdavFS) RemoveAll(ctx context.Context, name string) error {
+	key, err := f.fs3.resolve(f.path(name))
+	if err != nil {
+		return err
+	}
+	if key == f.fs3.root {
+		return os.ErrInvalid
+	}
+	return f.fs3.removeAll(ctx, key)
+}
+
+// Rename moves the named file or directory.
+func (f *webdavFS) Rename(ctx context.Context, oldName, newName string) error {
+	src, dst := f.path(oldName), f.path(newName)
+	info, err := f.fs3.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return f.fs3.Rename(src, dst)
+	}
+
+	srcKey, err := f.fs3.resolve(src)
+	if err != nil {
+		return err
+	}
+	dstKey, err := f.fs3.resolve(dst)
+	if err != nil {
+		return err
+	}
+	if srcKey == "" || strings.HasPrefix(dstKey+f.fs3.separator, srcKey+f.fs3.separator) {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrInvalid}
+	}
+	return f.fs3.renameDir(ctx, srcKey, dstKey)
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+func (f *webdavFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	return f.fs3.Stat(f.path(name))
+}
+
+// webdavFile implements webdav.File for a file opened in read mode.
+type webdavFile struct {
+	*s3ReadFile
+}
+
+// Readdir always fails, since files aren't directories.
+func (f *webdavFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.key, Err: ErrNotDirectory}
+}
+
+// webdavWriteFile implements webdav.File for a file opened in write mode.
+type webdavWriteFile struct {
+	*s3WriteFile
+	name string // Name as passed to OpenFile
+}
+
+// Readdir always fails, since files aren't directories.
+func (f *webdavWriteFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, &os.PathError{Op: "readdir", Path: f.name, Err: ErrNotDirectory}
+}
+
+// Stat describes the file as it will be once it is uploaded.
+func (f *webdavWriteFile) Stat() (os.FileInfo, error) {
+	name := f.fs.keyName(f.key)
+	return newFileInfo(name, int64(f.buf.Len()), time.Now(), nil), nil
+}
+
+// webdavDir implements webdav.File for a directory.
+type webdavDir struct {
+	fs3     *S3FS
+	path    string        // S3FS path of the directory
+	name    string        // Name as passed to OpenFile
+	info    os.FileInfo   // Directory info
+	entries []os.FileInfo // Directory entries, read on first Readdir call
+	read    bool          // Have the entries been read?
+	closed  bool          // Is the directory closed?
+}
+
+// Stat returns a FileInfo describing the directory.
+func (d *webdavDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+// Read always fails, since directories can't be read.
+func (d *webdavDir) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: d.name, Err: ErrIsDirectory}
+}
+
+// Write always fails, since directories can't be written.
+func (d *webdavDir) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: d.name, Err: ErrIsDirectory}
+}
+
+// Seek always fails, since directories can't be read.
+func (d *webdavDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, &os.PathError{Op: "seek", Path: d.name, Err: ErrIsDirectory}
+}
+
+// Close closes the directory.
+func (d *webdavDir) Close() error {
+	if d.closed {
+		return ErrFileClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// Readdir returns the next count entries of the directory, following the
+// semantics of os.File.Readdir.
+func (d *webdavDir) Readdir(count int) ([]os.FileInfo, error) {
+	if d.closed {
+		return nil, ErrFileClosed
+	}
+
+	// Read the entries on the first call
+	if !d.read {
+		entries, err := d.fs3.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if count <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	res := d.entries[:count]
+	d.entries = d.entries[count:]
+	return res, nil
+}
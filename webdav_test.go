@@ -0,0 +1,356 @@
+package s3fs_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-poor/s3fs"
+	"golang.org/x/net/webdav"
+)
+
+// newTestDAVServer serves fs3 over WebDAV and returns the server's URL.
+func newTestDAVServer(t *testing.T, fs3 *s3fs.S3FS) string {
+	t.Helper()
+
+	srv := httptest.NewServer(&webdav.Handler{
+		FileSystem: fs3.WebDAV(),
+		LockSystem: fs3.WebDAVLockSystem(),
+	})
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// dav makes a WebDAV request and returns the response status and body.
+func dav(t *testing.T, method, url, body string, hdr map[string]string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, url, err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading body: %s", err)
+	}
+	return res.StatusCode, string(b)
+}
+
+func TestWebDAVFiles(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator))
+	u := newTestDAVServer(t, fs3)
+
+	if status, _ := dav(t, "MKCOL", u+"/docs", "", nil); status != http.StatusCreated {
+		t.Fatalf("MKCOL: expected status 201, got %d", status)
+	}
+	if status, _ := dav(t, "MKCOL", u+"/docs", "", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL existing: expected status 405, got %d", status)
+	}
+	if status, _ := dav(t, "MKCOL", u+"/missing/docs", "", nil); status != http.StatusConflict {
+		t.Errorf("MKCOL without parent: expected status 409, got %d", status)
+	}
+
+	if status, _ := dav(t, http.MethodPut, u+"/docs/hello.txt", "Hello, World!", nil); status != http.StatusCreated {
+		t.Fatalf("PUT: expected status 201, got %d", status)
+	}
+	if got := readFile(t, fs3, "docs/hello.txt"); got != "Hello, World!" {
+		t.Errorf("expected file contents %q, got %q", "Hello, World!", got)
+	}
+
+	status, body := dav(t, http.MethodGet, u+"/docs/hello.txt", "", nil)
+	if status != http.StatusOK || body != "Hello, World!" {
+		t.Errorf("GET: expected 200 %q, got %d %q", "Hello, World!", status, body)
+	}
+
+	status, body = dav(t, "PROPFIND", u+"/docs/", "", map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND: expected status 207, got %d", status)
+	}
+	if !strings.Contains(body, "/docs/hello.txt") {
+		t.Errorf("PROPFIND: expected hello.txt in response, got %s", body)
+	}
+
+	status, _ = dav(t, "MOVE", u+"/docs/hello.txt", "", map[string]string{"Destination": u + "/docs/moved.txt"})
+	if status != http.StatusCreated {
+		t.Fatalf("MOVE: expected status 201, got %d", status)
+	}
+	if _, err := fs3.Stat("docs/hello.txt"); err == nil {
+		t.Error("expected the source file to be gone after MOVE")
+	}
+	if got := readFile(t, fs3, "docs/moved.txt"); got != "Hello, World!" {
+		t.Errorf("expected moved file contents %q, got %q", "Hello, World!", got)
+	}
+
+	if status, _ := dav(t, http.MethodDelete, u+"/docs/moved.txt", "", nil); status != http.StatusNoContent {
+		t.Errorf("DELETE: expected status 204, got %d", status)
+	}
+	if status, _ := dav(t, http.MethodGet, u+"/docs/moved.txt", "", nil); status != http.StatusNotFound {
+		t.Errorf("GET deleted: expected status 404, got %d", status)
+	}
+}
+
+func TestWebDAVDirectories(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator))
+	writeFile(t, fs3, "a/one.txt", "1")
+	writeFile(t, fs3, "a/b/two.txt", "2")
+	u := newTestDAVServer(t, fs3)
+
+	status, _ := dav(t, "MOVE", u+"/a/", "", map[string]string{"Destination": u + "/c/"})
+	if status != http.StatusCreated {
+		t.Fatalf("MOVE: expected status 201, got %d", status)
+	}
+	if got := readFile(t, fs3, "c/b/two.txt"); got != "2" {
+		t.Errorf("expected moved file contents %q, got %q", "2", got)
+	}
+	if _, err := fs3.Stat("a"); err == nil {
+		t.Error("expected the source directory to be gone after MOVE")
+	}
+
+	status, _ = dav(t, "COPY", u+"/c/", "", map[string]string{"Destination": u + "/d/"})
+	if status != http.StatusCreated {
+		t.Fatalf("COPY: expected status 201, got %d", status)
+	}
+	if got := readFile(t, fs3, "d/one.txt"); got != "1" {
+		t.Errorf("expected copied file contents %q, got %q", "1", got)
+	}
+
+	if status, _ := dav(t, http.MethodDelete, u+"/c/", "", nil); status != http.StatusNoContent {
+		t.Errorf("DELETE: expected status 204, got %d", status)
+	}
+	if _, err := fs3.Stat("c"); err == nil {
+		t.Error("expected the directory to be gone after DELETE")
+	}
+	if got := readFile(t, fs3, "d/b/two.txt"); got != "2" {
+		t.Errorf("expected copied file contents %q, got %q", "2", got)
+	}
+}
+
+func TestWebDAVLock(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "locked.txt", "original")
+	u := newTestDAVServer(t, fs3)
+
+	if status, _ := dav(t, "LOCK", u+"/locked.txt", lockBody, nil); status != http.StatusOK {
+		t.Fatalf("LOCK: expected status 200, got %d", status)
+	}
+
+	// Writes without the lock token are refused
+	if status, _ := dav(t, http.MethodPut, u+"/locked.txt", "changed", nil); status != http.StatusLocked {
+		t.Errorf("PUT: expected status 423, got %d", status)
+	}
+	if got := readFile(t, fs3, "locked.txt"); got != "original" {
+		t.Errorf("expected file contents %q, got %q", "original", got)
+	}
+}
+
+// lockBody is the body of a LOCK request for an exclusive write lock.
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+  <D:lockscope><D:exclusive/></D:lockscope>
+  <D:locktype><D:write/></D:locktype>
+</D:lockinfo>`
+
+// davLock locks the resource at url with the given depth, returning the
+// response status and lock token.
+func davLock(t *testing.T, url, depth string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest("LOCK", url, strings.NewReader(lockBody))
+	if err != nil {
+		t.Fatalf("NewRequest: %s", err)
+	}
+	req.Header.Set("Depth", depth)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("LOCK %s: %s", url, err)
+	}
+	res.Body.Close()
+	return res.StatusCode, res.Header.Get("Lock-Token")
+}
+
+func TestWebDAVLockToken(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "locked.txt", "original")
+	u := newTestDAVServer(t, fs3)
+
+	status, token := davLock(t, u+"/locked.txt", "0")
+	if status != http.StatusOK || token == "" {
+		t.Fatalf("LOCK: expected status 200 with a token, got %d %q", status, token)
+	}
+
+	// Writes with the lock token are allowed
+	status, _ = dav(t, http.MethodPut, u+"/locked.txt", "changed", map[string]string{"If": "(" + token + ")"})
+	if status != http.StatusCreated {
+		t.Errorf("PUT with token: expected status 201, got %d", status)
+	}
+	if got := readFile(t, fs3, "locked.txt"); got != "changed" {
+		t.Errorf("expected file contents %q, got %q", "changed", got)
+	}
+
+	// Locking the file blocks until the WebDAV lock is removed
+	f, err := fs3.Open("locked.txt")
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer f.Close()
+	locked := make(chan error, 1)
+	go func() { locked <- f.Lock() }()
+	select {
+	case err := <-locked:
+		t.Fatalf("expected Lock to block, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	status, _ = dav(t, "UNLOCK", u+"/locked.txt", "", map[string]string{"Lock-Token": token})
+	if status != http.StatusNoContent {
+		t.Fatalf("UNLOCK: expected status 204, got %d", status)
+	}
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Fatalf("Lock: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Lock to succeed after UNLOCK")
+	}
+
+	// WebDAV locks can't be taken while the file is locked
+	if status, _ := davLock(t, u+"/locked.txt", "0"); status != http.StatusLocked {
+		t.Errorf("LOCK locked file: expected status 423, got %d", status)
+	}
+	if status, _ := dav(t, http.MethodPut, u+"/locked.txt", "again", nil); status != http.StatusLocked {
+		t.Errorf("PUT locked file: expected status 423, got %d", status)
+	}
+	if err := f.Unlock(); err != nil {
+		t.Fatalf("Unlock: %s", err)
+	}
+	if status, _ := davLock(t, u+"/locked.txt", "0"); status != http.StatusOK {
+		t.Errorf("LOCK unlocked file: expected status 200, got %d", status)
+	}
+}
+
+func TestWebDAVLockDepth(t *testing.T) {
+	fs3 := newTestFS(t, s3fs.WithDirMarkerStyle(s3fs.DirMarkerTrailingSeparator))
+	writeFile(t, fs3, "dir/a.txt", "a")
+	writeFile(t, fs3, "dir/sub/b.txt", "b")
+	writeFile(t, fs3, "other.txt", "c")
+	u := newTestDAVServer(t, fs3)
+
+	// Infinite depth locks cover everything beneath the directory
+	status, _ := davLock(t, u+"/dir/", "infinity")
+	if status != http.StatusOK {
+		t.Fatalf("LOCK: expected status 200, got %d", status)
+	}
+	if status, _ := dav(t, http.MethodPut, u+"/dir/sub/b.txt", "changed", nil); status != http.StatusLocked {
+		t.Errorf("PUT beneath lock: expected status 423, got %d", status)
+	}
+	if status, _ := davLock(t, u+"/dir/sub/b.txt", "0"); status != http.StatusLocked {
+		t.Errorf("LOCK beneath lock: expected status 423, got %d", status)
+	}
+	if status, _ := dav(t, http.MethodPut, u+"/other.txt", "changed", nil); status != http.StatusCreated {
+		t.Errorf("PUT outside lock: expected status 201, got %d", status)
+	}
+
+	// Locks are shared with chroots of the filesystem
+	sub, err := fs3.Chroot("dir")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	su := newTestDAVServer(t, sub.(*s3fs.S3FS))
+	if status, _ := dav(t, http.MethodPut, su+"/a.txt", "changed", nil); status != http.StatusLocked {
+		t.Errorf("PUT in chroot: expected status 423, got %d", status)
+	}
+	if status, _ := davLock(t, su+"/", "infinity"); status != http.StatusLocked {
+		t.Errorf("LOCK chroot: expected status 423, got %d", status)
+	}
+}
+
+func TestWebDAVLockExpiry(t *testing.T) {
+	fs3 := newTestFS(t)
+	ls := fs3.WebDAVLockSystem()
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/a.txt", Duration: time.Minute, ZeroDepth: true})
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if _, err := ls.Create(now, webdav.LockDetails{Root: "/a.txt", Duration: time.Minute}); err != webdav.ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+
+	// Held locks can't be confirmed again, refreshed or unlocked
+	release, err := ls.Confirm(now, "/a.txt", "", webdav.Condition{Token: token})
+	if err != nil {
+		t.Fatalf("Confirm: %s", err)
+	}
+	if _, err := ls.Confirm(now, "/a.txt", "", webdav.Condition{Token: token}); err != webdav.ErrConfirmationFailed {
+		t.Errorf("expected ErrConfirmationFailed, got %v", err)
+	}
+	if _, err := ls.Refresh(now, token, time.Minute); err != webdav.ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	release()
+	if _, err := ls.Confirm(now, "/b.txt", "", webdav.Condition{Token: token}); err != webdav.ErrConfirmationFailed {
+		t.Errorf("expected ErrConfirmationFailed for another resource, got %v", err)
+	}
+
+	// Refreshed locks last longer, then expire
+	if _, err := ls.Refresh(now, token, 2*time.Minute); err != nil {
+		t.Fatalf("Refresh: %s", err)
+	}
+	if _, err := ls.Create(now.Add(90*time.Second), webdav.LockDetails{Root: "/a.txt"}); err != webdav.ErrLocked {
+		t.Errorf("expected ErrLocked before the lock expires, got %v", err)
+	}
+	if _, err := ls.Create(now.Add(3*time.Minute), webdav.LockDetails{Root: "/a.txt"}); err != nil {
+		t.Errorf("expected the lock to have expired, got %v", err)
+	}
+	if err := ls.Unlock(now.Add(3*time.Minute), token); err != webdav.ErrNoSuchLock {
+		t.Errorf("expected ErrNoSuchLock, got %v", err)
+	}
+}
+
+func TestWebDAVRemoveRoot(t *testing.T) {
+	fs3 := newTestFS(t)
+	writeFile(t, fs3, "a.txt", "a")
+	writeFile(t, fs3, "dir/b.txt", "b")
+	u := newTestDAVServer(t, fs3)
+
+	if status, _ := dav(t, http.MethodDelete, u+"/", "", nil); status < 400 {
+		t.Errorf("DELETE /: expected an error status, got %d", status)
+	}
+	for _, name := range []string{"/", "", "."} {
+		if err := fs3.WebDAV().RemoveAll(context.Background(), name); !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("RemoveAll(%q): expected os.ErrInvalid, got %v", name, err)
+		}
+	}
+
+	// Nor can the root of a chroot be removed
+	sub, err := fs3.Chroot("dir")
+	if err != nil {
+		t.Fatalf("Chroot: %s", err)
+	}
+	if err := sub.(*s3fs.S3FS).WebDAV().RemoveAll(context.Background(), "/"); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("RemoveAll in chroot: expected os.ErrInvalid, got %v", err)
+	}
+
+	if got := readFile(t, fs3, "a.txt"); got != "a" {
+		t.Errorf("expected a.txt to be kept, got %q", got)
+	}
+	if got := readFile(t, fs3, "dir/b.txt"); got != "b" {
+		t.Errorf("expected dir/b.txt to be kept, got %q", got)
+	}
+}
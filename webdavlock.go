@@ -0,0 +1,228 @@
+// webdavlock.go implements a golang.org/x/net/webdav LockSystem on top of
+// the lock table
+
+package s3fs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// Ensure webdavLS implements webdav.LockSystem
+var _ webdav.LockSystem = (*webdavLS)(nil)
+
+// WebDAVLockSystem returns a webdav.LockSystem for serving the WebDAV view
+// of the filesystem (see WebDAV) with a webdav.Handler.
+//
+// WebDAV locks are kept in the same table as the locks taken with
+// billy.File.Lock, shared with the filesystem's chroots. A WebDAV lock
+// can't be created while an open file it covers is locked, and locking a
+// file blocks while a WebDAV lock covers it. As with file locks, this only
+// coordinates clients of the same process, since S3 has no locking.
+func (fs3 *S3FS) WebDAVLockSystem() webdav.LockSystem {
+	return &webdavLS{fs3: fs3, fs: &webdavFS{fs3: fs3}}
+}
+
+// davLock is a lock created by a WebDAV client.
+type davLock struct {
+	key      string // Lock table key of the locked resource
+	prefix   string // Prefix of the lock table keys beneath the resource
+	details  webdav.LockDetails
+	expiry   time.Time     // When the lock expires, or zero if it doesn't
+	held     bool          // Is the lock held by a Confirm call?
+	released chan struct{} // Closed when the lock is removed
+}
+
+// covers reports whether the lock applies to the lock table key.
+func (l *davLock) covers(key string) bool {
+	return key == l.key || (!l.details.ZeroDepth && strings.HasPrefix(key, l.prefix))
+}
+
+// davLockOn returns a WebDAV lock covering key, or nil if there isn't one.
+// The caller must hold t.mu.
+func (t *lockTable) davLockOn(key string) *davLock {
+	for _, l := range t.dav {
+		if l.covers(key) {
+			return l
+		}
+	}
+	return nil
+}
+
+// expire removes the WebDAV locks that have expired, other than those
+// held by Confirm calls. The caller must hold t.mu.
+func (t *lockTable) expire(now time.Time) {
+	for token, l := range t.dav {
+		if !l.held && !l.expiry.IsZero() && !now.Before(l.expiry) {
+			t.removeDAV(token)
+		}
+	}
+}
+
+// removeDAV removes the WebDAV lock with the given token. The caller must
+// hold t.mu.
+func (t *lockTable) removeDAV(token string) {
+	if l, ok := t.dav[token]; ok {
+		close(l.released)
+		delete(t.dav, token)
+	}
+}
+
+// webdavLS implements webdav.LockSystem for an S3FS.
+type webdavLS struct {
+	fs3 *S3FS
+	fs  *webdavFS // Used to convert WebDAV names to paths
+}
+
+// keys returns the lock table key of the named resource, and the prefix
+// of the keys beneath it.
+func (ls *webdavLS) keys(name string) (string, string, error) {
+	key, err := ls.fs3.resolve(ls.fs.path(name))
+	if err != nil {
+		return "", "", err
+	}
+	if key == "" {
+		return lockKey(ls.fs3.bucket, ""), lockKey(ls.fs3.bucket, ""), nil
+	}
+	return lockKey(ls.fs3.bucket, key), lockKey(ls.fs3.bucket, key+ls.fs3.separator), nil
+}
+
+// Confirm checks that the conditions name locks covering the named
+// resources, and holds those locks until release is called.
+func (ls *webdavLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	t := ls.fs3.locks
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	// Find a lock for each resource
+	var held []*davLock
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		key, _, err := ls.keys(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var found *davLock
+		for _, c := range conditions {
+			// TODO: Support Condition.Not and Condition.ETag, like
+			// webdav.NewMemLS
+			l := t.dav[c.Token]
+			if l != nil && !l.held && l.covers(key) {
+				found = l
+				break
+			}
+		}
+		if found == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		if len(held) == 0 || held[0] != found {
+			held = append(held, found)
+		}
+	}
+
+	for _, l := range held {
+		l.held = true
+	}
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		for _, l := range held {
+			l.held = false
+		}
+		t.expire(time.Now())
+	}, nil
+}
+
+// Create locks the resource named by details.Root, failing with
+// webdav.ErrLocked if it conflicts with a WebDAV lock or a locked file.
+func (ls *webdavLS) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	key, prefix, err := ls.keys(details.Root)
+	if err != nil {
+		return "", err
+	}
+	l := &davLock{
+		key:      key,
+		prefix:   prefix,
+		details:  details,
+		released: make(chan struct{}),
+	}
+	if details.Duration >= 0 {
+		l.expiry = now.Add(details.Duration)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := "urn:s3fs:lock:" + hex.EncodeToString(b)
+
+	t := ls.fs3.locks
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	// Check for locks on the resource, its ancestors or (for infinite depth
+	// locks) anything beneath it
+	for _, dl := range t.dav {
+		if dl.covers(key) || l.covers(dl.key) {
+			return "", webdav.ErrLocked
+		}
+	}
+	for k := range t.locks {
+		if l.covers(k) {
+			return "", webdav.ErrLocked
+		}
+	}
+
+	t.dav[token] = l
+	return token, nil
+}
+
+// Refresh extends the lock with the given token.
+func (ls *webdavLS) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	t := ls.fs3.locks
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	l := t.dav[token]
+	if l == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	l.details.Duration = duration
+	l.expiry = time.Time{}
+	if duration >= 0 {
+		l.expiry = now.Add(duration)
+	}
+	return l.details, nil
+}
+
+// Unlock removes the lock with the given token.
+func (ls *webdavLS) Unlock(now time.Time, token string) error {
+	t := ls.fs3.locks
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(now)
+
+	l := t.dav[token]
+	if l == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if l.held {
+		return webdav.ErrLocked
+	}
+	t.removeDAV(token)
+	return nil
+}
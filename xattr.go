@@ -0,0 +1,262 @@
+// xattr.go implements extended attributes, stored as user metadata
+
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	internalMetaPrefix = "s3fs-" // Prefix of the metadata keys used by s3fs itself
+)
+
+var (
+	ErrNoXattr      = errors.New("no such attribute")
+	ErrInvalidXattr = errors.New("invalid attribute")
+)
+
+// XattrSetter is implemented by files opened for writing, whose attributes
+// can be set before they're closed and uploaded.
+type XattrSetter interface {
+	SetXattr(name, value string) error
+}
+
+// xattrKey validates an attribute name, returning it as a metadata key.
+// S3 returns metadata keys in lower case, so names are case-insensitive.
+func xattrKey(name string) (string, error) {
+	key := strings.ToLower(name)
+	if key == "" {
+		return "", fmt.Errorf("%w: name cannot be empty", ErrInvalidXattr)
+	}
+	if reservedMetaKey(key) {
+		return "", fmt.Errorf("%w: %q is reserved", ErrInvalidXattr, name)
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.", c)) {
+			return "", fmt.Errorf("%w: %q contains %q", ErrInvalidXattr, name, c)
+		}
+	}
+	return key, nil
+}
+
+// checkXattrValue checks that an attribute value can be sent as an HTTP
+// header.
+func checkXattrValue(value string) error {
+	for _, c := range value {
+		if c < ' ' || c > '~' {
+			return fmt.Errorf("%w: values must be printable ASCII", ErrInvalidXattr)
+		}
+	}
+	return nil
+}
+
+// setXattr validates an attribute and adds it to the metadata map.
+func setXattr(meta map[string]string, name, value string) error {
+	key, err := xattrKey(name)
+	if err != nil {
+		return err
+	}
+	if err := checkXattrValue(value); err != nil {
+		return err
+	}
+	meta[key] = value
+	return nil
+}
+
+// reservedMetaKey reports whether a metadata key is used by s3fs, either
+// for its own metadata or for a file's POSIX attributes.
+func reservedMetaKey(key string) bool {
+	switch key {
+	case metaMode, metaUID, metaGID, metaMtime, metaAtime:
+		return true
+	}
+	return internalMetaKey(key)
+}
+
+// internalMetaKey reports whether a metadata key is one of s3fs's own.
+func internalMetaKey(key string) bool {
+	return strings.HasPrefix(key, internalMetaPrefix)
+}
+
+// userMetadata returns the metadata without the keys used by s3fs.
+func userMetadata(meta map[string]string) map[string]string {
+	return filterMetadata(meta, reservedMetaKey)
+}
+
+// filterMetadata returns the metadata without the keys that drop reports
+// true for, or nil if there are none left.
+func filterMetadata(meta map[string]string, drop func(string) bool) map[string]string {
+	var res map[string]string
+	for k, v := range meta {
+		if drop(k) {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// GetXattr returns the value of the named attribute of a file.
+func (fs3 *S3FS) GetXattr(filename, name string) (string, error) {
+	key, err := xattrKey(name)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	meta, err := fs3.headMetadata(ctx, "getxattr", filename)
+	if err != nil {
+		return "", err
+	}
+	v, ok := meta[key]
+	if !ok {
+		return "", &os.PathError{Op: "getxattr", Path: filename, Err: ErrNoXattr}
+	}
+	return v, nil
+}
+
+// ListXattr returns the sorted names of a file's attributes.
+func (fs3 *S3FS) ListXattr(filename string) ([]string, error) {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	meta, err := fs3.headMetadata(ctx, "listxattr", filename)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(meta))
+	for k := range userMetadata(meta) {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetXattr sets the named attribute of a file, replacing its metadata by
+// copying the object onto itself. The object's tags, headers and
+// encryption are kept, but its ACL is reset.
+func (fs3 *S3FS) SetXattr(filename, name, value string) error {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	return fs3.updateMetadata(ctx, "setxattr", filename, func(meta map[string]string) error {
+		return setXattr(meta, name, value)
+	})
+}
+
+// RemoveXattr removes the named attribute of a file, the same way
+// SetXattr sets it.
+func (fs3 *S3FS) RemoveXattr(filename, name string) error {
+	ctx := context.TODO() // TODO: Get user-supplied context?
+	return fs3.updateMetadata(ctx, "removexattr", filename, func(meta map[string]string) error {
+		key, err := xattrKey(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := meta[key]; !ok {
+			return &os.PathError{Op: "removexattr", Path: filename, Err: ErrNoXattr}
+		}
+		delete(meta, key)
+		return nil
+	})
+}
+
+// headObject describes the object for a file, returning an
+// os.ErrNotExist error (for the given operation) if there isn't one.
+func (fs3 *S3FS) headObject(ctx context.Context, op, filename string) (string, *s3.HeadObjectOutput, error) {
+	key, err := fs3.resolve(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	in := &s3.HeadObjectInput{
+		Bucket: &fs3.bucket,
+		Key:    &key,
+	}
+	fs3.enc.applyHead(in)
+	res, err := fs3.client.HeadObject(ctx, in, fs3.optFns...)
+	if isNotFound(err) {
+		return "", nil, &os.PathError{Op: op, Path: filename, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to perform HeadObject operation: %w", err)
+	}
+	return key, res, nil
+}
+
+// headMetadata returns the metadata of the object for a file.
+func (fs3 *S3FS) headMetadata(ctx context.Context, op, filename string) (map[string]string, error) {
+	_, res, err := fs3.headObject(ctx, op, filename)
+	if err != nil {
+		return nil, err
+	}
+	return res.Metadata, nil
+}
+
+// updateMetadata replaces the metadata of the object for a file by
+// copying it onto itself, in parts if it's larger than the copy threshold.
+// update is given a copy of the current metadata to modify. The copy only
+// succeeds if the object hasn't changed since it was described.
+func (fs3 *S3FS) updateMetadata(ctx context.Context, op, filename string, update func(map[string]string) error) error {
+	key, head, err := fs3.headObject(ctx, op, filename)
+	if err != nil {
+		return err
+	}
+	meta := make(map[string]string, len(head.Metadata))
+	for k, v := range head.Metadata {
+		meta[k] = v
+	}
+	if err := update(meta); err != nil {
+		return err
+	}
+
+	if head.ContentLength > fs3.copyThreshold {
+		err = fs3.copyInParts(ctx, key, key, head, meta)
+	} else {
+		err = fs3.copyInPlace(ctx, key, head, meta)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The cached Stat result is now out of date
+	fs3.statCache.remove(key)
+	return nil
+}
+
+// copyInPlace replaces the metadata of an object by copying it onto itself
+// with CopyObject.
+func (fs3 *S3FS) copyInPlace(ctx context.Context, key string, head *s3.HeadObjectOutput, meta map[string]string) error {
+	in := &s3.CopyObjectInput{
+		Bucket:             &fs3.bucket,
+		CopySource:         aws.String(copySource(fs3.bucket, key)),
+		Key:                &key,
+		CopySourceIfMatch:  head.ETag,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           meta,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		Expires:            head.Expires,
+		StorageClass:       head.StorageClass,
+	}
+	fs3.enc.applyCopy(in, fs3.enc)
+
+	// Keep the object's own SSE-S3 or SSE-KMS settings
+	if in.ServerSideEncryption == "" && in.SSECustomerKey == nil {
+		in.ServerSideEncryption = head.ServerSideEncryption
+		in.SSEKMSKeyId = head.SSEKMSKeyId
+	}
+	if _, err := fs3.client.CopyObject(ctx, in, fs3.optFns...); err != nil {
+		return fmt.Errorf("unable to perform CopyObject operation: %w", err)
+	}
+	return nil
+}
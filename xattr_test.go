@@ -0,0 +1,216 @@
+package s3fs_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/a-poor/s3fs"
+	"github.com/a-poor/s3fs/s3mem"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestXattr(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+	writeWithOptions(t, fs3, "report.csv", s3fs.O_WRONLY, []byte("a,b\n"),
+		s3fs.WithFileHeaders(s3fs.Headers{CacheControl: "no-cache"}),
+		s3fs.WithFileTags(map[string]string{"team": "data"}),
+	)
+
+	// Set, get and list attributes
+	if err := fs3.SetXattr("report.csv", "Build-ID", "42"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	if err := fs3.SetXattr("report.csv", "commit", "abc123"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	if v, err := fs3.GetXattr("report.csv", "build-id"); err != nil || v != "42" {
+		t.Errorf("GetXattr: expected %q, got %q (%v)", "42", v, err)
+	}
+	names, err := fs3.ListXattr("report.csv")
+	if err != nil {
+		t.Fatalf("ListXattr: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"build-id", "commit"}) {
+		t.Errorf("unexpected attributes %v", names)
+	}
+
+	// The object's contents, headers and tags are kept
+	res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("report.csv"),
+	})
+	if err != nil {
+		t.Fatalf("GetObject: %s", err)
+	}
+	res.Body.Close()
+	if got := aws.ToString(res.ContentType); got != "text/csv; charset=utf-8" && got != "text/csv" {
+		t.Errorf("expected the Content-Type to be kept, got %q", got)
+	}
+	if got := aws.ToString(res.CacheControl); got != "no-cache" {
+		t.Errorf("expected the Cache-Control to be kept, got %q", got)
+	}
+	if res.TagCount != 1 {
+		t.Errorf("expected the tags to be kept, got %d", res.TagCount)
+	}
+	if got := readFile(t, fs3, "report.csv"); got != "a,b\n" {
+		t.Errorf("expected the contents to be kept, got %q", got)
+	}
+
+	// Remove an attribute
+	if err := fs3.RemoveXattr("report.csv", "BUILD-ID"); err != nil {
+		t.Fatalf("RemoveXattr: %s", err)
+	}
+	if _, err := fs3.GetXattr("report.csv", "build-id"); !errors.Is(err, s3fs.ErrNoXattr) {
+		t.Errorf("expected ErrNoXattr after removing the attribute, got %v", err)
+	}
+	if err := fs3.RemoveXattr("report.csv", "build-id"); !errors.Is(err, s3fs.ErrNoXattr) {
+		t.Errorf("expected ErrNoXattr removing a missing attribute, got %v", err)
+	}
+
+	// Errors
+	if _, err := fs3.GetXattr("missing", "a"); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error for a missing file, got %v", err)
+	}
+	if err := fs3.SetXattr("missing", "a", "b"); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error for a missing file, got %v", err)
+	}
+	for name, value := range map[string]string{
+		"":            "x",
+		"has space":   "x",
+		"s3fs-cipher": "x",
+		"valid":       "café",
+	} {
+		if err := fs3.SetXattr("report.csv", name, value); !errors.Is(err, s3fs.ErrInvalidXattr) {
+			t.Errorf("SetXattr(%q, %q): expected ErrInvalidXattr, got %v", name, value, err)
+		}
+	}
+}
+
+func TestXattrEncrypted(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3 := newEncryptedFS(t, client, 1)
+	writeFile(t, fs3, "secret.txt", "hello")
+
+	if err := fs3.SetXattr("secret.txt", "owner", "alice"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+
+	// The encryption metadata is kept, but hidden
+	if got := readFile(t, fs3, "secret.txt"); got != "hello" {
+		t.Errorf("expected %q, got %q", "hello", got)
+	}
+	names, err := fs3.ListXattr("secret.txt")
+	if err != nil {
+		t.Fatalf("ListXattr: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"owner"}) {
+		t.Errorf("unexpected attributes %v", names)
+	}
+}
+
+func TestXattrWriteHandles(t *testing.T) {
+	client, _ := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket)
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Write-only files
+	f, err := fs3.Create("small")
+	if err != nil {
+		t.Fatalf("Create: %s", err)
+	}
+	if err := f.(s3fs.XattrSetter).SetXattr("source", "ci"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	f.Write([]byte("data"))
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if v, err := fs3.GetXattr("small", "source"); err != nil || v != "ci" {
+		t.Errorf("small: expected %q, got %q (%v)", "ci", v, err)
+	}
+
+	// Multipart uploads, before and after the first part is uploaded
+	f, err = fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	if err := f.(s3fs.XattrSetter).SetXattr("early", "1"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	f.Write(testData(int(s3fs.MinPartSize) + 10))
+	if err := f.(s3fs.XattrSetter).SetXattr("late", "2"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	names, err := fs3.ListXattr("big")
+	if err != nil {
+		t.Fatalf("ListXattr: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"early", "late"}) {
+		t.Errorf("big: unexpected attributes %v", names)
+	}
+
+	// Files open for reading and writing keep the existing attributes
+	f, err = fs3.OpenFile("small", s3fs.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile(O_RDWR): %s", err)
+	}
+	if err := f.(s3fs.XattrSetter).SetXattr("edited", "yes"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	names, err = fs3.ListXattr("small")
+	if err != nil {
+		t.Fatalf("ListXattr: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"edited", "source"}) {
+		t.Errorf("small: unexpected attributes %v", names)
+	}
+	if got := readFile(t, fs3, "small"); got != "data" {
+		t.Errorf("expected %q, got %q", "data", got)
+	}
+}
+
+func TestXattrLateCopyInParts(t *testing.T) {
+	client, backend := newTestClient(t)
+	fs3, err := s3fs.NewS3FS(client, testBucket, s3fs.WithCopyThreshold(s3fs.MinPartSize))
+	if err != nil {
+		t.Fatalf("NewS3FS: %s", err)
+	}
+
+	// Attributes set on uploads above the copy threshold after the first
+	// part is uploaded are recorded without CopyObject
+	backend.SetFaults(s3mem.Faults{ErrorEvery: 1, Operations: []string{"CopyObject"}})
+	data := testData(int(s3fs.MinPartSize) + 10)
+	f, err := fs3.OpenFile("big", s3fs.O_WRMULTIPART, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile: %s", err)
+	}
+	f.Write(data)
+	if err := f.(s3fs.XattrSetter).SetXattr("late", "1"); err != nil {
+		t.Fatalf("SetXattr: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	if v, err := fs3.GetXattr("big", "late"); err != nil || v != "1" {
+		t.Errorf("expected %q, got %q (%v)", "1", v, err)
+	}
+	if got := readFile(t, fs3, "big"); got != string(data) {
+		t.Errorf("contents don't match (got %d bytes, expected %d)", len(got), len(data))
+	}
+}